@@ -15,10 +15,12 @@ import (
 	"github.com/weaveworks/libgitops/cmd/sample-app/version"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/storage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
-	CarGVK = v1alpha1.SchemeGroupVersion.WithKind("Car")
+	CarGVK        = v1alpha1.SchemeGroupVersion.WithKind("Car")
+	MotorcycleGVK = v1alpha1.SchemeGroupVersion.WithKind("Motorcycle")
 )
 
 func init() {
@@ -38,8 +40,25 @@ func NewCar(name string) *v1alpha1.Car {
 	return obj
 }
 
-func SetNewCarStatus(s storage.Storage, key storage.ObjectKey) error {
-	obj, err := s.Get(key)
+// NewTow returns the Motorcycle towing the given Car. It's owned by the Car, so
+// deleting the Car deletes it as well.
+func NewTow(car *v1alpha1.Car) *v1alpha1.Motorcycle {
+	obj := &v1alpha1.Motorcycle{}
+	obj.Name = car.Name + "-tow"
+	obj.Namespace = car.Namespace
+	obj.Spec.Color = "yellow"
+	obj.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: CarGVK.GroupVersion().String(),
+		Kind:       CarGVK.Kind,
+		Name:       car.Name,
+		UID:        car.UID,
+	}}
+
+	return obj
+}
+
+func SetNewCarStatus(ctx context.Context, s storage.Storage, key storage.ObjectKey) error {
+	obj, err := s.Get(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -48,7 +67,7 @@ func SetNewCarStatus(s storage.Storage, key storage.ObjectKey) error {
 	car.Status.Distance = rand.Uint64()
 	car.Status.Speed = rand.Float64() * 100
 
-	return s.Update(car)
+	return s.Update(ctx, car)
 }
 
 func ParseVersionFlag() {
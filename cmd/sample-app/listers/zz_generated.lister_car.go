@@ -0,0 +1,66 @@
+/*
+	Note: This file is autogenerated! Do not edit it manually!
+	Edit the lister template in pkg/informer instead, and run
+	hack/generate-client.sh afterwards.
+*/
+
+package listers
+
+import (
+	"fmt"
+
+	api "github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/informer"
+)
+
+// CarLister lists Cars from the Store of an Informer. The returned
+// Cars are shared with the Store, and must not be modified.
+type CarLister interface {
+	// Get returns the Car with the given identifier, e.g. "namespace/name".
+	// If it doesn't exist, an error wrapping storage.ErrNotFound is returned.
+	Get(identifier string) (*api.Car, error)
+	// List lists the Cars, optionally filtered and ordered by the given options
+	List(opts ...filter.ListOption) ([]*api.Car, error)
+}
+
+// NewCarLister returns a CarLister for the Store of an Informer of Cars
+func NewCarLister(store informer.Store) CarLister {
+	return &carLister{store: store}
+}
+
+// carLister is a struct implementing the CarLister interface
+type carLister struct {
+	store informer.Store
+}
+
+func (l *carLister) Get(identifier string) (*api.Car, error) {
+	obj, err := l.store.Get(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := obj.(*api.Car)
+	if !ok {
+		return nil, fmt.Errorf("expected a *Car, got %T", obj)
+	}
+	return result, nil
+}
+
+func (l *carLister) List(opts ...filter.ListOption) ([]*api.Car, error) {
+	objs, err := l.store.List(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*api.Car, 0, len(objs))
+	for _, obj := range objs {
+		o, ok := obj.(*api.Car)
+		if !ok {
+			return nil, fmt.Errorf("expected a *Car, got %T", obj)
+		}
+		result = append(result, o)
+	}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+/*
+	Note: This file is autogenerated! Do not edit it manually!
+	Edit the lister template in pkg/informer instead, and run
+	hack/generate-client.sh afterwards.
+*/
+
+package listers
+
+import (
+	"fmt"
+
+	api "github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/informer"
+)
+
+// MotorcycleLister lists Motorcycles from the Store of an Informer. The returned
+// Motorcycles are shared with the Store, and must not be modified.
+type MotorcycleLister interface {
+	// Get returns the Motorcycle with the given identifier, e.g. "namespace/name".
+	// If it doesn't exist, an error wrapping storage.ErrNotFound is returned.
+	Get(identifier string) (*api.Motorcycle, error)
+	// List lists the Motorcycles, optionally filtered and ordered by the given options
+	List(opts ...filter.ListOption) ([]*api.Motorcycle, error)
+}
+
+// NewMotorcycleLister returns a MotorcycleLister for the Store of an Informer of Motorcycles
+func NewMotorcycleLister(store informer.Store) MotorcycleLister {
+	return &motorcycleLister{store: store}
+}
+
+// motorcycleLister is a struct implementing the MotorcycleLister interface
+type motorcycleLister struct {
+	store informer.Store
+}
+
+func (l *motorcycleLister) Get(identifier string) (*api.Motorcycle, error) {
+	obj, err := l.store.Get(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := obj.(*api.Motorcycle)
+	if !ok {
+		return nil, fmt.Errorf("expected a *Motorcycle, got %T", obj)
+	}
+	return result, nil
+}
+
+func (l *motorcycleLister) List(opts ...filter.ListOption) ([]*api.Motorcycle, error) {
+	objs, err := l.store.List(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*api.Motorcycle, 0, len(objs))
+	for _, obj := range objs {
+		o, ok := obj.(*api.Motorcycle)
+		if !ok {
+			return nil, fmt.Errorf("expected a *Motorcycle, got %T", obj)
+		}
+		result = append(result, o)
+	}
+	return result, nil
+}
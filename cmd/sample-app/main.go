@@ -16,6 +16,7 @@ import (
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
 	"github.com/weaveworks/libgitops/pkg/storage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var manifestDirFlag = pflag.String("data-dir", "/tmp/libgitops/manifest", "Where to store the YAML files")
@@ -40,10 +41,12 @@ func run() error {
 	// Set the log level
 	logs.Logger.SetLevel(logrus.InfoLevel)
 
-	plainStorage := storage.NewGenericStorage(
+	plainStorage := storage.NewGenericStorageWithOptions(
 		storage.NewGenericRawStorage(*manifestDirFlag, v1alpha1.SchemeGroupVersion, serializer.ContentTypeYAML),
 		scheme.Serializer,
 		[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
+		// The tow Motorcycles are deleted together with their Cars
+		storage.GenericStorageOptions{DependentKinds: []storage.KindKey{storage.NewKindKey(common.MotorcycleGVK)}},
 	)
 	defer func() { _ = plainStorage.Close() }()
 
@@ -55,7 +58,7 @@ func run() error {
 			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
 		}
 
-		obj, err := plainStorage.Get(common.CarKeyForName(name))
+		obj, err := plainStorage.Get(c.Request().Context(), common.CarKeyForName(name))
 		if err != nil {
 			return err
 		}
@@ -72,7 +75,11 @@ func run() error {
 			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
 		}
 
-		if err := plainStorage.Create(common.NewCar(name)); err != nil {
+		car := common.NewCar(name)
+		if err := plainStorage.Create(c.Request().Context(), car); err != nil {
+			return err
+		}
+		if err := plainStorage.Create(c.Request().Context(), common.NewTow(car)); err != nil {
 			return err
 		}
 		return c.String(200, "OK!")
@@ -84,7 +91,25 @@ func run() error {
 			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
 		}
 
-		if err := common.SetNewCarStatus(plainStorage, common.CarKeyForName(name)); err != nil {
+		if err := common.SetNewCarStatus(c.Request().Context(), plainStorage, common.CarKeyForName(name)); err != nil {
+			return err
+		}
+		return c.String(200, "OK!")
+	})
+
+	e.DELETE("/plain/:name", func(c echo.Context) error {
+		name := c.Param("name")
+		if len(name) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
+		}
+
+		// The propagation query parameter decides what happens to the tow, default Background
+		policy := metav1.DeletionPropagation(c.QueryParam("propagation"))
+		if len(policy) == 0 {
+			policy = metav1.DeletePropagationBackground
+		}
+
+		if err := plainStorage.(*storage.GenericStorage).DeleteWithPropagation(c.Request().Context(), common.CarKeyForName(name), policy); err != nil {
 			return err
 		}
 		return c.String(200, "OK!")
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -16,13 +17,15 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/weaveworks/libgitops/cmd/common"
 	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/controller"
+	"github.com/weaveworks/libgitops/pkg/filter"
 	"github.com/weaveworks/libgitops/pkg/gitdir"
 	"github.com/weaveworks/libgitops/pkg/logs"
 	"github.com/weaveworks/libgitops/pkg/storage"
 	"github.com/weaveworks/libgitops/pkg/storage/transaction"
 	githubpr "github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/github"
 	"github.com/weaveworks/libgitops/pkg/storage/watch"
-	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 )
 
 var (
@@ -136,19 +139,41 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 	}
 	defer func() { _ = watchStorage.Close() }()
 
-	updates := make(chan update.Update, 4096)
-	watchStorage.SetUpdateStream(updates)
+	// Reconcile the Cars as they change in Git. This actuator only logs their state.
+	carController, err := controller.NewController(watchStorage, controller.ReconcilerFunc(func(ctx context.Context, key storage.ObjectKey) (controller.Result, error) {
+		obj, err := watchStorage.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			logrus.Infof("Car %s deleted", key.GetIdentifier())
+			return controller.Result{}, nil
+		} else if err != nil {
+			return controller.Result{}, err
+		}
 
+		car := obj.(*v1alpha1.Car)
+		logrus.Infof("Car %s has brand %q and speed %.1f", key.GetIdentifier(), car.Spec.Brand, car.Status.Speed)
+		return controller.Result{}, nil
+	}), controller.ControllerOptions{Kind: storage.NewKindKey(common.CarGVK)})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
-		for upd := range updates {
-			logrus.Infof("Got %s update for: %v %v", upd.Event, upd.PartialObject.GetObjectKind().GroupVersionKind(), upd.PartialObject.GetObjectMeta())
+		if err := carController.Run(ctx); err != nil {
+			logrus.Errorf("Car controller failed: %v", err)
 		}
 	}()
 
 	e := common.NewEcho()
 
 	e.GET("/git/", func(c echo.Context) error {
-		objs, err := gitStorage.List(storage.NewKindKey(common.CarGVK))
+		// Filter the Cars by e.g. ?labelSelector=app=foo&fieldSelector=status.speed>100
+		opts, err := filter.ParseSelectors(c.QueryParam("labelSelector"), c.QueryParam("annotationSelector"), c.QueryParam("fieldSelector"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		objs, err := gitStorage.List(c.Request().Context(), storage.NewKindKey(common.CarGVK), opts...)
 		if err != nil {
 			return err
 		}
@@ -162,10 +187,10 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 		}
 
 		objKey := common.CarKeyForName(name)
-		err := gitStorage.Transaction(context.Background(), fmt.Sprintf("%s-update-", name), func(ctx context.Context, s storage.Storage) (transaction.CommitResult, error) {
+		pr, err := gitStorage.Transaction(context.Background(), fmt.Sprintf("%s-update-", name), func(ctx context.Context, s storage.Storage) (transaction.CommitResult, error) {
 
 			// Update the status of the car
-			if err := common.SetNewCarStatus(s, objKey); err != nil {
+			if err := common.SetNewCarStatus(ctx, s, objKey); err != nil {
 				return nil, err
 			}
 
@@ -185,7 +210,7 @@ func run(identityFile, gitURL, ghToken, authorName, authorEmail string) error {
 			return err
 		}
 
-		return c.String(200, "OK!")
+		return c.String(200, fmt.Sprintf("OK! Created Pull Request %s", pr.URL))
 	})
 
 	return common.StartEcho(e)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,10 +12,14 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/weaveworks/libgitops/cmd/common"
 	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/listers"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/informer"
 	"github.com/weaveworks/libgitops/pkg/logs"
+	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
 	"github.com/weaveworks/libgitops/pkg/storage/watch"
-	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 )
 
 var watchDirFlag = pflag.String("watch-dir", "/tmp/libgitops/watch", "Where to watch for YAML/JSON manifests")
@@ -45,24 +50,54 @@ func run() error {
 	}
 	defer func() { _ = watchStorage.Close() }()
 
-	updates := make(chan update.Update, 4096)
-	watchStorage.SetUpdateStream(updates)
-
+	// Keep a local state of the Cars, and log their changes
+	carInformer, err := informer.NewInformer(watchStorage, informer.InformerOptions{Kind: storage.NewKindKey(common.CarGVK)})
+	if err != nil {
+		return err
+	}
+	carInformer.AddEventHandler(informer.ResourceEventHandlerFuncs{
+		AddFunc: func(obj runtime.Object) {
+			logrus.Infof("Car %s/%s added", obj.GetNamespace(), obj.GetName())
+		},
+		UpdateFunc: func(oldObj, newObj runtime.Object) {
+			logrus.Infof("Car %s/%s updated", newObj.GetNamespace(), newObj.GetName())
+		},
+		DeleteFunc: func(obj runtime.Object) {
+			logrus.Infof("Car %s/%s deleted", obj.GetNamespace(), obj.GetName())
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
-		for upd := range updates {
-			logrus.Infof("Got %s update for: %v %v", upd.Event, upd.PartialObject.GetObjectKind().GroupVersionKind(), upd.PartialObject.GetObjectMeta())
+		if err := carInformer.Run(ctx); err != nil {
+			logrus.Errorf("Car informer failed: %v", err)
 		}
 	}()
+	carLister := listers.NewCarLister(carInformer.GetStore())
 
 	e := common.NewEcho()
 
+	e.GET("/watch/", func(c echo.Context) error {
+		// List the Cars from the local state, e.g. ?labelSelector=app=foo
+		opts, err := filter.ParseSelectors(c.QueryParam("labelSelector"), c.QueryParam("annotationSelector"), c.QueryParam("fieldSelector"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		cars, err := carLister.List(opts...)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, cars)
+	})
+
 	e.GET("/watch/:name", func(c echo.Context) error {
 		name := c.Param("name")
 		if len(name) == 0 {
 			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
 		}
 
-		obj, err := watchStorage.Get(common.CarKeyForName(name))
+		obj, err := watchStorage.Get(c.Request().Context(), common.CarKeyForName(name))
 		if err != nil {
 			return err
 		}
@@ -79,7 +114,7 @@ func run() error {
 			return echo.NewHTTPError(http.StatusBadRequest, "Please set name")
 		}
 
-		if err := common.SetNewCarStatus(watchStorage, common.CarKeyForName(name)); err != nil {
+		if err := common.SetNewCarStatus(c.Request().Context(), watchStorage, common.CarKeyForName(name)); err != nil {
 			return err
 		}
 		return c.String(200, "OK!")
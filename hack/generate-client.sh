@@ -14,3 +14,14 @@ for Resource in ${RESOURCES}; do
         pkg/client/client_resource_template.go > \
         ${OUT_DIR}/zz_generated.client_${resource}.go
 done
+
+# The listers are typed wrappers around the Store of an Informer
+LISTERS_OUT_DIR=cmd/sample-app/listers
+LISTERS_API_DIR="${API_DIR}/v1alpha1"
+mkdir -p ${LISTERS_OUT_DIR}
+for Resource in ${RESOURCES}; do
+    resource=$(echo "${Resource}" | awk '{print tolower($0)}')
+    sed -e "s|Resource|${Resource}|g;s|resource|${resource}|g;/build ignore/d;s|API_DIR|${LISTERS_API_DIR}|g" \
+        pkg/informer/lister_resource_template.go > \
+        ${LISTERS_OUT_DIR}/zz_generated.lister_${resource}.go
+done
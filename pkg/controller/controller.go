@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Result is the outcome of a successful Reconcile.
+type Result struct {
+	// Requeue reconciles the Object again, after the backoff of the RateLimiter.
+	// +optional
+	Requeue bool
+	// RequeueAfter, if positive, reconciles the Object again after the given duration,
+	// e.g. for periodically checking external state. It takes precedence over Requeue.
+	// +optional
+	RequeueAfter time.Duration
+}
+
+// Reconciler brings the actual state in line with the desired state of an Object.
+type Reconciler interface {
+	// Reconcile reconciles the Object with the given key. The Object should be read from the
+	// storage, as the key may be reconciled long after the change that queued it. If the Object
+	// doesn't exist, it has been deleted. If an error is returned, the key is retried with
+	// exponential backoff. Reconcile must be idempotent.
+	Reconcile(ctx context.Context, key storage.ObjectKey) (Result, error)
+}
+
+// ReconcilerFunc implements Reconciler.
+var _ Reconciler = ReconcilerFunc(nil)
+
+// ReconcilerFunc is a function implementing Reconciler.
+type ReconcilerFunc func(ctx context.Context, key storage.ObjectKey) (Result, error)
+
+func (f ReconcilerFunc) Reconcile(ctx context.Context, key storage.ObjectKey) (Result, error) {
+	return f(ctx, key)
+}
+
+// ControllerOptions configures a Controller.
+type ControllerOptions struct {
+	// Kind is the kind of the Objects to reconcile. The keys passed to the Reconciler have its version.
+	// +required
+	Kind storage.KindKey
+	// Workers is the amount of keys reconciled concurrently. A key is never reconciled by
+	// two workers at the same time. Default 1.
+	// +optional
+	Workers int
+	// RateLimiter decides the backoff of failed keys. Default workqueue.DefaultControllerRateLimiter().
+	// +optional
+	RateLimiter workqueue.RateLimiter
+	// MaxRetries, if positive, drops a key after it has failed this many times in a row.
+	// The key is reconciled again on its next change. Default 0, which retries forever.
+	// +optional
+	MaxRetries int
+}
+
+func (o *ControllerOptions) Default() {
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+	if o.RateLimiter == nil {
+		o.RateLimiter = workqueue.DefaultControllerRateLimiter()
+	}
+}
+
+// Controller runs a Reconciler for the Objects of one kind in an update.EventStorage. The updates of
+// the EventStorage are turned into keys on a rate-limited workqueue, which the workers reconcile.
+type Controller struct {
+	es         update.EventStorage
+	reconciler Reconciler
+	opts       ControllerOptions
+	queue      workqueue.RateLimitingInterface
+}
+
+// NewController returns a Controller running the Reconciler for the Objects of opts.Kind in the EventStorage.
+func NewController(es update.EventStorage, reconciler Reconciler, opts ControllerOptions) (*Controller, error) {
+	if opts.Kind == nil {
+		return nil, fmt.Errorf("NewController: ControllerOptions.Kind is required")
+	}
+	opts.Default()
+
+	return &Controller{
+		es:         es,
+		reconciler: reconciler,
+		opts:       opts,
+		queue:      workqueue.NewRateLimitingQueue(opts.RateLimiter),
+	}, nil
+}
+
+// Queue returns the workqueue of the Controller, e.g. for queueing keys on external triggers.
+// The items of the queue are storage.ObjectKeys in the version of ControllerOptions.Kind.
+func (c *Controller) Queue() workqueue.RateLimitingInterface {
+	return c.queue
+}
+
+// Run queues all existing Objects of the kind, so changes made while the Controller wasn't running
+// are reconciled, and then queues the Objects changed according to the updates of the EventStorage.
+// Run blocks until the context is cancelled or the EventStorage is closed, and then waits for
+// the running reconciliations to finish. Run must only be called once.
+func (c *Controller) Run(ctx context.Context) error {
+	// Subscribe before listing, so that no change after the List is missed. Pending
+	// updates for the same Object are merged, as they result in the same key anyway.
+	sub := c.es.Subscribe(update.SubscribeOptions{Kind: c.opts.Kind, OverflowPolicy: update.OverflowCoalesce})
+	defer sub.Unsubscribe()
+
+	keys, err := c.es.RawStorage().List(ctx, c.opts.Kind)
+	if err != nil {
+		c.queue.ShutDown()
+		return err
+	}
+	for _, key := range keys {
+		c.queue.Add(c.keyFor(key))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNext(ctx) {
+			}
+		}()
+	}
+
+	c.watch(ctx, sub)
+	// Stop the workers, and wait for their reconciliations to finish
+	c.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+// watch queues the keys of the updates until the context is cancelled or the EventStorage is closed
+func (c *Controller) watch(ctx context.Context, sub update.Subscription) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case upd, ok := <-sub.Updates():
+			if !ok {
+				return // The EventStorage has been closed
+			}
+			log.Tracef("Controller: Queueing %v after %s update", upd.ObjectKey, upd.Event)
+			c.queue.Add(c.keyFor(upd.ObjectKey))
+		}
+	}
+}
+
+// keyFor returns the key of the Object in the version of the Controller's kind, as
+// the keys of the updates and the RawStorage may have any version
+func (c *Controller) keyFor(key storage.ObjectKey) storage.ObjectKey {
+	return storage.NewObjectKey(c.opts.Kind, runtime.NewIdentifier(key.GetIdentifier()))
+}
+
+// processNext reconciles the next key of the queue. It returns false when the queue is shut down.
+func (c *Controller) processNext(ctx context.Context) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+	key := item.(storage.ObjectKey)
+
+	result, err := c.reconcile(ctx, key)
+	switch {
+	case err != nil:
+		if c.opts.MaxRetries > 0 && c.queue.NumRequeues(key) >= c.opts.MaxRetries {
+			log.Errorf("Controller: Dropping %v after %d retries: %v", key, c.opts.MaxRetries, err)
+			c.queue.Forget(key)
+			return true
+		}
+		log.Warnf("Controller: Reconciling %v failed, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+	case result.RequeueAfter > 0:
+		c.queue.Forget(key)
+		c.queue.AddAfter(key, result.RequeueAfter)
+	case result.Requeue:
+		c.queue.AddRateLimited(key)
+	default:
+		c.queue.Forget(key)
+	}
+	return true
+}
+
+// reconcile runs the Reconciler, turning panics into errors so that they are retried like them
+func (c *Controller) reconcile(ctx context.Context, key storage.ObjectKey) (result Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.reconciler.Reconcile(ctx, key)
+}
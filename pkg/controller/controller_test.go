@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/memory"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// expectReconciles waits until the given identifiers have been reconciled, in any order
+func expectReconciles(t *testing.T, reconciled <-chan string, want ...string) {
+	t.Helper()
+	pending := make(map[string]int)
+	for _, identifier := range want {
+		pending[identifier]++
+	}
+	for len(pending) > 0 {
+		select {
+		case identifier := <-reconciled:
+			if pending[identifier]--; pending[identifier] <= 0 {
+				delete(pending, identifier)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the reconciles of %v", pending)
+		}
+	}
+}
+
+func TestController(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers)
+	es, err := memory.NewMemoryEventStorage(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Objects existing before the Controller starts are reconciled as well
+	if err := s.Create(ctx, storagetest.NewCar("existing", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+
+	var mux sync.Mutex
+	inFlight := make(map[string]bool)
+	failures, panics := 0, 0
+	requeued := false
+	reconciled := make(chan string, 100)
+
+	c, err := NewController(es, ReconcilerFunc(func(ctx context.Context, key storage.ObjectKey) (Result, error) {
+		identifier := key.GetIdentifier()
+		mux.Lock()
+		if inFlight[identifier] {
+			t.Errorf("%s is reconciled concurrently", identifier)
+		}
+		inFlight[identifier] = true
+		mux.Unlock()
+		defer func() {
+			mux.Lock()
+			inFlight[identifier] = false
+			mux.Unlock()
+			reconciled <- identifier
+		}()
+		time.Sleep(time.Millisecond)
+
+		if !key.EqualsGVK(storagetest.CarKind, true) {
+			t.Errorf("expected a key for %v, got %v", storagetest.CarKind, key)
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		switch identifier {
+		case "default/failing":
+			if failures < 2 {
+				failures++
+				return Result{}, errors.New("failure")
+			}
+		case "default/panicking":
+			if panics < 1 {
+				panics++
+				panic("failure")
+			}
+		case "default/requeued":
+			if !requeued {
+				requeued = true
+				return Result{RequeueAfter: 10 * time.Millisecond}, nil
+			}
+		}
+		return Result{}, nil
+	}), ControllerOptions{Kind: storagetest.CarKind, Workers: 4, RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, 10*time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- c.Run(runCtx) }()
+	expectReconciles(t, reconciled, "default/existing")
+
+	for _, name := range []string{"failing", "requeued"} {
+		if err := s.Create(ctx, storagetest.NewCar(name, "Volvo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Failures are retried, and the Result can requeue the key
+	expectReconciles(t, reconciled, "default/failing", "default/failing", "default/failing", "default/requeued", "default/requeued")
+
+	if err := s.Create(ctx, storagetest.NewCar("panicking", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+	expectReconciles(t, reconciled, "default/panicking", "default/panicking")
+
+	// Deletes are reconciled as well
+	if err := s.Delete(ctx, storagetest.CarKey("existing")); err != nil {
+		t.Fatal(err)
+	}
+	expectReconciles(t, reconciled, "default/existing")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for the Controller to stop")
+	}
+}
+
+func TestController_MaxRetries(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers)
+	es, err := memory.NewMemoryEventStorage(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, storagetest.NewCar("failing", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+
+	reconciled := make(chan string, 100)
+	c, err := NewController(es, ReconcilerFunc(func(ctx context.Context, key storage.ObjectKey) (Result, error) {
+		reconciled <- key.GetIdentifier()
+		return Result{}, errors.New("failure")
+	}), ControllerOptions{Kind: storagetest.CarKind, MaxRetries: 2, RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() { _ = c.Run(runCtx) }()
+
+	// The first try and two retries
+	expectReconciles(t, reconciled, "default/failing", "default/failing", "default/failing")
+	select {
+	case <-reconciled:
+		t.Error("expected the key to be dropped after two retries")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,269 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// FieldOperator is the comparison a FieldRequirement makes.
+type FieldOperator string
+
+const (
+	FieldOperatorEquals             FieldOperator = "="
+	FieldOperatorNotEquals          FieldOperator = "!="
+	FieldOperatorGreaterThan        FieldOperator = ">"
+	FieldOperatorGreaterThanOrEqual FieldOperator = ">="
+	FieldOperatorLessThan           FieldOperator = "<"
+	FieldOperatorLessThanOrEqual    FieldOperator = "<="
+)
+
+// fieldOperators lists the operators in the order they are matched when parsing,
+// longer operators first. "==" is an alias for "=".
+var fieldOperators = []string{"==", "!=", ">=", "<=", "=", ">", "<"}
+
+// FieldRequirement is a single comparison of the value at a JSON path of an object.
+type FieldRequirement struct {
+	// Path is the dot-separated JSON path of the field, e.g. "spec.brand". Numeric
+	// elements index into lists, e.g. "spec.containers.0.name".
+	// +required
+	Path string
+	// Operator is the comparison to make. The ordering operators require the field
+	// and Value to be numbers.
+	// +required
+	Operator FieldOperator
+	// Value is the value to compare the field to. Numbers are compared numerically,
+	// other values by their string representation, e.g. "true" for booleans.
+	Value string
+}
+
+func (r FieldRequirement) String() string {
+	return r.Path + string(r.Operator) + r.Value
+}
+
+func (r FieldRequirement) validate() error {
+	if len(r.Path) == 0 {
+		return fmt.Errorf("the FieldRequirement.Path field must not be empty: %w", ErrInvalidFilterParams)
+	}
+
+	switch r.Operator {
+	case FieldOperatorEquals, FieldOperatorNotEquals:
+		return nil
+	case FieldOperatorGreaterThan, FieldOperatorGreaterThanOrEqual, FieldOperatorLessThan, FieldOperatorLessThanOrEqual:
+		if _, err := strconv.ParseFloat(r.Value, 64); err != nil {
+			return fmt.Errorf("operator %q requires a number, got %q: %w", r.Operator, r.Value, ErrInvalidFilterParams)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown field operator %q: %w", r.Operator, ErrInvalidFilterParams)
+}
+
+// matches returns whether the field at r.Path of the decoded object obj meets the requirement.
+// Fields that don't exist only match the "!=" operator.
+func (r FieldRequirement) matches(obj interface{}) bool {
+	field, ok := lookupField(obj, splitPath(r.Path))
+
+	switch r.Operator {
+	case FieldOperatorEquals:
+		return ok && fieldEquals(field, r.Value)
+	case FieldOperatorNotEquals:
+		return !ok || !fieldEquals(field, r.Value)
+	}
+
+	number, isNumber := field.(float64)
+	if !ok || !isNumber {
+		return false
+	}
+	value, _ := strconv.ParseFloat(r.Value, 64) // Validated when parsing
+	switch r.Operator {
+	case FieldOperatorGreaterThan:
+		return number > value
+	case FieldOperatorGreaterThanOrEqual:
+		return number >= value
+	case FieldOperatorLessThan:
+		return number < value
+	case FieldOperatorLessThanOrEqual:
+		return number <= value
+	}
+	return false
+}
+
+// FieldFilter implements ObjectFilter, MetaFilter and ListOption.
+var _ ObjectFilter = FieldFilter{}
+var _ MetaFilter = FieldFilter{}
+var _ ListOption = FieldFilter{}
+
+// FieldFilter is an ObjectFilter that compares the values at arbitrary JSON paths of
+// the object, e.g. "spec.brand=Volvo,status.speed>100". All requirements must be met.
+type FieldFilter struct {
+	// Requirements are the comparisons the object must all pass.
+	// +required
+	Requirements []FieldRequirement
+}
+
+// ParseFieldFilter parses the given field selector into a FieldFilter. The selector consists of
+// comma-separated requirements in the form <path><operator><value>, where operator is one of
+// "=", "==", "!=", ">", ">=", "<" and "<=", e.g. "spec.brand=Volvo,status.speed>100".
+// The values can't contain commas.
+func ParseFieldFilter(selector string) (FieldFilter, error) {
+	f := FieldFilter{}
+	for _, part := range strings.Split(selector, ",") {
+		if part = strings.TrimSpace(part); len(part) == 0 {
+			continue
+		}
+
+		r, err := parseFieldRequirement(part)
+		if err != nil {
+			return FieldFilter{}, err
+		}
+		f.Requirements = append(f.Requirements, r)
+	}
+
+	if len(f.Requirements) == 0 {
+		return FieldFilter{}, fmt.Errorf("empty field selector %q: %w", selector, ErrInvalidFilterParams)
+	}
+	return f, nil
+}
+
+func parseFieldRequirement(s string) (FieldRequirement, error) {
+	// The operator starts at the first operator character
+	i := strings.IndexAny(s, "!=<>")
+	if i < 0 {
+		return FieldRequirement{}, fmt.Errorf("no operator in field requirement %q: %w", s, ErrInvalidFilterParams)
+	}
+
+	for _, op := range fieldOperators {
+		if !strings.HasPrefix(s[i:], op) {
+			continue
+		}
+
+		r := FieldRequirement{
+			Path:     strings.TrimSpace(s[:i]),
+			Operator: FieldOperator(op),
+			Value:    strings.TrimSpace(s[i+len(op):]),
+		}
+		if op == "==" {
+			r.Operator = FieldOperatorEquals
+		}
+		return r, r.validate()
+	}
+	return FieldRequirement{}, fmt.Errorf("invalid operator in field requirement %q: %w", s, ErrInvalidFilterParams)
+}
+
+// String returns the field selector FieldFilter was parsed from, in its canonical form.
+func (f FieldFilter) String() string {
+	parts := make([]string, 0, len(f.Requirements))
+	for _, r := range f.Requirements {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Filter implements ObjectFilter
+func (f FieldFilter) Filter(obj runtime.Object) (bool, error) {
+	// Require f.Requirements to always be set.
+	if len(f.Requirements) == 0 {
+		return false, fmt.Errorf("the FieldFilter.Requirements field must not be empty: %w", ErrInvalidFilterParams)
+	}
+	for _, r := range f.Requirements {
+		if err := r.validate(); err != nil {
+			return false, err
+		}
+	}
+
+	decoded, err := decodeJSON(obj)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range f.Requirements {
+		if !r.matches(decoded) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// MetaOnly implements MetaFilter, it's true if all paths point into the TypeMeta or ObjectMeta.
+func (f FieldFilter) MetaOnly() bool {
+	for _, r := range f.Requirements {
+		if !isMetaPath(r.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyToListOptions implements ListOption, and adds itself converted to
+// a ListFilter to ListOptions.Filters.
+func (f FieldFilter) ApplyToListOptions(target *ListOptions) error {
+	target.Filters = append(target.Filters, ObjectToListFilter(f))
+	return nil
+}
+
+// decodeJSON decodes the object into its generic JSON representation for looking up paths
+func decodeJSON(obj runtime.Object) (interface{}, error) {
+	content, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(content, &decoded)
+	return decoded, err
+}
+
+// splitPath splits a dot-separated JSON path, which may start with a dot
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "."), ".")
+}
+
+// isMetaPath returns whether the JSON path points into the TypeMeta or ObjectMeta of an object
+func isMetaPath(path string) bool {
+	switch splitPath(path)[0] {
+	case "apiVersion", "kind", "metadata":
+		return true
+	}
+	return false
+}
+
+// lookupField returns the value at the given path of a decoded JSON document
+func lookupField(obj interface{}, path []string) (interface{}, bool) {
+	for _, elem := range path {
+		switch o := obj.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if obj, ok = o[elem]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(elem)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil, false
+			}
+			obj = o[i]
+		default:
+			return nil, false
+		}
+	}
+	return obj, true
+}
+
+// fieldEquals compares a decoded JSON value to the value of a requirement
+func fieldEquals(field interface{}, value string) bool {
+	switch f := field.(type) {
+	case string:
+		return f == value
+	case float64:
+		v, err := strconv.ParseFloat(value, 64)
+		return err == nil && f == v
+	case bool:
+		return strconv.FormatBool(f) == value
+	case nil:
+		return value == "null"
+	}
+	// Lists and objects can't be compared to a value
+	return false
+}
@@ -17,6 +17,28 @@ type ObjectFilter interface {
 	Filter(obj runtime.Object) (bool, error)
 }
 
+// MetaFilter is implemented by filters that may only look at the TypeMeta and ObjectMeta of the
+// objects. Such filters give the same result for a runtime.PartialObject as for the full object,
+// so storages can apply them before fully decoding the objects, and only decode the ones passing.
+type MetaFilter interface {
+	// MetaOnly returns whether the filter only looks at the TypeMeta and ObjectMeta of the objects.
+	MetaOnly() bool
+}
+
+// IsMetaFilter returns whether the given filter is a MetaFilter only looking at metadata.
+func IsMetaFilter(f interface{}) bool {
+	mf, ok := f.(MetaFilter)
+	return ok && mf.MetaOnly()
+}
+
+// IsObjectFilter returns whether the given ListFilter was created from an ObjectFilter using
+// ObjectToListFilter. Such filters decide for every object on its own, so storages can apply
+// them to the objects one at a time. Other ListFilters are given the full list of objects.
+func IsObjectFilter(f ListFilter) bool {
+	_, ok := f.(*objectToListFilter)
+	return ok
+}
+
 // ObjectToListFilter transforms an ObjectFilter into a ListFilter. If of is nil,
 // this function panics.
 func ObjectToListFilter(of ObjectFilter) ListFilter {
@@ -30,6 +52,11 @@ type objectToListFilter struct {
 	of ObjectFilter
 }
 
+// MetaOnly implements MetaFilter, it's true if the ObjectFilter only looks at metadata.
+func (f objectToListFilter) MetaOnly() bool {
+	return IsMetaFilter(f.of)
+}
+
 // Filter implements ListFilter, but uses an ObjectFilter for the underlying logic.
 func (f objectToListFilter) Filter(objs ...runtime.Object) (retarr []runtime.Object, err error) {
 	// Walk through all objects
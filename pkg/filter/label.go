@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// LabelFilter implements ObjectFilter, MetaFilter and ListOption.
+var _ ObjectFilter = LabelFilter{}
+var _ MetaFilter = LabelFilter{}
+var _ ListOption = LabelFilter{}
+
+// LabelFilter is an ObjectFilter that matches runtime.Object.GetLabels()
+// against a Kubernetes-style label selector, e.g. "app=foo,tier in (a,b)".
+type LabelFilter struct {
+	// Selector matches the object by .metadata.labels.
+	// +required
+	Selector labels.Selector
+}
+
+// ParseLabelFilter parses the given label selector into a LabelFilter. The syntax is the same
+// as for kubectl, e.g. "app=foo", "app!=foo", "tier in (a,b)", "tier notin (a,b)", "app" and
+// "!app". The requirements are separated by commas, and must all be met.
+func ParseLabelFilter(selector string) (LabelFilter, error) {
+	s, err := parseSelector(selector)
+	return LabelFilter{Selector: s}, err
+}
+
+// Filter implements ObjectFilter
+func (f LabelFilter) Filter(obj runtime.Object) (bool, error) {
+	// Require f.Selector to always be set.
+	if f.Selector == nil {
+		return false, fmt.Errorf("the LabelFilter.Selector field must not be nil: %w", ErrInvalidFilterParams)
+	}
+	return f.Selector.Matches(labels.Set(obj.GetLabels())), nil
+}
+
+// MetaOnly implements MetaFilter, the LabelFilter only looks at metadata.
+func (f LabelFilter) MetaOnly() bool {
+	return true
+}
+
+// ApplyToListOptions implements ListOption, and adds itself converted to
+// a ListFilter to ListOptions.Filters.
+func (f LabelFilter) ApplyToListOptions(target *ListOptions) error {
+	target.Filters = append(target.Filters, ObjectToListFilter(f))
+	return nil
+}
+
+// AnnotationFilter implements ObjectFilter, MetaFilter and ListOption.
+var _ ObjectFilter = AnnotationFilter{}
+var _ MetaFilter = AnnotationFilter{}
+var _ ListOption = AnnotationFilter{}
+
+// AnnotationFilter is an ObjectFilter that matches runtime.Object.GetAnnotations()
+// against a label selector, e.g. "example.com/managed=true".
+//
+// Limitation: as the selector is a labels.Selector, the annotation values it compares to
+// must be valid label values, i.e. at most 63 alphanumeric characters, '-', '_' or '.',
+// starting and ending with an alphanumeric character. Annotations with other values, e.g.
+// URLs or JSON, can only be selected by their existence ("key" and "!key"). Use a custom
+// ObjectFilter for matching those values.
+type AnnotationFilter struct {
+	// Selector matches the object by .metadata.annotations.
+	// +required
+	Selector labels.Selector
+}
+
+// ParseAnnotationFilter parses the given selector into an AnnotationFilter. The syntax is the
+// same as for ParseLabelFilter, hence the values in it must be valid label values, even though
+// annotation values may be arbitrary strings. See AnnotationFilter for the details.
+func ParseAnnotationFilter(selector string) (AnnotationFilter, error) {
+	s, err := parseSelector(selector)
+	if err != nil {
+		return AnnotationFilter{}, fmt.Errorf("annotation selectors only support values that are valid label values: %w", err)
+	}
+	return AnnotationFilter{Selector: s}, nil
+}
+
+// Filter implements ObjectFilter
+func (f AnnotationFilter) Filter(obj runtime.Object) (bool, error) {
+	// Require f.Selector to always be set.
+	if f.Selector == nil {
+		return false, fmt.Errorf("the AnnotationFilter.Selector field must not be nil: %w", ErrInvalidFilterParams)
+	}
+	return f.Selector.Matches(labels.Set(obj.GetAnnotations())), nil
+}
+
+// MetaOnly implements MetaFilter, the AnnotationFilter only looks at metadata.
+func (f AnnotationFilter) MetaOnly() bool {
+	return true
+}
+
+// ApplyToListOptions implements ListOption, and adds itself converted to
+// a ListFilter to ListOptions.Filters.
+func (f AnnotationFilter) ApplyToListOptions(target *ListOptions) error {
+	target.Filters = append(target.Filters, ObjectToListFilter(f))
+	return nil
+}
+
+// parseSelector parses a label selector, wrapping parse errors in ErrInvalidFilterParams
+func parseSelector(selector string) (labels.Selector, error) {
+	s, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v: %w", selector, err, ErrInvalidFilterParams)
+	}
+	return s, nil
+}
@@ -7,8 +7,9 @@ import (
 	"github.com/weaveworks/libgitops/pkg/runtime"
 )
 
-// NameFilter implements ObjectFilter and ListOption.
+// NameFilter implements ObjectFilter, MetaFilter and ListOption.
 var _ ObjectFilter = NameFilter{}
+var _ MetaFilter = NameFilter{}
 var _ ListOption = NameFilter{}
 
 // NameFilter is an ObjectFilter that compares runtime.Object.GetName()
@@ -46,6 +47,11 @@ func (f NameFilter) Filter(obj runtime.Object) (bool, error) {
 	return f.Name == obj.GetName(), nil
 }
 
+// MetaOnly implements MetaFilter, the NameFilter only looks at metadata.
+func (f NameFilter) MetaOnly() bool {
+	return true
+}
+
 // ApplyToListOptions implements ListOption, and adds itself converted to
 // a ListFilter to ListOptions.Filters.
 func (f NameFilter) ApplyToListOptions(target *ListOptions) error {
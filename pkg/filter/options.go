@@ -5,6 +5,33 @@ type ListOptions struct {
 	// Filters contains a chain of ListFilters, which will be processed in order and pipe the
 	// available objects through before returning.
 	Filters []ListFilter
+	// SortBy orders the returned objects. Default by name.
+	SortBy SortBy
+	// Limit is the maximum amount of objects to return, 0 means no limit.
+	Limit int64
+	// Continue is the continue token returned by the previous List with a Limit.
+	Continue string
+}
+
+// SplitFilters splits the leading Filters deciding for every object on its own into the MetaFilters only
+// looking at metadata, and the other filters created from ObjectFilters. The storages apply the MetaFilters
+// first, and then the ObjectFilters, one object at a time. The rest, starting at the first filter that
+// needs the full list of objects, is applied last and in the given order, to the objects passing the
+// other filters. As MetaFilters and ObjectFilters only keep or drop objects, the result is the same as
+// when applying all filters in order.
+func (o *ListOptions) SplitFilters() (meta []ListFilter, object []ListFilter, rest []ListFilter) {
+	for i, f := range o.Filters {
+		switch {
+		case IsMetaFilter(f):
+			meta = append(meta, f)
+		case IsObjectFilter(f):
+			object = append(object, f)
+		default:
+			// The filters after this one may depend on its result
+			return meta, object, o.Filters[i:]
+		}
+	}
+	return
 }
 
 // ListOption is an interface which can be passed into e.g. List() methods as a variadic-length
@@ -25,3 +52,32 @@ func MakeListOptions(opts ...ListOption) (*ListOptions, error) {
 	}
 	return o, nil
 }
+
+// ParseSelectors parses the given label, annotation and field selectors into ListOptions, e.g. for
+// selectors given as query parameters of an HTTP request. Empty selectors are skipped. The values in the
+// annotation selector must be valid label values, see AnnotationFilter.
+func ParseSelectors(labelSelector, annotationSelector, fieldSelector string) ([]ListOption, error) {
+	opts := []ListOption{}
+	if len(labelSelector) > 0 {
+		f, err := ParseLabelFilter(labelSelector)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, f)
+	}
+	if len(annotationSelector) > 0 {
+		f, err := ParseAnnotationFilter(annotationSelector)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, f)
+	}
+	if len(fieldSelector) > 0 {
+		f, err := ParseFieldFilter(fieldSelector)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, f)
+	}
+	return opts, nil
+}
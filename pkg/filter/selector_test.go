@@ -0,0 +1,122 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+func newCar() runtime.Object {
+	car := &v1alpha1.Car{}
+	car.Name = "foo"
+	car.Labels = map[string]string{"app": "foo", "tier": "a"}
+	car.Annotations = map[string]string{"example.com/managed": "true"}
+	car.Spec.Brand = "Volvo"
+	car.Status.Speed = 120.5
+	car.Status.Persons = 2
+	return car
+}
+
+func TestLabelFilter(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"app=foo", true},
+		{"app=bar", false},
+		{"app!=bar", true},
+		{"app=foo,tier in (a,b)", true},
+		{"app=foo,tier notin (a,b)", false},
+		{"app", true},
+		{"!app", false},
+		{"missing", false},
+		{"", true},
+	}
+	for _, rt := range tests {
+		t.Run(rt.selector, func(t *testing.T) {
+			f, err := ParseLabelFilter(rt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := f.Filter(newCar()); err != nil || got != rt.want {
+				t.Errorf("expected %t, got %t (%v)", rt.want, got, err)
+			}
+		})
+	}
+}
+
+func TestAnnotationFilter(t *testing.T) {
+	f, err := ParseAnnotationFilter("example.com/managed=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Filter(newCar()); err != nil || !got {
+		t.Errorf("expected the annotation to match, got %t (%v)", got, err)
+	}
+	if got, err := (LabelFilter{Selector: f.Selector}).Filter(newCar()); err != nil || got {
+		t.Errorf("expected the labels not to match, got %t (%v)", got, err)
+	}
+
+	// Values that aren't valid label values can't be selected, only the existence of the annotation
+	if _, err := ParseAnnotationFilter("example.com/url=https://example.com"); !errors.Is(err, ErrInvalidFilterParams) {
+		t.Errorf("expected ErrInvalidFilterParams for a value that isn't a valid label value, got %v", err)
+	}
+	if _, err := ParseAnnotationFilter("example.com/url"); err != nil {
+		t.Errorf("expected the existence of an annotation to be selectable, got %v", err)
+	}
+}
+
+func TestFieldFilter(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"spec.brand=Volvo", true},
+		{"spec.brand==Volvo", true},
+		{".spec.brand=Volvo", true},
+		{"spec.brand=Saab", false},
+		{"spec.brand!=Saab", true},
+		{"spec.missing!=Saab", true},
+		{"spec.missing=Saab", false},
+		{"status.speed>100", true},
+		{"status.speed>=120.5", true},
+		{"status.speed<100", false},
+		{"status.persons=2", true},
+		{"status.persons<=1", false},
+		{"spec.brand>1", false},
+		{"metadata.labels.app=foo", true},
+		{"spec.brand=Volvo, status.speed>100", true},
+		{"spec.brand=Volvo,status.speed>200", false},
+	}
+	for _, rt := range tests {
+		t.Run(rt.selector, func(t *testing.T) {
+			f, err := ParseFieldFilter(rt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := f.Filter(newCar()); err != nil || got != rt.want {
+				t.Errorf("expected %t, got %t (%v)", rt.want, got, err)
+			}
+		})
+	}
+}
+
+func TestParseFieldFilter_Invalid(t *testing.T) {
+	for _, selector := range []string{"", "spec.brand", "=Volvo", "status.speed>fast", "spec.brand=!Volvo,status"} {
+		if _, err := ParseFieldFilter(selector); !errors.Is(err, ErrInvalidFilterParams) {
+			t.Errorf("%q: expected ErrInvalidFilterParams, got %v", selector, err)
+		}
+	}
+}
+
+func TestFieldFilter_String(t *testing.T) {
+	f, err := ParseFieldFilter("spec.brand == Volvo, status.speed>100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.String(), "spec.brand=Volvo,status.speed>100"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,229 @@
+package filter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// ErrInvalidContinue is returned when a continue token can't be decoded, or was
+// created for a List with another ordering.
+var ErrInvalidContinue = fmt.Errorf("invalid continue token: %w", ErrInvalidFilterParams)
+
+// SortField is the field a List is ordered by, either SortByName,
+// SortByCreationTimestamp or a dot-separated JSON path, e.g. "spec.brand".
+type SortField string
+
+const (
+	// SortByName orders the Objects by their identifier in the storage, i.e. by namespace and
+	// then name for the runtime.Metav1NameIdentifier. This is the default ordering, and the only
+	// one that doesn't require decoding all Objects of the kind before returning the first one.
+	SortByName SortField = "name"
+	// SortByCreationTimestamp orders the Objects by .metadata.creationTimestamp.
+	SortByCreationTimestamp SortField = "metadata.creationTimestamp"
+)
+
+// SortBy implements ListOption.
+var _ ListOption = SortBy{}
+
+// SortBy is a ListOption ordering the Objects of a List. Objects with equal values, or
+// without the field, are ordered by name. Values of different types are ordered as:
+// missing < null < booleans < numbers < strings < lists and objects.
+type SortBy struct {
+	// Field is the field to order the Objects by. Default SortByName.
+	// +optional
+	Field SortField
+	// Descending reverses the order.
+	// +optional
+	Descending bool
+}
+
+// ApplyToListOptions implements ListOption, and sets ListOptions.SortBy.
+func (s SortBy) ApplyToListOptions(target *ListOptions) error {
+	if len(s.Field) == 0 {
+		s.Field = SortByName
+	}
+	target.SortBy = s
+	return nil
+}
+
+// ByName returns whether the Objects are ordered by name only, which doesn't require decoding them.
+func (s SortBy) ByName() bool {
+	return len(s.Field) == 0 || s.Field == SortByName
+}
+
+// MetaOnly returns whether the Objects are ordered by a field in their TypeMeta or ObjectMeta,
+// so that they can be ordered based on their runtime.PartialObjects.
+func (s SortBy) MetaOnly() bool {
+	return s.ByName() || isMetaPath(string(s.Field))
+}
+
+// KeyFor returns the SortKey of the given Object, stored with the given identifier.
+func (s SortBy) KeyFor(obj runtime.Object, identifier string) (SortKey, error) {
+	key := SortKey{ID: identifier}
+	if s.ByName() {
+		return key, nil
+	}
+
+	decoded, err := decodeJSON(obj)
+	if err != nil {
+		return SortKey{}, err
+	}
+	key.Value, key.hasValue = lookupField(decoded, splitPath(string(s.Field)))
+	return key, nil
+}
+
+// Compare returns -1 if a comes before b in the order, 1 if it comes after b, and 0 if they are equal.
+func (s SortBy) Compare(a, b SortKey) int {
+	c := compareValues(a.Value, a.hasValue, b.Value, b.hasValue)
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	if s.Descending {
+		return -c
+	}
+	return c
+}
+
+// SortKey is the position of an Object in the order of a SortBy.
+type SortKey struct {
+	// Value is the value of the sort field, as decoded from JSON.
+	Value interface{}
+	// ID is the identifier of the Object, which breaks ties between equal values.
+	ID string
+	// hasValue is false if the Object doesn't have the sort field
+	hasValue bool
+}
+
+// compareValues compares two values decoded from JSON, see SortBy for the order
+func compareValues(a interface{}, hasA bool, b interface{}, hasB bool) int {
+	rankA, rankB := valueRank(a, hasA), valueRank(b, hasB)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+
+	switch va := a.(type) {
+	case bool:
+		vb := b.(bool)
+		if va == vb {
+			return 0
+		} else if !va {
+			return -1
+		}
+		return 1
+	case float64:
+		vb := b.(float64)
+		if va < vb {
+			return -1
+		} else if va > vb {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(va, b.(string))
+	}
+	// Missing values, nulls, lists and objects are all equal
+	return 0
+}
+
+func valueRank(v interface{}, ok bool) int {
+	if !ok {
+		return 0
+	}
+	switch v.(type) {
+	case nil:
+		return 1
+	case bool:
+		return 2
+	case float64:
+		return 3
+	case string:
+		return 4
+	}
+	return 5
+}
+
+// continueToken is the encoded form of a continue token
+type continueToken struct {
+	Field      SortField   `json:"field"`
+	Descending bool        `json:"descending,omitempty"`
+	Value      interface{} `json:"value,omitempty"`
+	HasValue   bool        `json:"hasValue,omitempty"`
+	ID         string      `json:"id"`
+}
+
+// ContinueToken returns the continue token for listing the Objects after last, the key
+// of the last returned Object. The token can only be used with the same ordering.
+func (s SortBy) ContinueToken(last SortKey) string {
+	content, _ := json.Marshal(continueToken{
+		Field:      s.sortField(),
+		Descending: s.Descending,
+		Value:      last.Value,
+		HasValue:   last.hasValue,
+		ID:         last.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// ParseContinue decodes the given continue token into the SortKey of the last Object
+// of the previous page. ErrInvalidContinue is returned if the token is invalid, or was
+// created for another ordering.
+func (s SortBy) ParseContinue(token string) (SortKey, error) {
+	content, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return SortKey{}, fmt.Errorf("%v: %w", err, ErrInvalidContinue)
+	}
+	var t continueToken
+	if err := json.Unmarshal(content, &t); err != nil {
+		return SortKey{}, fmt.Errorf("%v: %w", err, ErrInvalidContinue)
+	}
+
+	if t.Field != s.sortField() || t.Descending != s.Descending {
+		return SortKey{}, fmt.Errorf("the token was created for another ordering: %w", ErrInvalidContinue)
+	}
+	return SortKey{Value: t.Value, ID: t.ID, hasValue: t.HasValue}, nil
+}
+
+func (s SortBy) sortField() SortField {
+	if s.ByName() {
+		return SortByName
+	}
+	return s.Field
+}
+
+// Limit implements ListOption.
+var _ ListOption = Limit(0)
+
+// Limit is a ListOption limiting the amount of Objects returned by a List. If there are more
+// Objects, the List returns a continue token for listing the rest, see Continue.
+type Limit int64
+
+// ApplyToListOptions implements ListOption, and sets ListOptions.Limit.
+func (l Limit) ApplyToListOptions(target *ListOptions) error {
+	if l < 0 {
+		return fmt.Errorf("the Limit must not be negative, got %d: %w", l, ErrInvalidFilterParams)
+	}
+	target.Limit = int64(l)
+	return nil
+}
+
+// Continue implements ListOption.
+var _ ListOption = Continue("")
+
+// Continue is a ListOption continuing a List after the last Object returned by a previous List
+// with a Limit. The pagination is based on the position of that Object in the order, and not on
+// an offset, so Objects created or deleted between the pages don't cause other Objects to be
+// skipped or returned twice. The ordering must be the same for all pages.
+type Continue string
+
+// ApplyToListOptions implements ListOption, and sets ListOptions.Continue.
+func (c Continue) ApplyToListOptions(target *ListOptions) error {
+	target.Continue = string(c)
+	return nil
+}
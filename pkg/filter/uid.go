@@ -15,8 +15,9 @@ var (
 	ErrInvalidFilterParams = errors.New("invalid parameters given to filter")
 )
 
-// UIDFilter implements ObjectFilter and ListOption.
+// UIDFilter implements ObjectFilter, MetaFilter and ListOption.
 var _ ObjectFilter = UIDFilter{}
+var _ MetaFilter = UIDFilter{}
 var _ ListOption = UIDFilter{}
 
 // UIDFilter is an ObjectFilter that compares runtime.Object.GetUID() to
@@ -45,6 +46,11 @@ func (f UIDFilter) Filter(obj runtime.Object) (bool, error) {
 	return f.UID == obj.GetUID(), nil
 }
 
+// MetaOnly implements MetaFilter, the UIDFilter only looks at metadata.
+func (f UIDFilter) MetaOnly() bool {
+	return true
+}
+
 // ApplyToListOptions implements ListOption, and adds itself converted to
 // a ListFilter to ListOptions.Filters.
 func (f UIDFilter) ApplyToListOptions(target *ListOptions) error {
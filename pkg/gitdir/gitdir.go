@@ -86,16 +86,24 @@ type GitDirectory interface {
 	// CheckoutNewBranch creates a new branch and checks out to it.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	CheckoutNewBranch(branchName string) error
+	// CheckoutBranch checks out an existing local branch.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	CheckoutBranch(branchName string) error
 	// CheckoutMainBranch goes back to the main branch.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	CheckoutMainBranch() error
+	// DeleteBranch deletes the given local branch. The main branch, and the
+	// currently checked out branch can't be deleted.
+	// ErrNotStarted is returned if the repo hasn't been cloned yet.
+	DeleteBranch(branchName string) error
 
 	// Commit creates a commit of all changes in the current worktree with the given parameters.
 	// It also automatically pushes the branch after the commit.
 	// ErrNotStarted is returned if the repo hasn't been cloned yet.
 	// ErrCannotWriteToReadOnly is returned if opts.AuthMethod wasn't provided.
 	Commit(ctx context.Context, authorName, authorEmail, msg string) error
-	// CommitChannel is a channel to where new observed Git SHAs are written.
+	// CommitChannel is a channel to where new observed Git SHAs are written. Only commits on the
+	// main branch are observed, commits on other branches get there once merged.
 	CommitChannel() chan string
 
 	// Cleanup terminates any pending operations, and removes the temporary directory.
@@ -120,9 +128,8 @@ func NewGitDirectory(repoRef gitprovider.RepositoryRef, opts GitDirectoryOptions
 		repoRef:             repoRef,
 		GitDirectoryOptions: opts,
 		cloneDir:            tmpDir,
-		// TODO: This needs to be large, otherwise it can start blocking unnecessarily if nobody reads it
-		commitChan: make(chan string, 1024),
-		lock:       &sync.Mutex{},
+		commitChan:          newCommitChan(),
+		lock:                &sync.Mutex{},
 	}
 	// Set up the parent context for this class. d.cancel() is called only at Cleanup()
 	d.ctx, d.cancel = context.WithCancel(context.Background())
@@ -254,7 +261,7 @@ func (d *gitDirectory) clone() error {
 
 	log.Infof("Starting to clone the repository %s with timeout %s", d.repoRef, d.Timeout)
 	// Do a clone operation to the temporary directory, with a timeout
-	err := d.contextWithTimeout(d.ctx, func(ctx context.Context) error {
+	err := contextWithTimeout(d.ctx, d.Timeout, func(ctx context.Context) error {
 		var err error
 		d.repo, err = git.PlainCloneContext(ctx, d.Dir(), false, &git.CloneOptions{
 			URL:           d.cloneURL(),
@@ -310,7 +317,7 @@ func (d *gitDirectory) Pull(ctx context.Context) error {
 	}
 
 	// Perform the git pull operation using the timeout
-	err := d.contextWithTimeout(ctx, func(innerCtx context.Context) error {
+	err := contextWithTimeout(ctx, d.Timeout, func(innerCtx context.Context) error {
 		log.Trace("checkoutLoop: Starting pull operation")
 		return d.wt.PullContext(innerCtx, &git.PullOptions{
 			Auth:         d.AuthMethod,
@@ -353,58 +360,107 @@ func (d *gitDirectory) CheckoutNewBranch(branchName string) error {
 		return err
 	}
 
-	return d.wt.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(branchName),
-		Create: true,
-	})
+	return checkoutBranch(d.wt, branchName, true)
 }
 
-func (d *gitDirectory) CheckoutMainBranch() error {
+func (d *gitDirectory) CheckoutBranch(branchName string) error {
 	// Make sure it's okay to write
 	if err := d.verifyWrite(); err != nil {
 		return err
 	}
 
-	// Best-effort clean
-	_ = d.wt.Clean(&git.CleanOptions{
-		Dir: true,
-	})
-	// Force-checkout the main branch
-	return d.wt.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(d.Branch),
-		Force:  true,
-	})
+	return checkoutBranch(d.wt, branchName, false)
 }
 
-// observeCommit sets the lastCommit variable so that we know the latest state
-func (d *gitDirectory) observeCommit(commit plumbing.Hash) {
-	d.lastCommit = commit.String()
-	d.commitChan <- commit.String()
-	log.Infof("New commit observed on branch %q: %s", d.Branch, commit)
+func (d *gitDirectory) CheckoutMainBranch() error {
+	// Make sure it's okay to write
+	if err := d.verifyWrite(); err != nil {
+		return err
+	}
+
+	return checkoutMainBranch(d.wt, d.Branch)
 }
 
-// Commit creates a commit of all changes in the current worktree with the given parameters.
-// It also automatically pushes the branch after the commit.
-// ErrNotStarted is returned if the repo hasn't been cloned yet.
-// ErrCannotWriteToReadOnly is returned if opts.AuthMethod wasn't provided.
-func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg string) error {
+func (d *gitDirectory) DeleteBranch(branchName string) error {
 	// Make sure it's okay to write
 	if err := d.verifyWrite(); err != nil {
 		return err
 	}
 
-	s, err := d.wt.Status()
+	return deleteBranch(d.repo, d.Branch, branchName)
+}
+
+// deleteBranch deletes the local branch with the given name, unless it's the main or checked out one
+func deleteBranch(repo *git.Repository, mainBranch, branchName string) error {
+	refName := plumbing.NewBranchReferenceName(branchName)
+	if branchName == mainBranch {
+		return fmt.Errorf("cannot delete the main branch %q", branchName)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if head.Name() == refName {
+		return fmt.Errorf("cannot delete the checked out branch %q", branchName)
+	}
+
+	return repo.Storer.RemoveReference(refName)
+}
+
+// isMainBranch returns whether the main branch is checked out in repo
+func isMainBranch(repo *git.Repository, mainBranch string) (bool, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return false, err
+	}
+	return head.Name() == plumbing.NewBranchReferenceName(mainBranch), nil
+}
+
+// newCommitChan creates the channel observed commits are sent to
+func newCommitChan() chan string {
+	// TODO: This needs to be large, otherwise it can start blocking unnecessarily if nobody reads it
+	return make(chan string, 1024)
+}
+
+// checkoutBranch checks out the given branch in wt. If create is true, the branch is created
+// from HEAD first.
+func checkoutBranch(wt *git.Worktree, branchName string, create bool) error {
+	return wt.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branchName),
+		Create: create,
+	})
+}
+
+// checkoutMainBranch discards the changes in wt, and checks out the main branch
+func checkoutMainBranch(wt *git.Worktree, mainBranch string) error {
+	// Best-effort clean
+	_ = wt.Clean(&git.CleanOptions{
+		Dir: true,
+	})
+	// Force-checkout the main branch
+	return wt.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(mainBranch),
+		Force:  true,
+	})
+}
+
+// commitAll stages all changes in wt, including new files, and commits them with the given
+// author and message. If there are no changes, nothing is committed and plumbing.ZeroHash is returned.
+func commitAll(wt *git.Worktree, authorName, authorEmail, msg string) (plumbing.Hash, error) {
+	s, err := wt.Status()
 	if err != nil {
-		return fmt.Errorf("git status failed: %v", err)
+		return plumbing.ZeroHash, fmt.Errorf("git status failed: %v", err)
 	}
 	if s.IsClean() {
 		log.Debugf("No changed files in git repo, nothing to commit...")
-		return nil
+		return plumbing.ZeroHash, nil
 	}
 
-	// Do a commit and push
 	log.Debug("commitLoop: Committing all local changes")
-	hash, err := d.wt.Commit(msg, &git.CommitOptions{
+	if _, err := wt.Add("."); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("git add error: %v", err)
+	}
+	hash, err := wt.Commit(msg, &git.CommitOptions{
 		All: true,
 		Author: &object.Signature{
 			Name:  authorName,
@@ -413,39 +469,77 @@ func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("git commit error: %v", err)
+		return plumbing.ZeroHash, fmt.Errorf("git commit error: %v", err)
 	}
+	return hash, nil
+}
 
+// push pushes to the remote using the given options and timeout. A cancelled context isn't
+// treated as an error, as that only happens on Cleanup().
+func push(ctx context.Context, repo *git.Repository, opts *git.PushOptions, timeout time.Duration) error {
 	// Perform the git push operation using the timeout
-	err = d.contextWithTimeout(ctx, func(innerCtx context.Context) error {
+	err := contextWithTimeout(ctx, timeout, func(innerCtx context.Context) error {
 		log.Debug("commitLoop: Will push with timeout")
-		return d.repo.PushContext(innerCtx, &git.PushOptions{
-			Auth: d.AuthMethod,
-		})
+		return repo.PushContext(innerCtx, opts)
 	})
 	// Handle errors
 	switch err {
 	case nil, git.NoErrAlreadyUpToDate:
 		// no-op, just continue. Allow the git.NoErrAlreadyUpToDate error
+		return nil
 	case context.DeadlineExceeded:
-		return fmt.Errorf("git push operation took longer than deadline %s", d.Timeout)
+		return fmt.Errorf("git push operation took longer than deadline %s", timeout)
 	case context.Canceled:
 		log.Tracef("context was cancelled")
-		return nil // if Cleanup() was called, just exit the goroutine
+		return nil
 	default:
 		return fmt.Errorf("failed to push: %v", err)
 	}
+}
+
+// observeCommit sets the lastCommit variable so that we know the latest state
+func (d *gitDirectory) observeCommit(commit plumbing.Hash) {
+	d.lastCommit = commit.String()
+	d.commitChan <- commit.String()
+	log.Infof("New commit observed on branch %q: %s", d.Branch, commit)
+}
+
+// Commit creates a commit of all changes in the current worktree with the given parameters.
+// It also automatically pushes the branch after the commit.
+// ErrNotStarted is returned if the repo hasn't been cloned yet.
+// ErrCannotWriteToReadOnly is returned if opts.AuthMethod wasn't provided.
+func (d *gitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg string) error {
+	// Make sure it's okay to write
+	if err := d.verifyWrite(); err != nil {
+		return err
+	}
+
+	hash, err := commitAll(d.wt, authorName, authorEmail, msg)
+	if err != nil || hash.IsZero() {
+		return err
+	}
+
+	if err := push(ctx, d.repo, &git.PushOptions{Auth: d.AuthMethod}, d.Timeout); err != nil {
+		return err
+	}
 
-	// Notify upstream that we now have a new commit, and allow writing again
 	log.Infof("A new commit with the actual state has been created and pushed to the origin: %q", hash)
-	d.observeCommit(hash)
+	// Notify upstream that we now have a new commit, if it's on the main branch
+	onMain, err := isMainBranch(d.repo, d.Branch)
+	if err != nil {
+		return err
+	}
+	if onMain {
+		d.observeCommit(hash)
+	}
 	return nil
 }
 
-func (d *gitDirectory) contextWithTimeout(ctx context.Context, fn func(context.Context) error) error {
-	// Create a new context with a timeout. The push operation either succeeds in time, times out,
+// contextWithTimeout runs fn with a context derived from ctx, which times out after the given timeout
+func contextWithTimeout(ctx context.Context, timeout time.Duration, fn func(context.Context) error) error {
+	// Create a new context with a timeout. The operation either succeeds in time, times out,
 	// or is cancelled by Cleanup(). In case of a successful run, the context is always cancelled afterwards.
-	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// Run the function using the context and cancel directly afterwards
@@ -0,0 +1,415 @@
+package gitdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	defaultAuthorName  = "libgitops"
+	defaultAuthorEmail = "libgitops@weave.works"
+)
+
+// LocalGitDirectoryOptions provides options for the localGitDirectory.
+type LocalGitDirectoryOptions struct {
+	// Options
+	Branch   string        // default "master"
+	Interval time.Duration // default 30s
+	Timeout  time.Duration // default 1m
+
+	// RemoteURL optionally specifies a remote (e.g. file:///path/to/bare.git) to pull from and push
+	// to, registered as "origin". Local remotes need the git binary to be available in $PATH.
+	// +optional
+	RemoteURL string
+}
+
+func (o *LocalGitDirectoryOptions) Default() {
+	if o.Branch == "" {
+		o.Branch = defaultBranch
+	}
+	if o.Interval == 0 {
+		o.Interval = defaultInterval
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+}
+
+// NewLocalGitDirectory creates a GitDirectory for the on-disk repository in dir. If dir isn't a Git
+// repository yet, it is initialized with an empty commit on the main branch when StartCheckoutLoop
+// is run. Commits are only kept locally, unless opts.RemoteURL is set. As opposed to NewGitDirectory,
+// the directory is owned by the caller, and hence not removed on Cleanup.
+func NewLocalGitDirectory(dir string, opts LocalGitDirectoryOptions) (GitDirectory, error) {
+	if len(dir) == 0 {
+		return nil, errors.New("the directory of the local Git repository must be set")
+	}
+
+	// Default the options
+	opts.Default()
+
+	d := &localGitDirectory{
+		LocalGitDirectoryOptions: opts,
+		dir:                      dir,
+		commitChan:               newCommitChan(),
+		lock:                     &sync.Mutex{},
+	}
+	// Set up the parent context for this class. d.cancel() is called only at Cleanup()
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d, nil
+}
+
+// localGitDirectory is a GitDirectory for an existing or new on-disk repository
+type localGitDirectory struct {
+	// user-specified options
+	LocalGitDirectoryOptions
+
+	// the directory of the repository
+	dir string
+
+	// go-git objects. wt is the worktree of the repo, persistent during the lifetime of repo.
+	repo *git.Repository
+	wt   *git.Worktree
+
+	// latest known commit to the system
+	lastCommit string
+	// events channel from new commits
+	commitChan chan string
+
+	// the context and its cancel function for the lifetime of this struct (until Cleanup())
+	ctx    context.Context
+	cancel context.CancelFunc
+	// the lock for git operations (so pushing and pulling aren't done simultaneously)
+	lock *sync.Mutex
+}
+
+func (d *localGitDirectory) Dir() string {
+	return d.dir
+}
+
+func (d *localGitDirectory) MainBranch() string {
+	return d.Branch
+}
+
+// RepositoryRef returns nil, as a local repository isn't hosted by a Git provider.
+func (d *localGitDirectory) RepositoryRef() gitprovider.RepositoryRef {
+	return nil
+}
+
+// StartCheckoutLoop opens or initializes the repository synchronously, and then starts the
+// checkout loop non-blocking. If the checkout loop has been started already, this is a no-op.
+func (d *localGitDirectory) StartCheckoutLoop() error {
+	if d.wt != nil {
+		return nil // already initialized
+	}
+	if err := d.open(); err != nil {
+		return err
+	}
+	go d.checkoutLoop()
+	return nil
+}
+
+func (d *localGitDirectory) Suspend() {
+	d.lock.Lock()
+}
+
+func (d *localGitDirectory) Resume() {
+	d.lock.Unlock()
+}
+
+func (d *localGitDirectory) CommitChannel() chan string {
+	return d.commitChan
+}
+
+func (d *localGitDirectory) checkoutLoop() {
+	log.Info("Starting the checkout loop...")
+
+	wait.NonSlidingUntilWithContext(d.ctx, func(_ context.Context) {
+
+		log.Trace("checkoutLoop: Will perform pull operation")
+		// Pull from the remote, or observe new local commits
+		if err := d.Pull(d.ctx); err != nil {
+			log.Errorf("checkoutLoop: git pull failed with error: %v", err)
+			return
+		}
+
+	}, d.Interval)
+	log.Info("Exiting the checkout loop...")
+}
+
+func (d *localGitDirectory) open() error {
+	// Lock the mutex now that we're starting, and unlock it when exiting
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var err error
+	d.repo, err = git.PlainOpen(d.dir)
+	switch err {
+	case nil:
+		log.Infof("Opened the Git repository at %q", d.dir)
+	case git.ErrRepositoryNotExists:
+		if d.repo, err = d.cloneOrInit(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("git open error: %v", err)
+	}
+
+	// Populate the worktree pointer
+	d.wt, err = d.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("git get worktree error: %v", err)
+	}
+
+	if len(d.RemoteURL) != 0 {
+		if err := d.ensureRemote(); err != nil {
+			return err
+		}
+	}
+
+	// Get the latest HEAD commit and report it to the user. A new
+	// repository doesn't have one, hence create an empty commit.
+	ref, err := d.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		ref, err = d.initialCommit()
+	}
+	if err != nil {
+		return err
+	}
+
+	d.observeCommit(ref.Hash())
+	return nil
+}
+
+// cloneOrInit clones the remote if set and non-empty, otherwise initializes a new repository
+func (d *localGitDirectory) cloneOrInit() (*git.Repository, error) {
+	if len(d.RemoteURL) != 0 {
+		log.Infof("Cloning %q to %q", d.RemoteURL, d.dir)
+		var repo *git.Repository
+		err := contextWithTimeout(d.ctx, d.Timeout, func(ctx context.Context) error {
+			var err error
+			repo, err = git.PlainCloneContext(ctx, d.dir, false, &git.CloneOptions{
+				URL:           d.RemoteURL,
+				RemoteName:    defaultRemote,
+				ReferenceName: plumbing.NewBranchReferenceName(d.Branch),
+				SingleBranch:  true,
+			})
+			return err
+		})
+		if err != transport.ErrEmptyRemoteRepository {
+			if err != nil {
+				return nil, fmt.Errorf("git clone error: %v", err)
+			}
+			return repo, nil
+		}
+		// An empty remote can't be cloned, but is registered in open()
+		_ = os.RemoveAll(filepath.Join(d.dir, git.GitDirName))
+	}
+
+	log.Infof("Initializing a new Git repository at %q", d.dir)
+	repo, err := git.PlainInit(d.dir, false)
+	if err != nil {
+		return nil, fmt.Errorf("git init error: %v", err)
+	}
+	return repo, nil
+}
+
+// ensureRemote registers RemoteURL as the "origin" remote, if it isn't registered already
+func (d *localGitDirectory) ensureRemote() error {
+	remote, err := d.repo.Remote(defaultRemote)
+	if err == git.ErrRemoteNotFound {
+		_, err = d.repo.CreateRemote(&config.RemoteConfig{
+			Name: defaultRemote,
+			URLs: []string{d.RemoteURL},
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != d.RemoteURL {
+		return fmt.Errorf("remote %q is already registered with URLs %v", defaultRemote, urls)
+	}
+	return nil
+}
+
+// initialCommit creates an empty commit on the main branch of a new repository
+func (d *localGitDirectory) initialCommit() (*plumbing.Reference, error) {
+	// Point HEAD to the configured main branch before the first commit creates it
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(d.Branch))
+	if err := d.repo.Storer.SetReference(head); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.wt.Commit("Initialize repository", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  defaultAuthorName,
+			Email: defaultAuthorEmail,
+			When:  time.Now(),
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("git commit error: %v", err)
+	}
+
+	return d.repo.Head()
+}
+
+// Pull performs a pull & checkout to the latest revision, if a remote is configured. Without a remote,
+// it only observes commits made to the repository by others, e.g. using the git CLI.
+// ErrNotStarted is returned if the repo hasn't been opened yet.
+func (d *localGitDirectory) Pull(ctx context.Context) error {
+	// Lock the mutex now that we're starting, and unlock it when exiting
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Make sure it's okay to read
+	if d.wt == nil {
+		return fmt.Errorf("cannot pull: %w", ErrNotStarted)
+	}
+
+	if len(d.RemoteURL) != 0 {
+		// Perform the git pull operation using the timeout
+		err := contextWithTimeout(ctx, d.Timeout, func(innerCtx context.Context) error {
+			log.Trace("checkoutLoop: Starting pull operation")
+			return d.wt.PullContext(innerCtx, &git.PullOptions{
+				RemoteName:    defaultRemote,
+				ReferenceName: plumbing.NewBranchReferenceName(d.Branch),
+				SingleBranch:  true,
+			})
+		})
+		// Handle errors
+		switch err {
+		case nil, git.NoErrAlreadyUpToDate, transport.ErrEmptyRemoteRepository:
+			// no-op, just continue. Allow the git.NoErrAlreadyUpToDate error, and
+			// an empty remote, which gets the main branch on the first push
+		case context.DeadlineExceeded:
+			return fmt.Errorf("git pull operation took longer than deadline %s", d.Timeout)
+		case context.Canceled:
+			log.Tracef("context was cancelled")
+			return nil // if Cleanup() was called, just exit the goroutine
+		default:
+			return fmt.Errorf("failed to pull: %v", err)
+		}
+
+		log.Trace("checkoutLoop: Pulled successfully")
+	}
+
+	// get current head
+	ref, err := d.repo.Head()
+	if err != nil {
+		return err
+	}
+
+	// check if we changed commits
+	if d.lastCommit != ref.Hash().String() {
+		// Notify upstream that we now have a new commit
+		d.observeCommit(ref.Hash())
+	}
+
+	return nil
+}
+
+func (d *localGitDirectory) CheckoutNewBranch(branchName string) error {
+	if d.wt == nil {
+		return fmt.Errorf("cannot checkout: %w", ErrNotStarted)
+	}
+
+	return checkoutBranch(d.wt, branchName, true)
+}
+
+func (d *localGitDirectory) CheckoutBranch(branchName string) error {
+	if d.wt == nil {
+		return fmt.Errorf("cannot checkout: %w", ErrNotStarted)
+	}
+
+	return checkoutBranch(d.wt, branchName, false)
+}
+
+func (d *localGitDirectory) CheckoutMainBranch() error {
+	if d.wt == nil {
+		return fmt.Errorf("cannot checkout: %w", ErrNotStarted)
+	}
+
+	return checkoutMainBranch(d.wt, d.Branch)
+}
+
+func (d *localGitDirectory) DeleteBranch(branchName string) error {
+	if d.wt == nil {
+		return fmt.Errorf("cannot delete branch: %w", ErrNotStarted)
+	}
+
+	return deleteBranch(d.repo, d.Branch, branchName)
+}
+
+// observeCommit sets the lastCommit variable so that we know the latest state
+func (d *localGitDirectory) observeCommit(commit plumbing.Hash) {
+	d.lastCommit = commit.String()
+	d.commitChan <- commit.String()
+	log.Infof("New commit observed on branch %q: %s", d.Branch, commit)
+}
+
+// Commit creates a commit of all changes in the current worktree with the given parameters.
+// If a remote is configured, the branch is pushed after the commit.
+// ErrNotStarted is returned if the repo hasn't been opened yet.
+func (d *localGitDirectory) Commit(ctx context.Context, authorName, authorEmail, msg string) error {
+	if d.wt == nil {
+		return fmt.Errorf("cannot commit: %w", ErrNotStarted)
+	}
+
+	hash, err := commitAll(d.wt, authorName, authorEmail, msg)
+	if err != nil || hash.IsZero() {
+		return err
+	}
+
+	if len(d.RemoteURL) != 0 {
+		if err := d.push(ctx); err != nil {
+			return err
+		}
+		log.Infof("A new commit has been created and pushed to the remote: %q", hash)
+	} else {
+		log.Infof("A new commit has been created: %q", hash)
+	}
+
+	// Notify upstream that we now have a new commit, if it's on the main branch
+	onMain, err := isMainBranch(d.repo, d.Branch)
+	if err != nil {
+		return err
+	}
+	if onMain {
+		d.observeCommit(hash)
+	}
+	return nil
+}
+
+// push pushes the current branch to the remote
+func (d *localGitDirectory) push(ctx context.Context) error {
+	head, err := d.repo.Head()
+	if err != nil {
+		return err
+	}
+	refSpec := config.RefSpec(fmt.Sprintf("%s:%s", head.Name(), head.Name()))
+
+	return push(ctx, d.repo, &git.PushOptions{
+		RemoteName: defaultRemote,
+		RefSpecs:   []config.RefSpec{refSpec},
+	}, d.Timeout)
+}
+
+// Cleanup cancels running goroutines and operations. The repository directory is left as-is.
+func (d *localGitDirectory) Cleanup() error {
+	d.cancel()
+	return nil
+}
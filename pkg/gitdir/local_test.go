@@ -0,0 +1,154 @@
+package gitdir
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "libgitops-gitdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// startLocal starts a new local GitDirectory, and returns the initial commit
+func startLocal(t *testing.T, dir string, opts LocalGitDirectoryOptions) (GitDirectory, string) {
+	t.Helper()
+	d, err := NewLocalGitDirectory(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StartCheckoutLoop(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Cleanup() })
+	return d, nextCommit(t, d)
+}
+
+func nextCommit(t *testing.T, d GitDirectory) string {
+	t.Helper()
+	select {
+	case commit := <-d.CommitChannel():
+		return commit
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a commit")
+		return ""
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commit commits all changes, suspending the checkout loop like a transaction does
+func commit(t *testing.T, d GitDirectory, msg string) {
+	t.Helper()
+	d.Suspend()
+	defer d.Resume()
+	if err := d.Commit(context.Background(), "test", "test@example.com", msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalGitDirectory(t *testing.T) {
+	dir := tempDir(t)
+	d, initial := startLocal(t, dir, LocalGitDirectoryOptions{Branch: "main"})
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name() != plumbing.NewBranchReferenceName("main") || head.Hash().String() != initial {
+		t.Fatalf("expected the initial commit %s on main, got %s on %s", initial, head.Hash(), head.Name())
+	}
+
+	// Nothing to commit
+	commit(t, d, "noop")
+
+	// New files are committed
+	writeFile(t, dir, "foo.yaml", "foo")
+	commit(t, d, "Add foo")
+	added := nextCommit(t, d)
+	c, err := repo.CommitObject(plumbing.NewHash(added))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Message != "Add foo" {
+		t.Errorf("unexpected commit message %q", c.Message)
+	}
+	if _, err := c.File("foo.yaml"); err != nil {
+		t.Errorf("expected foo.yaml to be committed: %v", err)
+	}
+
+	// Reopening the repository observes the latest commit
+	if err := d.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, reopened := startLocal(t, dir, LocalGitDirectoryOptions{Branch: "main"}); reopened != added {
+		t.Errorf("expected %s after reopening, got %s", added, reopened)
+	}
+}
+
+func TestLocalGitDirectory_Remote(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("the git binary is needed for file:// remotes")
+	}
+	ctx := context.Background()
+
+	remoteDir := tempDir(t)
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatal(err)
+	}
+	opts := LocalGitDirectoryOptions{RemoteURL: "file://" + remoteDir}
+
+	// The first directory initializes the empty remote
+	dir1 := tempDir(t)
+	d1, _ := startLocal(t, dir1, opts)
+	writeFile(t, dir1, "foo.yaml", "foo")
+	commit(t, d1, "Add foo")
+	pushed := nextCommit(t, d1)
+
+	// The second directory clones it
+	dir2 := tempDir(t)
+	d2, cloned := startLocal(t, dir2, opts)
+	if cloned != pushed {
+		t.Fatalf("expected the clone to be at %s, got %s", pushed, cloned)
+	}
+
+	// Changes pushed from the second directory are pulled by the first
+	writeFile(t, dir2, "foo.yaml", "bar")
+	commit(t, d2, "Update foo")
+	updated := nextCommit(t, d2)
+	if err := d1.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pulled := nextCommit(t, d1); pulled != updated {
+		t.Errorf("expected to pull %s, got %s", updated, pulled)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir1, "foo.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "bar" {
+		t.Errorf("unexpected content after pull: %q", content)
+	}
+}
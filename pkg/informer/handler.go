@@ -0,0 +1,49 @@
+package informer
+
+import (
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// ResourceEventHandler handles the changes of the Objects an Informer observes. The handlers of
+// an Informer are called sequentially, in the order of the changes. The Objects are shared with
+// the Informer's Store, and must not be modified.
+type ResourceEventHandler interface {
+	// OnAdd is called for every Object in the initial List, and for every Object created later.
+	OnAdd(obj runtime.Object)
+	// OnUpdate is called when an Object has changed. On a resync, it's called
+	// for every Object in the Store, with oldObj and newObj being the same.
+	OnUpdate(oldObj, newObj runtime.Object)
+	// OnDelete is called with the last known state of a deleted Object.
+	OnDelete(obj runtime.Object)
+}
+
+// ResourceEventHandlerFuncs implements ResourceEventHandler.
+var _ ResourceEventHandler = ResourceEventHandlerFuncs{}
+
+// ResourceEventHandlerFuncs is a ResourceEventHandler calling the functions that are set.
+type ResourceEventHandlerFuncs struct {
+	// +optional
+	AddFunc func(obj runtime.Object)
+	// +optional
+	UpdateFunc func(oldObj, newObj runtime.Object)
+	// +optional
+	DeleteFunc func(obj runtime.Object)
+}
+
+func (f ResourceEventHandlerFuncs) OnAdd(obj runtime.Object) {
+	if f.AddFunc != nil {
+		f.AddFunc(obj)
+	}
+}
+
+func (f ResourceEventHandlerFuncs) OnUpdate(oldObj, newObj runtime.Object) {
+	if f.UpdateFunc != nil {
+		f.UpdateFunc(oldObj, newObj)
+	}
+}
+
+func (f ResourceEventHandlerFuncs) OnDelete(obj runtime.Object) {
+	if f.DeleteFunc != nil {
+		f.DeleteFunc(obj)
+	}
+}
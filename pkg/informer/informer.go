@@ -0,0 +1,236 @@
+package informer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// waitForSyncInterval is how often WaitForSync checks whether the Informers have synced
+const waitForSyncInterval = 100 * time.Millisecond
+
+// Informer keeps a local Store of the Objects of one kind in an update.EventStorage up
+// to date, and notifies its ResourceEventHandlers of the changes. It lists the Objects
+// once when started, and relies on the updates of the EventStorage afterwards.
+type Informer interface {
+	// AddEventHandler registers a handler for the changes of the Objects. If the Informer has
+	// synced already, the handler is called with OnAdd for every Object in the Store first.
+	AddEventHandler(handler ResourceEventHandler)
+	// GetStore returns the local Store of the Informer.
+	GetStore() Store
+	// Run lists the Objects, and then handles the updates of the EventStorage until the context
+	// is cancelled or the EventStorage is closed. Run blocks, and must only be called once.
+	Run(ctx context.Context) error
+	// HasSynced returns true when the initial List is in the Store, and the
+	// handlers registered before Run have been called for all Objects in it.
+	HasSynced() bool
+}
+
+// InformerOptions configures an Informer.
+type InformerOptions struct {
+	// Kind is the kind of the Objects to observe. The Objects are decoded into its version.
+	// +required
+	Kind storage.KindKey
+	// ResyncPeriod is how often the handlers' OnUpdate is called for all Objects in the
+	// Store, for periodically reconciling them. Default 0, which disables resyncs.
+	// +optional
+	ResyncPeriod time.Duration
+}
+
+type informer struct {
+	es    update.EventStorage
+	opts  InformerOptions
+	store *store
+
+	// mux serializes changing the Store and calling the handlers,
+	// so the handlers see the changes in order
+	mux      sync.Mutex
+	handlers []ResourceEventHandler
+	// synced is closed when the initial List has been handled
+	synced chan struct{}
+}
+
+// NewInformer returns an Informer for the Objects of opts.Kind in the given EventStorage.
+func NewInformer(es update.EventStorage, opts InformerOptions) (Informer, error) {
+	if opts.Kind == nil {
+		return nil, fmt.Errorf("NewInformer: InformerOptions.Kind is required")
+	}
+
+	return &informer{
+		es:     es,
+		opts:   opts,
+		store:  newStore(),
+		synced: make(chan struct{}),
+	}, nil
+}
+
+func (i *informer) AddEventHandler(handler ResourceEventHandler) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	if i.HasSynced() {
+		for _, identifier := range i.store.ListIdentifiers() {
+			obj, _ := i.store.Get(identifier) // The Store only changes while holding i.mux
+			handler.OnAdd(obj)
+		}
+	}
+	i.handlers = append(i.handlers, handler)
+}
+
+func (i *informer) GetStore() Store {
+	return i.store
+}
+
+func (i *informer) HasSynced() bool {
+	select {
+	case <-i.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+func (i *informer) Run(ctx context.Context) error {
+	// Subscribe before listing, so that no change after the List is missed
+	sub := i.es.Subscribe(update.SubscribeOptions{Kind: i.opts.Kind})
+	defer sub.Unsubscribe()
+
+	if err := i.list(ctx); err != nil {
+		return err
+	}
+
+	var resync <-chan time.Time
+	if i.opts.ResyncPeriod > 0 {
+		ticker := time.NewTicker(i.opts.ResyncPeriod)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case upd, ok := <-sub.Updates():
+			if !ok {
+				return nil // The EventStorage has been closed
+			}
+			if err := i.handle(ctx, upd); err != nil {
+				log.Warnf("Informer: Ignoring %s update for %v: %v", upd.Event, upd.ObjectKey, err)
+			}
+		case <-resync:
+			i.resync()
+		}
+	}
+}
+
+// list fills the Store with the initial List of the Objects
+func (i *informer) list(ctx context.Context) error {
+	objs, err := i.es.List(ctx, i.opts.Kind)
+	if err != nil {
+		return err
+	}
+
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	for _, obj := range objs {
+		key, err := i.es.ObjectKeyFor(obj)
+		if err != nil {
+			return err
+		}
+		i.store.set(key.GetIdentifier(), obj)
+		for _, handler := range i.handlers {
+			handler.OnAdd(obj)
+		}
+	}
+	close(i.synced)
+	return nil
+}
+
+// handle applies the update to the Store, and notifies the handlers. The updates only carry the
+// metadata of the Objects, so the Objects are read from the EventStorage. Updates for changes
+// that are already in the Store, e.g. as they happened before the initial List, are skipped.
+func (i *informer) handle(ctx context.Context, upd update.Update) error {
+	identifier := upd.ObjectKey.GetIdentifier()
+	if upd.Event == update.ObjectEventDelete {
+		i.delete(identifier)
+		return nil
+	}
+
+	obj, err := i.es.Get(ctx, storage.NewObjectKey(i.opts.Kind, runtime.NewIdentifier(identifier)))
+	if errors.Is(err, storage.ErrNotFound) {
+		// The Object has been deleted after the update was sent
+		i.delete(identifier)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	old := i.store.set(identifier, obj)
+	for _, handler := range i.handlers {
+		if old == nil {
+			handler.OnAdd(obj)
+		} else if old.GetResourceVersion() != obj.GetResourceVersion() {
+			handler.OnUpdate(old, obj)
+		}
+	}
+	return nil
+}
+
+// delete removes the Object from the Store, and notifies the handlers if it was known
+func (i *informer) delete(identifier string) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	if old := i.store.delete(identifier); old != nil {
+		for _, handler := range i.handlers {
+			handler.OnDelete(old)
+		}
+	}
+}
+
+// resync calls OnUpdate for all Objects in the Store
+func (i *informer) resync() {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	for _, identifier := range i.store.ListIdentifiers() {
+		obj, _ := i.store.Get(identifier)
+		for _, handler := range i.handlers {
+			handler.OnUpdate(obj, obj)
+		}
+	}
+}
+
+// WaitForSync blocks until all given Informers have synced, and returns true. If
+// the context is cancelled before, false is returned.
+func WaitForSync(ctx context.Context, informers ...Informer) bool {
+	ticker := time.NewTicker(waitForSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		synced := true
+		for _, i := range informers {
+			synced = synced && i.HasSynced()
+		}
+		if synced {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package informer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/memory"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+)
+
+// recorder is a ResourceEventHandler sending a description of every call to events
+func recorder(events chan<- string) ResourceEventHandler {
+	return ResourceEventHandlerFuncs{
+		AddFunc: func(obj runtime.Object) {
+			events <- fmt.Sprintf("add %s %s", obj.GetName(), obj.GetLabels()["color"])
+		},
+		UpdateFunc: func(oldObj, newObj runtime.Object) {
+			events <- fmt.Sprintf("update %s %s->%s", newObj.GetName(), oldObj.GetLabels()["color"], newObj.GetLabels()["color"])
+		},
+		DeleteFunc: func(obj runtime.Object) {
+			events <- fmt.Sprintf("delete %s %s", obj.GetName(), obj.GetLabels()["color"])
+		},
+	}
+}
+
+func expectEvents(t *testing.T, events <-chan string, want ...string) {
+	t.Helper()
+	got := make([]string, 0, len(want))
+	for len(got) < len(want) {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out, expected %v, got %v", want, got)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+// startInformer runs an Informer for Cars in es, with the handler registered before starting it
+func startInformer(t *testing.T, s storage.Storage, opts InformerOptions, handler ResourceEventHandler) Informer {
+	t.Helper()
+	es, err := memory.NewMemoryEventStorage(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Kind = storagetest.CarKind
+	i, err := NewInformer(es, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.AddEventHandler(handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- i.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	if !WaitForSync(waitCtx, i) {
+		t.Fatal("timed out waiting for the Informer to sync")
+	}
+	return i
+}
+
+func TestInformer(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers)
+	if err := s.Create(ctx, storagetest.NewColoredCar("car-0", "red")); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan string, 10)
+	i := startInformer(t, s, InformerOptions{}, recorder(events))
+	expectEvents(t, events, "add car-0 red")
+
+	// The Objects are read when handling the updates, so wait for each change to be handled
+	if err := s.Create(ctx, storagetest.NewColoredCar("car-1", "red")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, "add car-1 red")
+	car, err := s.Get(ctx, storagetest.CarKey("car-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	car.SetLabels(map[string]string{"color": "blue"})
+	if err := s.Update(ctx, car); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, "update car-0 red->blue")
+	if err := s.Delete(ctx, storagetest.CarKey("car-1")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, "delete car-1 red")
+
+	// Handlers added later get the current state
+	late := make(chan string, 10)
+	i.AddEventHandler(recorder(late))
+	expectEvents(t, late, "add car-0 blue")
+
+	store := i.GetStore()
+	if _, err := store.Get("default/car-1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for the deleted Car, got %v", err)
+	}
+	blue, err := filter.ParseLabelFilter("color=blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objs, err := store.List(blue); err != nil || len(objs) != 1 || objs[0].GetName() != "car-0" {
+		t.Errorf("expected the blue Car car-0, got %v (%v)", objs, err)
+	}
+}
+
+func TestInformer_Resync(t *testing.T) {
+	s := memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers)
+	if err := s.Create(context.Background(), storagetest.NewColoredCar("car-0", "red")); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan string, 10)
+	startInformer(t, s, InformerOptions{ResyncPeriod: 10 * time.Millisecond}, recorder(events))
+	expectEvents(t, events, "add car-0 red", "update car-0 red->red", "update car-0 red->red")
+}
+
+func TestNewInformer_NoKind(t *testing.T) {
+	es, err := memory.NewMemoryEventStorage(memory.NewMemoryStorage(scheme.Serializer, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewInformer(es, InformerOptions{}); err == nil {
+		t.Error("expected an error without a Kind")
+	}
+}
@@ -0,0 +1,68 @@
+// +build ignore
+
+/*
+	Note: This file is autogenerated! Do not edit it manually!
+	Edit the lister template in pkg/informer instead, and run
+	hack/generate-client.sh afterwards.
+*/
+
+package listers
+
+import (
+	"fmt"
+
+	api "API_DIR"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/informer"
+)
+
+// ResourceLister lists Resources from the Store of an Informer. The returned
+// Resources are shared with the Store, and must not be modified.
+type ResourceLister interface {
+	// Get returns the Resource with the given identifier, e.g. "namespace/name".
+	// If it doesn't exist, an error wrapping storage.ErrNotFound is returned.
+	Get(identifier string) (*api.Resource, error)
+	// List lists the Resources, optionally filtered and ordered by the given options
+	List(opts ...filter.ListOption) ([]*api.Resource, error)
+}
+
+// NewResourceLister returns a ResourceLister for the Store of an Informer of Resources
+func NewResourceLister(store informer.Store) ResourceLister {
+	return &resourceLister{store: store}
+}
+
+// resourceLister is a struct implementing the ResourceLister interface
+type resourceLister struct {
+	store informer.Store
+}
+
+func (l *resourceLister) Get(identifier string) (*api.Resource, error) {
+	obj, err := l.store.Get(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := obj.(*api.Resource)
+	if !ok {
+		return nil, fmt.Errorf("expected a *Resource, got %T", obj)
+	}
+	return result, nil
+}
+
+func (l *resourceLister) List(opts ...filter.ListOption) ([]*api.Resource, error) {
+	objs, err := l.store.List(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*api.Resource, 0, len(objs))
+	for _, obj := range objs {
+		o, ok := obj.(*api.Resource)
+		if !ok {
+			return nil, fmt.Errorf("expected a *Resource, got %T", obj)
+		}
+		result = append(result, o)
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// Store is the thread-safe local state of an Informer. The Objects are keyed by their
+// identifiers in the storage, e.g. "namespace/name" for runtime.Metav1NameIdentifier.
+// The returned Objects are shared with the Store and other readers, and must not be
+// modified; use DeepCopyObject for getting a modifiable copy.
+type Store interface {
+	// Get returns the Object with the given identifier. If it doesn't exist,
+	// an error wrapping storage.ErrNotFound is returned.
+	Get(identifier string) (runtime.Object, error)
+	// List lists the Objects in the Store. The filters, ordering and pagination
+	// work like for storage.GenericStorage, but no continue token is returned.
+	List(opts ...filter.ListOption) ([]runtime.Object, error)
+	// ListIdentifiers returns the identifiers of all Objects in the Store, in order.
+	ListIdentifiers() []string
+}
+
+// store implements Store.
+var _ Store = &store{}
+
+type store struct {
+	mux     sync.RWMutex
+	objects map[string]runtime.Object
+}
+
+func newStore() *store {
+	return &store{objects: make(map[string]runtime.Object)}
+}
+
+func (s *store) Get(identifier string) (runtime.Object, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	obj, ok := s.objects[identifier]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", identifier, storage.ErrNotFound)
+	}
+	return obj, nil
+}
+
+func (s *store) List(opts ...filter.ListOption) ([]runtime.Object, error) {
+	s.mux.RLock()
+	objs := make(map[string]runtime.Object, len(s.objects))
+	for identifier, obj := range s.objects {
+		objs[identifier] = obj
+	}
+	s.mux.RUnlock()
+
+	it, err := storage.NewObjectIterator(context.Background(), objs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]runtime.Object, 0)
+	for it.Next() {
+		result = append(result, it.Object())
+	}
+	return result, it.Err()
+}
+
+func (s *store) ListIdentifiers() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	identifiers := make([]string, 0, len(s.objects))
+	for identifier := range s.objects {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
+
+// set stores the Object, and returns the Object it replaced, if any
+func (s *store) set(identifier string, obj runtime.Object) runtime.Object {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	old := s.objects[identifier]
+	s.objects[identifier] = obj
+	return old
+}
+
+// delete removes the Object, and returns it if it was stored
+func (s *store) delete(identifier string) runtime.Object {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	old := s.objects[identifier]
+	delete(s.objects, identifier)
+	return old
+}
@@ -0,0 +1,33 @@
+package bolt
+
+import (
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/conformance"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+func TestBoltRawStorage_Conformance(t *testing.T) {
+	conformance.TestRawStorage(t, func(t *testing.T) storage.RawStorage {
+		return newTestRawStorage(t)
+	}, conformance.RawStorageOptions{})
+}
+
+func TestBoltStorage_Conformance(t *testing.T) {
+	conformance.TestStorage(t, func(t *testing.T, ser serializer.Serializer) storage.Storage {
+		return storage.NewGenericStorage(newTestRawStorage(t), ser, conformance.Identifiers)
+	})
+}
+
+func TestBoltEventStorage_Conformance(t *testing.T) {
+	conformance.TestEventStorage(t, func(t *testing.T, ser serializer.Serializer) (update.EventStorage, storage.Storage) {
+		es, err := NewBoltEventStorage(storage.NewGenericStorage(newTestRawStorage(t), ser, conformance.Identifiers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = es.Close() })
+		return es, es
+	}, conformance.EventStorageOptions{StrictOrdering: true})
+}
@@ -0,0 +1,82 @@
+package bolt
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"github.com/weaveworks/libgitops/pkg/util/sync"
+)
+
+// feedBuffer is how many committed transactions can be pending before writers are blocked
+const feedBuffer = 1024
+
+// NewBoltEventStorage returns an update.EventStorage for the given Storage, which must be backed
+// by a BoltRawStorage. Instead of watching files, the changes committed to the BoltRawStorage are
+// broadcast to the subscribers, in the order they were committed in. The OldRevision and NewRevision
+// of the updates are the revisions of the BoltRawStorage before and after the change.
+func NewBoltEventStorage(s storage.Storage) (*BoltEventStorage, error) {
+	raw, ok := s.RawStorage().(*BoltRawStorage)
+	if !ok {
+		return nil, fmt.Errorf("NewBoltEventStorage: expected a *BoltRawStorage, got %T", s.RawStorage())
+	}
+
+	es := &BoltEventStorage{
+		Storage:     s,
+		raw:         raw,
+		feed:        make(chan []Change, feedBuffer),
+		broadcaster: update.NewBroadcaster(),
+	}
+	raw.subscribe(es.feed)
+	es.monitor = sync.RunMonitor(es.monitorFunc)
+	return es, nil
+}
+
+// BoltEventStorage implements update.EventStorage for a BoltRawStorage.
+type BoltEventStorage struct {
+	storage.Storage
+	raw         *BoltRawStorage
+	feed        chan []Change
+	broadcaster *update.Broadcaster
+	monitor     *sync.Monitor
+}
+
+var _ update.EventStorage = &BoltEventStorage{}
+
+func (s *BoltEventStorage) Subscribe(opts update.SubscribeOptions) update.Subscription {
+	return s.broadcaster.Subscribe(opts)
+}
+
+// Close stops receiving changes, and closes the UpdateStreams of all subscribers after the
+// last pending change has been sent. The wrapped Storage and its BoltRawStorage are not
+// closed, as they may still be used without the BoltEventStorage.
+func (s *BoltEventStorage) Close() error {
+	s.raw.unsubscribe(s.feed)
+	close(s.feed)
+	s.monitor.Wait()
+	s.broadcaster.Close()
+	return nil
+}
+
+func (s *BoltEventStorage) monitorFunc() {
+	log.Debug("BoltEventStorage: Monitoring thread started")
+	defer log.Debug("BoltEventStorage: Monitoring thread stopped")
+
+	for changes := range s.feed {
+		for _, change := range changes {
+			s.handleChange(change)
+		}
+	}
+}
+
+func (s *BoltEventStorage) handleChange(change Change) {
+	upd, err := update.UpdateFor(s, change.Event, change.Key, change.Content, change.Revision)
+	if err != nil {
+		log.Warnf("BoltEventStorage: Ignoring change: %v", err)
+		return
+	}
+
+	log.Tracef("BoltEventStorage: Sending event: %v", upd.Event)
+	s.broadcaster.Broadcast(upd)
+}
@@ -0,0 +1,386 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoPaths is returned by GetKey, as Objects in a BoltRawStorage aren't stored in files.
+var ErrNoPaths = errors.New("BoltRawStorage doesn't store Objects in files")
+
+// objectsBucket is the root bucket, containing a nested bucket per kind. Its
+// sequence is the revision of the whole storage.
+var objectsBucket = []byte("objects")
+
+// revisionLen is the length of the big-endian revision prefixed to every stored value
+const revisionLen = 8
+
+// BoltRawStorageOptions configures a BoltRawStorage.
+type BoltRawStorageOptions struct {
+	// ContentType is the content type the Objects are encoded in. Default JSON.
+	// +optional
+	ContentType serializer.ContentType
+	// FileMode is the mode the database file is created with. Default 0600.
+	// +optional
+	FileMode os.FileMode
+	// Timeout is how long to wait for the lock of the database file, which is held
+	// by another process. Default 1 second.
+	// +optional
+	Timeout time.Duration
+}
+
+func (o *BoltRawStorageOptions) Default() {
+	if len(o.ContentType) == 0 {
+		o.ContentType = serializer.ContentTypeJSON
+	}
+	if o.FileMode == 0 {
+		o.FileMode = 0600
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 1 * time.Second
+	}
+}
+
+// Change describes a committed change of an Object in a BoltRawStorage.
+type Change struct {
+	// Event is CREATE, MODIFY or DELETE.
+	Event update.ObjectEvent
+	// Key is the key of the changed Object.
+	Key storage.ObjectKey
+	// Revision is the revision of the storage after the change.
+	Revision uint64
+	// Content is the new content of the Object, or nil if it was deleted.
+	Content []byte
+}
+
+// NewBoltRawStorage opens, or creates, the bbolt database at the given path and returns a
+// BoltRawStorage storing Objects in it. The database is locked until Close is called.
+func NewBoltRawStorage(path string, opts BoltRawStorageOptions) (*BoltRawStorage, error) {
+	opts.Default()
+	db, err := bbolt.Open(path, opts.FileMode, &bbolt.Options{Timeout: opts.Timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bbolt database %q: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(objectsBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltRawStorage{
+		db:       db,
+		ct:       opts.ContentType,
+		feeds:    make(map[chan<- []Change]struct{}),
+		mux:      &sync.RWMutex{},
+		writeMux: &sync.Mutex{},
+	}, nil
+}
+
+// BoltRawStorage is a storage.RawStorage storing Objects in an embedded bbolt database,
+// in a bucket per kind. Every write increments the revision of the storage, and the
+// Checksum of an Object is the revision it was last written at. The Objects of a kind
+// are listed without touching the ones of other kinds. Every method runs in its own
+// bbolt transaction, use Transaction to group multiple reads and writes atomically.
+// Committed changes are published to subscribers, see NewBoltEventStorage.
+type BoltRawStorage struct {
+	db *bbolt.DB
+	ct serializer.ContentType
+	// feeds receive the changes of every committed transaction
+	feeds map[chan<- []Change]struct{}
+	mux   *sync.RWMutex
+	// writeMux makes sure changes are published in the order they were committed in
+	writeMux *sync.Mutex
+}
+
+var _ storage.RawStorage = &BoltRawStorage{}
+
+// Transaction runs fn in a single read-write transaction. The RawStorage given to fn reads
+// and writes within the transaction, e.g. storage.NewGenericStorage can be wrapped around it to
+// read, modify and write Objects atomically, as write transactions are serialized. If fn returns
+// an error, none of its writes are applied. The changes are only published after the commit.
+func (r *BoltRawStorage) Transaction(_ context.Context, fn func(raw storage.RawStorage) error) error {
+	return r.update(func(raw *txRawStorage) error {
+		return fn(raw)
+	})
+}
+
+// view runs fn in a read-only transaction
+func (r *BoltRawStorage) view(fn func(raw *txRawStorage) error) error {
+	return r.db.View(func(tx *bbolt.Tx) error {
+		return fn(&txRawStorage{tx: tx, ct: r.ct})
+	})
+}
+
+// update runs fn in a read-write transaction, and publishes the changes after the commit
+func (r *BoltRawStorage) update(fn func(raw *txRawStorage) error) error {
+	r.writeMux.Lock()
+	defer r.writeMux.Unlock()
+
+	var changes []Change
+	if err := r.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&txRawStorage{tx: tx, ct: r.ct, changes: &changes})
+	}); err != nil {
+		return err
+	}
+
+	r.publish(changes)
+	return nil
+}
+
+// publish sends the changes of a committed transaction to all feeds
+func (r *BoltRawStorage) publish(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	for feed := range r.feeds {
+		feed <- changes
+	}
+}
+
+// subscribe registers a feed for the changes of every committed transaction
+func (r *BoltRawStorage) subscribe(feed chan<- []Change) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.feeds[feed] = struct{}{}
+}
+
+// unsubscribe removes a feed registered with subscribe, after which it can be closed
+func (r *BoltRawStorage) unsubscribe(feed chan<- []Change) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	delete(r.feeds, feed)
+}
+
+func (r *BoltRawStorage) Read(ctx context.Context, key storage.ObjectKey) (content []byte, err error) {
+	err = r.view(func(raw *txRawStorage) error {
+		content, err = raw.Read(ctx, key)
+		return err
+	})
+	return
+}
+
+func (r *BoltRawStorage) Exists(ctx context.Context, key storage.ObjectKey) (exists bool) {
+	_ = r.view(func(raw *txRawStorage) error {
+		exists = raw.Exists(ctx, key)
+		return nil
+	})
+	return
+}
+
+func (r *BoltRawStorage) Write(ctx context.Context, key storage.ObjectKey, content []byte) error {
+	return r.update(func(raw *txRawStorage) error {
+		return raw.Write(ctx, key, content)
+	})
+}
+
+func (r *BoltRawStorage) Delete(ctx context.Context, key storage.ObjectKey) error {
+	return r.update(func(raw *txRawStorage) error {
+		return raw.Delete(ctx, key)
+	})
+}
+
+func (r *BoltRawStorage) List(ctx context.Context, kind storage.KindKey) (keys []storage.ObjectKey, err error) {
+	err = r.view(func(raw *txRawStorage) error {
+		keys, err = raw.List(ctx, kind)
+		return err
+	})
+	return
+}
+
+// Checksum returns the revision the Object was last written at.
+// If the Object doesn't exist, returns ErrNotFound.
+func (r *BoltRawStorage) Checksum(ctx context.Context, key storage.ObjectKey) (checksum string, err error) {
+	err = r.view(func(raw *txRawStorage) error {
+		checksum, err = raw.Checksum(ctx, key)
+		return err
+	})
+	return
+}
+
+func (r *BoltRawStorage) ContentType(_ storage.ObjectKey) serializer.ContentType {
+	return r.ct
+}
+
+// WatchDir returns an empty string, as changes are published by the BoltRawStorage itself.
+func (r *BoltRawStorage) WatchDir() string {
+	return ""
+}
+
+// GetKey returns ErrNoPaths, as Objects aren't stored in files.
+func (r *BoltRawStorage) GetKey(_ string) (storage.ObjectKey, error) {
+	return nil, ErrNoPaths
+}
+
+// Revision returns the current revision of the storage, which is incremented on every write and delete.
+func (r *BoltRawStorage) Revision() (revision uint64, err error) {
+	err = r.db.View(func(tx *bbolt.Tx) error {
+		revision = tx.Bucket(objectsBucket).Sequence()
+		return nil
+	})
+	return
+}
+
+// Close closes the database. Any EventStorages using the BoltRawStorage should be closed first.
+func (r *BoltRawStorage) Close() error {
+	return r.db.Close()
+}
+
+// txRawStorage is a storage.RawStorage operating within a single bbolt transaction
+type txRawStorage struct {
+	tx *bbolt.Tx
+	ct serializer.ContentType
+	// changes collects the changes of a read-write transaction
+	changes *[]Change
+}
+
+var _ storage.RawStorage = &txRawStorage{}
+
+// kindBucketName returns the name of the bucket for the given kind. The version
+// is not part of it, as all versions of a kind share the same Objects.
+func kindBucketName(kind storage.KindKey) []byte {
+	return []byte(kind.GetKind() + "." + kind.GetGroup())
+}
+
+// kindBucket returns the bucket for the given kind, or nil if nothing has been stored for it yet
+func (r *txRawStorage) kindBucket(kind storage.KindKey) *bbolt.Bucket {
+	return r.tx.Bucket(objectsBucket).Bucket(kindBucketName(kind))
+}
+
+// get returns the revision and content of the Object with the given key
+func (r *txRawStorage) get(key storage.ObjectKey) (uint64, []byte, error) {
+	b := r.kindBucket(key)
+	if b == nil {
+		return 0, nil, storage.ErrNotFound
+	}
+	value := b.Get([]byte(key.GetIdentifier()))
+	if value == nil {
+		return 0, nil, storage.ErrNotFound
+	}
+	if len(value) < revisionLen {
+		return 0, nil, fmt.Errorf("BoltRawStorage: corrupt value for %s %q", key.GetGVK(), key.GetIdentifier())
+	}
+	return binary.BigEndian.Uint64(value[:revisionLen]), value[revisionLen:], nil
+}
+
+func (r *txRawStorage) Read(_ context.Context, key storage.ObjectKey) ([]byte, error) {
+	_, content, err := r.get(key)
+	if err != nil {
+		return nil, err
+	}
+	// Values are only valid during the transaction
+	return append([]byte(nil), content...), nil
+}
+
+func (r *txRawStorage) Exists(_ context.Context, key storage.ObjectKey) bool {
+	_, _, err := r.get(key)
+	return err == nil
+}
+
+func (r *txRawStorage) Write(_ context.Context, key storage.ObjectKey, content []byte) error {
+	if !r.tx.Writable() {
+		return bbolt.ErrTxNotWritable
+	}
+
+	event := update.ObjectEventCreate
+	if _, _, err := r.get(key); err == nil {
+		event = update.ObjectEventModify
+	}
+
+	b, err := r.tx.Bucket(objectsBucket).CreateBucketIfNotExists(kindBucketName(key))
+	if err != nil {
+		return err
+	}
+	revision, err := r.tx.Bucket(objectsBucket).NextSequence()
+	if err != nil {
+		return err
+	}
+
+	value := make([]byte, revisionLen, revisionLen+len(content))
+	binary.BigEndian.PutUint64(value, revision)
+	value = append(value, content...)
+	if err := b.Put([]byte(key.GetIdentifier()), value); err != nil {
+		return err
+	}
+
+	r.record(Change{Event: event, Key: key, Revision: revision, Content: value[revisionLen:]})
+	return nil
+}
+
+func (r *txRawStorage) Delete(_ context.Context, key storage.ObjectKey) error {
+	if !r.tx.Writable() {
+		return bbolt.ErrTxNotWritable
+	}
+	if _, _, err := r.get(key); err != nil {
+		return err
+	}
+
+	revision, err := r.tx.Bucket(objectsBucket).NextSequence()
+	if err != nil {
+		return err
+	}
+	if err := r.kindBucket(key).Delete([]byte(key.GetIdentifier())); err != nil {
+		return err
+	}
+
+	r.record(Change{Event: update.ObjectEventDelete, Key: key, Revision: revision})
+	return nil
+}
+
+// record adds a change to the ones published after the commit
+func (r *txRawStorage) record(change Change) {
+	if r.changes != nil {
+		*r.changes = append(*r.changes, change)
+	}
+}
+
+func (r *txRawStorage) List(_ context.Context, kind storage.KindKey) ([]storage.ObjectKey, error) {
+	result := make([]storage.ObjectKey, 0)
+	b := r.kindBucket(kind)
+	if b == nil {
+		return result, nil
+	}
+
+	err := b.ForEach(func(k, _ []byte) error {
+		result = append(result, storage.NewObjectKey(kind, runtime.NewIdentifier(string(k))))
+		return nil
+	})
+	return result, err
+}
+
+func (r *txRawStorage) Checksum(_ context.Context, key storage.ObjectKey) (string, error) {
+	revision, _, err := r.get(key)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(revision, 10), nil
+}
+
+func (r *txRawStorage) ContentType(_ storage.ObjectKey) serializer.ContentType {
+	return r.ct
+}
+
+func (r *txRawStorage) WatchDir() string {
+	return ""
+}
+
+func (r *txRawStorage) GetKey(_ string) (storage.ObjectKey, error) {
+	return nil, ErrNoPaths
+}
@@ -0,0 +1,169 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+var motorcycleGVK = v1alpha1.SchemeGroupVersion.WithKind("Motorcycle")
+
+func newTestRawStorage(t *testing.T) *BoltRawStorage {
+	t.Helper()
+	raw, err := NewBoltRawStorage(filepath.Join(storagetest.TempDir(t), "objects.db"), BoltRawStorageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	return raw
+}
+
+func TestBoltRawStorage(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestRawStorage(t)
+	s := storagetest.NewStorage(raw)
+
+	for _, name := range []string{"foo", "bar"} {
+		if err := s.Create(ctx, storagetest.NewCar(name, "Volvo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := raw.Write(ctx, storage.NewObjectKey(storage.NewKindKey(motorcycleGVK), runtime.NewIdentifier("default/baz")), []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the Objects of the kind are listed
+	keys, err := raw.List(ctx, storagetest.CarKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].GetIdentifier() != "default/bar" || keys[1].GetIdentifier() != "default/foo" {
+		t.Errorf("expected the two Cars to be listed, got %v", keys)
+	}
+
+	// Every write increments the revision, which is used as the Checksum
+	before, err := raw.Checksum(ctx, storagetest.CarKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Get(ctx, storagetest.CarKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	car := obj.(*v1alpha1.Car)
+	car.Spec.Brand = "Saab"
+	if err := s.Update(ctx, car); err != nil {
+		t.Fatal(err)
+	}
+	after, err := raw.Checksum(ctx, storagetest.CarKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != "1" || after != "4" {
+		t.Errorf("expected the checksum to go from revision 1 to 4, got %q and %q", before, after)
+	}
+	if revision, err := raw.Revision(); err != nil || revision != 4 {
+		t.Errorf("expected revision 4, got %d (%v)", revision, err)
+	}
+
+	if err := s.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Read(ctx, storagetest.CarKey("foo")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after deleting, got %v", err)
+	}
+	if err := raw.Delete(ctx, storagetest.CarKey("foo")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound when deleting twice, got %v", err)
+	}
+}
+
+func TestBoltRawStorage_Transaction(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestRawStorage(t)
+	errAbort := errors.New("abort")
+
+	// A failed transaction doesn't apply any of its writes
+	err := raw.Transaction(ctx, func(txRaw storage.RawStorage) error {
+		s := storagetest.NewStorage(txRaw)
+		if err := s.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+			return err
+		}
+		if !txRaw.Exists(ctx, storagetest.CarKey("foo")) {
+			t.Error("expected the write to be visible within the transaction")
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("expected the error of the transaction, got %v", err)
+	}
+	if raw.Exists(ctx, storagetest.CarKey("foo")) {
+		t.Error("expected the write of the failed transaction to be rolled back")
+	}
+
+	err = raw.Transaction(ctx, func(txRaw storage.RawStorage) error {
+		s := storagetest.NewStorage(txRaw)
+		for _, name := range []string{"foo", "bar"} {
+			if err := s.Create(ctx, storagetest.NewCar(name, "Volvo")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !raw.Exists(ctx, storagetest.CarKey("foo")) || !raw.Exists(ctx, storagetest.CarKey("bar")) {
+		t.Error("expected the writes of the transaction to be committed")
+	}
+}
+
+func TestBoltEventStorage(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestRawStorage(t)
+	es, err := NewBoltEventStorage(storagetest.NewStorage(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	sub := es.Subscribe(update.SubscribeOptions{})
+
+	if err := es.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+	car := storagetest.NewCar("foo", "Volvo")
+	car.Spec.Brand = "Saab"
+	if err := es.Update(ctx, car); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		event    update.ObjectEvent
+		name     string
+		revision string
+	}{
+		{update.ObjectEventCreate, "foo", "1"},
+		{update.ObjectEventModify, "foo", "2"},
+		{update.ObjectEventDelete, update.EventDeleteObjectName, "3"},
+	}
+	for _, w := range want {
+		select {
+		case upd := <-sub.Updates():
+			if upd.Event != w.event || upd.PartialObject.GetName() != w.name || upd.NewRevision != w.revision || upd.ObjectKey.GetIdentifier() != "default/foo" {
+				t.Errorf("expected %s of %q at revision %s, got %s of %q at revision %s", w.event, w.name, w.revision, upd.Event, upd.PartialObject.GetName(), upd.NewRevision)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %s update", w.event)
+		}
+	}
+}
@@ -1,197 +1,435 @@
 package cache
 
-/*
-
-TODO: Revisit if we need this file/package in the future.
-
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
 	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/filter"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
 	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-// Cache is an intermediate caching layer, which conforms to Storage
-// Typically you back the cache with an actual storage
+var (
+	// ErrIndexExists is returned when registering an index under a name that is already taken
+	ErrIndexExists = errors.New("index already exists")
+	// ErrIndexNotFound is returned when listing by an index that isn't registered
+	ErrIndexNotFound = errors.New("index not found")
+)
+
+// Cache is an intermediate caching layer, which conforms to Storage. It keeps the decoded
+// Objects in memory, and serves Get, List, ListIterator and Find from there. Writes go
+// through to the backing Storage, and invalidate the written Objects in the cache.
 type Cache interface {
 	storage.Storage
-	// Flush is used to write the state of the entire cache to storage
-	// Warning: this is a very expensive operation
-	Flush() error
+
+	// AddIndex registers an IndexFunc under the given name, which is used by ListByIndex. The
+	// index applies to all kinds. ErrIndexExists is returned if the name is already taken.
+	AddIndex(name string, fn IndexFunc) error
+	// ListByIndex lists the Objects of the given kind that have been indexed by the given value,
+	// ordered by their identifiers. ErrIndexNotFound is returned if there's no such index.
+	ListByIndex(ctx context.Context, kind storage.KindKey, index, value string) ([]runtime.Object, error)
+}
+
+// CacheOptions configures a Cache.
+type CacheOptions struct {
+	// Indexes are registered when the Cache is created, see Cache.AddIndex.
+	// +optional
+	Indexes map[string]IndexFunc
+	// TrustUpdates makes the Cache rely only on the updates of the backing Storage for invalidating
+	// Objects, when it is an update.EventStorage. Otherwise, the Checksum of every Object is compared to
+	// the cached one before returning it. Only set this if the backing Storage sends an update for every
+	// change, as the Cache serves stale Objects for changes it isn't notified about.
+	// +optional
+	TrustUpdates bool
 }
 
 type cache struct {
 	// storage is the backing Storage for the cache
 	// used to look up non-cached Objects
 	storage storage.Storage
-
-	// index caches the Objects by GroupVersionKind and UID
-	// This guarantees uniqueness when looking up a specific Object
-	index *index
+	// trustUpdates is set if the Objects aren't validated against their Checksum, see CacheOptions
+	trustUpdates bool
+	// sub is the subscription to the backing Storage, if it's an update.EventStorage
+	sub update.Subscription
+	// done is closed when all updates of sub have been handled
+	done chan struct{}
+
+	// mux guards kinds and indexers. It's held for writing while loading Objects,
+	// so that invalidations can't be overwritten by Objects loaded before them.
+	mux sync.RWMutex
+	// kinds caches the Objects by GroupVersionKind and identifier
+	kinds    map[schema.GroupVersionKind]*kindCache
+	indexers map[string]IndexFunc
 }
 
 var _ Cache = &cache{}
 
-func NewCache(backingStorage storage.Storage) Cache {
+// NewCache returns a Cache for the given Storage. If the Storage is an update.EventStorage,
+// the cached Objects are invalidated by its updates, in addition to their Checksum.
+func NewCache(backingStorage storage.Storage, opts CacheOptions) Cache {
 	c := &cache{
-		storage: backingStorage,
-		index:   newIndex(backingStorage),
+		storage:  backingStorage,
+		kinds:    make(map[schema.GroupVersionKind]*kindCache),
+		indexers: make(map[string]IndexFunc, len(opts.Indexes)),
+	}
+	for name, fn := range opts.Indexes {
+		c.indexers[name] = fn
 	}
 
+	if es, ok := backingStorage.(update.EventStorage); ok {
+		c.trustUpdates = opts.TrustUpdates
+		c.sub = es.Subscribe(update.SubscribeOptions{})
+		c.done = make(chan struct{})
+		go c.watch()
+	}
 	return c
 }
 
-func (s *cache) Serializer() serializer.Serializer {
-	return s.storage.Serializer()
-}
-
-func (c *cache) New(gvk schema.GroupVersionKind) (runtime.Object, error) {
-	// Request the storage to create the Object. The
-	// newly generated Object has not got an UID which
-	// is required for indexing, so just return it
-	// without storing it into the cache
-	return c.storage.New(gvk)
+func (c *cache) Serializer() serializer.Serializer {
+	return c.storage.Serializer()
 }
 
-func (c *cache) Get(gvk schema.GroupVersionKind, uid runtime.UID) (obj runtime.Object, err error) {
-	log.Tracef("cache: Get %s with UID %q", gvk.Kind, uid)
-
-	// If the requested Object resides in the cache, return it
-	if obj, err = c.index.loadByID(gvk, uid); err != nil || obj != nil {
-		return
+func (c *cache) Get(ctx context.Context, key storage.ObjectKey) (runtime.Object, error) {
+	c.mux.RLock()
+	co := c.lookup(key)
+	c.mux.RUnlock()
+
+	// If the requested Object resides in the cache and is up to date, return it
+	if co != nil {
+		valid, err := c.valid(ctx, key, co)
+		if err != nil {
+			return nil, err
+		} else if valid {
+			log.Tracef("cache: Cache hit for %s %q", key.GetKind(), key.GetIdentifier())
+			return copyObject(co.obj), nil
+		}
 	}
 
-	// Request the Object from the storage
-	obj, err = c.storage.Get(gvk, uid)
-
-	// If no errors occurred, cache it
-	if err == nil {
-		err = c.index.store(obj)
+	log.Tracef("cache: Cache miss for %s %q", key.GetKind(), key.GetIdentifier())
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	co, err := c.load(ctx, key)
+	if err != nil {
+		return nil, err
 	}
+	return copyObject(co.obj), nil
+}
 
-	return
+// GetMeta is passed through to the backing Storage, as decoding only the metadata is cheap
+func (c *cache) GetMeta(ctx context.Context, key storage.ObjectKey) (runtime.PartialObject, error) {
+	return c.storage.GetMeta(ctx, key)
 }
 
-func (c *cache) GetMeta(gvk schema.GroupVersionKind, uid runtime.UID) (obj runtime.Object, err error) {
-	log.Tracef("cache: GetMeta %s with UID %q", gvk.Kind, uid)
+func (c *cache) Create(ctx context.Context, obj runtime.Object) error {
+	return c.write(obj, func() error {
+		return c.storage.Create(ctx, obj)
+	})
+}
 
-	obj, err = c.storage.GetMeta(gvk, uid)
+func (c *cache) Update(ctx context.Context, obj runtime.Object) error {
+	return c.write(obj, func() error {
+		return c.storage.Update(ctx, obj)
+	})
+}
 
-	// If no errors occurred while loading, store the Object in the cache
-	if err == nil {
-		err = c.index.storeMeta(obj)
+func (c *cache) Patch(ctx context.Context, key storage.ObjectKey, patch []byte) error {
+	if err := c.storage.Patch(ctx, key, patch); err != nil {
+		return err
 	}
+	c.invalidate(key, false)
+	return nil
+}
 
-	return
+func (c *cache) Delete(ctx context.Context, key storage.ObjectKey) error {
+	if err := c.storage.Delete(ctx, key); err != nil {
+		return err
+	}
+	// The Object may be kept until its finalizers have been removed
+	c.invalidate(key, false)
+	return nil
 }
 
-func (c *cache) Set(gvk schema.GroupVersionKind, obj runtime.Object) error {
-	log.Tracef("cache: Set %s with UID %q", gvk.Kind, obj.GetUID())
+// write invokes fn for writing obj to the backing Storage, and invalidates it afterwards
+func (c *cache) write(obj runtime.Object, fn func() error) error {
+	if err := fn(); err != nil {
+		return err
+	}
 
-	// Store the changed Object in the cache
-	if err := c.index.store(obj); err != nil {
+	key, err := c.storage.ObjectKeyFor(obj)
+	if err != nil {
 		return err
 	}
+	c.invalidate(key, false)
+	return nil
+}
 
-	// TODO: For now the cache always flushes, we might add automatic flushing later
-	return c.storage.Set(gvk, obj)
+func (c *cache) Checksum(ctx context.Context, key storage.ObjectKey) (string, error) {
+	return c.storage.Checksum(ctx, key)
 }
 
-func (c *cache) Patch(gvk schema.GroupVersionKind, uid runtime.UID, patch []byte) error {
-	// TODO: For now patches are always flushed, the cache will load the updated Object on-demand on access
-	return c.storage.Patch(gvk, uid, patch)
+// List lists the Objects of the given kind from the cache. The filters, ordering
+// and pagination work like for storage.GenericStorage, see storage.ListPage.
+func (c *cache) List(ctx context.Context, kind storage.KindKey, opts ...filter.ListOption) ([]runtime.Object, error) {
+	objs, _, err := storage.ListPage(ctx, c, kind, opts...)
+	return objs, err
 }
 
-func (c *cache) Delete(gvk schema.GroupVersionKind, uid runtime.UID) error {
-	log.Tracef("cache: Delete %s with UID %q", gvk.Kind, uid)
+func (c *cache) ListIterator(ctx context.Context, kind storage.KindKey, opts ...filter.ListOption) (storage.ObjectIterator, error) {
+	objs := make(map[string]runtime.Object)
+	if err := c.withKind(ctx, kind, func(kc *kindCache) {
+		// The cached Objects are never modified, only replaced, so they can be filtered outside of the lock
+		for identifier, co := range kc.objects {
+			objs[identifier] = co.obj
+		}
+	}); err != nil {
+		return nil, err
+	}
 
-	// Delete the given Object from the cache and storage
-	c.index.delete(gvk, uid)
-	return c.storage.Delete(gvk, uid)
+	it, err := storage.NewObjectIterator(ctx, objs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return copyIterator{it}, nil
 }
 
-type listFunc func(gvk schema.GroupVersionKind) ([]runtime.Object, error)
-type cacheStoreFunc func([]runtime.Object) error
-
-// list is a common handler for List and ListMeta
-func (c *cache) list(gvk schema.GroupVersionKind, slf, clf listFunc, csf cacheStoreFunc) (objs []runtime.Object, err error) {
-	var storageCount uint64
-	if storageCount, err = c.storage.Count(gvk); err != nil {
-		return
+// Find does a List underneath, also using filters, but always returns one object. If the List
+// underneath returned two or more results, storage.ErrAmbiguousFind is returned. If no match
+// was found, storage.ErrNotFound is returned.
+func (c *cache) Find(ctx context.Context, kind storage.KindKey, opts ...filter.ListOption) (runtime.Object, error) {
+	objs, err := c.List(ctx, kind, opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	if c.index.count(gvk) != storageCount {
-		log.Tracef("cache: miss when listing: %s", gvk)
-		// If the cache doesn't track all of the Objects, request them from the storage
-		if objs, err = slf(gvk); err != nil {
-			// If no errors occurred, store the Objects in the cache
-			err = csf(objs)
-		}
-	} else {
-		log.Tracef("cache: hit when listing: %s", gvk)
-		// If the cache tracks everything, return the cache's contents
-		objs, err = clf(gvk)
+	switch l := len(objs); l {
+	case 0:
+		return nil, fmt.Errorf("no Find match found: %w", storage.ErrNotFound)
+	case 1:
+		return objs[0], nil
+	default:
+		return nil, fmt.Errorf("too many (%d) matches: %v: %w", l, objs, storage.ErrAmbiguousFind)
 	}
+}
 
-	return
+// ListMeta is passed through to the backing Storage, as decoding only the metadata is cheap
+func (c *cache) ListMeta(ctx context.Context, kind storage.KindKey, opts ...filter.ListOption) ([]runtime.PartialObject, error) {
+	return c.storage.ListMeta(ctx, kind, opts...)
 }
 
-func (c *cache) List(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return c.list(gvk, c.storage.List, c.index.list, c.index.storeAll)
+func (c *cache) Count(ctx context.Context, kind storage.KindKey) (uint64, error) {
+	return c.storage.Count(ctx, kind)
 }
 
-func (c *cache) ListMeta(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return c.list(gvk, c.storage.ListMeta, c.index.listMeta, c.index.storeAllMeta)
+func (c *cache) AddIndex(name string, fn IndexFunc) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if _, ok := c.indexers[name]; ok {
+		return fmt.Errorf("%q: %w", name, ErrIndexExists)
+	}
+
+	// Index the Objects that are already cached
+	for _, kc := range c.kinds {
+		if err := kc.addIndex(name, fn); err != nil {
+			for _, kc := range c.kinds {
+				kc.removeIndex(name)
+			}
+			return err
+		}
+	}
+	c.indexers[name] = fn
+	return nil
 }
 
-func (c *cache) Count(gvk schema.GroupVersionKind) (uint64, error) {
-	// The cache is transparent about how many items it has cached
-	return c.storage.Count(gvk)
+func (c *cache) ListByIndex(ctx context.Context, kind storage.KindKey, index, value string) ([]runtime.Object, error) {
+	c.mux.RLock()
+	_, ok := c.indexers[index]
+	c.mux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", index, ErrIndexNotFound)
+	}
+
+	var result []runtime.Object
+	if err := c.withKind(ctx, kind, func(kc *kindCache) {
+		identifiers := kc.lookup(index, value)
+		result = make([]runtime.Object, 0, len(identifiers))
+		for _, identifier := range identifiers {
+			result = append(result, copyObject(kc.objects[identifier].obj))
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (c *cache) Checksum(gvk schema.GroupVersionKind, uid runtime.UID) (string, error) {
-	// The cache is transparent about the checksums
-	return c.storage.Checksum(gvk, uid)
+func (c *cache) ObjectKeyFor(obj runtime.Object) (storage.ObjectKey, error) {
+	return c.storage.ObjectKeyFor(obj)
 }
 
 func (c *cache) RawStorage() storage.RawStorage {
 	return c.storage.RawStorage()
 }
 
+// Close stops watching the backing Storage for updates, and closes it
 func (c *cache) Close() error {
+	if c.sub != nil {
+		c.sub.Unsubscribe()
+		<-c.done
+	}
 	return c.storage.Close()
 }
 
-func (c *cache) Flush() error {
-	// Load the entire cache
-	allObjects, err := c.index.loadAll()
-	if err != nil {
-		return err
+// watch invalidates the Objects the updates of the backing Storage are sent for
+func (c *cache) watch() {
+	defer close(c.done)
+
+	for upd := range c.sub.Updates() {
+		log.Tracef("cache: Invalidating %v after %s update", upd.ObjectKey, upd.Event)
+		c.invalidate(upd.ObjectKey, upd.Event == update.ObjectEventDelete)
 	}
+}
 
-	for _, obj := range allObjects {
-		// Request the storage to save each Object
-		if err := c.storage.Set(obj); err != nil {
-			return err
+// invalidate drops the Object with the given key from the cache, in all versions. Unless
+// it was deleted, the kind isn't complete anymore, as the cache is missing the Object.
+func (c *cache) invalidate(key storage.ObjectKey, deleted bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// Without a key, there's no way of knowing what changed
+	if key == nil {
+		c.kinds = make(map[schema.GroupVersionKind]*kindCache)
+		return
+	}
+
+	for gvk, kc := range c.kinds {
+		if !key.EqualsGVK(storage.NewKindKey(gvk), false) {
+			continue
+		}
+
+		kc.remove(key.GetIdentifier())
+		if !deleted {
+			kc.complete = false
 		}
 	}
+}
 
+// lookup returns the cached Object for the key, or nil. c.mux must be held by the caller.
+func (c *cache) lookup(key storage.ObjectKey) *cacheObject {
+	if kc, ok := c.kinds[key.GetGVK()]; ok {
+		return kc.objects[key.GetIdentifier()]
+	}
 	return nil
 }
 
-// PartialObjectFrom is used to create a bound PartialObjectImpl from an Object.
-// Note: This might be useful later (maybe here or maybe in pkg/runtime) if re-enable the cache
-func PartialObjectFrom(obj Object) (PartialObject, error) {
-	tm, ok := obj.GetObjectKind().(*metav1.TypeMeta)
+// valid returns whether the cached Object is up to date with the backing Storage
+func (c *cache) valid(ctx context.Context, key storage.ObjectKey, co *cacheObject) (bool, error) {
+	if c.trustUpdates {
+		return true, nil
+	}
+
+	checksum, err := c.storage.Checksum(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return checksum == co.checksum, err
+}
+
+// load reads the Object from the backing Storage, and caches it. If it doesn't
+// exist, it's removed from the cache. c.mux must be held for writing by the caller.
+func (c *cache) load(ctx context.Context, key storage.ObjectKey) (*cacheObject, error) {
+	kc, ok := c.kinds[key.GetGVK()]
 	if !ok {
-		return nil, fmt.Errorf("PartialObjectFrom: Cannot cast obj to *metav1.TypeMeta, is %T", obj.GetObjectKind())
+		kc = newKindCache()
+		c.kinds[key.GetGVK()] = kc
+	}
+
+	obj, err := c.storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		kc.remove(key.GetIdentifier())
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	co := newCacheObject(obj)
+	if err := kc.store(key.GetIdentifier(), co, c.indexers); err != nil {
+		return nil, err
 	}
-	om, ok := obj.GetObjectMeta().(*metav1.ObjectMeta)
+	return co, nil
+}
+
+// withKind invokes fn with the up to date cache of the given kind, while holding c.mux.
+// If the kind isn't completely cached, or the Objects are validated against their
+// Checksums, the keys of the kind are listed, and the missing Objects are loaded.
+func (c *cache) withKind(ctx context.Context, kind storage.KindKey, fn func(kc *kindCache)) error {
+	c.mux.RLock()
+	if kc, ok := c.kinds[kind.GetGVK()]; ok && kc.complete && c.trustUpdates {
+		defer c.mux.RUnlock()
+		fn(kc)
+		return nil
+	}
+	c.mux.RUnlock()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	keys, err := c.storage.RawStorage().List(ctx, kind)
+	if err != nil {
+		return err
+	}
+
+	kc, ok := c.kinds[kind.GetGVK()]
 	if !ok {
-		return nil, fmt.Errorf("PartialObjectFrom: Cannot cast obj to *metav1.ObjectMeta, is %T", obj.GetObjectMeta())
+		kc = newKindCache()
+		c.kinds[kind.GetGVK()] = kc
 	}
-	return &PartialObjectImpl{tm, om}, nil
+
+	found := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		identifier := k.GetIdentifier()
+		found[identifier] = true
+
+		key := storage.NewObjectKey(kind, runtime.NewIdentifier(identifier))
+		if co, ok := kc.objects[identifier]; ok {
+			if valid, err := c.valid(ctx, key, co); err != nil {
+				return err
+			} else if valid {
+				continue
+			}
+		}
+
+		// The Object may have been deleted after listing the keys
+		if _, err := c.load(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+
+	// Forget the Objects that have been deleted
+	for identifier := range kc.objects {
+		if !found[identifier] {
+			kc.remove(identifier)
+		}
+	}
+	kc.complete = true
+
+	fn(kc)
+	return nil
+}
+
+// copyIterator returns copies of the cached Objects of the underlying iterator
+type copyIterator struct {
+	storage.ObjectIterator
 }
 
-*/
+func (it copyIterator) Object() runtime.Object {
+	if obj := it.ObjectIterator.Object(); obj != nil {
+		return copyObject(obj)
+	}
+	return nil
+}
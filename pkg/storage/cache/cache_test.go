@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/memory"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func names(objs []runtime.Object) []string {
+	result := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.GetName())
+	}
+	return result
+}
+
+func mustCreate(t *testing.T, s storage.Storage, objs ...runtime.Object) {
+	t.Helper()
+	for _, obj := range objs {
+		if err := s.Create(context.Background(), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCache_ListByIndex(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers), CacheOptions{
+		Indexes: map[string]IndexFunc{"color": IndexByLabel("color")},
+	})
+
+	owned := storagetest.NewColoredCar("tow", "red")
+	owned.OwnerReferences = []metav1.OwnerReference{{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "Car", Name: "blue-1"}}
+	mustCreate(t, c, storagetest.NewColoredCar("red-1", "red"), storagetest.NewColoredCar("blue-1", "blue"), storagetest.NewColoredCar("red-0", "red"), owned)
+	// Indexes can be added after Objects have been cached
+	if _, err := c.List(ctx, storagetest.CarKind); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddIndex("owner", IndexByOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddIndex("owner", IndexByOwner); !errors.Is(err, ErrIndexExists) {
+		t.Errorf("expected ErrIndexExists, got %v", err)
+	}
+
+	// Changes of the indexed values are reflected in the indexes
+	recolored, err := c.Get(ctx, storagetest.CarKey("blue-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recolored.SetLabels(map[string]string{"color": "red"})
+	if err := c.Update(ctx, recolored); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, storagetest.CarKey("red-0")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		index string
+		value string
+		want  []string
+	}{
+		{"label", "color", "red", []string{"blue-1", "red-1", "tow"}},
+		{"removed value", "color", "blue", []string{}},
+		{"owner", "owner", OwnerIndexValue(schema.GroupKind{Group: v1alpha1.SchemeGroupVersion.Group, Kind: "Car"}, "default", "blue-1"), []string{"tow"}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			objs, err := c.ListByIndex(ctx, storagetest.CarKind, rt.index, rt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(objs); !reflect.DeepEqual(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+
+	if _, err := c.ListByIndex(ctx, storagetest.CarKind, "foo", "bar"); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("expected ErrIndexNotFound, got %v", err)
+	}
+}
+
+func TestCache_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// newStorage returns the backing storage of the cache, and the storage for changing it behind the cache's back
+		newStorage func(t *testing.T) (backing storage.Storage, writer storage.Storage)
+		opts       CacheOptions
+	}{
+		{
+			name: "checksum",
+			newStorage: func(t *testing.T) (storage.Storage, storage.Storage) {
+				s := memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers)
+				return s, s
+			},
+		},
+		{
+			name: "updates",
+			newStorage: func(t *testing.T) (storage.Storage, storage.Storage) {
+				s := memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers)
+				es, err := memory.NewMemoryEventStorage(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return es, s
+			},
+			opts: CacheOptions{TrustUpdates: true},
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			backing, writer := rt.newStorage(t)
+			c := NewCache(backing, rt.opts)
+			defer c.Close()
+
+			mustCreate(t, c, storagetest.NewColoredCar("car-0", "red"), storagetest.NewColoredCar("car-1", "red"))
+			if _, err := c.List(ctx, storagetest.CarKind); err != nil {
+				t.Fatal(err)
+			}
+
+			// Change the Objects without going through the cache
+			car, err := writer.Get(ctx, storagetest.CarKey("car-0"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			car.SetLabels(map[string]string{"color": "blue"})
+			if err := writer.Update(ctx, car); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Delete(ctx, storagetest.CarKey("car-1")); err != nil {
+				t.Fatal(err)
+			}
+			mustCreate(t, writer, storagetest.NewColoredCar("car-2", "red"))
+
+			red, err := filter.ParseLabelFilter("color=red")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The updates are handled asynchronously by the cache
+			var got []string
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				objs, err := c.List(ctx, storagetest.CarKind, red)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got = names(objs); reflect.DeepEqual(got, []string{"car-2"}) {
+					break
+				}
+			}
+			if !reflect.DeepEqual(got, []string{"car-2"}) {
+				t.Errorf("expected the red Cars [car-2], got %v", got)
+			}
+		})
+	}
+}
+
+func TestCache_Copies(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers), CacheOptions{})
+	mustCreate(t, c, storagetest.NewColoredCar("car-0", "red"))
+
+	// Modifying the returned Objects doesn't affect the cached ones
+	for i := 0; i < 2; i++ {
+		obj, err := c.Find(ctx, storagetest.CarKind, filter.NameFilter{Name: "car-0", Namespace: "default"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if car := obj.(*v1alpha1.Car); car.Spec.Brand != "Volvo" {
+			t.Errorf("expected the cached Car to be unchanged, got brand %q", car.Spec.Brand)
+		} else {
+			car.Spec.Brand = "Tesla"
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/conformance"
+	"github.com/weaveworks/libgitops/pkg/storage/memory"
+)
+
+func TestCache_Conformance(t *testing.T) {
+	conformance.TestStorage(t, func(t *testing.T, ser serializer.Serializer) storage.Storage {
+		return NewCache(memory.NewMemoryStorage(ser, conformance.Identifiers), CacheOptions{})
+	})
+}
+
+func TestCache_TrustUpdates_Conformance(t *testing.T) {
+	conformance.TestStorage(t, func(t *testing.T, ser serializer.Serializer) storage.Storage {
+		es, err := memory.NewMemoryEventStorage(memory.NewMemoryStorage(ser, conformance.Identifiers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewCache(es, CacheOptions{TrustUpdates: true})
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	})
+}
@@ -1,156 +1,149 @@
 package cache
 
-/*
-
-TODO: Revisit if we need this file/package in the future.
-
 import (
-	log "github.com/sirupsen/logrus"
+	"fmt"
+	"sort"
+
 	"github.com/weaveworks/libgitops/pkg/runtime"
-	"github.com/weaveworks/libgitops/pkg/storage"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-type index struct {
-	storage storage.Storage
-	objects map[schema.GroupVersionKind]map[runtime.UID]*cacheObject
-}
+// IndexFunc returns the values an Object is indexed by. An Object can be indexed
+// by any number of values, and is left out of the index if none are returned.
+type IndexFunc func(obj runtime.Object) ([]string, error)
 
-func newIndex(storage storage.Storage) *index {
-	return &index{
-		storage: storage,
-		objects: make(map[schema.GroupVersionKind]map[runtime.UID]*cacheObject),
+// IndexByLabel returns an IndexFunc indexing the Objects by the value of the given label.
+func IndexByLabel(label string) IndexFunc {
+	return func(obj runtime.Object) ([]string, error) {
+		if value, ok := obj.GetLabels()[label]; ok {
+			return []string{value}, nil
+		}
+		return nil, nil
 	}
 }
 
-func (i *index) loadByID(gvk schema.GroupVersionKind, uid runtime.UID) (runtime.Object, error) {
-	if uids, ok := i.objects[gvk]; ok {
-		if obj, ok := uids[uid]; ok {
-			log.Tracef("index: cache hit for %s with UID %q", gvk.Kind, uid)
-			return obj.loadFull()
+// IndexByOwner is an IndexFunc indexing the Objects by their ownerReferences. The
+// values are created by OwnerIndexValue, owners are expected in the same namespace.
+func IndexByOwner(obj runtime.Object) ([]string, error) {
+	refs := obj.GetOwnerReferences()
+	values := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			return nil, err
 		}
+		values = append(values, OwnerIndexValue(gv.WithKind(ref.Kind).GroupKind(), obj.GetNamespace(), ref.Name))
 	}
+	return values, nil
+}
 
-	log.Tracef("index: cache miss for %s with UID %q", gvk.Kind, uid)
-	return nil, nil
+// OwnerIndexValue returns the value IndexByOwner indexes the dependents of the given owner by.
+func OwnerIndexValue(owner schema.GroupKind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", owner, namespace, name)
 }
 
-func (i *index) loadAll() ([]runtime.Object, error) {
-	var size uint64
+// kindCache holds the cached Objects of one kind, and their indexes
+type kindCache struct {
+	// objects maps the identifiers to the cached Objects
+	objects map[string]*cacheObject
+	// complete is true if objects holds all Objects of the kind in the backing Storage
+	complete bool
+	// indexes maps the index names to the index values to the identifiers of the Objects
+	indexes map[string]map[string]map[string]struct{}
+}
 
-	for gvk := range i.objects {
-		size += i.count(gvk)
+func newKindCache() *kindCache {
+	return &kindCache{
+		objects: make(map[string]*cacheObject),
+		indexes: make(map[string]map[string]map[string]struct{}),
 	}
+}
 
-	all := make([]runtime.Object, 0, size)
-
-	for gvk := range i.objects {
-		if objects, err := i.list(gvk); err == nil {
-			all = append(all, objects...)
-		} else {
-			return nil, err
+// store adds the Object to the cache and to the given indexes, replacing the
+// previously cached version. If an IndexFunc fails, the Object isn't cached.
+func (kc *kindCache) store(identifier string, co *cacheObject, indexers map[string]IndexFunc) error {
+	for name, fn := range indexers {
+		values, err := fn(co.obj)
+		if err != nil {
+			return fmt.Errorf("index %q failed for %q: %w", name, identifier, err)
 		}
+		co.indexValues[name] = values
 	}
 
-	return all, nil
-}
-
-func store(i *index, obj runtime.Object, apiType bool) error {
-	// If store is called for an invalid Object lacking an UID,
-	// panic and print the stack trace. This should never happen.
-	if obj.GetUID() == "" {
-		panic("Attempt to cache invalid Object: missing UID")
+	kc.remove(identifier)
+	kc.objects[identifier] = co
+	for name, values := range co.indexValues {
+		kc.addToIndex(name, identifier, values)
 	}
+	return nil
+}
 
-	co, err := newCacheObject(i.storage, obj, apiType)
-	if err != nil {
-		return err
+// addIndex indexes the cached Objects with a newly registered IndexFunc
+func (kc *kindCache) addIndex(name string, fn IndexFunc) error {
+	// Compute all values before changing anything, so a failure leaves the cache as it was
+	values := make(map[string][]string, len(kc.objects))
+	for identifier, co := range kc.objects {
+		v, err := fn(co.obj)
+		if err != nil {
+			return fmt.Errorf("index %q failed for %q: %w", name, identifier, err)
+		}
+		values[identifier] = v
 	}
 
-	gvk := co.object.GetObjectKind().GroupVersionKind()
-
-	if _, ok := i.objects[gvk]; !ok {
-		i.objects[gvk] = make(map[runtime.UID]*cacheObject)
+	for identifier, v := range values {
+		kc.objects[identifier].indexValues[name] = v
+		kc.addToIndex(name, identifier, v)
 	}
-
-	log.Tracef("index: storing %s object with UID %q, meta: %t", gvk.Kind, obj.GetName(), apiType)
-	i.objects[gvk][co.object.GetUID()] = co
-
 	return nil
 }
 
-func (i *index) store(obj runtime.Object) error {
-	return store(i, obj, false)
-}
+func (kc *kindCache) addToIndex(name, identifier string, values []string) {
+	index, ok := kc.indexes[name]
+	if !ok {
+		index = make(map[string]map[string]struct{})
+		kc.indexes[name] = index
+	}
 
-func (i *index) storeAll(objs []runtime.Object) (err error) {
-	for _, obj := range objs {
-		if err = i.store(obj); err != nil {
-			break
+	for _, value := range values {
+		if _, ok := index[value]; !ok {
+			index[value] = make(map[string]struct{})
 		}
+		index[value][identifier] = struct{}{}
 	}
-
-	return
 }
 
-func (i *index) storeMeta(obj runtime.Object) error {
-	return store(i, obj, true)
-}
+// remove drops the Object from the cache and its indexes
+func (kc *kindCache) remove(identifier string) {
+	co, ok := kc.objects[identifier]
+	if !ok {
+		return
+	}
 
-func (i *index) storeAllMeta(objs []runtime.Object) (err error) {
-	for _, obj := range objs {
-		if uids, ok := i.objects[obj.GetObjectKind().GroupVersionKind()]; ok {
-			if _, ok := uids[obj.GetUID()]; ok {
-				continue
+	for name, values := range co.indexValues {
+		for _, value := range values {
+			delete(kc.indexes[name][value], identifier)
+			if len(kc.indexes[name][value]) == 0 {
+				delete(kc.indexes[name], value)
 			}
 		}
-
-		if err = i.storeMeta(obj); err != nil {
-			break
-		}
 	}
-
-	return
+	delete(kc.objects, identifier)
 }
 
-func (i *index) delete(gvk schema.GroupVersionKind, uid runtime.UID) {
-	if uids, ok := i.objects[gvk]; ok {
-		delete(uids, uid)
+// lookup returns the identifiers of the Objects with the given index value, in order
+func (kc *kindCache) lookup(name, value string) []string {
+	identifiers := make([]string, 0, len(kc.indexes[name][value]))
+	for identifier := range kc.indexes[name][value] {
+		identifiers = append(identifiers, identifier)
 	}
+	sort.Strings(identifiers)
+	return identifiers
 }
 
-func (i *index) count(gvk schema.GroupVersionKind) (count uint64) {
-	count = uint64(len(i.objects[gvk]))
-	log.Tracef("index: counted %d %s object(s)", count, gvk.Kind)
-	return
-}
-
-func list(i *index, gvk schema.GroupVersionKind, apiTypes bool) ([]runtime.Object, error) {
-	uids := i.objects[gvk]
-	list := make([]runtime.Object, 0, len(uids))
-
-	log.Tracef("index: listing %s objects, meta: %t", gvk, apiTypes)
-	for _, obj := range uids {
-		loadFunc := obj.loadFull
-		if apiTypes {
-			loadFunc = obj.loadAPI
-		}
-
-		if result, err := loadFunc(); err != nil {
-			return nil, err
-		} else {
-			list = append(list, result)
-		}
+// removeIndex drops the index with the given name
+func (kc *kindCache) removeIndex(name string) {
+	delete(kc.indexes, name)
+	for _, co := range kc.objects {
+		delete(co.indexValues, name)
 	}
-
-	return list, nil
-}
-
-func (i *index) list(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return list(i, gvk, false)
-}
-
-func (i *index) listMeta(gvk schema.GroupVersionKind) ([]runtime.Object, error) {
-	return list(i, gvk, true)
 }
-*/
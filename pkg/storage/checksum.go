@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ChecksumStrategy computes the Checksum of an Object from its encoded content, i.e. the bytes
+// of its frame. Content-based checksums, unlike modification times, are stable across e.g.
+// "git checkout", copies and clock skew, and don't change when a file is only touched.
+// If a RawStorage isn't given a ChecksumStrategy, NewSHA256Checksum is used. The RawStorages can
+// use the modification times of the files instead, see e.g. MappedRawStorageOptions.ModTimeChecksum.
+type ChecksumStrategy interface {
+	Checksum(content []byte) (string, error)
+}
+
+// ChecksumFunc is a function implementing ChecksumStrategy.
+type ChecksumFunc func(content []byte) (string, error)
+
+// Checksum implements ChecksumStrategy.
+func (f ChecksumFunc) Checksum(content []byte) (string, error) {
+	return f(content)
+}
+
+// NewSHA256Checksum returns a ChecksumStrategy using the hex-encoded SHA-256 hash of the content.
+func NewSHA256Checksum() ChecksumStrategy {
+	return ChecksumFunc(func(content []byte) (string, error) {
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:]), nil
+	})
+}
+
+// NewGitBlobChecksum returns a ChecksumStrategy using the Git blob hash of the content. For files
+// containing a single Object, this is the hash Git stores for the file, so Checksums of a storage
+// backed by a GitDirectory match the ones of past revisions, e.g. in "git ls-tree" output.
+func NewGitBlobChecksum() ChecksumStrategy {
+	return ChecksumFunc(func(content []byte) (string, error) {
+		h := sha1.New()
+		_, _ = fmt.Fprintf(h, "blob %d\x00", len(content))
+		_, _ = h.Write(content)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	})
+}
+
+// NewNormalizedChecksum returns a ChecksumStrategy hashing the decoded Object instead of its
+// encoding, so that formatting changes like indentation, comments, key order and YAML vs. JSON
+// don't change the Checksum. Content that can't be decoded results in an error.
+func NewNormalizedChecksum() ChecksumStrategy {
+	return ChecksumFunc(func(content []byte) (string, error) {
+		var obj interface{}
+		// JSON is a subset of YAML, so both are supported here
+		if err := yaml.Unmarshal(content, &obj); err != nil {
+			return "", fmt.Errorf("failed to normalize content: %w", err)
+		}
+		// encoding/json sorts the keys of maps, which makes the output canonical
+		normalized, err := json.Marshal(obj)
+		if err != nil {
+			return "", fmt.Errorf("failed to normalize content: %w", err)
+		}
+		return NewSHA256Checksum().Checksum(normalized)
+	})
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChecksumStrategies(t *testing.T) {
+	const (
+		car         = "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: volvo\n"
+		reformatted = "# A comment\nkind: Car\nmetadata: {name: volvo}\napiVersion: sample-app.weave.works/v1alpha1\n"
+		asJSON      = `{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"volvo"}}`
+		changed     = "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: saab\n"
+	)
+	tests := []struct {
+		name     string
+		strategy ChecksumStrategy
+		equal    []string
+		want     string
+	}{
+		{
+			name:     "sha256",
+			strategy: NewSHA256Checksum(),
+			equal:    []string{car},
+			want:     "cabae8ea8e5d9854e1aa80dced9cdd9ed0c9f326a9bc840220e4964146ff996d",
+		},
+		{
+			name:     "git blob",
+			strategy: NewGitBlobChecksum(),
+			equal:    []string{car},
+			// The output of "git hash-object"
+			want: "45d434a74dc68d30b15b73740a4874396b8a1334",
+		},
+		{
+			name:     "normalized",
+			strategy: NewNormalizedChecksum(),
+			equal:    []string{car, reformatted, asJSON},
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			want, err := rt.strategy.Checksum([]byte(car))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rt.want != "" && want != rt.want {
+				t.Errorf("expected checksum %q, got %q", rt.want, want)
+			}
+			for _, content := range rt.equal {
+				if got, err := rt.strategy.Checksum([]byte(content)); err != nil || got != want {
+					t.Errorf("expected checksum %q for %q, got %q (%v)", want, content, got, err)
+				}
+			}
+			if got, err := rt.strategy.Checksum([]byte(changed)); err != nil || got == want {
+				t.Errorf("expected a different checksum for changed content, got %q (%v)", got, err)
+			}
+		})
+	}
+}
+
+func TestGenericMappedRawStorage_Checksum(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	file := filepath.Join(dir, "cars.yaml")
+	if err := ioutil.WriteFile(file, []byte(multiFrameYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The content-based checksum is used by default
+	raw := NewGenericMappedRawStorage(dir)
+	key, other := carKey("first"), carKey("second")
+	raw.AddMapping(key, FileLocation{Path: file, Frame: 0})
+	raw.AddMapping(other, FileLocation{Path: file, Frame: 1})
+
+	before, err := raw.Checksum(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBefore, err := raw.Checksum(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == otherBefore {
+		t.Fatal("expected the frames to have different checksums")
+	}
+
+	// Touching the file doesn't change the checksum
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := raw.Checksum(ctx, key); err != nil || after != before {
+		t.Errorf("expected checksum %q after touching the file, got %q (%v)", before, after, err)
+	}
+
+	// Changing a frame only changes its own checksum
+	if err := raw.Write(ctx, other, []byte(carYAML)); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := raw.Checksum(ctx, key); err != nil || after != before {
+		t.Errorf("expected checksum %q after changing another frame, got %q (%v)", before, after, err)
+	}
+	if after, err := raw.Checksum(ctx, other); err != nil || after == otherBefore {
+		t.Errorf("expected the checksum of the changed frame to change, got %q (%v)", after, err)
+	}
+}
+
+func TestGenericMappedRawStorage_ModTimeChecksum(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	file := filepath.Join(dir, "cars.yaml")
+	if err := ioutil.WriteFile(file, []byte(multiFrameYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := NewGenericMappedRawStorageWithOptions(dir, MappedRawStorageOptions{ModTimeChecksum: true})
+	key := carKey("first")
+	raw.AddMapping(key, FileLocation{Path: file, Frame: 0})
+
+	before, err := raw.Checksum(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Touching the file changes the checksum, as the content isn't looked at
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := raw.Checksum(ctx, key); err != nil || after == before {
+		t.Errorf("expected the checksum to change after touching the file, got %q (%v)", after, err)
+	}
+}
@@ -0,0 +1,161 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// EventStorageFactory returns a new, empty EventStorage using the given Serializer, together with
+// the Storage the changes are made through. The writer is usually the EventStorage itself, but
+// e.g. a GenericWatchStorage doesn't send updates for its own writes, so an external writer to the
+// same directory is needed. Closing the EventStorage can be registered with t.Cleanup.
+type EventStorageFactory func(t *testing.T, ser serializer.Serializer) (es update.EventStorage, writer storage.Storage)
+
+// EventStorageOptions configures the EventStorage conformance tests.
+type EventStorageOptions struct {
+	// Timeout is how long to wait for every update. Default 10s.
+	// +optional
+	Timeout time.Duration
+	// StrictOrdering, if set, requires the EventStorage to send an update for every change, in the
+	// order the changes were made in, even when they are made in a burst without waiting for the
+	// updates. EventStorages batching changes, like the GenericWatchStorage, don't guarantee this.
+	// +optional
+	StrictOrdering bool
+}
+
+func (o *EventStorageOptions) Default() {
+	if o.Timeout == 0 {
+		o.Timeout = 10 * time.Second
+	}
+}
+
+// expectedUpdate is an update an EventStorage is expected to send
+type expectedUpdate struct {
+	event update.ObjectEvent
+	key   storage.ObjectKey
+}
+
+func (u expectedUpdate) String() string {
+	return fmt.Sprintf("%s of %s %q", u.event, u.key.GetKind(), u.key.GetIdentifier())
+}
+
+// TestEventStorage runs the conformance tests for EventStorages. Every test gets its own
+// EventStorage from newEventStorage. The Objects written are Cars and Motorcycles of the sample-app.
+func TestEventStorage(t *testing.T, newEventStorage EventStorageFactory, opts EventStorageOptions) {
+	opts.Default()
+
+	tests := []struct {
+		name   string
+		strict bool
+		test   func(t *testing.T, es update.EventStorage, writer storage.Storage, opts EventStorageOptions)
+	}{
+		{"Lifecycle", false, testEventLifecycle},
+		{"KindFilter", false, testEventKindFilter},
+		{"Burst", true, testEventBurst},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			if rt.strict && !opts.StrictOrdering {
+				t.Skip("the EventStorage doesn't guarantee strict ordering")
+			}
+			es, writer := newEventStorage(t, scheme.Serializer)
+			rt.test(t, es, writer, opts)
+		})
+	}
+}
+
+// expectUpdate waits for the next update of sub, and checks that it matches want
+func expectUpdate(t *testing.T, sub update.Subscription, want expectedUpdate, timeout time.Duration) {
+	t.Helper()
+	select {
+	case upd, ok := <-sub.Updates():
+		if !ok {
+			t.Fatalf("the UpdateStream was closed while waiting for the %s", want)
+		}
+		if upd.Event != want.event || !upd.ObjectKey.EqualsGVK(want.key, false) || upd.ObjectKey.GetIdentifier() != want.key.GetIdentifier() {
+			t.Fatalf("expected the %s, got the %s", want, expectedUpdate{upd.Event, upd.ObjectKey})
+		}
+		if upd.PartialObject == nil {
+			t.Errorf("expected the %s to carry a PartialObject", want)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for the %s", want)
+	}
+}
+
+func testEventLifecycle(t *testing.T, es update.EventStorage, writer storage.Storage, opts EventStorageOptions) {
+	ctx := context.Background()
+	sub := es.Subscribe(update.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	// Wait for every update before making the next change, as changes may be batched
+	if err := writer.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	expectUpdate(t, sub, expectedUpdate{update.ObjectEventCreate, storagetest.CarKey("foo")}, opts.Timeout)
+
+	if err := writer.Patch(ctx, storagetest.CarKey("foo"), []byte(`{"spec":{"brand":"Saab"}}`)); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	expectUpdate(t, sub, expectedUpdate{update.ObjectEventModify, storagetest.CarKey("foo")}, opts.Timeout)
+
+	if err := writer.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	expectUpdate(t, sub, expectedUpdate{update.ObjectEventDelete, storagetest.CarKey("foo")}, opts.Timeout)
+}
+
+func testEventKindFilter(t *testing.T, es update.EventStorage, writer storage.Storage, opts EventStorageOptions) {
+	ctx := context.Background()
+	sub := es.Subscribe(update.SubscribeOptions{Kind: storagetest.CarKind})
+	defer sub.Unsubscribe()
+
+	if err := writer.Create(ctx, newMotorcycle("bar")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := writer.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// The update for the Motorcycle must have been filtered out
+	expectUpdate(t, sub, expectedUpdate{update.ObjectEventCreate, storagetest.CarKey("foo")}, opts.Timeout)
+}
+
+func testEventBurst(t *testing.T, es update.EventStorage, writer storage.Storage, opts EventStorageOptions) {
+	ctx := context.Background()
+	sub := es.Subscribe(update.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	var want []expectedUpdate
+	for i := 0; i < concurrency; i++ {
+		name := fmt.Sprintf("foo-%d", i)
+		key := storagetest.CarKey(name)
+		if err := writer.Create(ctx, storagetest.NewCar(name, "Volvo")); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := writer.Patch(ctx, key, []byte(fmt.Sprintf(`{"status":{"persons":%d}}`, i))); err != nil {
+			t.Fatalf("Patch: %v", err)
+		}
+		want = append(want,
+			expectedUpdate{update.ObjectEventCreate, key},
+			expectedUpdate{update.ObjectEventModify, key},
+		)
+		if i%2 == 0 {
+			if err := writer.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			want = append(want, expectedUpdate{update.ObjectEventDelete, key})
+		}
+	}
+
+	for _, w := range want {
+		expectUpdate(t, sub, w, opts.Timeout)
+	}
+}
@@ -0,0 +1,321 @@
+// Package conformance contains test suites checking that RawStorage, Storage and EventStorage
+// implementations behave like the built-in ones. The suites are run from a regular test:
+//
+//	func TestMyRawStorage(t *testing.T) {
+//		conformance.TestRawStorage(t, func(t *testing.T) storage.RawStorage {
+//			return NewMyRawStorage()
+//		}, conformance.RawStorageOptions{})
+//	}
+//
+// Run the tests with -race, so that the concurrency tests can detect data races.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// GroupVersion is the GroupVersion of the Objects written by TestRawStorage.
+	// The RawStorages under test must accept it.
+	GroupVersion = schema.GroupVersion{Group: "conformance.libgitops.weave.works", Version: "v1"}
+	// OtherGroupVersion is another version of GroupVersion, for testing that List
+	// ignores versions. The RawStorages under test must accept it as well.
+	OtherGroupVersion = schema.GroupVersion{Group: GroupVersion.Group, Version: "v2"}
+)
+
+// concurrency is the number of goroutines used for the concurrency tests
+const concurrency = 8
+
+// RawStorageFactory returns a new, empty RawStorage. Cleanup can be registered with t.Cleanup.
+type RawStorageFactory func(t *testing.T) storage.RawStorage
+
+// RawStorageOptions configures TestRawStorage.
+type RawStorageOptions struct {
+	// PathFor returns the path GetKey should map back to the given key. If unset, the
+	// GetKey tests are skipped, e.g. for RawStorages that don't store Objects in files.
+	// +optional
+	PathFor func(raw storage.RawStorage, key storage.ObjectKey) string
+}
+
+// TestRawStorage runs the conformance tests for RawStorages. Every test gets its own RawStorage
+// from newRaw. The Objects written are JSON documents of the kinds Foo and Bar in GroupVersion.
+// Checksums based on modification times may not pass the Checksum test on filesystems with a
+// coarse timestamp granularity, as two writes can then happen within the same timestamp.
+func TestRawStorage(t *testing.T, newRaw RawStorageFactory, opts RawStorageOptions) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, raw storage.RawStorage, opts RawStorageOptions)
+	}{
+		{"NotFound", testRawNotFound},
+		{"WriteRead", testRawWriteRead},
+		{"Checksum", testRawChecksum},
+		{"Delete", testRawDelete},
+		{"List", testRawList},
+		{"GetKey", testRawGetKey},
+		{"Concurrency", testRawConcurrency},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			rt.test(t, newRaw(t), opts)
+		})
+	}
+}
+
+// NewObjectKey returns the key of the Object with the given kind and name in GroupVersion,
+// in the "default" namespace.
+func NewObjectKey(kind, name string) storage.ObjectKey {
+	return newKey(GroupVersion.WithKind(kind), name)
+}
+
+func newKey(gvk schema.GroupVersionKind, name string) storage.ObjectKey {
+	return storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier("default/"+name))
+}
+
+// contentFor returns the encoded Object for the given key, JSON is valid YAML as well
+func contentFor(key storage.ObjectKey, generation int) []byte {
+	apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
+	content, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"namespace":  "default",
+			"name":       key.GetIdentifier()[len("default/"):],
+			"generation": generation,
+		},
+	})
+	return content
+}
+
+func mustWrite(t *testing.T, raw storage.RawStorage, key storage.ObjectKey, content []byte) {
+	t.Helper()
+	if err := raw.Write(context.Background(), key, content); err != nil {
+		t.Fatalf("Write %s: %v", key.GetIdentifier(), err)
+	}
+}
+
+func testRawNotFound(t *testing.T, raw storage.RawStorage, _ RawStorageOptions) {
+	ctx := context.Background()
+	key := NewObjectKey("Foo", "missing")
+
+	if raw.Exists(ctx, key) {
+		t.Error("Exists: expected false for a missing Object")
+	}
+	if _, err := raw.Read(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Read: expected ErrNotFound for a missing Object, got %v", err)
+	}
+	if _, err := raw.Checksum(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Checksum: expected ErrNotFound for a missing Object, got %v", err)
+	}
+	if err := raw.Delete(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Delete: expected ErrNotFound for a missing Object, got %v", err)
+	}
+	if keys, err := raw.List(ctx, key); err != nil || len(keys) != 0 {
+		t.Errorf("List: expected no keys and no error for an empty kind, got %v (%v)", keys, err)
+	}
+}
+
+func testRawWriteRead(t *testing.T, raw storage.RawStorage, _ RawStorageOptions) {
+	ctx := context.Background()
+	key := NewObjectKey("Foo", "foo")
+
+	for generation := 1; generation <= 2; generation++ {
+		mustWrite(t, raw, key, contentFor(key, generation))
+		if !raw.Exists(ctx, key) {
+			t.Errorf("Exists: expected true after generation %d was written", generation)
+		}
+		content, err := raw.Read(ctx, key)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if want := contentFor(key, generation); !contentEqual(content, want) {
+			t.Errorf("Read: expected %s, got %s", want, content)
+		}
+	}
+
+	if len(raw.ContentType(key)) == 0 {
+		t.Error("ContentType: expected the content type of a stored Object")
+	}
+}
+
+func testRawChecksum(t *testing.T, raw storage.RawStorage, _ RawStorageOptions) {
+	ctx := context.Background()
+	key := NewObjectKey("Foo", "foo")
+
+	mustWrite(t, raw, key, contentFor(key, 1))
+	before, err := raw.Checksum(ctx, key)
+	if err != nil {
+		t.Fatalf("Checksum: %v", err)
+	}
+	if again, err := raw.Checksum(ctx, key); err != nil || again != before {
+		t.Errorf("Checksum: expected %q without a write in between, got %q (%v)", before, again, err)
+	}
+
+	mustWrite(t, raw, key, contentFor(key, 2))
+	if after, err := raw.Checksum(ctx, key); err != nil || after == before {
+		t.Errorf("Checksum: expected a change after the Object was written, got %q (%v)", after, err)
+	}
+}
+
+func testRawDelete(t *testing.T, raw storage.RawStorage, _ RawStorageOptions) {
+	ctx := context.Background()
+	key, other := NewObjectKey("Foo", "foo"), NewObjectKey("Foo", "bar")
+
+	mustWrite(t, raw, key, contentFor(key, 1))
+	mustWrite(t, raw, other, contentFor(other, 1))
+	if err := raw.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if raw.Exists(ctx, key) {
+		t.Error("Exists: expected false after Delete")
+	}
+	if _, err := raw.Read(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Read: expected ErrNotFound after Delete, got %v", err)
+	}
+	if !raw.Exists(ctx, other) {
+		t.Error("Exists: expected other Objects to be kept by Delete")
+	}
+	if keys := mustList(t, raw, key); !equalIdentifiers(keys, "default/bar") {
+		t.Errorf("List: expected only default/bar after Delete, got %v", identifiers(keys))
+	}
+}
+
+func testRawList(t *testing.T, raw storage.RawStorage, _ RawStorageOptions) {
+	foo, bar := NewObjectKey("Foo", "foo"), NewObjectKey("Bar", "bar")
+	otherVersion := newKey(OtherGroupVersion.WithKind("Foo"), "baz")
+	for _, key := range []storage.ObjectKey{foo, bar, otherVersion} {
+		mustWrite(t, raw, key, contentFor(key, 1))
+	}
+
+	// Objects of all versions of the kind are listed, but no Objects of other kinds
+	for _, kind := range []storage.KindKey{foo, otherVersion} {
+		keys := mustList(t, raw, kind)
+		if !equalIdentifiers(keys, "default/baz", "default/foo") {
+			t.Errorf("List %s: expected default/baz and default/foo, got %v", kind.GetGVK(), identifiers(keys))
+		}
+		for _, key := range keys {
+			if key.GetGroup() != kind.GetGroup() || key.GetKind() != kind.GetKind() {
+				t.Errorf("List %s: got a key of the wrong kind: %s", kind.GetGVK(), key.GetGVK())
+			}
+		}
+	}
+	if keys := mustList(t, raw, bar); !equalIdentifiers(keys, "default/bar") {
+		t.Errorf("List %s: expected default/bar, got %v", bar.GetGVK(), identifiers(keys))
+	}
+}
+
+func testRawGetKey(t *testing.T, raw storage.RawStorage, opts RawStorageOptions) {
+	if opts.PathFor == nil {
+		t.Skip("PathFor is not set")
+	}
+
+	key := NewObjectKey("Foo", "foo")
+	mustWrite(t, raw, key, contentFor(key, 1))
+	got, err := raw.GetKey(opts.PathFor(raw, key))
+	if err != nil {
+		t.Fatalf("GetKey: %v", err)
+	}
+	if !got.EqualsGVK(key, false) || got.GetIdentifier() != key.GetIdentifier() {
+		t.Errorf("GetKey: expected %s %s, got %s %s", key.GetGVK(), key.GetIdentifier(), got.GetGVK(), got.GetIdentifier())
+	}
+}
+
+func testRawConcurrency(t *testing.T, raw storage.RawStorage, _ RawStorageOptions) {
+	ctx := context.Background()
+	kind := storage.NewKindKey(GroupVersion.WithKind("Foo"))
+
+	// Every goroutine writes, reads and lists its own Object a few times
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(key storage.ObjectKey) {
+			defer wg.Done()
+			for generation := 1; generation <= 3; generation++ {
+				if err := raw.Write(ctx, key, contentFor(key, generation)); err != nil {
+					errs <- fmt.Errorf("Write %s: %w", key.GetIdentifier(), err)
+					return
+				}
+				if _, err := raw.Read(ctx, key); err != nil {
+					errs <- fmt.Errorf("Read %s: %w", key.GetIdentifier(), err)
+					return
+				}
+				if _, err := raw.List(ctx, kind); err != nil {
+					errs <- fmt.Errorf("List: %w", err)
+					return
+				}
+			}
+		}(NewObjectKey("Foo", fmt.Sprintf("foo-%d", i)))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if keys := mustList(t, raw, kind); len(keys) != concurrency {
+		t.Errorf("List: expected %d Objects after the concurrent writes, got %v", concurrency, identifiers(keys))
+	}
+	for i := 0; i < concurrency; i++ {
+		key := NewObjectKey("Foo", fmt.Sprintf("foo-%d", i))
+		if content, err := raw.Read(ctx, key); err != nil || !contentEqual(content, contentFor(key, 3)) {
+			t.Errorf("Read %s: expected the last generation, got %s (%v)", key.GetIdentifier(), content, err)
+		}
+	}
+}
+
+func mustList(t *testing.T, raw storage.RawStorage, kind storage.KindKey) []storage.ObjectKey {
+	t.Helper()
+	keys, err := raw.List(context.Background(), kind)
+	if err != nil {
+		t.Fatalf("List %s: %v", kind.GetGVK(), err)
+	}
+	return keys
+}
+
+// identifiers returns the sorted identifiers of the given keys
+func identifiers(keys []storage.ObjectKey) []string {
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, key.GetIdentifier())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func equalIdentifiers(keys []storage.ObjectKey, want ...string) bool {
+	got := identifiers(keys)
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// contentEqual compares two documents semantically, as RawStorages may e.g. convert the content to YAML
+func contentEqual(a, b []byte) bool {
+	var objA, objB interface{}
+	if err := yaml.Unmarshal(a, &objA); err != nil {
+		return false
+	}
+	if err := yaml.Unmarshal(b, &objB); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(objA, objB)
+}
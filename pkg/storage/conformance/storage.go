@@ -0,0 +1,240 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+)
+
+var motorcycleGVK = v1alpha1.SchemeGroupVersion.WithKind("Motorcycle")
+
+// StorageFactory returns a new, empty Storage using the given Serializer, which can encode
+// the Car and Motorcycle types of the sample-app. The Objects are identified by
+// runtime.Metav1NameIdentifier. Cleanup can be registered with t.Cleanup.
+type StorageFactory func(t *testing.T, ser serializer.Serializer) storage.Storage
+
+// Identifiers are the identifiers the Storages under test must use.
+var Identifiers = storagetest.Identifiers
+
+// TestStorage runs the conformance tests for Storages. Every test gets its own Storage from
+// newStorage. The Objects written are Cars and Motorcycles of the sample-app.
+func TestStorage(t *testing.T, newStorage StorageFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"CRUD", testStorageCRUD},
+		{"NotFound", testStorageNotFound},
+		{"AlreadyExists", testStorageAlreadyExists},
+		{"Conflict", testStorageConflict},
+		{"List", testStorageList},
+		{"Concurrency", testStorageConcurrency},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			rt.test(t, newStorage(t, scheme.Serializer))
+		})
+	}
+}
+
+func newMotorcycle(name string) *v1alpha1.Motorcycle {
+	motorcycle := &v1alpha1.Motorcycle{}
+	motorcycle.SetGroupVersionKind(motorcycleGVK)
+	motorcycle.Name = name
+	motorcycle.Namespace = "default"
+	return motorcycle
+}
+
+func mustGetCar(t *testing.T, s storage.Storage, name string) *v1alpha1.Car {
+	t.Helper()
+	obj, err := s.Get(context.Background(), storagetest.CarKey(name))
+	if err != nil {
+		t.Fatalf("Get %s: %v", name, err)
+	}
+	car, ok := obj.(*v1alpha1.Car)
+	if !ok {
+		t.Fatalf("Get %s: expected a *v1alpha1.Car, got %T", name, obj)
+	}
+	return car
+}
+
+func testStorageCRUD(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if err := s.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	car := mustGetCar(t, s, "foo")
+	if car.Spec.Brand != "Volvo" || car.CreationTimestamp.IsZero() || len(car.GetResourceVersion()) == 0 {
+		t.Errorf("Get: expected the created Car with a creationTimestamp and resourceVersion, got %+v", car)
+	}
+
+	car.Spec.Brand = "Saab"
+	if err := s.Update(ctx, car); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got := mustGetCar(t, s, "foo"); got.Spec.Brand != "Saab" || got.GetResourceVersion() != car.GetResourceVersion() {
+		t.Errorf("Get: expected the updated Car with resourceVersion %q, got %+v", car.GetResourceVersion(), got)
+	}
+
+	if err := s.Patch(ctx, storagetest.CarKey("foo"), []byte(`{"spec":{"engine":"V8"}}`)); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if got := mustGetCar(t, s, "foo"); got.Spec.Brand != "Saab" || got.Spec.Engine != "V8" {
+		t.Errorf("Get: expected the patched Car, got %+v", got)
+	}
+
+	if err := s.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, storagetest.CarKey("foo")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get: expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func testStorageNotFound(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, storagetest.CarKey("missing")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get: expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetMeta(ctx, storagetest.CarKey("missing")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetMeta: expected ErrNotFound, got %v", err)
+	}
+	if err := s.Update(ctx, storagetest.NewCar("missing", "Volvo")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Update: expected ErrNotFound, got %v", err)
+	}
+	if err := s.Patch(ctx, storagetest.CarKey("missing"), []byte(`{}`)); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Patch: expected ErrNotFound, got %v", err)
+	}
+	if err := s.Delete(ctx, storagetest.CarKey("missing")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Delete: expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Checksum(ctx, storagetest.CarKey("missing")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Checksum: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testStorageAlreadyExists(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if err := s.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := s.Create(ctx, storagetest.NewCar("foo", "Saab")); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("Create: expected ErrAlreadyExists, got %v", err)
+	}
+	if got := mustGetCar(t, s, "foo"); got.Spec.Brand != "Volvo" {
+		t.Errorf("Get: expected the first Car to be kept, got brand %q", got.Spec.Brand)
+	}
+}
+
+func testStorageConflict(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if err := s.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	first, second := mustGetCar(t, s, "foo"), mustGetCar(t, s, "foo")
+
+	first.Spec.Brand = "Saab"
+	if err := s.Update(ctx, first); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	second.Spec.Brand = "Tesla"
+	if err := s.Update(ctx, second); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Update: expected ErrConflict for a stale resourceVersion, got %v", err)
+	}
+	if got := mustGetCar(t, s, "foo"); got.Spec.Brand != "Saab" {
+		t.Errorf("Get: expected the first update to be kept, got brand %q", got.Spec.Brand)
+	}
+}
+
+func testStorageList(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	for _, name := range []string{"foo", "bar"} {
+		if err := s.Create(ctx, storagetest.NewCar(name, "Volvo")); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if err := s.Create(ctx, newMotorcycle("baz")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	cars, err := s.List(ctx, storagetest.CarKind)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, obj := range cars {
+		if _, ok := obj.(*v1alpha1.Car); !ok {
+			t.Errorf("List: expected only Cars, got %T", obj)
+		}
+		names[obj.GetName()] = true
+	}
+	if len(cars) != 2 || !names["foo"] || !names["bar"] {
+		t.Errorf("List: expected the Cars foo and bar, got %v", names)
+	}
+
+	metas, err := s.ListMeta(ctx, storage.NewKindKey(motorcycleGVK))
+	if err != nil {
+		t.Fatalf("ListMeta: %v", err)
+	}
+	if len(metas) != 1 || metas[0].GetName() != "baz" {
+		t.Errorf("ListMeta: expected the Motorcycle baz, got %v", metas)
+	}
+	if count, err := s.Count(ctx, storagetest.CarKind); err != nil || count != 2 {
+		t.Errorf("Count: expected 2 Cars, got %d (%v)", count, err)
+	}
+}
+
+func testStorageConcurrency(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	if err := s.Create(ctx, storagetest.NewCar("shared", "Volvo")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Every goroutine creates its own Car, and patches a shared one
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.Create(ctx, storagetest.NewCar(fmt.Sprintf("foo-%d", i), "Volvo")); err != nil {
+				errs <- fmt.Errorf("Create: %w", err)
+				return
+			}
+			patch := fmt.Sprintf(`{"status":{"persons":%d}}`, i)
+			// Concurrent patches may conflict, but must never corrupt the Object
+			if err := s.Patch(ctx, storagetest.CarKey("shared"), []byte(patch)); err != nil && !errors.Is(err, storage.ErrConflict) {
+				errs <- fmt.Errorf("Patch: %w", err)
+				return
+			}
+			if _, err := s.List(ctx, storagetest.CarKind); err != nil {
+				errs <- fmt.Errorf("List: %w", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if count, err := s.Count(ctx, storagetest.CarKind); err != nil || count != concurrency+1 {
+		t.Errorf("Count: expected %d Cars after the concurrent creates, got %d (%v)", concurrency+1, count, err)
+	}
+	if got := mustGetCar(t, s, "shared"); got.Spec.Brand != "Volvo" {
+		t.Errorf("Get: expected the shared Car to keep its spec, got %+v", got)
+	}
+}
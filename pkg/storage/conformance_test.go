@@ -0,0 +1,64 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/conformance"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+)
+
+func TestGenericRawStorage_Conformance(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout storage.PathLayout
+	}{
+		{"GroupKind", storage.NewGroupKindLayout()},
+		{"Flat", storage.NewFlatLayout()},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			opts := storage.GenericRawStorageOptions{
+				Layout: rt.layout,
+			}
+			conformance.TestRawStorage(t, func(t *testing.T) storage.RawStorage {
+				raw, err := storage.NewGenericRawStorageWithOptions(storagetest.TempDir(t), serializer.ContentTypeJSON, opts,
+					conformance.GroupVersion, conformance.OtherGroupVersion)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			}, conformance.RawStorageOptions{
+				PathFor: func(raw storage.RawStorage, key storage.ObjectKey) string {
+					return filepath.Join(raw.WatchDir(), filepath.FromSlash(rt.layout.ObjectPath(key, ".json")))
+				},
+			})
+		})
+	}
+}
+
+func TestGenericMappedRawStorage_Conformance(t *testing.T) {
+	placement := storage.NewObjectPerFilePlacement(serializer.ContentTypeYAML)
+	conformance.TestRawStorage(t, func(t *testing.T) storage.RawStorage {
+		return storage.NewGenericMappedRawStorageWithOptions(storagetest.TempDir(t), storage.MappedRawStorageOptions{
+			Placement: placement,
+		})
+	}, conformance.RawStorageOptions{
+		PathFor: func(raw storage.RawStorage, key storage.ObjectKey) string {
+			p, _ := placement.Place(key)
+			return filepath.Join(raw.WatchDir(), filepath.FromSlash(p))
+		},
+	})
+}
+
+func TestGenericStorage_Conformance(t *testing.T) {
+	conformance.TestStorage(t, func(t *testing.T, ser serializer.Serializer) storage.Storage {
+		raw := storage.NewGenericMappedRawStorageWithOptions(storagetest.TempDir(t), storage.MappedRawStorageOptions{
+			// Patches are applied to JSON documents
+			Placement: storage.NewObjectPerFilePlacement(serializer.ContentTypeJSON),
+		})
+		return storage.NewGenericStorage(raw, ser, conformance.Identifiers)
+	})
+}
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// DeleteWithPropagation deletes the Object like Delete, but handles its dependents according to the
+// given policy instead of GenericStorageOptions.PropagationPolicy:
+//
+//   - metav1.DeletePropagationBackground removes the Object first, and then deletes its dependents.
+//   - metav1.DeletePropagationForeground adds the "foregroundDeletion" finalizer, which keeps the
+//     Object until its dependents have been deleted in the foreground as well, and removed.
+//   - metav1.DeletePropagationOrphan adds the "orphan" finalizer, which removes the ownerReferences
+//     to the Object from its dependents before the Object is removed.
+//
+// The dependents are searched for in GenericStorageOptions.DependentKinds.
+func (s *GenericStorage) DeleteWithPropagation(ctx context.Context, key ObjectKey, policy metav1.DeletionPropagation) error {
+	switch policy {
+	case metav1.DeletePropagationBackground, metav1.DeletePropagationForeground, metav1.DeletePropagationOrphan:
+	default:
+		return fmt.Errorf("unknown deletion propagation policy %q", policy)
+	}
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	return s.delete(ctx, key, policy)
+}
+
+// delete marks the Object as being deleted, and finalizes it. Objects without finalizers, owners and
+// possible dependents are removed right away, without decoding them fully. s.writeMux must be held by the caller.
+func (s *GenericStorage) delete(ctx context.Context, key ObjectKey, policy metav1.DeletionPropagation) error {
+	meta, err := s.GetMeta(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return err
+	} else if err != nil {
+		// The Object can't be decoded, e.g. as its file is corrupt, hence its finalizers
+		// and dependents can't be handled. Remove it nevertheless.
+		logrus.Warnf("Removing %s, which can't be decoded: %v", key, err)
+		return s.raw.Delete(ctx, key)
+	}
+	if len(meta.GetFinalizers()) == 0 && len(meta.GetOwnerReferences()) == 0 && len(s.opts.DependentKinds) == 0 {
+		return s.raw.Delete(ctx, key)
+	}
+
+	obj, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	// Objects already being deleted keep the policy of the first Delete
+	if obj.GetDeletionTimestamp() == nil {
+		switch policy {
+		case metav1.DeletePropagationOrphan:
+			addFinalizer(obj, metav1.FinalizerOrphanDependents)
+		case metav1.DeletePropagationForeground:
+			addFinalizer(obj, metav1.FinalizerDeleteDependents)
+		}
+
+		// Without finalizers, the Object is removed right away by finalize
+		if len(obj.GetFinalizers()) != 0 {
+			now := metav1.Now()
+			obj.SetDeletionTimestamp(&now)
+			if err := s.write(ctx, key, obj); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.finalize(ctx, key, obj)
+}
+
+// finalize processes the "orphan" and "foregroundDeletion" finalizers of an Object being deleted, and
+// removes the Object once no finalizers are left. The other finalizers are removed by their owners,
+// using Update or Patch, which finalize the Object again. s.writeMux must be held by the caller.
+func (s *GenericStorage) finalize(ctx context.Context, key ObjectKey, obj runtime.Object) error {
+	// Deleting the dependents may finalize their owners again, skip the Objects already being finalized
+	id := deletionID(key)
+	if s.finalizing[id] {
+		return nil
+	}
+	s.finalizing[id] = true
+	defer delete(s.finalizing, id)
+
+	finalizers := len(obj.GetFinalizers())
+	if hasFinalizer(obj, metav1.FinalizerOrphanDependents) {
+		if err := s.orphanDependents(ctx, key, obj); err != nil {
+			return err
+		}
+		removeFinalizer(obj, metav1.FinalizerOrphanDependents)
+	}
+	if hasFinalizer(obj, metav1.FinalizerDeleteDependents) {
+		remaining, err := s.deleteDependents(ctx, key, obj, metav1.DeletePropagationForeground)
+		if err != nil {
+			return err
+		}
+		// Dependents with finalizers of their own keep the Object until they're removed
+		if remaining == 0 {
+			removeFinalizer(obj, metav1.FinalizerDeleteDependents)
+		}
+	}
+
+	if len(obj.GetFinalizers()) != 0 {
+		if len(obj.GetFinalizers()) == finalizers {
+			return nil
+		}
+		return s.write(ctx, key, obj)
+	}
+
+	if err := s.raw.Delete(ctx, key); err != nil {
+		return err
+	}
+	if _, err := s.deleteDependents(ctx, key, obj, metav1.DeletePropagationBackground); err != nil {
+		return err
+	}
+	return s.finalizeOwners(ctx, obj)
+}
+
+// deleteDependents deletes the dependents of the Object, which aren't being deleted already, with the given
+// policy. It returns how many dependents remain, e.g. as they have finalizers. s.writeMux must be held by the caller.
+func (s *GenericStorage) deleteDependents(ctx context.Context, key ObjectKey, obj runtime.Object, policy metav1.DeletionPropagation) (int, error) {
+	dependents, err := s.dependents(ctx, key, obj)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	for _, dependent := range dependents {
+		if dependent.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		dependentKey, err := s.ObjectKeyFor(dependent)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// The dependent may have been removed already by deleting another dependent
+		if err := s.delete(ctx, dependentKey, policy); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, fmt.Errorf("failed to delete dependent %s: %w", dependentKey, err))
+		}
+	}
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return 0, err
+	}
+
+	if dependents, err = s.dependents(ctx, key, obj); err != nil {
+		return 0, err
+	}
+	return len(dependents), nil
+}
+
+// orphanDependents removes the ownerReferences to the Object from its dependents. s.writeMux must be held by the caller.
+func (s *GenericStorage) orphanDependents(ctx context.Context, key ObjectKey, obj runtime.Object) error {
+	dependents, err := s.dependents(ctx, key, obj)
+	if err != nil {
+		return err
+	}
+
+	for _, dependent := range dependents {
+		dependentKey, err := s.ObjectKeyFor(dependent)
+		if err != nil {
+			return err
+		}
+
+		refs := dependent.GetOwnerReferences()
+		kept := make([]metav1.OwnerReference, 0, len(refs))
+		for _, ref := range refs {
+			if !ownerReferenceTo(ref, key.GetGVK().GroupKind(), obj, dependent.GetNamespace()) {
+				kept = append(kept, ref)
+			}
+		}
+		dependent.SetOwnerReferences(kept)
+
+		if err := s.write(ctx, dependentKey, dependent); err != nil {
+			return fmt.Errorf("failed to orphan dependent %s: %w", dependentKey, err)
+		}
+	}
+	return nil
+}
+
+// finalizeOwners finalizes the owners of a removed Object, which wait for their dependents to be
+// removed due to the "foregroundDeletion" finalizer. s.writeMux must be held by the caller.
+func (s *GenericStorage) finalizeOwners(ctx context.Context, obj runtime.Object) error {
+	for _, ref := range obj.GetOwnerReferences() {
+		owner := &runtime.PartialObjectImpl{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: ref.APIVersion,
+				Kind:       ref.Kind,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ref.Name,
+				Namespace: obj.GetNamespace(),
+				UID:       ref.UID,
+			},
+		}
+		ownerKey, err := s.ObjectKeyFor(owner)
+		if err != nil {
+			continue // The owner can't be identified, so it's not in this Storage
+		}
+
+		// The owner may have been removed already, or be of a kind this Storage doesn't contain
+		ownerObj, err := s.Get(ctx, ownerKey)
+		if err != nil {
+			continue
+		}
+
+		if ownerObj.GetDeletionTimestamp() != nil && hasFinalizer(ownerObj, metav1.FinalizerDeleteDependents) {
+			if err := s.finalize(ctx, ownerKey, ownerObj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dependents returns the Objects in GenericStorageOptions.DependentKinds that have
+// an ownerReference to the given Object. s.writeMux must be held by the caller.
+func (s *GenericStorage) dependents(ctx context.Context, key ObjectKey, obj runtime.Object) ([]runtime.Object, error) {
+	var result []runtime.Object
+	for _, kind := range s.opts.DependentKinds {
+		objs, err := s.List(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dependent := range objs {
+			for _, ref := range dependent.GetOwnerReferences() {
+				if ownerReferenceTo(ref, key.GetGVK().GroupKind(), obj, dependent.GetNamespace()) {
+					result = append(result, dependent)
+					break
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// ownerReferenceTo returns whether the ownerReference of an Object in the given namespace refers to
+// the owner of the given GroupKind. The version of the owner doesn't matter, and the UIDs are only
+// compared if both are set, as the Objects are usually identified by their namespace and name.
+func ownerReferenceTo(ref metav1.OwnerReference, gk schema.GroupKind, owner runtime.Object, namespace string) bool {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil || gv.Group != gk.Group || ref.Kind != gk.Kind {
+		return false
+	}
+	if ref.Name != owner.GetName() || namespace != owner.GetNamespace() {
+		return false
+	}
+	return len(ref.UID) == 0 || len(owner.GetUID()) == 0 || ref.UID == owner.GetUID()
+}
+
+// deletionID identifies the Object of the key in all versions
+func deletionID(key ObjectKey) string {
+	return fmt.Sprintf("%s %s", key.GetGVK().GroupKind(), key.GetIdentifier())
+}
+
+func hasFinalizer(obj runtime.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func addFinalizer(obj runtime.Object, finalizer string) {
+	if !hasFinalizer(obj, finalizer) {
+		obj.SetFinalizers(append(obj.GetFinalizers(), finalizer))
+	}
+}
+
+func removeFinalizer(obj runtime.Object, finalizer string) {
+	finalizers := make([]string, 0, len(obj.GetFinalizers()))
+	for _, f := range obj.GetFinalizers() {
+		if f != finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	obj.SetFinalizers(finalizers)
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testFinalizer = "sample-app.weave.works/cleanup"
+
+var motorcycleGVK = v1alpha1.SchemeGroupVersion.WithKind("Motorcycle")
+
+// newTow returns a Motorcycle owned by the Car with the given name
+func newTow(name, owner string) *v1alpha1.Motorcycle {
+	motorcycle := &v1alpha1.Motorcycle{}
+	motorcycle.SetGroupVersionKind(motorcycleGVK)
+	motorcycle.Name = name
+	motorcycle.Namespace = "default"
+	motorcycle.OwnerReferences = []metav1.OwnerReference{{APIVersion: carGVK.GroupVersion().String(), Kind: carGVK.Kind, Name: owner}}
+	return motorcycle
+}
+
+func motorcycleKey(name string) ObjectKey {
+	return NewObjectKey(NewKindKey(motorcycleGVK), runtime.NewIdentifier("default/"+name))
+}
+
+func newDeletionTestStorage(t *testing.T) *GenericStorage {
+	t.Helper()
+	// Patch requires JSON content
+	raw := NewGenericRawStorage(tempDir(t), v1alpha1.SchemeGroupVersion, serializer.ContentTypeJSON)
+	return NewGenericStorageWithOptions(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}, GenericStorageOptions{
+		DependentKinds: []KindKey{NewKindKey(carGVK), NewKindKey(motorcycleGVK)},
+	}).(*GenericStorage)
+}
+
+// existing returns the names of the Objects of the given kind
+func existing(t *testing.T, s Storage, gvk KindKey) []string {
+	t.Helper()
+	objs, err := s.List(context.Background(), gvk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		names = append(names, obj.GetName())
+	}
+	return names
+}
+
+func TestGenericStorage_Finalizers(t *testing.T) {
+	ctx := context.Background()
+	s := newDeletionTestStorage(t)
+
+	car := newCar("foo")
+	car.Finalizers = []string{testFinalizer}
+	if err := s.Create(ctx, car); err != nil {
+		t.Fatal(err)
+	}
+
+	// The finalizer keeps the Car, which is marked as being deleted
+	if err := s.Delete(ctx, carKey("foo")); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Get(ctx, carKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetDeletionTimestamp() == nil {
+		t.Fatal("expected Delete to set the deletionTimestamp")
+	}
+
+	// The deletionTimestamp can't be unset
+	obj.SetDeletionTimestamp(nil)
+	if err := s.Update(ctx, obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err = s.Get(ctx, carKey("foo")); err != nil {
+		t.Fatal(err)
+	} else if obj.GetDeletionTimestamp() == nil {
+		t.Error("expected Update to keep the deletionTimestamp")
+	}
+
+	// Removing the finalizer removes the Car
+	if err := s.Patch(ctx, carKey("foo"), []byte(`{"metadata":{"finalizers":null}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, carKey("foo")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGenericStorage_DeletePropagation(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy metav1.DeletionPropagation
+		// finalizer sets a finalizer on the Motorcycle "tow-1", which is removed after the Delete
+		finalizer bool
+		// wantCars and wantTows are the Objects left after the Delete
+		wantCars []string
+		wantTows []string
+		// wantFinalizedCars are the Cars left after removing the finalizer of "tow-1"
+		wantFinalizedCars []string
+	}{
+		{"Background", metav1.DeletePropagationBackground, false, []string{"other"}, []string{}, []string{"other"}},
+		{"Foreground", metav1.DeletePropagationForeground, false, []string{"other"}, []string{}, []string{"other"}},
+		{"Orphan", metav1.DeletePropagationOrphan, false, []string{"other"}, []string{"tow-1", "tow-2"}, []string{"other"}},
+		{"BackgroundFinalizer", metav1.DeletePropagationBackground, true, []string{"other"}, []string{"tow-1"}, []string{"other"}},
+		{"ForegroundFinalizer", metav1.DeletePropagationForeground, true, []string{"foo", "other"}, []string{"tow-1"}, []string{"other"}},
+		{"OrphanFinalizer", metav1.DeletePropagationOrphan, true, []string{"other"}, []string{"tow-1", "tow-2"}, []string{"other"}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newDeletionTestStorage(t)
+
+			tow1, tow2 := newTow("tow-1", "foo"), newTow("tow-2", "foo")
+			if rt.finalizer {
+				tow1.Finalizers = []string{testFinalizer}
+			}
+			for _, obj := range []runtime.Object{newCar("foo"), newCar("other"), tow1, tow2, newTow("tow-other", "other")} {
+				if err := s.Create(ctx, obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.DeleteWithPropagation(ctx, carKey("foo"), rt.policy); err != nil {
+				t.Fatal(err)
+			}
+			if got := existing(t, s, NewKindKey(carGVK)); !reflect.DeepEqual(got, rt.wantCars) {
+				t.Errorf("expected Cars %v, got %v", rt.wantCars, got)
+			}
+			// The Motorcycle of the other Car is never touched
+			wantTows := append(rt.wantTows, "tow-other")
+			if got := existing(t, s, NewKindKey(motorcycleGVK)); !reflect.DeepEqual(got, wantTows) {
+				t.Errorf("expected Motorcycles %v, got %v", wantTows, got)
+			}
+
+			if rt.policy == metav1.DeletePropagationOrphan {
+				obj, err := s.Get(ctx, motorcycleKey("tow-2"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if refs := obj.GetOwnerReferences(); len(refs) != 0 {
+					t.Errorf("expected the orphan to have no ownerReferences, got %v", refs)
+				}
+			}
+
+			if !rt.finalizer {
+				return
+			}
+			obj, err := s.Get(ctx, motorcycleKey("tow-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rt.policy != metav1.DeletePropagationOrphan && obj.GetDeletionTimestamp() == nil {
+				t.Error("expected the Motorcycle with the finalizer to be marked as being deleted")
+			}
+			obj.SetFinalizers(nil)
+			if err := s.Update(ctx, obj); err != nil {
+				t.Fatal(err)
+			}
+			if got := existing(t, s, NewKindKey(carGVK)); !reflect.DeepEqual(got, rt.wantFinalizedCars) {
+				t.Errorf("expected Cars %v after finalizing, got %v", rt.wantFinalizedCars, got)
+			}
+		})
+	}
+}
+
+func TestGenericStorage_PatchDeletionTimestamp(t *testing.T) {
+	tests := []struct {
+		name string
+		// deleting marks the Car as being deleted before patching it
+		deleting bool
+		patch    string
+	}{
+		{"Unset", true, `{"metadata":{"deletionTimestamp":null}}`},
+		{"Set", false, `{"metadata":{"deletionTimestamp":"2020-01-01T00:00:00Z"}}`},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newDeletionTestStorage(t)
+
+			car := newCar("foo")
+			car.Finalizers = []string{testFinalizer}
+			for _, obj := range []runtime.Object{car, newTow("tow", "foo")} {
+				if err := s.Create(ctx, obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if rt.deleting {
+				if err := s.Delete(ctx, carKey("foo")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The deletionTimestamp is kept as-is by Patch
+			if err := s.Patch(ctx, carKey("foo"), []byte(rt.patch)); err != nil {
+				t.Fatal(err)
+			}
+			obj, err := s.Get(ctx, carKey("foo"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleting := obj.GetDeletionTimestamp() != nil; deleting != rt.deleting {
+				t.Errorf("expected the Car to be marked as being deleted: %t, got %t", rt.deleting, deleting)
+			}
+
+			// Removing the finalizer only removes the Car if it's being deleted
+			if err := s.Patch(ctx, carKey("foo"), []byte(`{"metadata":{"finalizers":null}}`)); err != nil {
+				t.Fatal(err)
+			}
+			wantCars, wantTows := []string{"foo"}, []string{"tow"}
+			if rt.deleting {
+				wantCars, wantTows = []string{}, []string{}
+			}
+			if got := existing(t, s, NewKindKey(carGVK)); !reflect.DeepEqual(got, wantCars) {
+				t.Errorf("expected Cars %v, got %v", wantCars, got)
+			}
+			if got := existing(t, s, NewKindKey(motorcycleGVK)); !reflect.DeepEqual(got, wantTows) {
+				t.Errorf("expected Motorcycles %v, got %v", wantTows, got)
+			}
+		})
+	}
+}
+
+func TestGenericStorage_DeleteUndecodable(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"corrupt", `{"apiVersion": "sample-app.weave.works/v1alpha1", "kind": "Car", "metadata": {`},
+		{"unknown kind", `{"apiVersion": "sample-app.weave.works/v1alpha1", "kind": "Truck", "metadata": {"name": "foo", "namespace": "default"}}`},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			// The dependents of the Object can't be searched for, it's removed nevertheless
+			s := newDeletionTestStorage(t)
+			if err := s.raw.Write(ctx, carKey("foo"), []byte(rt.content)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete(ctx, carKey("foo")); err != nil {
+				t.Fatal(err)
+			}
+			if s.raw.Exists(ctx, carKey("foo")) {
+				t.Error("expected the Object to be removed")
+			}
+			if err := s.Delete(ctx, carKey("foo")); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound when deleting twice, got %v", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package storage
 
-import "github.com/weaveworks/libgitops/pkg/serializer"
+import (
+	"sort"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+)
 
 // ContentTypes describes the connection between
 // file extensions and a content types.
@@ -10,11 +14,18 @@ var ContentTypes = map[string]serializer.ContentType{
 	".yml":  serializer.ContentTypeYAML,
 }
 
+// extForContentType returns the first matching extension in alphabetical order,
+// so that the result is stable, e.g. ".yaml" for YAML.
 func extForContentType(wanted serializer.ContentType) string {
+	exts := make([]string, 0, len(ContentTypes))
 	for ext, ct := range ContentTypes {
 		if ct == wanted {
-			return ext
+			exts = append(exts, ext)
 		}
 	}
-	return ""
+	if len(exts) == 0 {
+		return ""
+	}
+	sort.Strings(exts)
+	return exts[0]
 }
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+)
+
+// yamlFrameSeparator is what separates two documents in a multi-frame file. This follows the
+// semantics of k8s.io/apimachinery/pkg/util/yaml.NewDocumentDecoder, which is what the
+// serializer's YAML FrameReader uses under the hood: the separator is "\n---", and the rest
+// of that line is discarded.
+var yamlFrameSeparator = []byte("\n---")
+
+// frameSpan describes the byte range [start, end) of one non-empty document in a file.
+type frameSpan struct {
+	start int
+	end   int
+}
+
+// frameSpans returns the byte ranges of all non-empty documents in content. Empty documents
+// (e.g. a leading "---") are skipped, which makes the frame indices match the ones returned
+// by serializer.ReadFrameList and DecodePartialObjects.
+func frameSpans(content []byte) []frameSpan {
+	// Prepending a newline makes a separator on the very first line match as well.
+	// Hence, all indices into data are offset by one compared to content.
+	data := append([]byte{'\n'}, content...)
+
+	var spans []frameSpan
+	add := func(start, end int) {
+		if end > start && len(bytes.TrimSpace(data[start:end])) > 0 {
+			spans = append(spans, frameSpan{start - 1, end - 1})
+		}
+	}
+
+	docStart, searchPos := 1, 0
+	for {
+		i := bytes.Index(data[searchPos:], yamlFrameSeparator)
+		if i < 0 {
+			// No more separators, the rest of the file is the last document
+			add(docStart, len(data))
+			return spans
+		}
+
+		// The document ends right before the newline of the separator
+		add(docStart, searchPos+i)
+
+		// Skip the rest of the separator line. The newline ending it might
+		// also be the start of the next separator, so search from there.
+		sepEnd := searchPos + i + len(yamlFrameSeparator)
+		j := bytes.IndexByte(data[sepEnd:], '\n')
+		if j < 0 {
+			return spans
+		}
+		searchPos = sepEnd + j
+		docStart = searchPos + 1
+	}
+}
+
+// SplitFrames splits the given content into its non-empty YAML documents. The indices of the
+// returned frames are the frame indices used in FileLocation.
+func SplitFrames(content []byte) [][]byte {
+	spans := frameSpans(content)
+	frames := make([][]byte, 0, len(spans))
+	for _, span := range spans {
+		frames = append(frames, content[span.start:span.end])
+	}
+	return frames
+}
+
+// replaceFrame returns content where the frame with the given index has been replaced with
+// frame. All other bytes in content are preserved as-is.
+func replaceFrame(content []byte, index int, frame []byte) ([]byte, error) {
+	spans := frameSpans(content)
+	if index < 0 || index >= len(spans) {
+		return nil, ErrFrameNotFound
+	}
+	span := spans[index]
+
+	// The separator in front of the next document starts with a newline already,
+	// avoid adding an empty line before it each time the frame is written.
+	if span.end < len(content) {
+		frame = bytes.TrimSuffix(frame, []byte("\n"))
+	}
+
+	result := make([]byte, 0, len(content)-(span.end-span.start)+len(frame))
+	result = append(result, content[:span.start]...)
+	result = append(result, frame...)
+	result = append(result, content[span.end:]...)
+	return result, nil
+}
+
+// removeFrame returns content where the frame with the given index, and one separator
+// next to it, has been removed. All other bytes in content are preserved as-is. If
+// the removed frame was the only one, an empty slice is returned.
+func removeFrame(content []byte, index int) ([]byte, error) {
+	spans := frameSpans(content)
+	if index < 0 || index >= len(spans) {
+		return nil, ErrFrameNotFound
+	}
+
+	switch {
+	case len(spans) == 1:
+		return []byte{}, nil
+	case index < len(spans)-1:
+		// Remove the document, and the separator following it
+		result := make([]byte, 0, len(content))
+		result = append(result, content[:spans[index].start]...)
+		return append(result, content[spans[index+1].start:]...), nil
+	default:
+		// Remove the last document and the separator preceding it, but keep the trailing newline
+		result := make([]byte, 0, spans[index-1].end+1)
+		result = append(result, content[:spans[index-1].end]...)
+		return append(result, '\n'), nil
+	}
+}
+
+// appendFrame returns content with frame added as a new last document. All bytes in
+// content are preserved as-is.
+func appendFrame(content, frame []byte) []byte {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return frame
+	}
+
+	result := make([]byte, 0, len(content)+len(frame)+5)
+	result = append(result, content...)
+	if !bytes.HasSuffix(result, []byte("\n")) {
+		result = append(result, '\n')
+	}
+	result = append(result, "---\n"...)
+	return append(result, frame...)
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	carYAML = `apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+`
+	motorcycleYAML = `apiVersion: sample-app.weave.works/v1alpha1
+kind: Motorcycle
+metadata:
+  name: bar
+`
+	// multiFrameYAML has a leading separator, a comment on a separator line and no trailing newline
+	multiFrameYAML = "---\n" + carYAML + "--- # the second one\n" + motorcycleYAML + "---\n" + "# a comment\nfoo: bar"
+)
+
+func TestSplitFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "single frame",
+			content: carYAML,
+			want:    []string{carYAML},
+		},
+		{
+			name:    "multiple frames",
+			content: multiFrameYAML,
+			want:    []string{carYAML[:len(carYAML)-1], motorcycleYAML[:len(motorcycleYAML)-1], "# a comment\nfoo: bar"},
+		},
+		{
+			name:    "empty frames are skipped",
+			content: "\n---\n\n---\n" + carYAML + "---\n",
+			want:    []string{carYAML[:len(carYAML)-1]},
+		},
+		{
+			name:    "empty content",
+			content: "",
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, frame := range SplitFrames([]byte(tt.content)) {
+				got = append(got, string(frame))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitFrames() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_replaceFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		index   int
+		frame   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "replace middle frame",
+			content: multiFrameYAML,
+			index:   1,
+			frame:   "kind: Motorcycle\n",
+			want:    "---\n" + carYAML + "--- # the second one\nkind: Motorcycle\n---\n# a comment\nfoo: bar",
+		},
+		{
+			name:    "replace last frame",
+			content: multiFrameYAML,
+			index:   2,
+			frame:   "foo: baz\n",
+			want:    "---\n" + carYAML + "--- # the second one\n" + motorcycleYAML + "---\nfoo: baz\n",
+		},
+		{
+			name:    "replacing with the same content is a no-op",
+			content: multiFrameYAML,
+			index:   0,
+			frame:   carYAML,
+			want:    multiFrameYAML,
+		},
+		{
+			name:    "out of range",
+			content: multiFrameYAML,
+			index:   3,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replaceFrame([]byte(tt.content), tt.index, []byte(tt.frame))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replaceFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("replaceFrame() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_removeFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		index   int
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "remove first frame",
+			content: multiFrameYAML,
+			index:   0,
+			want:    "---\n" + motorcycleYAML + "---\n# a comment\nfoo: bar",
+		},
+		{
+			name:    "remove last frame",
+			content: multiFrameYAML,
+			index:   2,
+			want:    "---\n" + carYAML + "--- # the second one\n" + motorcycleYAML,
+		},
+		{
+			name:    "remove only frame",
+			content: carYAML,
+			index:   0,
+			want:    "",
+		},
+		{
+			name:    "out of range",
+			content: carYAML,
+			index:   1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := removeFrame([]byte(tt.content), tt.index)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("removeFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("removeFrame() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_appendFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "empty file",
+			content: "\n",
+			want:    motorcycleYAML,
+		},
+		{
+			name:    "trailing newline",
+			content: carYAML,
+			want:    carYAML + "---\n" + motorcycleYAML,
+		},
+		{
+			name:    "no trailing newline",
+			content: multiFrameYAML,
+			want:    multiFrameYAML + "\n---\n" + motorcycleYAML,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := appendFrame([]byte(tt.content), []byte(motorcycleYAML))
+			if string(got) != tt.want {
+				t.Errorf("appendFrame() = %q, want %q", got, tt.want)
+			}
+			// The appended frame must be the last one when splitting again
+			frames := SplitFrames(got)
+			if last := string(frames[len(frames)-1]); last != motorcycleYAML {
+				t.Errorf("expected the last frame to be the appended one, got %q", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+)
+
+// ObjectIterator iterates over the Objects returned by ReadStorage.ListIterator.
+// An ObjectIterator is not safe for concurrent use.
+type ObjectIterator interface {
+	// Next advances to the next Object, which is then returned by Object. Next returns false
+	// when there are no more Objects, the Limit has been reached, or an error occurred.
+	Next() bool
+	// Object returns the current Object.
+	Object() runtime.Object
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+	// Continue returns the continue token for listing the Objects after the current one, if
+	// the iteration stopped because the Limit was reached. Otherwise it's empty. The next page
+	// may still be empty, if none of the remaining Objects match the filters.
+	Continue() string
+}
+
+// ListPage lists one page of the Objects of the given kind, see ReadStorage.ListIterator.
+// If there are more Objects than the given filter.Limit, the continue token for the next page
+// is returned as well. Pass it as filter.Continue to List the next page.
+func ListPage(ctx context.Context, s ReadStorage, kind KindKey, opts ...filter.ListOption) ([]runtime.Object, string, error) {
+	it, err := s.ListIterator(ctx, kind, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make([]runtime.Object, 0)
+	for it.Next() {
+		result = append(result, it.Object())
+	}
+	if err := it.Err(); err != nil {
+		return nil, "", err
+	}
+	return result, it.Continue(), nil
+}
+
+// ListIterator returns an ObjectIterator over the Objects of the given kind. When ordering by name,
+// the Objects are read and decoded one at a time as the iterator advances, and only until the Limit
+// is reached. Other orderings read all Objects of the kind before returning the first one, but only
+// decode their metadata when ordering by a metadata field. The filter.MetaFilters before the first
+// filter needing the full list of Objects are applied to the metadata of the Objects first, and only
+// the Objects passing them are fully decoded. The filters created from filter.ObjectFilters before it
+// are applied to the Objects one at a time as well. The remaining filters are applied in order to the
+// full list of the Objects passing the others, so all of them are decoded before the first one is
+// returned. See filter.ListOptions.SplitFilters.
+func (s *GenericStorage) ListIterator(ctx context.Context, kind KindKey, opts ...filter.ListOption) (ObjectIterator, error) {
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.newListIterator(ctx, kind, o, false)
+}
+
+// newListIterator returns a listIterator for the given options. If metaOnly is set, the iterator
+// returns runtime.PartialObjects, and the options must only filter and order by metadata.
+func (s *GenericStorage) newListIterator(ctx context.Context, kind KindKey, o *filter.ListOptions, metaOnly bool) (*listIterator, error) {
+	it := &listIterator{ctx: ctx, s: s, opts: o, metaOnly: metaOnly}
+	it.metaFilters, it.objectFilters, it.listFilters = o.SplitFilters()
+
+	var err error
+	if it.entries, err = s.listEntries(ctx, kind, o.SortBy, it.metaFilters); err != nil {
+		return nil, err
+	}
+	if err := it.filterList(); err != nil {
+		return nil, err
+	}
+
+	if it.entries, err = skipContinued(it.entries, o); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// NewObjectIterator returns an ObjectIterator over the given Objects, keyed by their identifiers.
+// The filters, ordering and pagination of the options are applied like by GenericStorage.ListIterator.
+// This is meant for Storages keeping decoded Objects in memory, e.g. caches.
+func NewObjectIterator(ctx context.Context, objs map[string]runtime.Object, opts ...filter.ListOption) (ObjectIterator, error) {
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &listIterator{ctx: ctx, opts: o}
+	it.metaFilters, it.objectFilters, it.listFilters = o.SplitFilters()
+	for identifier, obj := range objs {
+		sortKey, err := o.SortBy.KeyFor(obj, identifier)
+		if err != nil {
+			return nil, err
+		}
+		it.entries = append(it.entries, listEntry{sortKey: sortKey, obj: obj})
+	}
+	sortEntries(it.entries, o.SortBy)
+	if err := it.filterList(); err != nil {
+		return nil, err
+	}
+
+	if it.entries, err = skipContinued(it.entries, o); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// sortEntries orders the entries by sortBy
+func sortEntries(entries []listEntry, sortBy filter.SortBy) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return sortBy.Compare(entries[i].sortKey, entries[j].sortKey) < 0
+	})
+}
+
+// skipContinued skips the ordered entries up to and including the last one of the previous page
+func skipContinued(entries []listEntry, o *filter.ListOptions) ([]listEntry, error) {
+	if len(o.Continue) == 0 {
+		return entries, nil
+	}
+
+	last, err := o.SortBy.ParseContinue(o.Continue)
+	if err != nil {
+		return nil, err
+	}
+	return entries[sort.Search(len(entries), func(i int) bool {
+		return o.SortBy.Compare(entries[i].sortKey, last) > 0
+	}):], nil
+}
+
+// listEntry is an Object to be returned by a listIterator
+type listEntry struct {
+	key     ObjectKey
+	sortKey filter.SortKey
+	// meta is set if the metadata had to be decoded for ordering the Object. It
+	// has passed the MetaFilters already.
+	meta runtime.PartialObject
+	// obj is set if the Object had to be decoded for ordering it, or for applying the ListFilters
+	obj runtime.Object
+	// filtered is set if obj has passed all filters already
+	filtered bool
+}
+
+// listEntries returns the entries for all Objects of the given kind, ordered by sortBy. If the metadata
+// of the Objects is decoded for ordering them, the Objects not passing metaFilters are left out.
+func (s *GenericStorage) listEntries(ctx context.Context, kind KindKey, sortBy filter.SortBy, metaFilters []filter.ListFilter) ([]listEntry, error) {
+	var entries []listEntry
+	var err error
+	if sortBy.ByName() {
+		// The Objects can be ordered by their keys, and decoded later
+		keys, err := s.raw.List(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			entries = append(entries, listEntry{key: key, sortKey: filter.SortKey{ID: key.GetIdentifier()}})
+		}
+	} else if sortBy.MetaOnly() {
+		err = s.walkKind(ctx, kind, func(key ObjectKey, content []byte, resourceVersion string) error {
+			meta, err := s.decodeMeta(key, content)
+			if err != nil {
+				return err
+			}
+			meta.SetResourceVersion(resourceVersion)
+
+			if obj, err := applyFilters(metaFilters, meta); err != nil || obj == nil {
+				return err
+			}
+			sortKey, err := sortBy.KeyFor(meta, key.GetIdentifier())
+			if err != nil {
+				return err
+			}
+			entries = append(entries, listEntry{key: key, sortKey: sortKey, meta: meta})
+			return nil
+		})
+	} else {
+		err = s.walkKind(ctx, kind, func(key ObjectKey, content []byte, resourceVersion string) error {
+			obj, err := s.decode(key, content)
+			if err != nil {
+				return err
+			}
+			obj.SetResourceVersion(resourceVersion)
+
+			sortKey, err := sortBy.KeyFor(obj, key.GetIdentifier())
+			if err != nil {
+				return err
+			}
+			entries = append(entries, listEntry{key: key, sortKey: sortKey, obj: obj})
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sortEntries(entries, sortBy)
+	return entries, nil
+}
+
+// applyFilters pipes the Object through the given list filters, in order. If it
+// doesn't pass them, nil is returned. This is only meant for the filters applied
+// per Object, i.e. the filter.MetaFilters and the ones created from filter.ObjectFilters.
+func applyFilters(filters []filter.ListFilter, obj runtime.Object) (runtime.Object, error) {
+	objs := []runtime.Object{obj}
+	for _, f := range filters {
+		var err error
+		if objs, err = f.Filter(objs...); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(objs) == 0 {
+		return nil, nil
+	}
+	return objs[0], nil
+}
+
+// listIterator implements ObjectIterator for GenericStorage, and for decoded Objects
+type listIterator struct {
+	ctx context.Context
+	// s is only used for reading the Objects not decoded yet
+	s        *GenericStorage
+	opts     *filter.ListOptions
+	metaOnly bool
+	// metaFilters and objectFilters are applied per Object, listFilters to all Objects at once
+	metaFilters   []filter.ListFilter
+	objectFilters []filter.ListFilter
+	listFilters   []filter.ListFilter
+	entries       []listEntry
+
+	obj      runtime.Object
+	last     filter.SortKey
+	returned int64
+	cont     string
+	err      error
+}
+
+var _ ObjectIterator = &listIterator{}
+
+func (it *listIterator) Next() bool {
+	it.obj = nil
+	if it.err != nil {
+		return false
+	}
+
+	for len(it.entries) > 0 {
+		// Only return a continue token if there are Objects left after reaching the Limit
+		if it.opts.Limit > 0 && it.returned == it.opts.Limit {
+			it.cont = it.opts.SortBy.ContinueToken(it.last)
+			return false
+		}
+
+		entry := it.entries[0]
+		it.entries = it.entries[1:]
+
+		obj, err := it.resolve(entry)
+		if err != nil {
+			it.err = err
+			return false
+		} else if obj == nil {
+			continue // The Object doesn't pass the filters, or was deleted after listing the keys
+		}
+
+		it.obj = obj
+		it.last = entry.sortKey
+		it.returned++
+		return true
+	}
+	return false
+}
+
+// resolve decodes the Object of the entry, and applies the filters to it. The MetaFilters are
+// applied before decoding the full Object. If the Object doesn't pass the filters, or doesn't
+// exist anymore, nil is returned.
+func (it *listIterator) resolve(entry listEntry) (runtime.Object, error) {
+	if entry.obj != nil {
+		if entry.filtered {
+			return entry.obj, nil
+		}
+		if obj, err := applyFilters(it.metaFilters, entry.obj); err != nil || obj == nil {
+			return nil, err
+		}
+		return applyFilters(it.objectFilters, entry.obj)
+	}
+
+	var content []byte
+	var resourceVersion string
+	var err error
+	meta := entry.meta
+	if meta == nil {
+		if content, resourceVersion, err = it.read(entry.key); err != nil || content == nil {
+			return nil, err
+		}
+		if meta, err = it.s.decodeMeta(entry.key, content); err != nil {
+			return nil, err
+		}
+		meta.SetResourceVersion(resourceVersion)
+
+		if obj, err := applyFilters(it.metaFilters, meta); err != nil || obj == nil {
+			return nil, err
+		}
+	}
+	if it.metaOnly {
+		return meta, nil
+	}
+
+	// Only the Objects passing the MetaFilters are fully decoded
+	if content == nil {
+		if content, resourceVersion, err = it.read(entry.key); err != nil || content == nil {
+			return nil, err
+		}
+	}
+	obj, err := it.s.decode(entry.key, content)
+	if err != nil {
+		return nil, err
+	}
+	obj.SetResourceVersion(resourceVersion)
+	return applyFilters(it.objectFilters, obj)
+}
+
+// filterList applies the ListFilters that aren't applied per Object to the full list of the Objects
+// passing the other filters, which requires resolving all entries up front. The ListFilters must
+// return a subset of the Objects they are given.
+func (it *listIterator) filterList() error {
+	if len(it.listFilters) == 0 {
+		return nil
+	}
+
+	objs := make([]runtime.Object, 0, len(it.entries))
+	entries := make(map[runtime.Object]listEntry, len(it.entries))
+	for _, entry := range it.entries {
+		obj, err := it.resolve(entry)
+		if err != nil {
+			return err
+		} else if obj == nil {
+			continue
+		}
+
+		entry.obj, entry.filtered = obj, true
+		objs = append(objs, obj)
+		entries[obj] = entry
+	}
+
+	for _, f := range it.listFilters {
+		var err error
+		if objs, err = f.Filter(objs...); err != nil {
+			return err
+		}
+	}
+
+	it.entries = make([]listEntry, 0, len(objs))
+	for _, obj := range objs {
+		entry, ok := entries[obj]
+		if !ok {
+			return fmt.Errorf("ListFilter returned an Object it wasn't given: %s", obj.GetName())
+		}
+		it.entries = append(it.entries, entry)
+	}
+	// The ListFilters may have reordered the Objects
+	sortEntries(it.entries, it.opts.SortBy)
+	return nil
+}
+
+// read returns the content and resourceVersion of the Object. If it doesn't exist anymore, nil is returned.
+func (it *listIterator) read(key ObjectKey) ([]byte, string, error) {
+	// Stop reading as soon as the caller isn't interested in the result anymore
+	if err := it.ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	if !it.s.raw.Exists(it.ctx, key) {
+		return nil, "", nil
+	}
+
+	content, resourceVersion, err := it.s.read(it.ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", nil
+	}
+	return content, resourceVersion, err
+}
+
+func (it *listIterator) Object() runtime.Object {
+	return it.obj
+}
+
+func (it *listIterator) Err() error {
+	return it.err
+}
+
+func (it *listIterator) Continue() string {
+	return it.cont
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+)
+
+// newListTestStorage returns a Storage with the Cars car-0 to car-4, which have the speeds 40, 30, 20, 10, 0
+func newListTestStorage(t *testing.T) Storage {
+	t.Helper()
+	s := newTestStorage(t, NewGenericRawStorage(tempDir(t), v1alpha1.SchemeGroupVersion, serializer.ContentTypeJSON))
+	for i := 0; i < 5; i++ {
+		car := newCar(fmt.Sprintf("car-%d", i))
+		car.Status.Speed = float64(40 - 10*i)
+		if err := s.Create(context.Background(), car); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func names(objs []runtime.Object) []string {
+	result := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.GetName())
+	}
+	return result
+}
+
+func TestGenericStorage_ListSorted(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []filter.ListOption
+		want []string
+	}{
+		{"default", nil, []string{"car-0", "car-1", "car-2", "car-3", "car-4"}},
+		{"descending", []filter.ListOption{filter.SortBy{Descending: true}}, []string{"car-4", "car-3", "car-2", "car-1", "car-0"}},
+		{"path", []filter.ListOption{filter.SortBy{Field: "status.speed"}}, []string{"car-4", "car-3", "car-2", "car-1", "car-0"}},
+		{"limit", []filter.ListOption{filter.Limit(2)}, []string{"car-0", "car-1"}},
+		{"filtered", []filter.ListOption{filter.FieldFilter{Requirements: []filter.FieldRequirement{{Path: "status.speed", Operator: ">", Value: "15"}}}, filter.Limit(2), filter.SortBy{Field: "status.speed"}}, []string{"car-2", "car-1"}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			objs, err := newListTestStorage(t).List(context.Background(), NewKindKey(carGVK), rt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(objs); !reflect.DeepEqual(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+}
+
+func TestListPage(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		sortBy filter.SortBy
+		want   []string
+	}{
+		{"name", filter.SortBy{}, []string{"car-0", "car-1", "car-2", "car-3", "car-4"}},
+		{"path", filter.SortBy{Field: "status.speed"}, []string{"car-4", "car-3", "car-2", "car-1", "car-0"}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			s := newListTestStorage(t)
+
+			var got []string
+			var cont string
+			for page := 0; page == 0 || len(cont) > 0; page++ {
+				objs, next, err := ListPage(ctx, s, NewKindKey(carGVK), rt.sortBy, filter.Limit(2), filter.Continue(cont))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, names(objs)...)
+				cont = next
+
+				// Objects created or deleted between the pages don't affect the other Objects
+				if page == 0 {
+					if err := s.Delete(ctx, carKey(objs[0].GetName())); err != nil {
+						t.Fatal(err)
+					}
+					if err := s.Create(ctx, newCar("car-00")); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+}
+
+func TestListPage_InvalidContinue(t *testing.T) {
+	ctx := context.Background()
+	s := newListTestStorage(t)
+
+	_, cont, err := ListPage(ctx, s, NewKindKey(carGVK), filter.Limit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The token can't be used with another ordering
+	_, _, err = ListPage(ctx, s, NewKindKey(carGVK), filter.Continue(cont), filter.SortBy{Descending: true})
+	if !errors.Is(err, filter.ErrInvalidContinue) {
+		t.Errorf("expected ErrInvalidContinue, got %v", err)
+	}
+	if _, _, err := ListPage(ctx, s, NewKindKey(carGVK), filter.Continue("foo")); !errors.Is(err, filter.ErrInvalidContinue) {
+		t.Errorf("expected ErrInvalidContinue, got %v", err)
+	}
+}
+
+func TestGenericStorage_ListMetaFilters(t *testing.T) {
+	ctx := context.Background()
+	s := newListTestStorage(t)
+
+	// The spec of this Car can't be decoded, so any List fully decoding it fails
+	broken := []byte(`{"apiVersion":"sample-app.weave.works/v1alpha1","kind":"Car","metadata":{"name":"broken","namespace":"default"},"spec":{"brand":5}}`)
+	if err := s.RawStorage().Write(ctx, carKey("broken"), broken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Filters on metadata are applied before fully decoding the Objects
+	obj, err := s.Find(ctx, NewKindKey(carGVK), filter.NameFilter{Name: "car-1", Namespace: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if car, ok := obj.(*v1alpha1.Car); !ok || car.Spec.Brand != "Volvo" {
+		t.Errorf("expected the fully decoded Car car-1, got %v", obj)
+	}
+	objs, err := s.List(ctx, NewKindKey(carGVK), filter.SortBy{Field: filter.SortByCreationTimestamp}, filter.FieldFilter{
+		Requirements: []filter.FieldRequirement{{Path: "metadata.name", Operator: "!=", Value: "broken"}},
+	})
+	if err != nil || len(objs) != 5 {
+		t.Errorf("expected the 5 Cars that can be decoded, got %d (%v)", len(objs), err)
+	}
+	if _, err := s.List(ctx, NewKindKey(carGVK), filter.FieldFilter{
+		Requirements: []filter.FieldRequirement{{Path: "spec.brand", Operator: "=", Value: "Volvo"}},
+	}); err == nil {
+		t.Error("expected filtering on the spec to decode the broken Car")
+	}
+
+	metas, err := s.ListMeta(ctx, NewKindKey(carGVK), filter.NameFilter{Name: "car-", MatchPrefix: true}, filter.Limit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 || metas[0].GetName() != "car-0" || metas[1].GetName() != "car-1" {
+		t.Errorf("expected the metadata of car-0 and car-1, got %v", metas)
+	}
+	if _, err := s.ListMeta(ctx, NewKindKey(carGVK), filter.SortBy{Field: "status.speed"}); !errors.Is(err, filter.ErrInvalidFilterParams) {
+		t.Errorf("expected ListMeta to reject ordering by the status, got %v", err)
+	}
+}
+
+// fastestFilter is a ListFilter that only keeps the fastest Car of the ones it's given
+type fastestFilter struct{}
+
+func (fastestFilter) Filter(objs ...runtime.Object) ([]runtime.Object, error) {
+	var fastest *v1alpha1.Car
+	for _, obj := range objs {
+		if car := obj.(*v1alpha1.Car); fastest == nil || car.Status.Speed > fastest.Status.Speed {
+			fastest = car
+		}
+	}
+	if fastest == nil {
+		return nil, nil
+	}
+	return []runtime.Object{fastest}, nil
+}
+
+func (f fastestFilter) ApplyToListOptions(target *filter.ListOptions) error {
+	target.Filters = append(target.Filters, f)
+	return nil
+}
+
+func TestGenericStorage_ListFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []filter.ListOption
+		want []string
+	}{
+		{"all", []filter.ListOption{fastestFilter{}}, []string{"car-0"}},
+		{"after name filter", []filter.ListOption{filter.NameFilter{Name: "car-3", Namespace: "default"}, fastestFilter{}}, []string{"car-3"}},
+		{"before name filter", []filter.ListOption{fastestFilter{}, filter.NameFilter{Name: "car-3", Namespace: "default"}}, []string{}},
+		{"with limit", []filter.ListOption{fastestFilter{}, filter.Limit(1), filter.SortBy{Field: "status.speed"}}, []string{"car-0"}},
+		{"after field filter", []filter.ListOption{filter.FieldFilter{Requirements: []filter.FieldRequirement{{Path: "status.speed", Operator: "<", Value: "25"}}}, fastestFilter{}}, []string{"car-2"}},
+		{"before field filter", []filter.ListOption{fastestFilter{}, filter.FieldFilter{Requirements: []filter.FieldRequirement{{Path: "status.speed", Operator: "<", Value: "25"}}}}, []string{}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			// The ListFilter is given all Objects passing the filters before it at once, not one at a
+			// time, and the filters after it are applied to its result
+			objs, err := newListTestStorage(t).List(context.Background(), NewKindKey(carGVK), rt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(objs); !reflect.DeepEqual(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// PathLayout decides where a GenericRawStorage stores Objects, and how to get the
+// ObjectKey back from a path. All paths are slash-separated, and relative to the
+// directory of the storage.
+type PathLayout interface {
+	// ObjectPath returns the path of the file storing the Object with the given key.
+	// The version of the key must not affect the path.
+	ObjectPath(key ObjectKey, ext string) string
+	// KindPath returns the directory containing the files of all Objects of the given kind.
+	// It may contain files of other kinds as well.
+	KindPath(kind KindKey) string
+	// ObjectKeyFor returns the ObjectKey for the given path, inverting ObjectPath. The group
+	// and version of the key are chosen among gvs, the GroupVersions served by the storage,
+	// if they aren't part of the path. An error is returned if the path doesn't match the layout.
+	ObjectKeyFor(p, ext string, gvs []schema.GroupVersion) (ObjectKey, error)
+	// ValidateGroupVersions returns an error if the Objects of the given GroupVersions can't
+	// be told apart by their paths, so a storage can't serve all of them with this layout.
+	ValidateGroupVersions(gvs []schema.GroupVersion) error
+}
+
+// NewLegacyLayout returns the PathLayout used by NewGenericRawStorage, in the form:
+// <kind>/<identifier>/metadata<ext>. Namespaced identifiers result in nested directories,
+// i.e. <kind>/<namespace>/<name>/metadata<ext>. As the group isn't part of the path, the
+// storage may only serve a single group, and the first served GroupVersion is used for the keys.
+func NewLegacyLayout() PathLayout {
+	return legacyLayout{}
+}
+
+type legacyLayout struct{}
+
+func (legacyLayout) ObjectPath(key ObjectKey, ext string) string {
+	return path.Join(key.GetKind(), key.GetIdentifier(), "metadata"+ext)
+}
+
+func (legacyLayout) KindPath(kind KindKey) string {
+	return kind.GetKind()
+}
+
+func (legacyLayout) ObjectKeyFor(p, ext string, gvs []schema.GroupVersion) (ObjectKey, error) {
+	parts := strings.Split(p, "/")
+	if len(parts) < 3 || parts[len(parts)-1] != "metadata"+ext || len(gvs) == 0 {
+		return nil, fmt.Errorf("path %q doesn't match the <kind>/<identifier>/metadata%s layout", p, ext)
+	}
+	if err := (legacyLayout{}).ValidateGroupVersions(gvs); err != nil {
+		return nil, fmt.Errorf("path %q: %w", p, err)
+	}
+
+	kind := gvs[0].WithKind(parts[0])
+	identifier := strings.Join(parts[1:len(parts)-1], "/")
+	return NewObjectKey(NewKindKey(kind), runtime.NewIdentifier(identifier)), nil
+}
+
+func (legacyLayout) ValidateGroupVersions(gvs []schema.GroupVersion) error {
+	for _, gv := range gvs {
+		if gv.Group != gvs[0].Group {
+			return fmt.Errorf("the group isn't part of the <kind>/<identifier>/metadata layout, it can't serve both %q and %q", gvs[0].Group, gv.Group)
+		}
+	}
+	return nil
+}
+
+// NewGroupKindLayout returns a PathLayout in the form: <group>/<kind>/<namespace>/<name><ext>,
+// or <group>/<kind>/<name><ext> for non-namespaced Objects. The core group is stored as "core".
+// The version of the keys is the one of the first served GroupVersion with the same group.
+func NewGroupKindLayout() PathLayout {
+	return groupKindLayout{}
+}
+
+// coreGroupDir is the directory name for the core ("") group
+const coreGroupDir = "core"
+
+type groupKindLayout struct{}
+
+func (groupKindLayout) ObjectPath(key ObjectKey, ext string) string {
+	return path.Join(groupDir(key.GetGroup()), key.GetKind(), key.GetIdentifier()) + ext
+}
+
+func (groupKindLayout) KindPath(kind KindKey) string {
+	return path.Join(groupDir(kind.GetGroup()), kind.GetKind())
+}
+
+func (groupKindLayout) ObjectKeyFor(p, ext string, gvs []schema.GroupVersion) (ObjectKey, error) {
+	parts := strings.Split(strings.TrimSuffix(p, ext), "/")
+	if !strings.HasSuffix(p, ext) || len(parts) < 3 || len(parts) > 4 {
+		return nil, fmt.Errorf("path %q doesn't match the <group>/<kind>/[<namespace>/]<name>%s layout", p, ext)
+	}
+
+	group := parts[0]
+	if group == coreGroupDir {
+		group = ""
+	}
+	gv, err := servedVersion(group, gvs)
+	if err != nil {
+		return nil, fmt.Errorf("path %q: %w", p, err)
+	}
+	identifier := strings.Join(parts[2:], "/")
+	return NewObjectKey(NewKindKey(gv.WithKind(parts[1])), runtime.NewIdentifier(identifier)), nil
+}
+
+// ValidateGroupVersions accepts all GroupVersions, as the group is part of the path
+func (groupKindLayout) ValidateGroupVersions(_ []schema.GroupVersion) error {
+	return nil
+}
+
+// NewFlatLayout returns a PathLayout storing all Objects of a kind in the same directory,
+// without any nested directories, in the form: <kind>.<group>/<namespace>_<name><ext>, or
+// <kind>.<group>/<name><ext> for non-namespaced Objects. For the core group, the directory
+// is just <kind>. Namespaces and names can't contain underscores, so the paths are unambiguous.
+// The version of the keys is the one of the first served GroupVersion with the same group.
+func NewFlatLayout() PathLayout {
+	return flatLayout{}
+}
+
+type flatLayout struct{}
+
+func (flatLayout) ObjectPath(key ObjectKey, ext string) string {
+	return path.Join(flatLayout{}.KindPath(key), strings.Replace(key.GetIdentifier(), "/", "_", 1)+ext)
+}
+
+func (flatLayout) KindPath(kind KindKey) string {
+	if len(kind.GetGroup()) == 0 {
+		return kind.GetKind()
+	}
+	return kind.GetKind() + "." + kind.GetGroup()
+}
+
+func (flatLayout) ObjectKeyFor(p, ext string, gvs []schema.GroupVersion) (ObjectKey, error) {
+	parts := strings.Split(p, "/")
+	if len(parts) != 2 || !strings.HasSuffix(p, ext) {
+		return nil, fmt.Errorf("path %q doesn't match the <kind>.<group>/[<namespace>_]<name>%s layout", p, ext)
+	}
+
+	// Kinds can't contain dots, but groups can
+	kind, group := parts[0], ""
+	if i := strings.Index(parts[0], "."); i >= 0 {
+		kind, group = parts[0][:i], parts[0][i+1:]
+	}
+	gv, err := servedVersion(group, gvs)
+	if err != nil {
+		return nil, fmt.Errorf("path %q: %w", p, err)
+	}
+	identifier := strings.Replace(strings.TrimSuffix(parts[1], ext), "_", "/", 1)
+	return NewObjectKey(NewKindKey(gv.WithKind(kind)), runtime.NewIdentifier(identifier)), nil
+}
+
+// ValidateGroupVersions accepts all GroupVersions, as the group is part of the path
+func (flatLayout) ValidateGroupVersions(_ []schema.GroupVersion) error {
+	return nil
+}
+
+func groupDir(group string) string {
+	if len(group) == 0 {
+		return coreGroupDir
+	}
+	return group
+}
+
+// servedVersion returns the first of gvs with the given group
+func servedVersion(group string, gvs []schema.GroupVersion) (schema.GroupVersion, error) {
+	for _, gv := range gvs {
+		if gv.Group == group {
+			return gv, nil
+		}
+	}
+	return schema.GroupVersion{}, fmt.Errorf("group %q isn't served by this storage", group)
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	appsGV = schema.GroupVersion{Group: "apps.example.com", Version: "v1"}
+	coreGV = schema.GroupVersion{Version: "v1"}
+)
+
+func newKey(gv schema.GroupVersion, kind, identifier string) ObjectKey {
+	return NewObjectKey(NewKindKey(gv.WithKind(kind)), runtime.NewIdentifier(identifier))
+}
+
+func TestPathLayouts(t *testing.T) {
+	gvs := []schema.GroupVersion{appsGV, coreGV}
+	tests := []struct {
+		name   string
+		layout PathLayout
+		key    ObjectKey
+		want   string
+		// gvs are the served GroupVersions, if the layout can't serve both appsGV and coreGV
+		gvs []schema.GroupVersion
+	}{
+		{
+			name:   "legacy",
+			layout: NewLegacyLayout(),
+			key:    newKey(appsGV, "Car", "default/volvo"),
+			want:   "Car/default/volvo/metadata.yaml",
+			gvs:    []schema.GroupVersion{appsGV},
+		},
+		{
+			name:   "legacy non-namespaced",
+			layout: NewLegacyLayout(),
+			key:    newKey(appsGV, "Car", "volvo"),
+			want:   "Car/volvo/metadata.yaml",
+			gvs:    []schema.GroupVersion{appsGV},
+		},
+		{
+			name:   "group/kind",
+			layout: NewGroupKindLayout(),
+			key:    newKey(appsGV, "Car", "default/volvo"),
+			want:   "apps.example.com/Car/default/volvo.yaml",
+		},
+		{
+			name:   "group/kind core",
+			layout: NewGroupKindLayout(),
+			key:    newKey(coreGV, "Node", "node-1"),
+			want:   "core/Node/node-1.yaml",
+		},
+		{
+			name:   "flat",
+			layout: NewFlatLayout(),
+			key:    newKey(appsGV, "Car", "default/volvo"),
+			want:   "Car.apps.example.com/default_volvo.yaml",
+		},
+		{
+			name:   "flat core",
+			layout: NewFlatLayout(),
+			key:    newKey(coreGV, "Node", "node-1"),
+			want:   "Node/node-1.yaml",
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			p := rt.layout.ObjectPath(rt.key, ".yaml")
+			if p != rt.want {
+				t.Fatalf("expected path %q, got %q", rt.want, p)
+			}
+
+			served := gvs
+			if rt.gvs != nil {
+				served = rt.gvs
+			}
+			key, err := rt.layout.ObjectKeyFor(p, ".yaml", served)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.GetGVK() != rt.key.GetGVK() || key.GetIdentifier() != rt.key.GetIdentifier() {
+				t.Errorf("expected key %s %s, got %s %s", rt.key.GetGVK(), rt.key.GetIdentifier(), key.GetGVK(), key.GetIdentifier())
+			}
+		})
+	}
+}
+
+func TestPathLayouts_Invalid(t *testing.T) {
+	gvs := []schema.GroupVersion{appsGV}
+	tests := []struct {
+		name   string
+		layout PathLayout
+		path   string
+	}{
+		{name: "legacy no metadata file", layout: NewLegacyLayout(), path: "Car/volvo.yaml"},
+		{name: "group/kind too shallow", layout: NewGroupKindLayout(), path: "Car/volvo.yaml"},
+		{name: "group/kind wrong extension", layout: NewGroupKindLayout(), path: "apps.example.com/Car/volvo.json"},
+		{name: "group/kind unserved group", layout: NewGroupKindLayout(), path: "core/Node/node-1.yaml"},
+		{name: "flat nested", layout: NewFlatLayout(), path: "Car.apps.example.com/default/volvo.yaml"},
+		{name: "flat unserved group", layout: NewFlatLayout(), path: "Car.other.example.com/volvo.yaml"},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			if key, err := rt.layout.ObjectKeyFor(rt.path, ".yaml", gvs); err == nil {
+				t.Errorf("expected an error, got key %s %s", key.GetGVK(), key.GetIdentifier())
+			}
+		})
+	}
+}
+
+func TestGenericRawStorage_Layout(t *testing.T) {
+	ctx := context.Background()
+	// The legacy layout doesn't include the group in the path, so it can only serve one group per kind
+	for _, layout := range []PathLayout{NewGroupKindLayout(), NewFlatLayout()} {
+		dir := tempDir(t)
+		raw, err := NewGenericRawStorageWithLayout(dir, layout, serializer.ContentTypeYAML, appsGV, coreGV)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := []ObjectKey{
+			newKey(appsGV, "Car", "default/volvo"),
+			newKey(appsGV, "Car", "default/saab"),
+			newKey(appsGV, "Car", "kube-system/volvo"),
+			newKey(coreGV, "Car", "tesla"),
+		}
+		for _, key := range keys {
+			if err := raw.Write(ctx, key, []byte("content")); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := raw.GetKey(filepath.Join(dir, filepath.FromSlash(layout.ObjectPath(key, ".yaml")))); err != nil || got.GetGVK() != key.GetGVK() {
+				t.Errorf("%T: GetKey didn't invert the path of %s: %v", layout, key.GetIdentifier(), err)
+			}
+		}
+
+		// Only the Objects of the apps group are listed, even if the kinds are equal
+		listed, err := raw.List(ctx, NewKindKey(appsGV.WithKind("Car")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, key := range listed {
+			ids = append(ids, key.GetIdentifier())
+		}
+		sort.Strings(ids)
+		if want := []string{"default/saab", "default/volvo", "kube-system/volvo"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("%T: expected %v to be listed, got %v", layout, want, ids)
+		}
+
+		// Unsupported GroupVersions are rejected
+		if err := raw.Write(ctx, newKey(schema.GroupVersion{Group: "other", Version: "v1"}, "Car", "volvo"), nil); err == nil {
+			t.Errorf("%T: expected an error for an unsupported GroupVersion", layout)
+		}
+
+		if err := raw.Delete(ctx, keys[2]); err != nil {
+			t.Fatal(err)
+		}
+		if raw.Exists(ctx, keys[2]) || !raw.Exists(ctx, keys[0]) {
+			t.Errorf("%T: expected only %s to be deleted", layout, keys[2].GetIdentifier())
+		}
+	}
+}
+
+func TestNewGenericRawStorageWithOptions_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		ct   serializer.ContentType
+		gvs  []schema.GroupVersion
+	}{
+		{name: "no GroupVersions", ct: serializer.ContentTypeYAML},
+		{name: "unsupported content type", ct: "text/plain", gvs: []schema.GroupVersion{appsGV}},
+		{name: "legacy layout with multiple groups", ct: serializer.ContentTypeYAML, gvs: []schema.GroupVersion{appsGV, coreGV}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			if _, err := NewGenericRawStorageWithOptions(tempDir(t), rt.ct, GenericRawStorageOptions{}, rt.gvs...); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
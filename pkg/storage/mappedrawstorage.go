@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
@@ -15,103 +20,299 @@ import (
 var (
 	// ErrNotTracked is returned when the requested resource wasn't found.
 	ErrNotTracked = fmt.Errorf("untracked object: %w", ErrNotFound)
+	// ErrFrameNotFound is returned when the file of a mapping doesn't contain the mapped frame (anymore).
+	ErrFrameNotFound = errors.New("frame not found in file")
 )
 
+// FileLocation describes where an Object is stored on disk: as the
+// Frame'th (zero-indexed) document of the file at Path.
+type FileLocation struct {
+	// Path is the physical path of the file.
+	Path string
+	// Frame is the index of the (non-empty) YAML document in the file.
+	Frame int
+}
+
 // MappedRawStorage is an interface for RawStorages which store their
 // data in a flat/unordered directory format like manifest directories.
+// One file may contain multiple Objects, separated as YAML documents.
 type MappedRawStorage interface {
 	RawStorage
 
-	// AddMapping binds a Key's virtual path to a physical file path
-	AddMapping(key ObjectKey, path string)
+	// AddMapping binds a Key's virtual path to a physical file location
+	AddMapping(key ObjectKey, loc FileLocation)
 	// RemoveMapping removes the physical file
-	// path mapping matching the given Key
+	// location mapping matching the given Key
 	RemoveMapping(key ObjectKey)
+	// GetKeys returns all Keys mapped to the given physical
+	// file path, ordered by their frame index
+	GetKeys(path string) []ObjectKey
+	// GetMapping returns the physical file location the
+	// given Key is mapped to, and if a mapping exists
+	GetMapping(key ObjectKey) (FileLocation, bool)
 
 	// SetMappings overwrites all known mappings
-	SetMappings(m map[ObjectKey]string)
+	SetMappings(m map[ObjectKey]FileLocation)
+}
+
+// MappedRawStorageOptions configures a GenericMappedRawStorage.
+type MappedRawStorageOptions struct {
+	// Placement chooses the files new Objects are written to. If unset, only Objects
+	// that already have a mapping can be written.
+	// +optional
+	Placement PlacementPolicy
+	// RemoveEmptyDirs makes Delete remove the directories, up to the directory of the
+	// storage, that become empty when the last Object of a file is deleted.
+	RemoveEmptyDirs bool
+	// Checksum computes the Checksums of the Objects from their frames, which are their
+	// resourceVersions. Defaults to NewSHA256Checksum().
+	// +optional
+	Checksum ChecksumStrategy
+	// ModTimeChecksum uses the modification time of the file an Object is stored in as its
+	// Checksum instead, as a UnixNano string. It's cheaper than hashing the content, but writes
+	// within the same timestamp tick of the filesystem get the same Checksum, so conflicting
+	// writes may go undetected. A write to one Object of a file changes the Checksums of all
+	// the Objects in it. If set, Checksum is ignored.
+	// +optional
+	ModTimeChecksum bool
+}
+
+func (o *MappedRawStorageOptions) Default() {
+	if o.Checksum == nil {
+		o.Checksum = NewSHA256Checksum()
+	}
 }
 
 func NewGenericMappedRawStorage(dir string) MappedRawStorage {
+	return NewGenericMappedRawStorageWithOptions(dir, MappedRawStorageOptions{})
+}
+
+func NewGenericMappedRawStorageWithOptions(dir string, opts MappedRawStorageOptions) MappedRawStorage {
+	opts.Default()
+	removeTempFiles(dir)
 	return &GenericMappedRawStorage{
 		dir:          dir,
-		fileMappings: make(map[ObjectKey]string),
+		opts:         opts,
+		fileMappings: make(map[ObjectKey]FileLocation),
 		mux:          &sync.Mutex{},
+		fileMux:      &sync.Mutex{},
 	}
 }
 
 // GenericMappedRawStorage is the default implementation of a MappedRawStorage,
-// it stores files in the given directory via a path translation map.
+// it stores files in the given directory via a path translation map. Reads, writes
+// and deletes of an Object only touch its own frame, other documents in the same
+// file are preserved byte-for-byte. New Objects are written to the file chosen by
+// the PlacementPolicy, if one is given.
 type GenericMappedRawStorage struct {
 	dir          string
-	fileMappings map[ObjectKey]string
+	opts         MappedRawStorageOptions
+	fileMappings map[ObjectKey]FileLocation
 	mux          *sync.Mutex
+	// fileMux serializes the read-modify-write cycles of multi-frame files
+	fileMux *sync.Mutex
 }
 
-func (r *GenericMappedRawStorage) realPath(key ObjectKey) (string, error) {
+func (r *GenericMappedRawStorage) realPath(key ObjectKey) (FileLocation, error) {
 	r.mux.Lock()
-	path, ok := r.fileMappings[key]
+	loc, ok := r.fileMappings[key]
 	r.mux.Unlock()
 	if !ok {
-		return "", fmt.Errorf("GenericMappedRawStorage: cannot resolve %q: %w", key, ErrNotTracked)
+		return FileLocation{}, fmt.Errorf("GenericMappedRawStorage: cannot resolve %q: %w", key, ErrNotTracked)
 	}
 
-	return path, nil
+	return loc, nil
 }
 
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
-func (r *GenericMappedRawStorage) Read(key ObjectKey) ([]byte, error) {
-	file, err := r.realPath(key)
+func (r *GenericMappedRawStorage) Read(ctx context.Context, key ObjectKey) ([]byte, error) {
+	loc, err := r.realPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(loc.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	return ioutil.ReadFile(file)
+	frames := SplitFrames(content)
+	if loc.Frame >= len(frames) {
+		return nil, fmt.Errorf("GenericMappedRawStorage: cannot read frame %d of %q: %w", loc.Frame, loc.Path, ErrFrameNotFound)
+	}
+
+	return frames[loc.Frame], nil
 }
 
-func (r *GenericMappedRawStorage) Exists(key ObjectKey) bool {
-	file, err := r.realPath(key)
+func (r *GenericMappedRawStorage) Exists(ctx context.Context, key ObjectKey) bool {
+	loc, err := r.realPath(key)
 	if err != nil {
 		return false
 	}
 
-	return util.FileExists(file)
+	return util.FileExists(loc.Path)
 }
 
-func (r *GenericMappedRawStorage) Write(key ObjectKey, content []byte) error {
-	// GenericMappedRawStorage isn't going to generate files itself,
-	// only write if the file is already known
-	file, err := r.realPath(key)
+// placedPath returns the absolute path the PlacementPolicy chooses for key
+func (r *GenericMappedRawStorage) placedPath(key ObjectKey) (string, error) {
+	if r.opts.Placement == nil {
+		return "", fmt.Errorf("GenericMappedRawStorage: cannot create %q without a PlacementPolicy: %w", key, ErrNotTracked)
+	}
+
+	rel, err := r.opts.Placement.Place(key)
 	if err != nil {
+		return "", fmt.Errorf("GenericMappedRawStorage: cannot place %q: %w", key, err)
+	}
+	p := filepath.Join(r.dir, filepath.FromSlash(rel))
+	// Make sure the path doesn't escape the directory, e.g. through the identifier
+	if relToDir, err := filepath.Rel(r.dir, p); err != nil || relToDir == "." || relToDir == ".." || strings.HasPrefix(relToDir, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("GenericMappedRawStorage: placement %q of %q is outside of %q", rel, key, r.dir)
+	}
+	if _, ok := ContentTypes[filepath.Ext(p)]; !ok {
+		return "", fmt.Errorf("GenericMappedRawStorage: placement %q of %q has an unsupported extension", rel, key)
+	}
+	return p, nil
+}
+
+func (r *GenericMappedRawStorage) Write(ctx context.Context, key ObjectKey, content []byte) error {
+	loc, err := r.realPath(key)
+	if errors.Is(err, ErrNotTracked) {
+		// Let the PlacementPolicy choose a file for the new Object
+		return r.create(key, content)
+	} else if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	r.fileMux.Lock()
+	defer r.fileMux.Unlock()
+
+	// If the file doesn't exist (anymore), the Object will be its only frame
+	if !util.FileExists(loc.Path) {
+		if loc.Frame != 0 {
+			return fmt.Errorf("GenericMappedRawStorage: cannot write frame %d of missing file %q: %w", loc.Frame, loc.Path, ErrFrameNotFound)
+		}
+		return util.AtomicWriteFile(loc.Path, content, 0644)
+	}
+
+	oldContent, err := ioutil.ReadFile(loc.Path)
+	if err != nil {
+		return err
+	}
+
+	newContent, err := replaceFrame(oldContent, loc.Frame, content)
+	if err != nil {
+		return fmt.Errorf("GenericMappedRawStorage: cannot write frame %d of %q: %w", loc.Frame, loc.Path, err)
+	}
+
+	return util.AtomicWriteFile(loc.Path, newContent, 0644)
+}
+
+// create writes the untracked Object to the file chosen by the PlacementPolicy,
+// as its last frame if the file exists already, and maps key to it.
+func (r *GenericMappedRawStorage) create(key ObjectKey, content []byte) error {
+	p, err := r.placedPath(key)
+	if err != nil {
+		return err
+	}
+
+	r.fileMux.Lock()
+	defer r.fileMux.Unlock()
+
+	loc := FileLocation{Path: p}
+	if util.FileExists(p) {
+		oldContent, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		// Only YAML files can contain multiple documents
+		if len(bytes.TrimSpace(oldContent)) != 0 && ContentTypes[filepath.Ext(p)] != serializer.ContentTypeYAML {
+			return fmt.Errorf("GenericMappedRawStorage: cannot add %q to %q, only YAML files can contain multiple Objects", key, p)
+		}
+		loc.Frame = len(SplitFrames(oldContent))
+		content = appendFrame(oldContent, content)
+	} else if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	if err := util.AtomicWriteFile(p, content, 0644); err != nil {
+		return err
+	}
+	r.AddMapping(key, loc)
+	return nil
 }
 
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
-func (r *GenericMappedRawStorage) Delete(key ObjectKey) (err error) {
-	file, err := r.realPath(key)
+func (r *GenericMappedRawStorage) Delete(ctx context.Context, key ObjectKey) (err error) {
+	loc, err := r.realPath(key)
 	if err != nil {
 		return
 	}
 
+	r.fileMux.Lock()
+	defer r.fileMux.Unlock()
+
 	// GenericMappedRawStorage files can be deleted
 	// externally, check that the file exists first
-	if util.FileExists(file) {
-		err = os.Remove(file)
+	if util.FileExists(loc.Path) {
+		err = r.deleteFrame(loc)
 	}
 
 	if err == nil {
 		r.RemoveMapping(key)
+		r.shiftFrames(loc)
 	}
 
 	return
 }
 
-func (r *GenericMappedRawStorage) List(kind KindKey) ([]ObjectKey, error) {
+// deleteFrame removes the given frame from its file, or the
+// whole file if the frame was the only one in it.
+func (r *GenericMappedRawStorage) deleteFrame(loc FileLocation) error {
+	oldContent, err := ioutil.ReadFile(loc.Path)
+	if err != nil {
+		return err
+	}
+
+	newContent, err := removeFrame(oldContent, loc.Frame)
+	if err != nil {
+		return fmt.Errorf("GenericMappedRawStorage: cannot delete frame %d of %q: %w", loc.Frame, loc.Path, err)
+	}
+
+	if len(newContent) == 0 {
+		if err := os.Remove(loc.Path); err != nil {
+			return err
+		}
+		if r.opts.RemoveEmptyDirs {
+			if err := removeEmptyDirs(filepath.Dir(loc.Path), r.dir); err != nil {
+				log.Warnf("GenericMappedRawStorage: failed to remove empty directories: %v", err)
+			}
+		}
+		return nil
+	}
+
+	return util.AtomicWriteFile(loc.Path, newContent, 0644)
+}
+
+// shiftFrames decrements the frame indices of all mappings
+// in the same file that come after the removed location.
+func (r *GenericMappedRawStorage) shiftFrames(removed FileLocation) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for key, loc := range r.fileMappings {
+		if loc.Path == removed.Path && loc.Frame > removed.Frame {
+			loc.Frame--
+			r.fileMappings[key] = loc
+		}
+	}
+}
+
+func (r *GenericMappedRawStorage) List(ctx context.Context, kind KindKey) ([]ObjectKey, error) {
 	result := make([]ObjectKey, 0)
 
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
 	for key := range r.fileMappings {
 		// Include objects with the same kind and group, ignore version mismatches
 		if key.EqualsGVK(kind, false) {
@@ -122,20 +323,30 @@ func (r *GenericMappedRawStorage) List(kind KindKey) ([]ObjectKey, error) {
 	return result, nil
 }
 
-// This returns the modification time as a UnixNano string.
+// This returns the Checksum of the Object's frame computed by the ChecksumStrategy,
+// or the modification time of its file if MappedRawStorageOptions.ModTimeChecksum is set.
 // If the file doesn't exist, returns ErrNotFound + ErrNotTracked.
-func (r *GenericMappedRawStorage) Checksum(key ObjectKey) (string, error) {
-	path, err := r.realPath(key)
+func (r *GenericMappedRawStorage) Checksum(ctx context.Context, key ObjectKey) (string, error) {
+	loc, err := r.realPath(key)
 	if err != nil {
 		return "", err
 	}
 
-	return checksumFromModTime(path)
+	if r.opts.ModTimeChecksum {
+		return checksumFromModTime(loc.Path)
+	}
+	content, err := r.Read(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return r.opts.Checksum.Checksum(content)
 }
 
 func (r *GenericMappedRawStorage) ContentType(key ObjectKey) (ct serializer.ContentType) {
-	if file, err := r.realPath(key); err == nil {
-		ct = ContentTypes[filepath.Ext(file)] // Retrieve the correct format based on the extension
+	if loc, err := r.realPath(key); err == nil {
+		ct = ContentTypes[filepath.Ext(loc.Path)] // Retrieve the correct format based on the extension
+	} else if p, err := r.placedPath(key); err == nil {
+		ct = ContentTypes[filepath.Ext(p)] // New Objects are written in the format of the placed file
 	}
 
 	return
@@ -145,20 +356,45 @@ func (r *GenericMappedRawStorage) WatchDir() string {
 	return r.dir
 }
 
+// GetKey returns the Key of the first frame in the given file.
+// Use GetKeys to retrieve the Keys for all frames in the file.
 func (r *GenericMappedRawStorage) GetKey(path string) (ObjectKey, error) {
-	for key, p := range r.fileMappings {
-		if p == path {
-			return key, nil
-		}
+	if keys := r.GetKeys(path); len(keys) > 0 {
+		return keys[0], nil
 	}
 
 	return objectKey{}, fmt.Errorf("no mapping found for path %q", path)
 }
 
-func (r *GenericMappedRawStorage) AddMapping(key ObjectKey, path string) {
-	log.Debugf("GenericMappedRawStorage: AddMapping: %q -> %q", key, path)
+func (r *GenericMappedRawStorage) GetKeys(path string) []ObjectKey {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var keys []ObjectKey
+	for key, loc := range r.fileMappings {
+		if loc.Path == path {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return r.fileMappings[keys[i]].Frame < r.fileMappings[keys[j]].Frame
+	})
+	return keys
+}
+
+func (r *GenericMappedRawStorage) GetMapping(key ObjectKey) (FileLocation, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	loc, ok := r.fileMappings[key]
+	return loc, ok
+}
+
+func (r *GenericMappedRawStorage) AddMapping(key ObjectKey, loc FileLocation) {
+	log.Debugf("GenericMappedRawStorage: AddMapping: %q -> %q (frame %d)", key, loc.Path, loc.Frame)
 	r.mux.Lock()
-	r.fileMappings[key] = path
+	r.fileMappings[key] = loc
 	r.mux.Unlock()
 }
 
@@ -169,7 +405,7 @@ func (r *GenericMappedRawStorage) RemoveMapping(key ObjectKey) {
 	r.mux.Unlock()
 }
 
-func (r *GenericMappedRawStorage) SetMappings(m map[ObjectKey]string) {
+func (r *GenericMappedRawStorage) SetMappings(m map[ObjectKey]FileLocation) {
 	log.Debugf("GenericMappedRawStorage: SetMappings: %v", m)
 	r.mux.Lock()
 	r.fileMappings = m
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/conformance"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+func TestMemoryRawStorage_Conformance(t *testing.T) {
+	conformance.TestRawStorage(t, func(t *testing.T) storage.RawStorage {
+		return NewMemoryRawStorage(MemoryRawStorageOptions{})
+	}, conformance.RawStorageOptions{})
+}
+
+func TestMemoryStorage_Conformance(t *testing.T) {
+	conformance.TestStorage(t, func(t *testing.T, ser serializer.Serializer) storage.Storage {
+		return NewMemoryStorage(ser, conformance.Identifiers)
+	})
+}
+
+func TestMemoryEventStorage_Conformance(t *testing.T) {
+	conformance.TestEventStorage(t, func(t *testing.T, ser serializer.Serializer) (update.EventStorage, storage.Storage) {
+		es, err := NewMemoryEventStorage(NewMemoryStorage(ser, conformance.Identifiers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = es.Close() })
+		return es, es
+	}, conformance.EventStorageOptions{StrictOrdering: true})
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// NewMemoryStorage returns a storage.Storage backed by a new MemoryRawStorage.
+func NewMemoryStorage(ser serializer.Serializer, identifiers []runtime.IdentifierFactory) storage.Storage {
+	return storage.NewGenericStorage(NewMemoryRawStorage(MemoryRawStorageOptions{}), ser, identifiers)
+}
+
+// NewMemoryEventStorage returns an update.EventStorage for the given Storage, which must be
+// backed by a MemoryRawStorage. The updates are broadcast synchronously by every write and
+// delete, i.e. they have been handed to the subscribers when the write returns. This means
+// that tests don't have to wait for the update of their own write to show up.
+func NewMemoryEventStorage(s storage.Storage) (*MemoryEventStorage, error) {
+	raw, ok := s.RawStorage().(*MemoryRawStorage)
+	if !ok {
+		return nil, fmt.Errorf("NewMemoryEventStorage: expected a *MemoryRawStorage, got %T", s.RawStorage())
+	}
+
+	es := &MemoryEventStorage{
+		Storage:     s,
+		raw:         raw,
+		broadcaster: update.NewBroadcaster(),
+	}
+
+	raw.mux.Lock()
+	defer raw.mux.Unlock()
+	if raw.onChange != nil {
+		return nil, fmt.Errorf("NewMemoryEventStorage: the MemoryRawStorage is already used by another MemoryEventStorage")
+	}
+	raw.onChange = es.handleChange
+	return es, nil
+}
+
+// MemoryEventStorage implements update.EventStorage for a MemoryRawStorage.
+type MemoryEventStorage struct {
+	storage.Storage
+	raw         *MemoryRawStorage
+	broadcaster *update.Broadcaster
+}
+
+var _ update.EventStorage = &MemoryEventStorage{}
+
+func (s *MemoryEventStorage) Subscribe(opts update.SubscribeOptions) update.Subscription {
+	return s.broadcaster.Subscribe(opts)
+}
+
+// Close stops sending updates, and closes the UpdateStreams of all subscribers. The
+// wrapped Storage is not closed, as it may still be used without the MemoryEventStorage.
+func (s *MemoryEventStorage) Close() error {
+	s.raw.mux.Lock()
+	s.raw.onChange = nil
+	s.raw.mux.Unlock()
+
+	s.broadcaster.Close()
+	return nil
+}
+
+func (s *MemoryEventStorage) handleChange(event update.ObjectEvent, key storage.ObjectKey, content []byte, revision uint64) {
+	upd, err := update.UpdateFor(s, event, key, content, revision)
+	if err != nil {
+		log.Warnf("MemoryEventStorage: Ignoring change: %v", err)
+		return
+	}
+	s.broadcaster.Broadcast(upd)
+}
@@ -0,0 +1,298 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// ErrNoPaths is returned by GetKey, as Objects in a MemoryRawStorage aren't stored in files.
+var ErrNoPaths = errors.New("MemoryRawStorage doesn't store Objects in files")
+
+// Operation is an operation of a RawStorage faults can be injected into.
+type Operation string
+
+const (
+	OperationRead     Operation = "Read"
+	OperationExists   Operation = "Exists"
+	OperationWrite    Operation = "Write"
+	OperationDelete   Operation = "Delete"
+	OperationList     Operation = "List"
+	OperationChecksum Operation = "Checksum"
+)
+
+// Fault describes an error injected into the operations of a MemoryRawStorage.
+type Fault struct {
+	// Operation is the operation to fail. A failing Exists returns false.
+	Operation Operation
+	// Key, if set, only fails the operations on the Object with this key. The version is ignored.
+	// +optional
+	Key storage.ObjectKey
+	// Skip is how many matching operations succeed before the fault is triggered,
+	// e.g. 2 to fail the 3rd write.
+	// +optional
+	Skip int
+	// Times is how many matching operations fail once the fault is triggered, 0 means forever.
+	// +optional
+	Times int
+	// Err is the error the failing operations return.
+	Err error
+}
+
+// MemoryRawStorageOptions configures a MemoryRawStorage.
+type MemoryRawStorageOptions struct {
+	// ContentType is the content type the Objects are encoded in. Default JSON.
+	// +optional
+	ContentType serializer.ContentType
+	// Latency is added to every operation, e.g. to reproduce races. The waits
+	// are cancelled together with the context of the operation.
+	// +optional
+	Latency time.Duration
+}
+
+func (o *MemoryRawStorageOptions) Default() {
+	if len(o.ContentType) == 0 {
+		o.ContentType = serializer.ContentTypeJSON
+	}
+}
+
+// NewMemoryRawStorage returns an empty MemoryRawStorage.
+func NewMemoryRawStorage(opts MemoryRawStorageOptions) *MemoryRawStorage {
+	opts.Default()
+	return &MemoryRawStorage{
+		opts:     opts,
+		objects:  make(map[objectID]*object),
+		mux:      &sync.RWMutex{},
+		writeMux: &sync.Mutex{},
+	}
+}
+
+// MemoryRawStorage is a thread-safe storage.RawStorage keeping the Objects in memory, meant
+// for tests. Like a BoltRawStorage, every write increments the revision of the storage, which
+// is used as the Checksum. Errors and latency can be injected into its operations.
+type MemoryRawStorage struct {
+	opts     MemoryRawStorageOptions
+	objects  map[objectID]*object
+	revision uint64
+	faults   []*faultState
+	// onChange, if set, is called synchronously after every write and delete
+	onChange func(event update.ObjectEvent, key storage.ObjectKey, content []byte, revision uint64)
+	mux      *sync.RWMutex
+	// writeMux makes sure onChange is called in the order the changes were made in
+	writeMux *sync.Mutex
+}
+
+var _ storage.RawStorage = &MemoryRawStorage{}
+
+// objectID identifies an Object independently of the version of its key
+type objectID struct {
+	group, kind, identifier string
+}
+
+func idFor(key storage.ObjectKey) objectID {
+	return objectID{key.GetGroup(), key.GetKind(), key.GetIdentifier()}
+}
+
+type object struct {
+	content  []byte
+	revision uint64
+}
+
+type faultState struct {
+	Fault
+	seen int
+}
+
+// InjectFault makes the operations matching the Fault fail.
+func (r *MemoryRawStorage) InjectFault(f Fault) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.faults = append(r.faults, &faultState{Fault: f})
+}
+
+// FailNthWrite makes the nth write from now on, counting from 1, fail with err.
+func (r *MemoryRawStorage) FailNthWrite(n int, err error) {
+	r.InjectFault(Fault{Operation: OperationWrite, Skip: n - 1, Times: 1, Err: err})
+}
+
+// ClearFaults removes all injected faults.
+func (r *MemoryRawStorage) ClearFaults() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.faults = nil
+}
+
+// SetLatency changes the latency added to every operation.
+func (r *MemoryRawStorage) SetLatency(latency time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.opts.Latency = latency
+}
+
+// before waits for the configured latency, and returns the error of a triggered fault, if any
+func (r *MemoryRawStorage) before(ctx context.Context, op Operation, key storage.ObjectKey) error {
+	r.mux.Lock()
+	latency := r.opts.Latency
+	err := r.triggerFault(op, key)
+	r.mux.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// triggerFault counts the operation for all matching faults, and returns the error of the
+// first triggered one. r.mux must be locked.
+func (r *MemoryRawStorage) triggerFault(op Operation, key storage.ObjectKey) error {
+	var err error
+	for _, f := range r.faults {
+		if f.Operation != op || (f.Key != nil && (key == nil || idFor(f.Key) != idFor(key))) {
+			continue
+		}
+
+		f.seen++
+		triggered := f.seen > f.Skip && (f.Times == 0 || f.seen <= f.Skip+f.Times)
+		if triggered && err == nil {
+			err = f.Err
+		}
+	}
+	return err
+}
+
+func (r *MemoryRawStorage) Read(ctx context.Context, key storage.ObjectKey) ([]byte, error) {
+	if err := r.before(ctx, OperationRead, key); err != nil {
+		return nil, err
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	obj, ok := r.objects[idFor(key)]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return append([]byte(nil), obj.content...), nil
+}
+
+func (r *MemoryRawStorage) Exists(ctx context.Context, key storage.ObjectKey) bool {
+	if err := r.before(ctx, OperationExists, key); err != nil {
+		return false
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	_, ok := r.objects[idFor(key)]
+	return ok
+}
+
+func (r *MemoryRawStorage) Write(ctx context.Context, key storage.ObjectKey, content []byte) error {
+	if err := r.before(ctx, OperationWrite, key); err != nil {
+		return err
+	}
+	r.writeMux.Lock()
+	defer r.writeMux.Unlock()
+
+	r.mux.Lock()
+	event := update.ObjectEventCreate
+	if _, ok := r.objects[idFor(key)]; ok {
+		event = update.ObjectEventModify
+	}
+	r.revision++
+	content = append([]byte(nil), content...)
+	r.objects[idFor(key)] = &object{content: content, revision: r.revision}
+	revision, onChange := r.revision, r.onChange
+	r.mux.Unlock()
+
+	if onChange != nil {
+		onChange(event, key, content, revision)
+	}
+	return nil
+}
+
+func (r *MemoryRawStorage) Delete(ctx context.Context, key storage.ObjectKey) error {
+	if err := r.before(ctx, OperationDelete, key); err != nil {
+		return err
+	}
+	r.writeMux.Lock()
+	defer r.writeMux.Unlock()
+
+	r.mux.Lock()
+	if _, ok := r.objects[idFor(key)]; !ok {
+		r.mux.Unlock()
+		return storage.ErrNotFound
+	}
+	r.revision++
+	delete(r.objects, idFor(key))
+	revision, onChange := r.revision, r.onChange
+	r.mux.Unlock()
+
+	if onChange != nil {
+		onChange(update.ObjectEventDelete, key, nil, revision)
+	}
+	return nil
+}
+
+// List returns the keys of the Objects of the given kind, sorted by their identifier.
+func (r *MemoryRawStorage) List(ctx context.Context, kind storage.KindKey) ([]storage.ObjectKey, error) {
+	if err := r.before(ctx, OperationList, nil); err != nil {
+		return nil, err
+	}
+
+	r.mux.RLock()
+	identifiers := make([]string, 0)
+	for id := range r.objects {
+		if id.group == kind.GetGroup() && id.kind == kind.GetKind() {
+			identifiers = append(identifiers, id.identifier)
+		}
+	}
+	r.mux.RUnlock()
+
+	sort.Strings(identifiers)
+	result := make([]storage.ObjectKey, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		result = append(result, storage.NewObjectKey(kind, runtime.NewIdentifier(identifier)))
+	}
+	return result, nil
+}
+
+// Checksum returns the revision the Object was last written at.
+// If the Object doesn't exist, returns ErrNotFound.
+func (r *MemoryRawStorage) Checksum(ctx context.Context, key storage.ObjectKey) (string, error) {
+	if err := r.before(ctx, OperationChecksum, key); err != nil {
+		return "", err
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	obj, ok := r.objects[idFor(key)]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return strconv.FormatUint(obj.revision, 10), nil
+}
+
+func (r *MemoryRawStorage) ContentType(_ storage.ObjectKey) serializer.ContentType {
+	return r.opts.ContentType
+}
+
+// WatchDir returns an empty string, as there is nothing to watch.
+func (r *MemoryRawStorage) WatchDir() string {
+	return ""
+}
+
+// GetKey returns ErrNoPaths, as Objects aren't stored in files.
+func (r *MemoryRawStorage) GetKey(_ string) (storage.ObjectKey, error) {
+	return nil, ErrNoPaths
+}
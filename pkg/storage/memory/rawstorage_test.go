@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+func TestMemoryRawStorage_Faults(t *testing.T) {
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+	tests := []struct {
+		name  string
+		fault Fault
+		// want is the expected error of every operation, in order
+		want []error
+		ops  func(r *MemoryRawStorage) []error
+	}{
+		{
+			name:  "fail the 2nd write",
+			fault: Fault{Operation: OperationWrite, Skip: 1, Times: 1, Err: errBoom},
+			want:  []error{nil, errBoom, nil},
+			ops: func(r *MemoryRawStorage) []error {
+				return []error{
+					r.Write(ctx, storagetest.CarKey("foo"), []byte("1")),
+					r.Write(ctx, storagetest.CarKey("foo"), []byte("2")),
+					r.Write(ctx, storagetest.CarKey("foo"), []byte("3")),
+				}
+			},
+		},
+		{
+			name:  "not found for a single key",
+			fault: Fault{Operation: OperationRead, Key: storagetest.CarKey("foo"), Err: storage.ErrNotFound},
+			want:  []error{storage.ErrNotFound, nil, storage.ErrNotFound},
+			ops: func(r *MemoryRawStorage) []error {
+				_, err1 := r.Read(ctx, storagetest.CarKey("foo"))
+				_, err2 := r.Read(ctx, storagetest.CarKey("bar"))
+				_, err3 := r.Read(ctx, storagetest.CarKey("foo"))
+				return []error{err1, err2, err3}
+			},
+		},
+		{
+			name:  "failing list",
+			fault: Fault{Operation: OperationList, Times: 1, Err: errBoom},
+			want:  []error{errBoom, nil},
+			ops: func(r *MemoryRawStorage) []error {
+				_, err1 := r.List(ctx, storagetest.CarKind)
+				_, err2 := r.List(ctx, storagetest.CarKind)
+				return []error{err1, err2}
+			},
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			r := NewMemoryRawStorage(MemoryRawStorageOptions{})
+			for _, name := range []string{"foo", "bar"} {
+				if err := r.Write(ctx, storagetest.CarKey(name), []byte("0")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r.InjectFault(rt.fault)
+			got := rt.ops(r)
+			for i := range rt.want {
+				if got[i] != rt.want[i] {
+					t.Errorf("operation %d: expected error %v, got %v", i, rt.want[i], got[i])
+				}
+			}
+
+			r.ClearFaults()
+			if _, err := r.Read(ctx, storagetest.CarKey("foo")); err != nil {
+				t.Errorf("expected no error after clearing the faults, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryRawStorage_FailNthWrite(t *testing.T) {
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+	s := storagetest.NewStorage(NewMemoryRawStorage(MemoryRawStorageOptions{}))
+	s.RawStorage().(*MemoryRawStorage).FailNthWrite(2, errBoom)
+
+	if err := s.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, storagetest.NewCar("bar", "Volvo")); !errors.Is(err, errBoom) {
+		t.Errorf("expected the 2nd write to fail, got %v", err)
+	}
+	if s.RawStorage().Exists(ctx, storagetest.CarKey("bar")) {
+		t.Error("expected the failed write not to be applied")
+	}
+}
+
+func TestMemoryRawStorage_Latency(t *testing.T) {
+	r := NewMemoryRawStorage(MemoryRawStorageOptions{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := r.Write(ctx, storagetest.CarKey("foo"), []byte("0")); err != context.DeadlineExceeded {
+		t.Errorf("expected the write to be cancelled, got %v", err)
+	}
+
+	r.SetLatency(0)
+	if err := r.Write(context.Background(), storagetest.CarKey("foo"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryEventStorage(t *testing.T) {
+	ctx := context.Background()
+	es, err := NewMemoryEventStorage(storagetest.NewStorage(NewMemoryRawStorage(MemoryRawStorageOptions{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	sub := es.Subscribe(update.SubscribeOptions{})
+
+	if err := es.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The updates have been handed to the subscribers once the writes return
+	for _, want := range []update.ObjectEvent{update.ObjectEventCreate, update.ObjectEventDelete} {
+		select {
+		case upd := <-sub.Updates():
+			if upd.Event != want || upd.ObjectKey.GetIdentifier() != "default/foo" {
+				t.Errorf("expected %s of default/foo, got %s of %s", want, upd.Event, upd.ObjectKey.GetIdentifier())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a %s update", want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"path"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+)
+
+// PlacementPolicy chooses where a MappedRawStorage stores Objects that don't have a mapping yet.
+type PlacementPolicy interface {
+	// Place returns the slash-separated path, relative to the directory of the storage, of the
+	// file the new Object with the given key should be written to. If the file exists already,
+	// the Object is appended to it as a new frame. The extension of the path decides the
+	// content type of the Object.
+	Place(key ObjectKey) (string, error)
+}
+
+// PlacementFunc is a function implementing PlacementPolicy, e.g. for user callbacks.
+type PlacementFunc func(key ObjectKey) (string, error)
+
+// Place implements PlacementPolicy.
+func (f PlacementFunc) Place(key ObjectKey) (string, error) {
+	return f(key)
+}
+
+// NewObjectPerFilePlacement returns a PlacementPolicy storing every Object in its own file, in
+// the form: <kind>/<namespace>/<name><ext>, or <kind>/<name><ext> for non-namespaced Objects.
+// The extension is chosen based on the given content type.
+func NewObjectPerFilePlacement(ct serializer.ContentType) PlacementPolicy {
+	ext := extForContentType(ct)
+	if ext == "" {
+		panic("Invalid content type")
+	}
+	return PlacementFunc(func(key ObjectKey) (string, error) {
+		// The identifier is either "name" or "namespace/name"
+		return path.Join(key.GetKind(), key.GetIdentifier()) + ext, nil
+	})
+}
+
+// NewFixedFilePlacement returns a PlacementPolicy appending all new Objects to the file at the
+// given relative path, e.g. "cars.yaml". Only YAML files can contain multiple Objects.
+func NewFixedFilePlacement(file string) PlacementPolicy {
+	return PlacementFunc(func(_ ObjectKey) (string, error) {
+		return file, nil
+	})
+}
@@ -1,15 +1,15 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/weaveworks/libgitops/pkg/runtime"
+	log "github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/serializer"
 	"github.com/weaveworks/libgitops/pkg/util"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -17,24 +17,26 @@ import (
 
 // RawStorage is a Key-indexed low-level interface to
 // store byte-encoded Objects (resources) in non-volatile
-// memory.
+// memory. The context given to the I/O methods is the one
+// passed to the Storage by the caller, so request-scoped
+// values are available to custom implementations.
 type RawStorage interface {
 	// Read returns a resource's content based on key.
 	// If the resource does not exist, it returns ErrNotFound.
-	Read(key ObjectKey) ([]byte, error)
+	Read(ctx context.Context, key ObjectKey) ([]byte, error)
 	// Exists checks if the resource indicated by key exists.
-	Exists(key ObjectKey) bool
+	Exists(ctx context.Context, key ObjectKey) bool
 	// Write writes the given content to the resource indicated by key.
 	// Error returns are implementation-specific.
-	Write(key ObjectKey, content []byte) error
+	Write(ctx context.Context, key ObjectKey, content []byte) error
 	// Delete deletes the resource indicated by key.
 	// If the resource does not exist, it returns ErrNotFound.
-	Delete(key ObjectKey) error
+	Delete(ctx context.Context, key ObjectKey) error
 	// List returns all matching object keys based on the given KindKey.
-	List(key KindKey) ([]ObjectKey, error)
+	List(ctx context.Context, key KindKey) ([]ObjectKey, error)
 	// Checksum returns a string checksum for the resource indicated by key.
 	// If the resource does not exist, it returns ErrNotFound.
-	Checksum(key ObjectKey) (string, error)
+	Checksum(ctx context.Context, key ObjectKey) (string, error)
 	// ContentType returns the content type of the contents of the resource indicated by key.
 	ContentType(key ObjectKey) serializer.ContentType
 
@@ -46,60 +48,120 @@ type RawStorage interface {
 }
 
 func NewGenericRawStorage(dir string, gv schema.GroupVersion, ct serializer.ContentType) RawStorage {
+	raw, err := NewGenericRawStorageWithLayout(dir, NewLegacyLayout(), ct, gv)
+	if err != nil {
+		// Only an invalid content type is possible here
+		panic(err)
+	}
+	return raw
+}
+
+// GenericRawStorageOptions configures a GenericRawStorage.
+type GenericRawStorageOptions struct {
+	// Layout decides the paths of the Objects. Defaults to NewLegacyLayout().
+	// +optional
+	Layout PathLayout
+	// Checksum computes the Checksums of the Objects, which are their resourceVersions.
+	// Defaults to NewSHA256Checksum().
+	// +optional
+	Checksum ChecksumStrategy
+	// ModTimeChecksum uses the modification times of the files as the Checksums instead, as
+	// UnixNano strings. It's cheaper than hashing the content, but writes within the same
+	// timestamp tick of the filesystem get the same Checksum, so conflicting writes may go
+	// undetected. If set, Checksum is ignored.
+	// +optional
+	ModTimeChecksum bool
+}
+
+func (o *GenericRawStorageOptions) Default() {
+	if o.Layout == nil {
+		o.Layout = NewLegacyLayout()
+	}
+	if o.Checksum == nil {
+		o.Checksum = NewSHA256Checksum()
+	}
+}
+
+// NewGenericRawStorageWithLayout returns a GenericRawStorage storing the Objects of the given
+// GroupVersions in dir, at the paths decided by layout.
+func NewGenericRawStorageWithLayout(dir string, layout PathLayout, ct serializer.ContentType, gvs ...schema.GroupVersion) (RawStorage, error) {
+	return NewGenericRawStorageWithOptions(dir, ct, GenericRawStorageOptions{Layout: layout}, gvs...)
+}
+
+// NewGenericRawStorageWithOptions returns a GenericRawStorage storing the Objects of the given
+// GroupVersions in dir, configured by opts. An error is returned for an unsupported content
+// type, if no GroupVersions are given, and if the PathLayout can't serve all of them.
+func NewGenericRawStorageWithOptions(dir string, ct serializer.ContentType, opts GenericRawStorageOptions, gvs ...schema.GroupVersion) (RawStorage, error) {
 	ext := extForContentType(ct)
 	if ext == "" {
-		panic("Invalid content type")
+		return nil, fmt.Errorf("invalid content type %q", ct)
 	}
-	return &GenericRawStorage{
-		dir: dir,
-		gv:  gv,
-		ct:  ct,
-		ext: ext,
+	if len(gvs) == 0 {
+		return nil, fmt.Errorf("at least one GroupVersion is required")
 	}
+	opts.Default()
+	if err := opts.Layout.ValidateGroupVersions(gvs); err != nil {
+		return nil, err
+	}
+	removeTempFiles(dir)
+	return &GenericRawStorage{
+		dir:      dir,
+		gvs:      gvs,
+		ct:       ct,
+		ext:      ext,
+		layout:   opts.Layout,
+		checksum: opts.Checksum,
+		modTime:  opts.ModTimeChecksum,
+	}, nil
 }
 
-// GenericRawStorage is a rawstorage which stores objects as JSON files on disk,
-// in the form: <dir>/<kind>/<identifier>/metadata.json.
-// The GenericRawStorage only supports one GroupVersion at a time, and will error if given
-// any other resources
+// GenericRawStorage is a rawstorage which stores objects as JSON or YAML files on disk,
+// one Object per file, at the paths decided by its PathLayout. By default the legacy
+// layout is used: <dir>/<kind>/<identifier>/metadata.json. The GenericRawStorage only
+// supports the GroupVersions it was created with, and will error if given any other resources
 type GenericRawStorage struct {
-	dir string
-	gv  schema.GroupVersion
-	ct  serializer.ContentType
-	ext string
+	dir      string
+	gvs      []schema.GroupVersion
+	ct       serializer.ContentType
+	ext      string
+	layout   PathLayout
+	checksum ChecksumStrategy
+	modTime  bool
 }
 
 func (r *GenericRawStorage) keyPath(key ObjectKey) string {
-	return path.Join(r.dir, key.GetKind(), key.GetIdentifier(), fmt.Sprintf("metadata%s", r.ext))
+	return filepath.Join(r.dir, filepath.FromSlash(r.layout.ObjectPath(key, r.ext)))
 }
 
 func (r *GenericRawStorage) kindKeyPath(kindKey KindKey) string {
-	return path.Join(r.dir, kindKey.GetKind())
+	return filepath.Join(r.dir, filepath.FromSlash(r.layout.KindPath(kindKey)))
 }
 
 func (r *GenericRawStorage) validateGroupVersion(kind KindKey) error {
-	if r.gv.Group == kind.GetGroup() && r.gv.Version == kind.GetVersion() {
-		return nil
+	for _, gv := range r.gvs {
+		if gv.Group == kind.GetGroup() && gv.Version == kind.GetVersion() {
+			return nil
+		}
 	}
 
 	return fmt.Errorf("GroupVersion %s/%s not supported by this GenericRawStorage", kind.GetGroup(), kind.GetVersion())
 }
 
-func (r *GenericRawStorage) Read(key ObjectKey) ([]byte, error) {
+func (r *GenericRawStorage) Read(ctx context.Context, key ObjectKey) ([]byte, error) {
 	// Validate GroupVersion first
 	if err := r.validateGroupVersion(key); err != nil {
 		return nil, err
 	}
 
 	// Check if the resource indicated by key exists
-	if !r.Exists(key) {
+	if !r.Exists(ctx, key) {
 		return nil, ErrNotFound
 	}
 
 	return ioutil.ReadFile(r.keyPath(key))
 }
 
-func (r *GenericRawStorage) Exists(key ObjectKey) bool {
+func (r *GenericRawStorage) Exists(ctx context.Context, key ObjectKey) bool {
 	// Validate GroupVersion first
 	if err := r.validateGroupVersion(key); err != nil {
 		return false
@@ -108,7 +170,7 @@ func (r *GenericRawStorage) Exists(key ObjectKey) bool {
 	return util.FileExists(r.keyPath(key))
 }
 
-func (r *GenericRawStorage) Write(key ObjectKey, content []byte) error {
+func (r *GenericRawStorage) Write(ctx context.Context, key ObjectKey, content []byte) error {
 	// Validate GroupVersion first
 	if err := r.validateGroupVersion(key); err != nil {
 		return err
@@ -117,62 +179,90 @@ func (r *GenericRawStorage) Write(key ObjectKey, content []byte) error {
 	file := r.keyPath(key)
 
 	// Create the underlying directories if they do not exist already
-	if !r.Exists(key) {
-		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+	if !r.Exists(ctx, key) {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
 			return err
 		}
 	}
 
-	return ioutil.WriteFile(file, content, 0644)
+	return util.AtomicWriteFile(file, content, 0644)
 }
 
-func (r *GenericRawStorage) Delete(key ObjectKey) error {
+func (r *GenericRawStorage) Delete(ctx context.Context, key ObjectKey) error {
 	// Validate GroupVersion first
 	if err := r.validateGroupVersion(key); err != nil {
 		return err
 	}
 
 	// Check if the resource indicated by key exists
-	if !r.Exists(key) {
+	if !r.Exists(ctx, key) {
 		return ErrNotFound
 	}
 
-	return os.RemoveAll(path.Dir(r.keyPath(key)))
+	file := r.keyPath(key)
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	// Remove the directories that are empty now, but keep the one of the kind
+	if err := removeEmptyDirs(filepath.Dir(file), r.kindKeyPath(key)); err != nil {
+		log.Warnf("GenericRawStorage: %v", err)
+	}
+	return nil
 }
 
-func (r *GenericRawStorage) List(kind KindKey) ([]ObjectKey, error) {
+func (r *GenericRawStorage) List(ctx context.Context, kind KindKey) ([]ObjectKey, error) {
 	// Validate GroupVersion first
 	if err := r.validateGroupVersion(kind); err != nil {
 		return nil, err
 	}
 
-	entries, err := ioutil.ReadDir(r.kindKeyPath(kind))
-	if err != nil {
-		return nil, err
+	kindDir := r.kindKeyPath(kind)
+	if exists, _ := util.PathExists(kindDir); !exists {
+		return []ObjectKey{}, nil
 	}
 
-	result := make([]ObjectKey, 0, len(entries))
-	for _, entry := range entries {
-		result = append(result, NewObjectKey(kind, runtime.NewIdentifier(entry.Name())))
-	}
+	result := make([]ObjectKey, 0)
+	err := filepath.Walk(kindDir, func(p string, info os.FileInfo, err error) error {
+		// Files may be removed by concurrent writes (temporary files) and deletes during the walk
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() || filepath.Ext(p) != r.ext {
+			return err
+		}
 
-	return result, nil
+		// The kind directory may contain other files as well, only list the ones matching the layout
+		key, err := r.GetKey(p)
+		if err != nil || !key.EqualsGVK(kind, false) {
+			return nil
+		}
+		result = append(result, NewObjectKey(kind, key))
+		return nil
+	})
+	return result, err
 }
 
-// This returns the modification time as a UnixNano string
-// If the file doesn't exist, return ErrNotFound
-func (r *GenericRawStorage) Checksum(key ObjectKey) (string, error) {
+// This returns the Checksum computed by the ChecksumStrategy, or the modification
+// time of the file if GenericRawStorageOptions.ModTimeChecksum is set. If the file doesn't exist, return ErrNotFound
+func (r *GenericRawStorage) Checksum(ctx context.Context, key ObjectKey) (string, error) {
 	// Validate GroupVersion first
 	if err := r.validateGroupVersion(key); err != nil {
 		return "", err
 	}
 
 	// Check if the resource indicated by key exists
-	if !r.Exists(key) {
+	if !r.Exists(ctx, key) {
 		return "", ErrNotFound
 	}
 
-	return checksumFromModTime(r.keyPath(key))
+	if r.modTime {
+		return checksumFromModTime(r.keyPath(key))
+	}
+	content, err := ioutil.ReadFile(r.keyPath(key))
+	if err != nil {
+		return "", err
+	}
+	return r.checksum.Checksum(content)
 }
 
 func (r *GenericRawStorage) ContentType(_ ObjectKey) serializer.ContentType {
@@ -183,28 +273,38 @@ func (r *GenericRawStorage) WatchDir() string {
 	return r.dir
 }
 
+// GetKey returns the ObjectKey for the given path by inverting the PathLayout.
 func (r *GenericRawStorage) GetKey(p string) (ObjectKey, error) {
-	splitDir := strings.Split(filepath.Clean(r.dir), string(os.PathSeparator))
-	splitPath := strings.Split(filepath.Clean(p), string(os.PathSeparator))
+	rel, err := filepath.Rel(filepath.Clean(r.dir), filepath.Clean(p))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("path has wrong base: %s", p)
+	}
+
+	return r.layout.ObjectKeyFor(filepath.ToSlash(rel), r.ext, r.gvs)
+}
 
-	if len(splitPath) < len(splitDir)+2 {
-		return nil, fmt.Errorf("path not long enough: %s", p)
+// removeTempFiles removes the temporary files left behind in dir by writes interrupted by a
+// crash. The files are never read, so failing to remove them is only logged.
+func removeTempFiles(dir string) {
+	if err := util.RemoveTempFiles(dir, ".git"); err != nil {
+		log.Warnf("Failed to remove leftover temporary files in %q: %v", dir, err)
 	}
+}
 
-	for i := 0; i < len(splitDir); i++ {
-		if splitDir[i] != splitPath[i] {
-			return nil, fmt.Errorf("path has wrong base: %s", p)
+// removeEmptyDirs removes dir and its parents, up to (but excluding) root, until one of them
+// isn't empty. Callers only log the returned error, as the Object itself was already removed.
+func removeEmptyDirs(dir, root string) error {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil || len(entries) != 0 {
+			return nil
+		}
+		if err := os.Remove(dir); err != nil {
+			return fmt.Errorf("failed to remove empty directory %q: %w", dir, err)
 		}
 	}
-	kind := splitPath[len(splitDir)]
-	uid := splitPath[len(splitDir)+1]
-	gvk := schema.GroupVersionKind{
-		Group:   r.gv.Group,
-		Version: r.gv.Version,
-		Kind:    kind,
-	}
-
-	return NewObjectKey(NewKindKey(gvk), runtime.NewIdentifier(uid)), nil
+	return nil
 }
 
 func checksumFromModTime(path string) (string, error) {
@@ -2,9 +2,11 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/filter"
@@ -23,21 +25,29 @@ var (
 	ErrNotFound = errors.New("resource not found")
 	// ErrAlreadyExists is returned when when WriteStorage.Create is called for an already stored object.
 	ErrAlreadyExists = errors.New("resource already exists")
+	// ErrConflict is returned when an Object has been modified in the storage after it was read, i.e.
+	// the resourceVersion given in the write request doesn't match the current one.
+	ErrConflict = errors.New("resource has been modified, resourceVersion mismatch")
 )
 
 type ReadStorage interface {
 	// Get returns a new Object for the resource at the specified kind/uid path, based on the file content.
 	// If the resource referred to by the given ObjectKey does not exist, Get returns ErrNotFound.
-	Get(key ObjectKey) (runtime.Object, error)
+	Get(ctx context.Context, key ObjectKey) (runtime.Object, error)
 
 	// List lists Objects for the specific kind. Optionally, filters can be applied (see the filter package
-	// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{})
-	List(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error)
+	// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{}). The Objects are ordered by
+	// name, unless a filter.SortBy is given. Use ListPage to get the continue token with a filter.Limit.
+	List(ctx context.Context, kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error)
+	// ListIterator returns an ObjectIterator over the Objects of the given kind, which decodes the
+	// Objects lazily where possible. It accepts the same options as List, and for paginating with a
+	// filter.Limit, returns the continue token for the next page.
+	ListIterator(ctx context.Context, kind KindKey, opts ...filter.ListOption) (ObjectIterator, error)
 
 	// Find does a List underneath, also using filters, but always returns one object. If the List
 	// underneath returned two or more results, ErrAmbiguousFind is returned. If no match was found,
 	// ErrNotFound is returned.
-	Find(kind KindKey, opts ...filter.ListOption) (runtime.Object, error)
+	Find(ctx context.Context, kind KindKey, opts ...filter.ListOption) (runtime.Object, error)
 
 	//
 	// Partial object getters.
@@ -46,12 +56,13 @@ type ReadStorage interface {
 
 	// GetMeta returns a new Object's APIType representation for the resource at the specified kind/uid path.
 	// If the resource referred to by the given ObjectKey does not exist, GetMeta returns ErrNotFound.
-	GetMeta(key ObjectKey) (runtime.PartialObject, error)
+	GetMeta(ctx context.Context, key ObjectKey) (runtime.PartialObject, error)
 	// ListMeta lists all Objects' APIType representation. In other words,
 	// only metadata about each Object is unmarshalled (uid/name/kind/apiVersion).
 	// This allows for faster runs (no need to unmarshal "the world"), and less
-	// resource usage, when only metadata is unmarshalled into memory
-	ListMeta(kind KindKey) ([]runtime.PartialObject, error)
+	// resource usage, when only metadata is unmarshalled into memory. The options may only filter
+	// and order by metadata, i.e. only filter.MetaFilters and metadata fields for filter.SortBy.
+	ListMeta(ctx context.Context, kind KindKey, opts ...filter.ListOption) ([]runtime.PartialObject, error)
 
 	//
 	// Cache-related methods.
@@ -61,10 +72,10 @@ type ReadStorage interface {
 	// The checksum should change if any modifications have been made to the
 	// Object on disk, it can be e.g. the Object's modification timestamp or
 	// calculated checksum. If the Object is not found, ErrNotFound is returned.
-	Checksum(key ObjectKey) (string, error)
+	Checksum(ctx context.Context, key ObjectKey) (string, error)
 	// Count returns the amount of available Objects of a specific kind
 	// This is used by Caches to check if all Objects are cached to perform a List
-	Count(kind KindKey) (uint64, error)
+	Count(ctx context.Context, kind KindKey) (uint64, error)
 
 	//
 	// Access to underlying Resources.
@@ -85,22 +96,30 @@ type ReadStorage interface {
 	Close() error
 }
 
+// WriteStorage is the write part of a Storage. Writes use optimistic concurrency: Objects returned from a
+// ReadStorage have their ObjectMeta.ResourceVersion set to the Checksum of the Object at read time. The
+// ResourceVersion is never persisted, but is set on the given Object after a successful write.
 type WriteStorage interface {
 	// Create creates an entry for and stores the given Object in the storage. The Object must be new to the storage.
 	// The ObjectMeta.CreationTimestamp field is set automatically to the current time if it is unset.
-	Create(obj runtime.Object) error
+	Create(ctx context.Context, obj runtime.Object) error
 	// Update updates the state of the given Object in the storage. The Object must exist in the storage.
 	// The ObjectMeta.CreationTimestamp field is set automatically to the current time if it is unset.
-	Update(obj runtime.Object) error
+	// If the ObjectMeta.ResourceVersion field is set, and doesn't match the current Checksum of the
+	// Object in the storage, ErrConflict is returned.
+	Update(ctx context.Context, obj runtime.Object) error
 
-	// Patch performs a strategic merge patch on the Object with the given UID, using the byte-encoded patch given
-	Patch(key ObjectKey, patch []byte) error
+	// Patch performs a strategic merge patch on the Object with the given UID, using the byte-encoded patch given.
+	// If the Object was modified by someone else while the patch was applied, ErrConflict is returned.
+	Patch(ctx context.Context, key ObjectKey, patch []byte) error
 	// Delete removes an Object from the storage
-	Delete(key ObjectKey) error
+	Delete(ctx context.Context, key ObjectKey) error
 }
 
 // Storage is an interface for persisting and retrieving API objects to/from a backend
-// One Storage instance handles all different Kinds of Objects
+// One Storage instance handles all different Kinds of Objects. The given context is passed
+// all the way down to the RawStorage; long-running operations like List abort when it is
+// cancelled or its deadline is exceeded, returning the context's error.
 type Storage interface {
 	ReadStorage
 	WriteStorage
@@ -108,7 +127,40 @@ type Storage interface {
 
 // NewGenericStorage constructs a new Storage
 func NewGenericStorage(rawStorage RawStorage, serializer serializer.Serializer, identifiers []runtime.IdentifierFactory) Storage {
-	return &GenericStorage{rawStorage, serializer, patchutil.NewPatcher(serializer), identifiers}
+	return NewGenericStorageWithOptions(rawStorage, serializer, identifiers, GenericStorageOptions{})
+}
+
+// GenericStorageOptions configures a GenericStorage.
+type GenericStorageOptions struct {
+	// DependentKinds are the kinds searched for the dependents of a deleted Object, i.e. the Objects
+	// having an ownerReference to it. Default none, which leaves all dependents as-is.
+	// +optional
+	DependentKinds []KindKey
+	// PropagationPolicy decides what Delete does with the dependents of an Object. It can be overridden
+	// per Object with the "orphan" and "foregroundDeletion" finalizers, or per call with
+	// GenericStorage.DeleteWithPropagation. Default metav1.DeletePropagationBackground.
+	// +optional
+	PropagationPolicy metav1.DeletionPropagation
+}
+
+func (o *GenericStorageOptions) Default() {
+	if len(o.PropagationPolicy) == 0 {
+		o.PropagationPolicy = metav1.DeletePropagationBackground
+	}
+}
+
+// NewGenericStorageWithOptions constructs a new Storage configured by opts
+func NewGenericStorageWithOptions(rawStorage RawStorage, serializer serializer.Serializer, identifiers []runtime.IdentifierFactory, opts GenericStorageOptions) Storage {
+	opts.Default()
+	return &GenericStorage{
+		raw:         rawStorage,
+		serializer:  serializer,
+		patcher:     patchutil.NewPatcher(serializer),
+		identifiers: identifiers,
+		opts:        opts,
+		writeMux:    &sync.Mutex{},
+		finalizing:  make(map[string]bool),
+	}
 }
 
 // GenericStorage implements the Storage interface
@@ -117,6 +169,13 @@ type GenericStorage struct {
 	serializer  serializer.Serializer
 	patcher     patchutil.Patcher
 	identifiers []runtime.IdentifierFactory
+	opts        GenericStorageOptions
+	// writeMux makes the resourceVersion check and the following write atomic
+	// for writers using the same GenericStorage
+	writeMux *sync.Mutex
+	// finalizing holds the Objects being finalized by the current Delete, see finalize.
+	// It's protected by writeMux.
+	finalizing map[string]bool
 }
 
 var _ Storage = &GenericStorage{}
@@ -126,28 +185,87 @@ func (s *GenericStorage) Serializer() serializer.Serializer {
 }
 
 // Get returns a new Object for the resource at the specified kind/uid path, based on the file content
-func (s *GenericStorage) Get(key ObjectKey) (runtime.Object, error) {
-	content, err := s.raw.Read(key)
+func (s *GenericStorage) Get(ctx context.Context, key ObjectKey) (runtime.Object, error) {
+	content, resourceVersion, err := s.read(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.decode(key, content)
+	obj, err := s.decode(key, content)
+	if err != nil {
+		return nil, err
+	}
+
+	obj.SetResourceVersion(resourceVersion)
+	return obj, nil
 }
 
 // TODO: Verify this works
 // GetMeta returns a new Object's APIType representation for the resource at the specified kind/uid path
-func (s *GenericStorage) GetMeta(key ObjectKey) (runtime.PartialObject, error) {
-	content, err := s.raw.Read(key)
+func (s *GenericStorage) GetMeta(ctx context.Context, key ObjectKey) (runtime.PartialObject, error) {
+	content, resourceVersion, err := s.read(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.decodeMeta(key, content)
+	obj, err := s.decodeMeta(key, content)
+	if err != nil {
+		return nil, err
+	}
+
+	obj.SetResourceVersion(resourceVersion)
+	return obj, nil
+}
+
+// read returns the content of the Object, and its resourceVersion. The checksum is
+// retrieved before the content is read: if the Object changes in between, a later
+// write with the returned resourceVersion conflicts instead of overwriting the change.
+func (s *GenericStorage) read(ctx context.Context, key ObjectKey) ([]byte, string, error) {
+	resourceVersion, err := s.raw.Checksum(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, err := s.raw.Read(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return content, resourceVersion, nil
+}
+
+// checkResourceVersion returns ErrConflict if resourceVersion is set, and doesn't
+// match the current checksum of the Object. s.writeMux must be held by the caller.
+func (s *GenericStorage) checkResourceVersion(ctx context.Context, key ObjectKey, resourceVersion string) error {
+	if len(resourceVersion) == 0 {
+		return nil
+	}
+
+	current, err := s.raw.Checksum(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if current != resourceVersion {
+		return fmt.Errorf("%s: expected resourceVersion %q, got %q: %w", key, resourceVersion, current, ErrConflict)
+	}
+	return nil
+}
+
+// observeResourceVersion sets the resourceVersion of obj to the
+// current checksum of the Object after it has been written.
+func (s *GenericStorage) observeResourceVersion(ctx context.Context, key ObjectKey, obj runtime.Object) error {
+	resourceVersion, err := s.raw.Checksum(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	obj.SetResourceVersion(resourceVersion)
+	return nil
 }
 
 // TODO: Make sure we don't save a partial object
-func (s *GenericStorage) write(key ObjectKey, obj runtime.Object) error {
+func (s *GenericStorage) write(ctx context.Context, key ObjectKey, obj runtime.Object) error {
 	// Set the content type based on the format given by the RawStorage, but default to JSON
 	contentType := serializer.ContentTypeJSON
 	if ct := s.raw.ContentType(key); len(ct) != 0 {
@@ -160,46 +278,84 @@ func (s *GenericStorage) write(key ObjectKey, obj runtime.Object) error {
 		obj.SetCreationTimestamp(metav1.Now())
 	}
 
+	// The resourceVersion is derived from the RawStorage, never persist it
+	obj.SetResourceVersion("")
+
 	var objBytes bytes.Buffer
 	err := s.serializer.Encoder().Encode(serializer.NewFrameWriter(contentType, &objBytes), obj)
 	if err != nil {
 		return err
 	}
 
-	return s.raw.Write(key, objBytes.Bytes())
+	if err := s.raw.Write(ctx, key, objBytes.Bytes()); err != nil {
+		return err
+	}
+
+	return s.observeResourceVersion(ctx, key, obj)
 }
 
-func (s *GenericStorage) Create(obj runtime.Object) error {
+func (s *GenericStorage) Create(ctx context.Context, obj runtime.Object) error {
 	key, err := s.ObjectKeyFor(obj)
 	if err != nil {
 		return err
 	}
 
-	if s.raw.Exists(key) {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if s.raw.Exists(ctx, key) {
 		return ErrAlreadyExists
 	}
 
 	// The object was not found so we can safely create it
-	return s.write(key, obj)
+	return s.write(ctx, key, obj)
 }
 
-func (s *GenericStorage) Update(obj runtime.Object) error {
+func (s *GenericStorage) Update(ctx context.Context, obj runtime.Object) error {
 	key, err := s.ObjectKeyFor(obj)
 	if err != nil {
 		return err
 	}
 
-	if !s.raw.Exists(key) {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if !s.raw.Exists(ctx, key) {
 		return ErrNotFound
 	}
 
+	// Make sure the object hasn't been changed since the caller read it
+	if err := s.checkResourceVersion(ctx, key, obj.GetResourceVersion()); err != nil {
+		return err
+	}
+
 	// The object was found so we can safely update it
-	return s.write(key, obj)
+	return s.writeExisting(ctx, key, obj)
+}
+
+// writeExisting writes an Object that exists in the storage. The deletionTimestamp is only set by
+// Delete and can't be unset, so the stored one is kept. An Object being deleted is removed once
+// its last finalizer has been removed. s.writeMux must be held by the caller.
+func (s *GenericStorage) writeExisting(ctx context.Context, key ObjectKey, obj runtime.Object) error {
+	current, err := s.GetMeta(ctx, key)
+	if err != nil {
+		return err
+	}
+	obj.SetDeletionTimestamp(current.GetDeletionTimestamp())
+
+	if err := s.write(ctx, key, obj); err != nil {
+		return err
+	}
+
+	if obj.GetDeletionTimestamp() != nil {
+		return s.finalize(ctx, key, obj)
+	}
+	return nil
 }
 
 // Patch performs a strategic merge patch on the object with the given UID, using the byte-encoded patch given
-func (s *GenericStorage) Patch(key ObjectKey, patch []byte) error {
-	oldContent, err := s.raw.Read(key)
+func (s *GenericStorage) Patch(ctx context.Context, key ObjectKey, patch []byte) error {
+	oldContent, resourceVersion, err := s.read(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -209,63 +365,47 @@ func (s *GenericStorage) Patch(key ObjectKey, patch []byte) error {
 		return err
 	}
 
-	return s.raw.Write(key, newContent)
-}
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
 
-// Delete removes an Object from the storage
-func (s *GenericStorage) Delete(key ObjectKey) error {
-	return s.raw.Delete(key)
-}
+	// Make sure the object wasn't changed while the patch was applied
+	if err := s.checkResourceVersion(ctx, key, resourceVersion); err != nil {
+		return err
+	}
 
-// Checksum returns a string representing the state of an Object on disk
-func (s *GenericStorage) Checksum(key ObjectKey) (string, error) {
-	return s.raw.Checksum(key)
+	obj, err := s.decode(key, newContent)
+	if err != nil {
+		return err
+	}
+	return s.writeExisting(ctx, key, obj)
 }
 
-func (s *GenericStorage) list(kind KindKey) (result []runtime.Object, walkerr error) {
-	walkerr = s.walkKind(kind, func(key ObjectKey, content []byte) error {
-		obj, err := s.decode(key, content)
-		if err != nil {
-			return err
-		}
+// Delete removes an Object from the storage. If the Object has finalizers, it's only marked as being
+// deleted by setting its deletionTimestamp, and removed once its finalizers have been removed through
+// Update or Patch. Its dependents are handled according to GenericStorageOptions.PropagationPolicy.
+func (s *GenericStorage) Delete(ctx context.Context, key ObjectKey) error {
+	return s.DeleteWithPropagation(ctx, key, s.opts.PropagationPolicy)
+}
 
-		result = append(result, obj)
-		return nil
-	})
-	return
+// Checksum returns a string representing the state of an Object on disk
+func (s *GenericStorage) Checksum(ctx context.Context, key ObjectKey) (string, error) {
+	return s.raw.Checksum(ctx, key)
 }
 
 // List lists Objects for the specific kind. Optionally, filters can be applied (see the filter package
-// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{})
-func (s *GenericStorage) List(kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error) {
-	// First, complete the options struct
-	o, err := filter.MakeListOptions(opts...)
-	if err != nil {
-		return nil, err
-	}
-
-	// Do an internal list to get all objects
-	objs, err := s.list(kind)
-	if err != nil {
-		return nil, err
-	}
-
-	// For all list filters, pipe the output of the previous as the input to the next, in order.
-	for _, filter := range o.Filters {
-		objs, err = filter.Filter(objs...)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return objs, nil
+// for more information, e.g. filter.NameFilter{} and filter.UIDFilter{}). The Objects are ordered by
+// name, unless a filter.SortBy is given. Use ListPage to get the continue token with a filter.Limit.
+func (s *GenericStorage) List(ctx context.Context, kind KindKey, opts ...filter.ListOption) ([]runtime.Object, error) {
+	objs, _, err := ListPage(ctx, s, kind, opts...)
+	return objs, err
 }
 
 // Find does a List underneath, also using filters, but always returns one object. If the List
 // underneath returned two or more results, ErrAmbiguousFind is returned. If no match was found,
 // ErrNotFound is returned.
-func (s *GenericStorage) Find(kind KindKey, opts ...filter.ListOption) (runtime.Object, error) {
+func (s *GenericStorage) Find(ctx context.Context, kind KindKey, opts ...filter.ListOption) (runtime.Object, error) {
 	// Do a normal list underneath
-	objs, err := s.List(kind, opts...)
+	objs, err := s.List(ctx, kind, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -283,24 +423,34 @@ func (s *GenericStorage) Find(kind KindKey, opts ...filter.ListOption) (runtime.
 // ListMeta lists all Objects' APIType representation. In other words,
 // only metadata about each Object is unmarshalled (uid/name/kind/apiVersion).
 // This allows for faster runs (no need to unmarshal "the world"), and less
-// resource usage, when only metadata is unmarshalled into memory
-func (s *GenericStorage) ListMeta(kind KindKey) (result []runtime.PartialObject, walkerr error) {
-	walkerr = s.walkKind(kind, func(key ObjectKey, content []byte) error {
-
-		obj, err := s.decodeMeta(key, content)
-		if err != nil {
-			return err
-		}
+// resource usage, when only metadata is unmarshalled into memory. Only the
+// filter.MetaFilters and orderings by metadata fields are supported.
+func (s *GenericStorage) ListMeta(ctx context.Context, kind KindKey, opts ...filter.ListOption) ([]runtime.PartialObject, error) {
+	o, err := filter.MakeListOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, object, rest := o.SplitFilters(); len(object)+len(rest) > 0 {
+		return nil, fmt.Errorf("ListMeta only supports filters on metadata: %w", filter.ErrInvalidFilterParams)
+	}
+	if !o.SortBy.MetaOnly() {
+		return nil, fmt.Errorf("ListMeta can't order by %q, only by metadata: %w", o.SortBy.Field, filter.ErrInvalidFilterParams)
+	}
 
-		result = append(result, obj)
-		return nil
-	})
-	return
+	it, err := s.newListIterator(ctx, kind, o, true)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]runtime.PartialObject, 0)
+	for it.Next() {
+		result = append(result, it.Object().(runtime.PartialObject))
+	}
+	return result, it.Err()
 }
 
 // Count counts the Objects for the specific kind
-func (s *GenericStorage) Count(kind KindKey) (uint64, error) {
-	entries, err := s.raw.List(kind)
+func (s *GenericStorage) Count(ctx context.Context, kind KindKey) (uint64, error) {
+	entries, err := s.raw.List(ctx, kind)
 	return uint64(len(entries)), err
 }
 
@@ -384,24 +534,31 @@ func (s *GenericStorage) decodeMeta(key ObjectKey, content []byte) (runtime.Part
 	return partobjs[0], nil
 }
 
-func (s *GenericStorage) walkKind(kind KindKey, fn func(key ObjectKey, content []byte) error) error {
-	keys, err := s.raw.List(kind)
+// walkKind reads the content and resourceVersion of every Object of the given kind, and calls fn for it. If
+// ctx is cancelled or its deadline exceeded during the walk, the walk is aborted and ctx.Err() returned.
+func (s *GenericStorage) walkKind(ctx context.Context, kind KindKey, fn func(key ObjectKey, content []byte, resourceVersion string) error) error {
+	keys, err := s.raw.List(ctx, kind)
 	if err != nil {
 		return err
 	}
 
 	for _, key := range keys {
+		// Stop walking as soon as the caller isn't interested in the result anymore
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// Allow metadata.json to not exist, although the directory does exist
-		if !s.raw.Exists(key) {
+		if !s.raw.Exists(ctx, key) {
 			continue
 		}
 
-		content, err := s.raw.Read(key)
+		content, resourceVersion, err := s.read(ctx, key)
 		if err != nil {
 			return err
 		}
 
-		if err := fn(key, content); err != nil {
+		if err := fn(key, content, resourceVersion); err != nil {
 			return err
 		}
 	}
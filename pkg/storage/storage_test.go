@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+)
+
+// The fixtures of the storagetest package can't be used by the tests in this package, as
+// storagetest imports it. These mirror them.
+var carGVK = v1alpha1.SchemeGroupVersion.WithKind("Car")
+
+func newCar(name string) *v1alpha1.Car {
+	car := &v1alpha1.Car{}
+	car.SetGroupVersionKind(carGVK)
+	car.Name = name
+	car.Namespace = "default"
+	car.Spec.Brand = "Volvo"
+	return car
+}
+
+func carKey(name string) ObjectKey {
+	return NewObjectKey(NewKindKey(carGVK), runtime.NewIdentifier("default/"+name))
+}
+
+func newTestStorage(t *testing.T, raw RawStorage) Storage {
+	t.Helper()
+	return NewGenericStorage(raw, scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "libgitops-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// testResourceVersionConflict expects the Car "foo" to exist in s
+func testResourceVersionConflict(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	// Two controllers read the same object
+	obj1, err := s.Get(ctx, carKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj2, err := s.Get(ctx, carKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obj1.GetResourceVersion()) == 0 {
+		t.Fatal("expected Get to set the resourceVersion")
+	}
+
+	// The first one updates successfully, and observes the new resourceVersion
+	obj1.(*v1alpha1.Car).Status.Speed = 10
+	if err := s.Update(ctx, obj1); err != nil {
+		t.Fatal(err)
+	}
+	if obj1.GetResourceVersion() == obj2.GetResourceVersion() {
+		t.Fatal("expected Update to set a new resourceVersion")
+	}
+
+	// The second one is working on stale data, and conflicts
+	obj2.(*v1alpha1.Car).Status.Speed = 20
+	if err := s.Update(ctx, obj2); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	// An Update without a resourceVersion is unconditional
+	obj2.SetResourceVersion("")
+	if err := s.Update(ctx, obj2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resourceVersion is never persisted
+	content, err := s.RawStorage().Read(ctx, carKey("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := runtime.NewPartialObject(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv := obj.GetResourceVersion(); len(rv) != 0 {
+		t.Errorf("expected no persisted resourceVersion, got %q", rv)
+	}
+}
+
+func TestGenericStorage_ResourceVersionConflict(t *testing.T) {
+	raw := NewGenericRawStorage(tempDir(t), v1alpha1.SchemeGroupVersion, serializer.ContentTypeYAML)
+	s := newTestStorage(t, raw)
+	if err := s.Create(context.Background(), newCar("foo")); err != nil {
+		t.Fatal(err)
+	}
+	testResourceVersionConflict(t, s)
+}
+
+func TestGenericStorage_ResourceVersionConflictMapped(t *testing.T) {
+	const motorcycle = "---\napiVersion: sample-app.weave.works/v1alpha1\nkind: Motorcycle\nmetadata:\n  name: bar\n"
+	const car = "---\napiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\n"
+
+	// The Car is stored as the second frame of vehicles.yaml
+	path := filepath.Join(tempDir(t), "vehicles.yaml")
+	if err := ioutil.WriteFile(path, []byte(motorcycle+car), 0644); err != nil {
+		t.Fatal(err)
+	}
+	raw := NewGenericMappedRawStorage(filepath.Dir(path))
+	raw.AddMapping(carKey("foo"), FileLocation{Path: path, Frame: 1})
+
+	s := newTestStorage(t, raw)
+	testResourceVersionConflict(t, s)
+
+	// Deleting the Car must leave the Motorcycle as-is
+	if err := s.Delete(context.Background(), carKey("foo")); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != motorcycle {
+		t.Errorf("unexpected file content after delete: %q", content)
+	}
+}
+
+func TestGenericMappedRawStorage_Placement(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement PlacementPolicy
+		existing  map[string]string
+		wantPath  string
+		wantFrame int
+		wantErr   bool
+	}{
+		{
+			name:      "object per file",
+			placement: NewObjectPerFilePlacement(serializer.ContentTypeYAML),
+			wantPath:  "Car/default/foo.yaml",
+		},
+		{
+			name:      "fixed file is created",
+			placement: NewFixedFilePlacement("cars.yaml"),
+			wantPath:  "cars.yaml",
+		},
+		{
+			name:      "fixed file is appended to",
+			placement: NewFixedFilePlacement("cars.yaml"),
+			existing:  map[string]string{"cars.yaml": carYAML},
+			wantPath:  "cars.yaml",
+			wantFrame: 1,
+		},
+		{
+			name: "callback",
+			placement: PlacementFunc(func(key ObjectKey) (string, error) {
+				return "vehicles/" + key.GetKind() + ".json", nil
+			}),
+			wantPath: "vehicles/Car.json",
+		},
+		{
+			name:    "no placement",
+			wantErr: true,
+		},
+		{
+			name:      "outside of the directory",
+			placement: NewFixedFilePlacement("../cars.yaml"),
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported extension",
+			placement: NewFixedFilePlacement("cars.txt"),
+			wantErr:   true,
+		},
+		{
+			name:      "JSON files can't be appended to",
+			placement: NewFixedFilePlacement("cars.json"),
+			existing:  map[string]string{"cars.json": "{}"},
+			wantErr:   true,
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := tempDir(t)
+			for file, content := range rt.existing {
+				if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			raw := NewGenericMappedRawStorageWithOptions(dir, MappedRawStorageOptions{Placement: rt.placement})
+			s := newTestStorage(t, raw)
+
+			err := s.Create(ctx, newCar("foo"))
+			if rt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The Car is stored as the last frame of the file
+			path := filepath.Join(dir, filepath.FromSlash(rt.wantPath))
+			if keys := raw.GetKeys(path); len(keys) != 1 || keys[0] != carKey("foo") {
+				t.Fatalf("expected %s to be mapped to %q, got %v", carKey("foo"), path, keys)
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frames := SplitFrames(content); len(frames) != rt.wantFrame+1 {
+				t.Errorf("expected %d frames, got %d", rt.wantFrame+1, len(frames))
+			}
+			obj, err := s.Get(ctx, carKey("foo"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if brand := obj.(*v1alpha1.Car).Spec.Brand; brand != "Volvo" {
+				t.Errorf("unexpected brand %q", brand)
+			}
+			// Creating it again fails
+			if err := s.Create(ctx, newCar("foo")); !errors.Is(err, ErrAlreadyExists) {
+				t.Errorf("expected ErrAlreadyExists, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGenericMappedRawStorage_RemoveEmptyDirs(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	raw := NewGenericMappedRawStorageWithOptions(dir, MappedRawStorageOptions{
+		Placement:       NewObjectPerFilePlacement(serializer.ContentTypeYAML),
+		RemoveEmptyDirs: true,
+	})
+	s := newTestStorage(t, raw)
+	for _, name := range []string{"foo", "bar"} {
+		if err := s.Create(ctx, newCar(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The directory is kept as long as it contains files
+	if err := s.Delete(ctx, carKey("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Car", "default")); err != nil {
+		t.Errorf("expected Car/default to be kept: %v", err)
+	}
+
+	// Deleting the last Object removes the empty directories, but not the storage directory
+	if err := s.Delete(ctx, carKey("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Car")); !os.IsNotExist(err) {
+		t.Errorf("expected Car to be removed, got %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected the storage directory to be kept: %v", err)
+	}
+}
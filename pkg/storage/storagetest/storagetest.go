@@ -0,0 +1,63 @@
+// Package storagetest contains the fixtures shared by the tests of the Storages and their
+// consumers, built on the Car type of the sample-app. The tests of the storage package itself
+// can't use it, as this package imports it.
+package storagetest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+var (
+	// CarGVK is the GroupVersionKind of the sample-app Car
+	CarGVK = v1alpha1.SchemeGroupVersion.WithKind("Car")
+	// CarKind is the KindKey of the sample-app Car
+	CarKind = storage.NewKindKey(CarGVK)
+)
+
+// Identifiers are the identifiers used by the Storages returned by NewStorage.
+var Identifiers = []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}
+
+// NewCar returns a Car of the given brand with the given name, in the "default" namespace.
+func NewCar(name, brand string) *v1alpha1.Car {
+	car := &v1alpha1.Car{}
+	car.SetGroupVersionKind(CarGVK)
+	car.Name = name
+	car.Namespace = "default"
+	car.Spec.Brand = brand
+	return car
+}
+
+// NewColoredCar returns a Volvo with the given name, labeled with the given "color".
+func NewColoredCar(name, color string) *v1alpha1.Car {
+	car := NewCar(name, "Volvo")
+	car.Labels = map[string]string{"color": color}
+	return car
+}
+
+// CarKey returns the key of the Car with the given name in the "default" namespace.
+func CarKey(name string) storage.ObjectKey {
+	return storage.NewObjectKey(CarKind, runtime.NewIdentifier("default/"+name))
+}
+
+// NewStorage returns a GenericStorage for raw, using the sample-app scheme and Identifiers.
+func NewStorage(raw storage.RawStorage) storage.Storage {
+	return storage.NewGenericStorage(raw, scheme.Serializer, Identifiers)
+}
+
+// TempDir creates a temporary directory, which is removed when the test finishes.
+func TempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "libgitops-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
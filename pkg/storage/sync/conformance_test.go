@@ -0,0 +1,53 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/conformance"
+	"github.com/weaveworks/libgitops/pkg/storage/memory"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+func newConformanceSyncStorage(t *testing.T, ser serializer.Serializer, primaryEvents bool) *SyncStorage {
+	var primary storage.Storage = memory.NewMemoryStorage(ser, conformance.Identifiers)
+	if primaryEvents {
+		es, err := memory.NewMemoryEventStorage(primary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		primary = es
+	}
+	replica := memory.NewMemoryStorage(ser, conformance.Identifiers)
+
+	ss, err := NewSyncStorage(context.Background(), primary, []storage.Storage{replica}, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ss.Close() })
+	return ss
+}
+
+func TestSyncStorage_Conformance(t *testing.T) {
+	conformance.TestStorage(t, func(t *testing.T, ser serializer.Serializer) storage.Storage {
+		return newConformanceSyncStorage(t, ser, false)
+	})
+}
+
+func TestSyncStorage_EventStorage_Conformance(t *testing.T) {
+	// The SyncStorage sends the updates for a primary that isn't an EventStorage itself
+	conformance.TestEventStorage(t, func(t *testing.T, ser serializer.Serializer) (update.EventStorage, storage.Storage) {
+		ss := newConformanceSyncStorage(t, ser, false)
+		return ss, ss
+	}, conformance.EventStorageOptions{StrictOrdering: true})
+}
+
+func TestSyncStorage_PrimaryEvents_Conformance(t *testing.T) {
+	// The updates of the primary are forwarded, after propagating the changes
+	conformance.TestEventStorage(t, func(t *testing.T, ser serializer.Serializer) (update.EventStorage, storage.Storage) {
+		ss := newConformanceSyncStorage(t, ser, true)
+		return ss, ss
+	}, conformance.EventStorageOptions{})
+}
@@ -1,188 +1,518 @@
 package sync
 
-/*
-
-TODO: Revisit if we need this file/package in the future.
-
 import (
+	"context"
+	"errors"
 	"fmt"
+	gosync "sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/storage"
-	"github.com/weaveworks/libgitops/pkg/storage/watch"
 	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
-	"github.com/weaveworks/libgitops/pkg/util/sync"
+	"k8s.io/apimachinery/pkg/api/equality"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// SourceOfTruth decides which Storage wins when the reconciliation of a
+// SyncStorage finds the primary and the replicas to differ.
+type SourceOfTruth byte
+
+var _ fmt.Stringer = SourceOfTruth(0)
+
+const (
+	// SourceOfTruthPrimary makes the replicas mirror the primary. Objects that only
+	// exist in a replica are deleted from it.
+	SourceOfTruthPrimary SourceOfTruth = iota // 0
+	// SourceOfTruthReplica makes the primary and the other replicas mirror the first
+	// replica. Objects that don't exist in the first replica are deleted.
+	SourceOfTruthReplica // 1
+	// SourceOfTruthMerge copies every Object to the Storages missing it, and never deletes
+	// anything. Differing Objects are taken from the primary, or else from the first replica
+	// having them.
+	SourceOfTruthMerge // 2
 )
 
-const updateBuffer = 4096 // How many updates to buffer, 4096 should be enough for even a high update frequency
+func (s SourceOfTruth) String() string {
+	switch s {
+	case SourceOfTruthPrimary:
+		return "Primary"
+	case SourceOfTruthReplica:
+		return "Replica"
+	case SourceOfTruthMerge:
+		return "Merge"
+	}
 
-// SyncStorage is a Storage implementation taking in multiple Storages and
-// keeping them in sync. Any write operation executed on the SyncStorage
-// is propagated to all of the Storages it manages (including the embedded
-// one). For any retrieval or generation operation, the embedded Storage
-// will be used (it is treated as read-write). As all other Storages only
-// receive write operations, they can be thought of as write-only.
+	// Should never happen
+	return "UNKNOWN"
+}
+
+// SyncOptions configures a SyncStorage.
+type SyncOptions struct {
+	// Kinds are the kinds reconciled by SyncStorage.Reconcile, as the Storages
+	// can't list the kinds they contain. Default none, which skips the reconciliation.
+	// +optional
+	Kinds []storage.KindKey
+	// SourceOfTruth decides how the reconciliation resolves differences. Default SourceOfTruthPrimary.
+	// +optional
+	SourceOfTruth SourceOfTruth
+}
+
+// SyncStorage is a Storage implementation taking in multiple Storages and keeping them in sync.
+// Any write operation executed on the SyncStorage is applied to the primary first, and then
+// propagated to all replicas. Reads are served by the primary. Changes observed in any of the
+// Storages that is an update.EventStorage are propagated to all other Storages.
+//
+// The SyncStorage is an update.EventStorage itself, its updates describe the changes of the
+// primary: if the primary is an EventStorage, its updates are forwarded. Otherwise, the
+// SyncStorage sends updates for the changes it applies to the primary.
 type SyncStorage struct {
 	storage.Storage
-	storages       []storage.Storage
-	inboundStream  update.UpdateStream
-	outboundStream update.UpdateStream
-	monitor        *sync.Monitor
+	replicas []storage.Storage
+	opts     SyncOptions
+
+	// primaryEvents is set if the primary is an EventStorage, whose updates are forwarded
+	primaryEvents bool
+	broadcaster   *update.Broadcaster
+	subs          []update.Subscription
+	// ctx is cancelled when closing the SyncStorage, stopping the propagation of changes
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     gosync.WaitGroup
+	// propagateMux serializes the writes, the propagation of changes and the reconciliation,
+	// so that they don't race to write the same Object to a Storage
+	propagateMux gosync.Mutex
 }
 
 // SyncStorage implements update.EventStorage.
 var _ update.EventStorage = &SyncStorage{}
 
-// NewSyncStorage constructs a new SyncStorage
-func NewSyncStorage(rwStorage storage.Storage, wStorages ...storage.Storage) storage.Storage {
+// NewSyncStorage constructs a new SyncStorage for the given primary and replicas. Before it's
+// returned, the Storages are reconciled according to opts, so that changes made while the
+// SyncStorage wasn't running are synced as well.
+func NewSyncStorage(ctx context.Context, primary storage.Storage, replicas []storage.Storage, opts SyncOptions) (*SyncStorage, error) {
+	if opts.SourceOfTruth == SourceOfTruthReplica && len(replicas) == 0 {
+		return nil, fmt.Errorf("NewSyncStorage: SourceOfTruth %s requires a replica", opts.SourceOfTruth)
+	}
+
 	ss := &SyncStorage{
-		Storage:  rwStorage,
-		storages: append(wStorages, rwStorage),
+		Storage:     primary,
+		replicas:    replicas,
+		opts:        opts,
+		broadcaster: update.NewBroadcaster(),
 	}
+	ss.ctx, ss.cancel = context.WithCancel(context.Background())
 
-	for _, s := range ss.storages {
-		if watchStorage, ok := s.(watch.WatchStorage); ok {
-			// Populate eventStream if we found a watchstorage
-			if ss.inboundStream == nil {
-				ss.inboundStream = make(update.UpdateStream, updateBuffer)
-			}
-			watchStorage.SetUpdateStream(ss.inboundStream)
+	// Subscribe before reconciling, so that no change after the reconciliation is missed.
+	// The changes are read from the Storages when propagating them, so pending updates
+	// for the same Object can be merged.
+	for i, s := range ss.storages() {
+		es, ok := s.(update.EventStorage)
+		if !ok {
+			continue
 		}
+		if i == 0 {
+			ss.primaryEvents = true
+		}
+
+		sub := es.Subscribe(update.SubscribeOptions{OverflowPolicy: update.OverflowCoalesce})
+		ss.subs = append(ss.subs, sub)
+		ss.wg.Add(1)
+		go ss.monitor(i, sub)
 	}
 
-	if ss.inboundStream != nil {
-		ss.monitor = sync.RunMonitor(ss.monitorFunc)
-		ss.outboundStream = make(update.UpdateStream, updateBuffer)
+	if err := ss.Reconcile(ctx); err != nil {
+		ss.stop()
+		return nil, err
 	}
+	return ss, nil
+}
 
-	return ss
+// storages returns the primary followed by the replicas
+func (ss *SyncStorage) storages() []storage.Storage {
+	return append([]storage.Storage{ss.Storage}, ss.replicas...)
 }
 
-// Set is propagated to all Storages
-func (ss *SyncStorage) Set(obj runtime.Object) error {
-	return ss.runAll(func(s storage.Storage) error {
-		return s.Set(obj)
-	})
+func (ss *SyncStorage) Subscribe(opts update.SubscribeOptions) update.Subscription {
+	return ss.broadcaster.Subscribe(opts)
 }
 
-// Patch is propagated to all Storages
-func (ss *SyncStorage) Patch(key storage.ObjectKey, patch []byte) error {
-	return ss.runAll(func(s storage.Storage) error {
-		return s.Patch(key, patch)
-	})
+// Create creates the Object in the primary, and then in all replicas
+func (ss *SyncStorage) Create(ctx context.Context, obj runtime.Object) error {
+	ss.propagateMux.Lock()
+	defer ss.propagateMux.Unlock()
+
+	if err := ss.Storage.Create(ctx, obj); err != nil {
+		return err
+	}
+	key, err := ss.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+	return ss.replicate(ctx, key, update.ObjectEventCreate)
 }
 
-// Delete is propagated to all Storages
-func (ss *SyncStorage) Delete(key storage.ObjectKey) error {
-	return ss.runAll(func(s storage.Storage) error {
-		return s.Delete(key)
-	})
+// Update updates the Object in the primary, and then in all replicas. The resourceVersion
+// of the Object is only checked against the primary.
+func (ss *SyncStorage) Update(ctx context.Context, obj runtime.Object) error {
+	ss.propagateMux.Lock()
+	defer ss.propagateMux.Unlock()
+
+	if err := ss.Storage.Update(ctx, obj); err != nil {
+		return err
+	}
+	key, err := ss.ObjectKeyFor(obj)
+	if err != nil {
+		return err
+	}
+	return ss.replicate(ctx, key, update.ObjectEventModify)
 }
 
-func (ss *SyncStorage) Close() error {
-	// Close all WatchStorages
-	for _, s := range ss.storages {
-		if watchStorage, ok := s.(watch.WatchStorage); ok {
-			_ = watchStorage.Close()
-		}
+// Patch patches the Object in the primary, and then writes the result to all replicas
+func (ss *SyncStorage) Patch(ctx context.Context, key storage.ObjectKey, patch []byte) error {
+	ss.propagateMux.Lock()
+	defer ss.propagateMux.Unlock()
+
+	if err := ss.Storage.Patch(ctx, key, patch); err != nil {
+		return err
 	}
+	return ss.replicate(ctx, key, update.ObjectEventModify)
+}
 
-	// Close the event streams if set
-	if ss.inboundStream != nil {
-		close(ss.inboundStream)
+// Delete deletes the Object from the primary, and then from all replicas. If the primary only
+// marks the Object as being deleted, e.g. as it has finalizers, the marked Object is replicated.
+func (ss *SyncStorage) Delete(ctx context.Context, key storage.ObjectKey) error {
+	ss.propagateMux.Lock()
+	defer ss.propagateMux.Unlock()
+
+	if err := ss.Storage.Delete(ctx, key); err != nil {
+		return err
 	}
-	if ss.outboundStream != nil {
-		close(ss.outboundStream)
+	return ss.replicate(ctx, key, update.ObjectEventModify)
+}
+
+// replicate writes the Object with the given key, which has been written to the primary, to all
+// replicas. The Object is read back from the primary, as e.g. a Delete may only mark it as being
+// deleted, and an Update removing its last finalizer may remove it. If the primary doesn't contain
+// the Object anymore, it's removed from the replicas as well.
+func (ss *SyncStorage) replicate(ctx context.Context, key storage.ObjectKey, event update.ObjectEvent) error {
+	obj, err := ss.Storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		ss.notify(ctx, update.ObjectEventDelete, key)
+		return ss.runAll(func(s storage.Storage) error {
+			_, err := removeObject(ctx, s, key)
+			return err
+		})
+	} else if err != nil {
+		return err
 	}
-	// Wait for the monitor goroutine
-	ss.monitor.Wait()
-	return nil
+	ss.notify(ctx, event, key)
+
+	return ss.runAll(func(s storage.Storage) error {
+		_, err := putObject(ctx, s, key, obj)
+		return err
+	})
 }
 
-func (ss *SyncStorage) GetUpdateStream() update.UpdateStream {
-	return ss.outboundStream
+// Close stops propagating changes, and closes all Storages
+func (ss *SyncStorage) Close() error {
+	ss.stop()
+
+	var errs []error
+	for _, s := range ss.storages() {
+		errs = append(errs, s.Close())
+	}
+	return utilerrors.NewAggregate(errs)
 }
 
-// runAll runs the given function for all Storages in parallel and aggregates all errors
-func (ss *SyncStorage) runAll(f func(storage.Storage) error) (err error) {
-	type result struct {
-		int
-		error
+// stop stops propagating changes and sending updates
+func (ss *SyncStorage) stop() {
+	ss.cancel()
+	for _, sub := range ss.subs {
+		sub.Unsubscribe()
 	}
+	ss.wg.Wait()
+	ss.broadcaster.Close()
+}
+
+// runAll runs the given function for all replicas in parallel and aggregates all errors
+func (ss *SyncStorage) runAll(f func(storage.Storage) error) error {
+	errs := make([]error, len(ss.replicas))
 
-	errC := make(chan result)
-	for i, s := range ss.storages {
+	var wg gosync.WaitGroup
+	for i, s := range ss.replicas {
+		wg.Add(1)
 		go func(i int, s storage.Storage) {
-			errC <- result{i, f(s)}
+			defer wg.Done()
+			if err := f(s); err != nil {
+				errs[i] = fmt.Errorf("SyncStorage: Error in replica %d: %w", i, err)
+			}
 		}(i, s) // NOTE: This requires i and s as arguments, otherwise they will be evaluated for one Storage only
 	}
+	wg.Wait()
 
-	for i := 0; i < len(ss.storages); i++ {
-		if result := <-errC; result.error != nil {
-			if err == nil {
-				err = fmt.Errorf("SyncStorage: Error in Storage %d: %v", result.int, result.error)
-			} else {
-				err = fmt.Errorf("%v\n%29s %d: %v", err, "and error in Storage", result.int, result.error)
-			}
+	return utilerrors.NewAggregate(errs)
+}
+
+// monitor propagates the changes of the Storage with the given index in ss.storages()
+func (ss *SyncStorage) monitor(i int, sub update.Subscription) {
+	defer ss.wg.Done()
+	log.Debugf("SyncStorage: Monitoring thread for Storage %d started", i)
+	defer log.Debugf("SyncStorage: Monitoring thread for Storage %d stopped", i)
+
+	for upd := range sub.Updates() {
+		log.Debugf("SyncStorage: Received %s update for %v from Storage %d", upd.Event, upd.ObjectKey, i)
+		if err := ss.propagate(i, upd); err != nil {
+			log.Errorf("SyncStorage: Failed to propagate %s of %v: %v", upd.Event, upd.ObjectKey, err)
+		}
+
+		// Forward the updates of the primary, with the SyncStorage as their source
+		if i == 0 {
+			upd.Storage = ss
+			ss.broadcaster.Broadcast(upd)
+		}
+	}
+}
+
+// propagate applies the change of the Storage with the given index to all other Storages. The
+// Object is read from the Storage, as it may have changed again since the update was sent.
+// Storages that are in sync already aren't written to, so that propagated changes don't bounce
+// back and forth between Storages.
+func (ss *SyncStorage) propagate(src int, upd update.Update) error {
+	ss.propagateMux.Lock()
+	defer ss.propagateMux.Unlock()
+
+	storages := ss.storages()
+	key := upd.ObjectKey
+
+	var obj runtime.Object
+	if upd.Event != update.ObjectEventDelete {
+		var err error
+		if obj, err = storages[src].Get(ss.ctx, key); errors.Is(err, storage.ErrNotFound) {
+			return nil // Deleted since, which is propagated by the update for the deletion
+		} else if err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for i, s := range storages {
+		if i == src {
+			continue
+		}
+
+		var event update.ObjectEvent
+		var err error
+		if obj == nil {
+			event, err = removeObject(ss.ctx, s, key)
+		} else {
+			event, err = putObject(ss.ctx, s, key, obj)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Storage %d: %w", i, err))
+		} else if i == 0 {
+			ss.notify(ss.ctx, event, key)
 		}
 	}
+	return utilerrors.NewAggregate(errs)
+}
 
-	return
+// Reconcile makes the Objects of the kinds in SyncOptions.Kinds the same in all Storages,
+// resolving differences according to SyncOptions.SourceOfTruth. It's run when creating the
+// SyncStorage, and can be run again e.g. periodically. Changes aren't propagated meanwhile.
+func (ss *SyncStorage) Reconcile(ctx context.Context) error {
+	ss.propagateMux.Lock()
+	defer ss.propagateMux.Unlock()
+
+	var errs []error
+	for _, kind := range ss.opts.Kinds {
+		if err := ss.reconcileKind(ctx, kind); err != nil {
+			errs = append(errs, fmt.Errorf("SyncStorage: Failed to reconcile %s: %w", kind, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
 }
 
-func (ss *SyncStorage) monitorFunc() {
-	log.Debug("SyncStorage: Monitoring thread started")
-	defer log.Debug("SyncStorage: Monitoring thread stopped")
+func (ss *SyncStorage) reconcileKind(ctx context.Context, kind storage.KindKey) error {
+	storages := ss.storages()
+
+	// existing holds the identifiers of the Objects in each Storage
+	existing := make([]map[string]bool, len(storages))
+	for i, s := range storages {
+		keys, err := s.RawStorage().List(ctx, kind)
+		if err != nil {
+			return err
+		}
+		existing[i] = make(map[string]bool, len(keys))
+		for _, key := range keys {
+			existing[i][key.GetIdentifier()] = true
+		}
+	}
 
-	// TODO: Support detecting changes done when the GitOps daemon isn't running
-	// This is difficult to do though, as we have don't know which state is the latest
-	// For now, only update the state on write when the daemon is running
-	for {
-		upd, ok := <-ss.inboundStream
-		if ok {
-			log.Debugf("SyncStorage: Received update %v %t", upd, ok)
+	// sources are the indexes of the Storages the Objects are taken from, in order of priority
+	sources := []int{0}
+	switch ss.opts.SourceOfTruth {
+	case SourceOfTruthReplica:
+		sources = []int{1}
+	case SourceOfTruthMerge:
+		sources = make([]int, len(storages))
+		for i := range storages {
+			sources[i] = i
+		}
+	}
 
-			gvk := upd.PartialObject.GetObjectKind().GroupVersionKind()
-			uid := upd.PartialObject.GetUID()
-			key := storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(string(uid)))
-			log.Debugf("SyncStorage: Object has gvk=%q and uid=%q", gvk, uid)
+	var errs []error
+	identifiers := make(map[string]bool)
+	for _, src := range sources {
+		for identifier := range existing[src] {
+			if identifiers[identifier] {
+				continue // Taken from a source with higher priority already
+			}
+			identifiers[identifier] = true
 
-			switch upd.Event {
-			case update.ObjectEventModify, update.ObjectEventCreate:
-				// First load the Object using the Storage given in the update,
-				// then set it using the client constructed above
+			key := storage.NewObjectKey(kind, runtime.NewIdentifier(identifier))
+			if err := ss.reconcileObject(ctx, key, src); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
 
-				obj, err := upd.Storage.Get(key)
-				if err != nil {
-					log.Errorf("Failed to get Object with UID %q: %v", upd.PartialObject.GetUID(), err)
+	// Without merging, the Objects missing in the source of truth are deleted everywhere
+	if ss.opts.SourceOfTruth != SourceOfTruthMerge {
+		for i, s := range storages {
+			for identifier := range existing[i] {
+				if identifiers[identifier] {
 					continue
 				}
 
-				if err = ss.Set(obj); err != nil {
-					log.Errorf("Failed to set Object with UID %q: %v", upd.PartialObject.GetUID(), err)
-					continue
-				}
-			case update.ObjectEventDelete:
-				// For deletion we use the generated "fake" APIType object
-				if err := ss.Delete(key); err != nil {
-					log.Errorf("Failed to delete Object with UID %q: %v", upd.PartialObject.GetUID(), err)
-					continue
+				key := storage.NewObjectKey(kind, runtime.NewIdentifier(identifier))
+				log.Infof("SyncStorage: Deleting %v from Storage %d, as it's missing in the source of truth", key, i)
+				if deleted, err := removeObject(ctx, s, key); err != nil {
+					errs = append(errs, fmt.Errorf("Storage %d: %w", i, err))
+				} else if i == 0 {
+					ss.notify(ctx, deleted, key)
 				}
 			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
 
-			// Send the update to the listeners unless the channel is full,
-			// in which case issue a warning. The channel can hold as many
-			// updates as updateBuffer specifies.
-			select {
-			case ss.outboundStream <- upd:
-				log.Debugf("SyncStorage: Sent update: %v", upd)
-			default:
-				log.Warn("SyncStorage: Failed to send update, channel full")
-			}
-		} else {
+// reconcileObject writes the Object from the Storage with index src to all other Storages
+func (ss *SyncStorage) reconcileObject(ctx context.Context, key storage.ObjectKey, src int) error {
+	storages := ss.storages()
+	obj, err := storages[src].Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil // Deleted since listing it
+	} else if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, s := range storages {
+		if i == src {
+			continue
+		}
+
+		event, err := putObject(ctx, s, key, obj)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Storage %d: %w", i, err))
+			continue
+		} else if event != update.ObjectEventNone {
+			log.Infof("SyncStorage: Reconciled %v in Storage %d from Storage %d", key, i, src)
+		}
+		if i == 0 {
+			ss.notify(ctx, event, key)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// notify sends an update for a change of the primary, unless the primary sends the updates itself
+func (ss *SyncStorage) notify(ctx context.Context, event update.ObjectEvent, key storage.ObjectKey) {
+	if ss.primaryEvents || event == update.ObjectEventNone {
+		return
+	}
+
+	upd := update.Update{
+		Event:     event,
+		ObjectKey: key,
+		Storage:   ss,
+	}
+	if event == update.ObjectEventDelete {
+		upd.PartialObject = update.DeletedObjectFor(key)
+	} else {
+		partObj, err := ss.Storage.GetMeta(ctx, key)
+		if err != nil {
+			log.Warnf("SyncStorage: Not sending %s update for %v: %v", event, key, err)
 			return
 		}
+		upd.PartialObject = partObj
+	}
+	ss.broadcaster.Broadcast(upd)
+}
+
+// putObject creates or updates the Object in the Storage, unless it's equal already.
+// The returned event describes the change, which is ObjectEventNone if nothing changed.
+func putObject(ctx context.Context, s storage.Storage, key storage.ObjectKey, obj runtime.Object) (update.ObjectEvent, error) {
+	// The resourceVersion is specific to the Storage the Object was read from
+	obj = obj.DeepCopyObject().(runtime.Object)
+	obj.SetResourceVersion("")
+
+	current, err := s.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return update.ObjectEventCreate, s.Create(ctx, obj)
+	} else if err != nil {
+		return update.ObjectEventNone, err
+	}
+
+	// The deletionTimestamp is set by the Delete of every Storage itself, only whether it's set is synced
+	deleting := obj.GetDeletionTimestamp() != nil && current.GetDeletionTimestamp() == nil
+	obj.SetDeletionTimestamp(current.GetDeletionTimestamp())
+
+	// Only overwrite the version that has been compared
+	event := update.ObjectEventNone
+	resourceVersion := current.GetResourceVersion()
+	current.SetResourceVersion("")
+	if !equality.Semantic.DeepEqual(current, obj) {
+		obj.SetResourceVersion(resourceVersion)
+		if err := s.Update(ctx, obj); err != nil {
+			return update.ObjectEventNone, err
+		}
+		event = update.ObjectEventModify
+	}
+
+	// The Storage marks the Object as being deleted, as the finalizers have been synced already
+	if deleting {
+		if err := s.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return update.ObjectEventNone, err
+		}
+		return update.ObjectEventModify, nil
+	}
+	return event, nil
+}
+
+// removeObject removes the Object from the Storage, if it exists, as the source it's synced from
+// doesn't contain it anymore. Its finalizers are removed first, so that it isn't kept as being
+// deleted. The returned event describes the change, which is ObjectEventNone if the Object didn't exist.
+func removeObject(ctx context.Context, s storage.Storage, key storage.ObjectKey) (update.ObjectEvent, error) {
+	current, err := s.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return update.ObjectEventNone, nil
+	} else if err != nil {
+		return update.ObjectEventNone, err
+	}
+
+	// Removing the last finalizer of an Object being deleted removes it already
+	if len(current.GetFinalizers()) != 0 {
+		current.SetFinalizers(nil)
+		if err := s.Update(ctx, current); err != nil {
+			return update.ObjectEventNone, err
+		}
+	}
+	if err := s.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return update.ObjectEventNone, err
 	}
+	return update.ObjectEventDelete, nil
 }
-*/
@@ -0,0 +1,253 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/memory"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+var errReplicaDown = errors.New("replica down")
+
+func newMemoryStorage() storage.Storage {
+	return memory.NewMemoryStorage(scheme.Serializer, storagetest.Identifiers)
+}
+
+// brands returns the brands of the Cars in the Storage by name
+func brands(t *testing.T, s storage.Storage) map[string]string {
+	t.Helper()
+	objs, err := s.List(context.Background(), storagetest.CarKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]string, len(objs))
+	for _, obj := range objs {
+		result[obj.GetName()] = obj.(*v1alpha1.Car).Spec.Brand
+	}
+	return result
+}
+
+// failingStorage fails all writes
+type failingStorage struct {
+	storage.Storage
+}
+
+func (s failingStorage) Create(context.Context, runtime.Object) error { return errReplicaDown }
+func (s failingStorage) Update(context.Context, runtime.Object) error { return errReplicaDown }
+func (s failingStorage) Delete(context.Context, storage.ObjectKey) error {
+	return errReplicaDown
+}
+
+func TestSyncStorage_Writes(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := newMemoryStorage(), newMemoryStorage()
+	ss, err := NewSyncStorage(ctx, primary, []storage.Storage{replica}, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	tests := []struct {
+		name  string
+		write func() error
+		want  map[string]string
+	}{
+		{"Create", func() error { return ss.Create(ctx, storagetest.NewCar("foo", "Volvo")) }, map[string]string{"foo": "Volvo"}},
+		{"Update", func() error { return ss.Update(ctx, storagetest.NewCar("foo", "Saab")) }, map[string]string{"foo": "Saab"}},
+		{"Patch", func() error { return ss.Patch(ctx, storagetest.CarKey("foo"), []byte(`{"spec":{"brand":"Audi"}}`)) }, map[string]string{"foo": "Audi"}},
+		{"Delete", func() error { return ss.Delete(ctx, storagetest.CarKey("foo")) }, map[string]string{}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			if err := rt.write(); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []storage.Storage{primary, replica} {
+				if got := brands(t, s); !reflect.DeepEqual(got, rt.want) {
+					t.Errorf("expected %v, got %v", rt.want, got)
+				}
+			}
+		})
+	}
+
+	// The primary decides whether a write succeeds
+	if err := ss.Delete(ctx, storagetest.CarKey("foo")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSyncStorage_ReplicaErrors(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := newMemoryStorage(), newMemoryStorage()
+	ss, err := NewSyncStorage(ctx, primary, []storage.Storage{failingStorage{newMemoryStorage()}, replica}, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	// The write is applied to the primary and the healthy replica, and the error is returned
+	if err := ss.Create(ctx, storagetest.NewCar("foo", "Volvo")); !errors.Is(err, errReplicaDown) {
+		t.Errorf("expected errReplicaDown, got %v", err)
+	}
+	want := map[string]string{"foo": "Volvo"}
+	for _, s := range []storage.Storage{primary, replica} {
+		if got := brands(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSyncStorage_Reconcile(t *testing.T) {
+	tests := []struct {
+		name          string
+		sourceOfTruth SourceOfTruth
+		want          map[string]string
+	}{
+		{"Primary", SourceOfTruthPrimary, map[string]string{"a": "Volvo", "b": "Saab"}},
+		{"Replica", SourceOfTruthReplica, map[string]string{"a": "Audi", "c": "Opel"}},
+		{"Merge", SourceOfTruthMerge, map[string]string{"a": "Volvo", "b": "Saab", "c": "Opel"}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			ctx := context.Background()
+			primary, replica := newMemoryStorage(), newMemoryStorage()
+			for _, car := range []*v1alpha1.Car{storagetest.NewCar("a", "Volvo"), storagetest.NewCar("b", "Saab")} {
+				if err := primary.Create(ctx, car); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, car := range []*v1alpha1.Car{storagetest.NewCar("a", "Audi"), storagetest.NewCar("c", "Opel")} {
+				if err := replica.Create(ctx, car); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ss, err := NewSyncStorage(ctx, primary, []storage.Storage{replica}, SyncOptions{
+				Kinds:         []storage.KindKey{storagetest.CarKind},
+				SourceOfTruth: rt.sourceOfTruth,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ss.Close()
+
+			for _, s := range []storage.Storage{primary, replica} {
+				if got := brands(t, s); !reflect.DeepEqual(got, rt.want) {
+					t.Errorf("expected %v, got %v", rt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSyncStorage_NoReplica(t *testing.T) {
+	if _, err := NewSyncStorage(context.Background(), newMemoryStorage(), nil, SyncOptions{SourceOfTruth: SourceOfTruthReplica}); err == nil {
+		t.Error("expected an error for SourceOfTruthReplica without replicas")
+	}
+}
+
+func TestSyncStorage_Propagation(t *testing.T) {
+	ctx := context.Background()
+	primary := newMemoryStorage()
+	replica, err := memory.NewMemoryEventStorage(newMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := NewSyncStorage(ctx, primary, []storage.Storage{replica}, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	sub := ss.Subscribe(update.SubscribeOptions{})
+
+	// Changes made directly in a replica are propagated to the primary, which is observed through the SyncStorage
+	tests := []struct {
+		name  string
+		write func() error
+		event update.ObjectEvent
+		want  map[string]string
+	}{
+		{"Create", func() error { return replica.Create(ctx, storagetest.NewCar("foo", "Volvo")) }, update.ObjectEventCreate, map[string]string{"foo": "Volvo"}},
+		{"Update", func() error { return replica.Update(ctx, storagetest.NewCar("foo", "Saab")) }, update.ObjectEventModify, map[string]string{"foo": "Saab"}},
+		{"Delete", func() error { return replica.Delete(ctx, storagetest.CarKey("foo")) }, update.ObjectEventDelete, map[string]string{}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			if err := rt.write(); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case upd := <-sub.Updates():
+				if upd.Event != rt.event || upd.ObjectKey.GetIdentifier() != "default/foo" || upd.Storage != ss {
+					t.Errorf("expected a %s update of default/foo from the SyncStorage, got a %s update of %s", rt.event, upd.Event, upd.ObjectKey)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the %s update", rt.event)
+			}
+			if got := brands(t, primary); !reflect.DeepEqual(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+}
+
+func TestSyncStorage_Finalizers(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := newMemoryStorage(), newMemoryStorage()
+	ss, err := NewSyncStorage(ctx, primary, []storage.Storage{replica}, SyncOptions{Kinds: []storage.KindKey{storagetest.CarKind}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	car := storagetest.NewCar("foo", "Volvo")
+	car.Finalizers = []string{"sample-app.weave.works/cleanup"}
+	if err := ss.Create(ctx, car); err != nil {
+		t.Fatal(err)
+	}
+	sub := ss.Subscribe(update.SubscribeOptions{})
+
+	// The finalizer keeps the Car in all Storages, marked as being deleted
+	if err := ss.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if upd := <-sub.Updates(); upd.Event != update.ObjectEventModify {
+		t.Errorf("expected a %s update, got %s", update.ObjectEventModify, upd.Event)
+	}
+	// Reconciling doesn't change anything, as the Storages are in sync
+	if err := ss.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []storage.Storage{primary, replica} {
+		obj, err := s.Get(ctx, storagetest.CarKey("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.GetDeletionTimestamp() == nil {
+			t.Error("expected the Car to be marked as being deleted")
+		}
+	}
+
+	// Removing the finalizer removes the Car from all Storages
+	if err := ss.Patch(ctx, storagetest.CarKey("foo"), []byte(`{"metadata":{"finalizers":null}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if upd := <-sub.Updates(); upd.Event != update.ObjectEventDelete {
+		t.Errorf("expected a %s update, got %s", update.ObjectEventDelete, upd.Event)
+	}
+	for _, s := range []storage.Storage{primary, replica} {
+		if got := brands(t, s); len(got) != 0 {
+			t.Errorf("expected no Cars, got %v", got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+	"sigs.k8s.io/yaml"
+)
+
+// ObjectDiff describes how a transaction changes an Object.
+type ObjectDiff struct {
+	// Key is the key of the changed Object.
+	Key storage.ObjectKey
+	// Event is either ObjectEventCreate, ObjectEventModify or ObjectEventDelete.
+	Event update.ObjectEvent
+	// ChangedFields contains the sorted paths of the leaf fields that were added, changed
+	// or removed in a modified Object, e.g. "spec.brand" or "spec.tags[1]". It can be
+	// empty if only the formatting of the Object changed.
+	// +optional
+	ChangedFields []string
+}
+
+// DryRun runs fn like Transaction does, but instead of committing the changes, it returns what
+// Objects fn changed, and discards the changes. The returned CommitResult is validated.
+func (s *GitStorage) DryRun(ctx context.Context, fn TransactionFunc) (diff []ObjectDiff, retErr error) {
+	// Make sure we have the latest available state
+	if err := s.gitDir.Pull(ctx); err != nil {
+		return nil, err
+	}
+	// Make sure no other Git ops can take place during the dry run, wait for other ongoing operations.
+	s.gitDir.Suspend()
+	defer s.gitDir.Resume()
+	// Always discard the changes made to the worktree.
+	defer func() { retErr = combineErrors(retErr, s.resetWorktree("")) }()
+
+	result, err := fn(ctx, s.s)
+	if err != nil {
+		return nil, err
+	}
+	if err := result.Validate(); err != nil {
+		return nil, fmt.Errorf("transaction result is not valid: %w", err)
+	}
+
+	return s.worktreeDiff()
+}
+
+// worktreeDiff computes the changes of the Objects in the worktree compared to HEAD.
+// The GitDirectory must be suspended.
+func (s *GitStorage) worktreeDiff() ([]ObjectDiff, error) {
+	wt, err := s.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("git status failed: %v", err)
+	}
+	head, err := s.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := treeAt(s.repo, head.Hash().String())
+	if err != nil {
+		return nil, err
+	}
+
+	oldObjs := make(map[storage.ObjectKey]revisionObject)
+	newObjs := make(map[storage.ObjectKey]revisionObject)
+	for path, fileStatus := range status {
+		if fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified {
+			continue
+		}
+		if _, ok := storage.ContentTypes[filepath.Ext(path)]; !ok {
+			continue
+		}
+
+		// The file at HEAD doesn't exist for new files
+		if file, err := headTree.File(path); err == nil {
+			if err := decodeRevisionFile(s.s, file, oldObjs); err != nil {
+				return nil, err
+			}
+		}
+		// The file in the worktree doesn't exist for deleted files
+		content, err := ioutil.ReadFile(filepath.Join(s.gitDir.Dir(), path))
+		if err == nil {
+			decodeFrames(s.s, path, content, newObjs)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	changes := diffObjects(oldObjs, newObjs)
+	diff := make([]ObjectDiff, 0, len(changes))
+	for _, change := range changes {
+		objDiff := ObjectDiff{Key: change.key, Event: change.event}
+		if change.event == update.ObjectEventModify {
+			if objDiff.ChangedFields, err = changedFields(oldObjs[change.key].content, newObjs[change.key].content); err != nil {
+				return nil, fmt.Errorf("failed to diff %s: %w", change.key, err)
+			}
+		}
+		diff = append(diff, objDiff)
+	}
+	return diff, nil
+}
+
+// changedFields returns the sorted paths of the fields that differ between the given YAML or JSON documents
+func changedFields(oldContent, newContent []byte) ([]string, error) {
+	var oldObj, newObj interface{}
+	if err := yaml.Unmarshal(oldContent, &oldObj); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(newContent, &newObj); err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	diffFields("", oldObj, newObj, &paths)
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// diffFields appends the paths of the differing fields of a and b to paths. Maps are compared
+// per field, a missing or null map counts as an empty map, so that the leaf fields are reported.
+func diffFields(path string, a, b interface{}, paths *[]string) {
+	aMap, aIsMap := a.(map[string]interface{})
+	bMap, bIsMap := b.(map[string]interface{})
+	if (aIsMap || a == nil) && (bIsMap || b == nil) && (aIsMap || bIsMap) {
+		for key := range aMap {
+			diffFields(joinFieldPath(path, key), aMap[key], bMap[key], paths)
+		}
+		for key := range bMap {
+			if _, ok := aMap[key]; !ok {
+				diffFields(joinFieldPath(path, key), nil, bMap[key], paths)
+			}
+		}
+		return
+	}
+
+	aList, aIsList := a.([]interface{})
+	bList, bIsList := b.([]interface{})
+	if aIsList && bIsList && len(aList) == len(bList) {
+		for i := range aList {
+			diffFields(path+"["+strconv.Itoa(i)+"]", aList[i], bList[i], paths)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*paths = append(*paths, path)
+	}
+}
+
+func joinFieldPath(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,156 @@
+package transaction
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// objectChange describes how an Object changed between two revisions.
+type objectChange struct {
+	event update.ObjectEvent
+	key   storage.ObjectKey
+	// obj is the new state of the Object, or the old state for deletions
+	obj runtime.PartialObject
+}
+
+// revisionObject is an Object decoded from a file in a specific revision.
+type revisionObject struct {
+	obj     runtime.PartialObject
+	content []byte
+	loc     storage.FileLocation
+}
+
+// diffRevisions computes the per-Object changes between the trees of the two given commits.
+func diffRevisions(repo *git.Repository, s storage.Storage, oldSHA, newSHA string) ([]objectChange, error) {
+	oldTree, err := treeAt(repo, oldSHA)
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := treeAt(repo, newSHA)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := diffTrees(s, oldTree, newTree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s and %s: %w", oldSHA, newSHA, err)
+	}
+	return changes, nil
+}
+
+// diffTrees computes the per-Object changes between the given trees, a nil tree is empty.
+// Only the files that changed between the trees are decoded. An Object that moved to
+// another file or frame without changing its content doesn't count as changed.
+func diffTrees(s storage.Storage, oldTree, newTree *object.Tree) ([]objectChange, error) {
+	fileChanges, err := object.DiffTree(oldTree, newTree)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the Objects of the changed files, in both revisions
+	oldObjs := make(map[storage.ObjectKey]revisionObject)
+	newObjs := make(map[storage.ObjectKey]revisionObject)
+	for _, fileChange := range fileChanges {
+		from, to, err := fileChange.Files()
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeRevisionFile(s, from, oldObjs); err != nil {
+			return nil, err
+		}
+		if err := decodeRevisionFile(s, to, newObjs); err != nil {
+			return nil, err
+		}
+	}
+
+	return diffObjects(oldObjs, newObjs), nil
+}
+
+// diffObjects computes the changes between the given old and new Objects. The
+// content is compared without the surrounding whitespace of the frames.
+func diffObjects(oldObjs, newObjs map[storage.ObjectKey]revisionObject) []objectChange {
+	changes := make([]objectChange, 0, len(oldObjs)+len(newObjs))
+	for key, newObj := range newObjs {
+		oldObj, ok := oldObjs[key]
+		switch {
+		case !ok:
+			changes = append(changes, objectChange{update.ObjectEventCreate, key, newObj.obj})
+		case !bytes.Equal(bytes.TrimSpace(oldObj.content), bytes.TrimSpace(newObj.content)):
+			changes = append(changes, objectChange{update.ObjectEventModify, key, newObj.obj})
+		}
+	}
+	for key, oldObj := range oldObjs {
+		if _, ok := newObjs[key]; !ok {
+			changes = append(changes, objectChange{update.ObjectEventDelete, key, oldObj.obj})
+		}
+	}
+
+	// Make the order of the changes deterministic
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].key.String() < changes[j].key.String()
+	})
+	return changes
+}
+
+// treeAt returns the tree of the commit with the given SHA
+func treeAt(repo *git.Repository, sha string) (*object.Tree, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", sha, err)
+	}
+	return commit.Tree()
+}
+
+// decodeRevisionFile adds all Objects in the given file to objs. Files
+// with unknown extensions, or that can't be decoded, are skipped.
+func decodeRevisionFile(s storage.Storage, file *object.File, objs map[storage.ObjectKey]revisionObject) error {
+	if file == nil {
+		return nil
+	}
+	if _, ok := storage.ContentTypes[filepath.Ext(file.Name)]; !ok {
+		return nil
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", file.Name, err)
+	}
+
+	decodeFrames(s, file.Name, []byte(content), objs)
+	return nil
+}
+
+// decodeFrames adds all Objects in the given content of the file at path to objs. Like computeMappings
+// does, files that contain Objects of kinds unknown to the scheme of s are skipped.
+func decodeFrames(s storage.Storage, path string, content []byte, objs map[storage.ObjectKey]revisionObject) {
+	partObjs, err := storage.DecodePartialObjects(ioutil.NopCloser(bytes.NewReader(content)), s.Serializer().Scheme(), true, nil)
+	if err != nil {
+		logrus.Errorf("couldn't decode %q into partial objects: %v", path, err)
+		return
+	}
+	frames := storage.SplitFrames(content)
+	if len(frames) != len(partObjs) {
+		logrus.Errorf("couldn't split %q into frames: expected %d frames, got %d", path, len(partObjs), len(frames))
+		return
+	}
+
+	for i, partObj := range partObjs {
+		key, err := s.ObjectKeyFor(partObj)
+		if err != nil {
+			logrus.Errorf("couldn't get objectkey for partial object: %v", err)
+			continue
+		}
+		objs[key] = revisionObject{partObj, frames[i], storage.FileLocation{Path: path, Frame: i}}
+	}
+}
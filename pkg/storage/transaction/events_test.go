@@ -0,0 +1,138 @@
+package transaction
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+const (
+	fooCar     = "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: foo\n  namespace: default\n"
+	fooCarFast = fooCar + "status:\n  speed: 100\n"
+	barCar     = "apiVersion: sample-app.weave.works/v1alpha1\nkind: Car\nmetadata:\n  name: bar\n  namespace: default\n"
+	bazBike    = "apiVersion: sample-app.weave.works/v1alpha1\nkind: Motorcycle\nmetadata:\n  name: baz\n  namespace: default\n"
+	// quxDeployment is of a kind unknown to the scheme
+	quxDeployment = "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: qux\n  namespace: default\n"
+)
+
+// commitFiles writes and adds the given files to the worktree (removing files set to ""), and commits them
+func commitFiles(t *testing.T, repo *git.Repository, dir, msg string, files map[string]string) string {
+	t.Helper()
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if len(content) == 0 {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := wt.Commit(msg, &git.CommitOptions{
+		All:    true,
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash.String()
+}
+
+// newTestRepo initializes a new Git repository in a temporary directory, and a Storage for it
+func newTestRepo(t *testing.T) (string, *git.Repository, storage.Storage) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "libgitops-transaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := storage.NewGenericStorage(storage.NewGenericMappedRawStorage(dir), scheme.Serializer, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	return dir, repo, s
+}
+
+func TestDiffRevisions(t *testing.T) {
+	dir, repo, s := newTestRepo(t)
+	initial := commitFiles(t, repo, dir, "initial", map[string]string{
+		"cars.yaml": fooCar + "---\n" + barCar,
+		"README.md": "not an object",
+	})
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name:  "modify one frame",
+			files: map[string]string{"cars.yaml": fooCarFast + "---\n" + barCar},
+			want:  []string{"MODIFY default/foo"},
+		},
+		{
+			name:  "create in new file",
+			files: map[string]string{"bikes.yaml": bazBike, "README.md": "still not an object"},
+			want:  []string{"CREATE default/baz"},
+		},
+		{
+			name:  "move without change",
+			files: map[string]string{"cars.yaml": barCar, "bikes.yaml": bazBike + "---\n" + fooCarFast},
+			want:  []string{},
+		},
+		{
+			name:  "unknown kind",
+			files: map[string]string{"deployment.yaml": quxDeployment},
+			want:  []string{},
+		},
+		{
+			name:  "delete files",
+			files: map[string]string{"cars.yaml": "", "bikes.yaml": "", "deployment.yaml": ""},
+			want:  []string{"DELETE default/bar", "DELETE default/foo", "DELETE default/baz"},
+		},
+	}
+
+	prev := initial
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			commit := commitFiles(t, repo, dir, rt.name, rt.files)
+			changes, err := diffRevisions(repo, s, prev, commit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prev = commit
+
+			got := make([]string, 0, len(changes))
+			for _, change := range changes {
+				got = append(got, change.event.String()+" "+change.key.GetIdentifier())
+			}
+			if len(got) != len(rt.want) {
+				t.Fatalf("expected %v, got %v", rt.want, got)
+			}
+			for i := range got {
+				if got[i] != rt.want[i] {
+					t.Errorf("expected %v, got %v", rt.want, got)
+				}
+			}
+		})
+	}
+}
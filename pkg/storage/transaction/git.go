@@ -2,28 +2,58 @@ package transaction
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/gitdir"
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/serializer"
 	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 	"github.com/weaveworks/libgitops/pkg/util"
 	"github.com/weaveworks/libgitops/pkg/util/watcher"
 )
 
 var excludeDirs = []string{".git"}
 
+// identifiers are used to compute the ObjectKeys of the Objects in the Git repository
+var identifiers = []runtime.IdentifierFactory{runtime.Metav1NameIdentifier}
+
+// ErrNoTransaction is returned when a GitStorage is written to outside of a Transaction.
+var ErrNoTransaction = errors.New("writes to a GitStorage must be done in a transaction")
+
+// NewGitStorage creates a TransactionStorage backed by the given GitDirectory. The returned GitStorage
+// also implements update.EventStorage: for every new commit observed from the GitDirectory, an update
+// is sent for each Object that was created, modified or deleted since the previous commit.
 func NewGitStorage(gitDir gitdir.GitDirectory, prProvider PullRequestProvider, ser serializer.Serializer) (TransactionStorage, error) {
 	// Make sure the repo is cloned. If this func has already been called, it will be a no-op.
 	if err := gitDir.StartCheckoutLoop(); err != nil {
 		return nil, err
 	}
 
-	raw := storage.NewGenericMappedRawStorage(gitDir.Dir())
-	s := storage.NewGenericStorage(raw, ser, []runtime.IdentifierFactory{runtime.Metav1NameIdentifier})
+	repo, err := git.PlainOpen(gitDir.Dir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the git repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	// New Objects are stored in their own files, and empty directories are removed,
+	// as Git doesn't track them anyways. Checkouts rewrite files, so the Checksums are
+	// based on the content instead of the modification time.
+	raw := storage.NewGenericMappedRawStorageWithOptions(gitDir.Dir(), storage.MappedRawStorageOptions{
+		Placement:       storage.NewObjectPerFilePlacement(serializer.ContentTypeYAML),
+		RemoveEmptyDirs: true,
+		Checksum:        storage.NewGitBlobChecksum(),
+	})
+	s := storage.NewGenericStorage(raw, ser, identifiers)
 
 	gitStorage := &GitStorage{
 		ReadStorage: s,
@@ -31,16 +61,25 @@ func NewGitStorage(gitDir gitdir.GitDirectory, prProvider PullRequestProvider, s
 		raw:         raw,
 		gitDir:      gitDir,
 		prProvider:  prProvider,
+		repo:        repo,
+		broadcaster: update.NewBroadcaster(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+
+		pullRequests: make(map[string]*PullRequest),
+		prMux:        &sync.Mutex{},
 	}
 	// Do a first sync now, and then start the background loop
-	if err := gitStorage.sync(); err != nil {
+	if err := gitStorage.sync(head.Hash().String()); err != nil {
 		return nil, err
 	}
-	gitStorage.syncLoop()
+	go gitStorage.syncLoop()
 
 	return gitStorage, nil
 }
 
+// GitStorage is a TransactionStorage and update.EventStorage backed by a GitDirectory.
+// Outside of a Transaction it is read-only, the write methods return ErrNoTransaction.
 type GitStorage struct {
 	storage.ReadStorage
 
@@ -48,22 +87,98 @@ type GitStorage struct {
 	raw        storage.MappedRawStorage
 	gitDir     gitdir.GitDirectory
 	prProvider PullRequestProvider
+	// repo is only accessed while the GitDirectory is suspended, as go-git
+	// doesn't support concurrent reads and writes of the same repository
+	repo        *git.Repository
+	broadcaster *update.Broadcaster
+	// stop is closed on Close, to stop the sync loop. done is closed when it has exited.
+	stop chan struct{}
+	done chan struct{}
+	// lastCommit is the commit the mappings and sent events are based on.
+	// It is only accessed by sync, which isn't run concurrently.
+	lastCommit string
+	// pullRequests maps the stream names to the open Pull Requests created for them, guarded by prMux
+	pullRequests map[string]*PullRequest
+	prMux        *sync.Mutex
 }
 
+var _ update.EventStorage = &GitStorage{}
+
 func (s *GitStorage) syncLoop() {
-	go func() {
-		for {
-			if commit, ok := <-s.gitDir.CommitChannel(); ok {
-				logrus.Debugf("GitStorage: Got info about commit %q, syncing...", commit)
-				if err := s.sync(); err != nil {
-					logrus.Errorf("GitStorage: Got sync error: %v", err)
-				}
+	defer close(s.done)
+	for {
+		select {
+		case commit := <-s.gitDir.CommitChannel():
+			logrus.Debugf("GitStorage: Got info about commit %q, syncing...", commit)
+			if err := s.sync(commit); err != nil {
+				logrus.Errorf("GitStorage: Got sync error: %v", err)
 			}
+		case <-s.stop:
+			return
 		}
-	}()
+	}
+}
+
+func (s *GitStorage) sync(commit string) error {
+	if commit == s.lastCommit {
+		return nil
+	}
+
+	// Wait for the ongoing Git operations, e.g. a Transaction, so the worktree
+	// and repository aren't written to while they are read
+	s.gitDir.Suspend()
+	oldCommit, changes, err := s.diffCommit(commit)
+	s.gitDir.Resume()
+	if err != nil {
+		return err
+	}
+
+	// Send the events after the mappings are updated, so that subscribers can
+	// read the new state. The GitDirectory isn't suspended while sending, as
+	// subscribers may start Transactions when handling the events.
+	for _, change := range changes {
+		logrus.Tracef("GitStorage: Sending %s event for %s", change.event, change.key)
+		s.broadcaster.Broadcast(update.Update{
+			Event:         change.event,
+			PartialObject: change.obj,
+			ObjectKey:     change.key,
+			Storage:       s,
+			OldRevision:   oldCommit,
+			NewRevision:   commit,
+		})
+	}
+	return nil
 }
 
-func (s *GitStorage) sync() error {
+// diffCommit updates the mappings for the given new commit, and computes the changes since the
+// previous one. There are no changes for the initial commit, and for commits which aren't the head
+// of the main branch, e.g. the ones of a Transaction before it's merged. The GitDirectory must be suspended.
+func (s *GitStorage) diffCommit(commit string) (string, []objectChange, error) {
+	mainRef, err := s.repo.Reference(plumbing.NewBranchReferenceName(s.gitDir.MainBranch()), true)
+	if err != nil {
+		return "", nil, err
+	}
+	if mainRef.Hash().String() != commit {
+		logrus.Debugf("GitStorage: Skipping commit %q, which isn't the head of the main branch", commit)
+		return "", nil, nil
+	}
+
+	if err := s.updateMappings(); err != nil {
+		return "", nil, err
+	}
+
+	oldCommit := s.lastCommit
+	s.lastCommit = commit
+	if len(oldCommit) == 0 {
+		return oldCommit, nil, nil
+	}
+
+	changes, err := diffRevisions(s.repo, s.s, oldCommit, commit)
+	return oldCommit, changes, err
+}
+
+// updateMappings recomputes the mappings from the files in the worktree
+func (s *GitStorage) updateMappings() error {
 	mappings, err := computeMappings(s.gitDir.Dir(), s.s)
 	if err != nil {
 		return err
@@ -73,63 +188,161 @@ func (s *GitStorage) sync() error {
 	return nil
 }
 
-func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn TransactionFunc) error {
+// Subscribe implements update.EventStorage. The updates are sent per new commit, subscribers
+// should List the current state first, as no events are sent for the initial commit.
+func (s *GitStorage) Subscribe(opts update.SubscribeOptions) update.Subscription {
+	return s.broadcaster.Subscribe(opts)
+}
+
+func (s *GitStorage) Create(ctx context.Context, obj runtime.Object) error {
+	return ErrNoTransaction
+}
+
+func (s *GitStorage) Update(ctx context.Context, obj runtime.Object) error {
+	return ErrNoTransaction
+}
+
+func (s *GitStorage) Patch(ctx context.Context, key storage.ObjectKey, patch []byte) error {
+	return ErrNoTransaction
+}
+
+func (s *GitStorage) Delete(ctx context.Context, key storage.ObjectKey) error {
+	return ErrNoTransaction
+}
+
+// Close stops syncing new commits, closes the UpdateStreams of all subscribers, and the underlying Storage.
+// It waits for an ongoing sync to finish, so the GitDirectory can be cleaned up afterwards.
+func (s *GitStorage) Close() error {
+	close(s.stop)
+	// Closing the subscriptions first unblocks the sync loop if it's sending to a full one
+	s.broadcaster.Close()
+	<-s.done
+	return s.ReadStorage.Close()
+}
+
+func (s *GitStorage) Transaction(ctx context.Context, streamName string, fn TransactionFunc) (_ *PullRequest, retErr error) {
 	// Append random bytes to the end of the stream name if it ends with a dash
 	if strings.HasSuffix(streamName, "-") {
 		suffix, err := util.RandomSHA(4)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		streamName += suffix
 	}
 
 	// Make sure we have the latest available state
 	if err := s.gitDir.Pull(ctx); err != nil {
-		return err
+		return nil, err
 	}
 	// Make sure no other Git ops can take place during the transaction, wait for other ongoing operations.
 	s.gitDir.Suspend()
 	defer s.gitDir.Resume()
-	// Always switch back to the main branch afterwards.
-	// TODO ordering of the defers, and return deferred error
-	defer func() { _ = s.gitDir.CheckoutMainBranch() }()
 
-	// Check out a new branch with the given name
-	if err := s.gitDir.CheckoutNewBranch(streamName); err != nil {
-		return err
+	// Continue on the branch of an earlier transaction on the same stream, or check out a new one
+	existing, err := s.branchExists(streamName)
+	if err != nil {
+		return nil, err
+	}
+	if existing {
+		err = s.gitDir.CheckoutBranch(streamName)
+	} else {
+		err = s.gitDir.CheckoutNewBranch(streamName)
 	}
+	if err != nil {
+		return nil, err
+	}
+	// Always switch back to the main branch afterwards, before resuming other Git operations. If
+	// the changes haven't been committed, roll back by deleting the new branch too. Errors while
+	// doing that are returned combined with the error of the transaction.
+	committed := false
+	defer func() {
+		branch := streamName
+		if committed || existing {
+			branch = ""
+		}
+		retErr = combineErrors(retErr, s.resetWorktree(branch))
+	}()
 	// Invoke the transaction
 	result, err := fn(ctx, s.s)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Make sure the result is valid
 	if err := result.Validate(); err != nil {
-		return fmt.Errorf("transaction result is not valid: %w", err)
+		return nil, fmt.Errorf("transaction result is not valid: %w", err)
 	}
 	// Perform the commit
 	if err := s.gitDir.Commit(ctx, result.GetAuthorName(), result.GetAuthorEmail(), result.GetMessage()); err != nil {
-		return err
+		return nil, err
 	}
+	committed = true
 	// Return if no PR should be made
 	prResult, ok := result.(PullRequestResult)
 	if !ok {
-		return nil
+		return nil, nil
 	}
 	// If a PR was asked for, and no provider was given, error out
 	if s.prProvider == nil {
-		return ErrNoPullRequestProvider
+		return nil, ErrNoPullRequestProvider
 	}
-	// Create the PR using the provider.
-	return s.prProvider.CreatePullRequest(ctx, &GenericPullRequestSpec{
+	spec := &GenericPullRequestSpec{
 		PullRequestResult: prResult,
 		MainBranch:        s.gitDir.MainBranch(),
 		MergeBranch:       streamName,
 		RepositoryRef:     s.gitDir.RepositoryRef(),
-	})
+	}
+	// If a PR is already open for this stream, the new commit was pushed to it. Update its metadata.
+	if pr := s.pullRequestFor(streamName); pr != nil {
+		return pr, s.prProvider.UpdatePullRequest(ctx, pr, spec)
+	}
+	// Otherwise, create the PR using the provider.
+	pr, err := s.prProvider.CreatePullRequest(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	s.setPullRequestFor(streamName, pr)
+	return pr, nil
 }
 
-func computeMappings(dir string, s storage.Storage) (map[storage.ObjectKey]string, error) {
+// branchExists returns whether the given local branch exists. The GitDirectory must be suspended.
+func (s *GitStorage) branchExists(branchName string) (bool, error) {
+	_, err := s.repo.Reference(plumbing.NewBranchReferenceName(branchName), false)
+	switch err {
+	case nil:
+		return true, nil
+	case plumbing.ErrReferenceNotFound:
+		return false, nil
+	}
+	return false, err
+}
+
+// resetWorktree checks out the main branch, which discards all uncommitted changes, and deletes
+// the given branch, if set. The mappings are recomputed, as they could have been changed.
+func (s *GitStorage) resetWorktree(deleteBranch string) error {
+	if err := s.gitDir.CheckoutMainBranch(); err != nil {
+		return fmt.Errorf("failed to check out the main branch: %w", err)
+	}
+	if len(deleteBranch) != 0 {
+		if err := s.gitDir.DeleteBranch(deleteBranch); err != nil {
+			return fmt.Errorf("failed to delete branch %q: %w", deleteBranch, err)
+		}
+	}
+	return s.updateMappings()
+}
+
+// combineErrors returns err annotated with cleanupErr, or whichever is set.
+// The returned error wraps err, so e.g. ErrAbortTransaction can still be checked for.
+func combineErrors(err, cleanupErr error) error {
+	switch {
+	case cleanupErr == nil:
+		return err
+	case err == nil:
+		return cleanupErr
+	}
+	return fmt.Errorf("%w (cleanup also failed: %v)", err, cleanupErr)
+}
+
+func computeMappings(dir string, s storage.Storage) (map[storage.ObjectKey]storage.FileLocation, error) {
 	validExts := make([]string, 0, len(storage.ContentTypes))
 	for ext := range storage.ContentTypes {
 		validExts = append(validExts, ext)
@@ -140,22 +353,23 @@ func computeMappings(dir string, s storage.Storage) (map[storage.ObjectKey]strin
 		return nil, err
 	}
 
-	// TODO: Compute the difference between the earlier state, and implement EventStorage so the user
-	// can automatically subscribe to changes of objects between versions.
-	m := map[storage.ObjectKey]string{}
+	m := map[storage.ObjectKey]storage.FileLocation{}
 	for _, file := range files {
-		partObjs, err := storage.DecodePartialObjects(serializer.FromFile(file), s.Serializer().Scheme(), false, nil)
+		partObjs, err := storage.DecodePartialObjects(serializer.FromFile(file), s.Serializer().Scheme(), true, nil)
 		if err != nil {
-			logrus.Errorf("couldn't decode %q into a partial object: %v", file, err)
+			logrus.Errorf("couldn't decode %q into partial objects: %v", file, err)
 			continue
 		}
-		key, err := s.ObjectKeyFor(partObjs[0])
-		if err != nil {
-			logrus.Errorf("couldn't get objectkey for partial object: %v", err)
-			continue
+		// Map every frame of the file to the object it contains
+		for i, partObj := range partObjs {
+			key, err := s.ObjectKeyFor(partObj)
+			if err != nil {
+				logrus.Errorf("couldn't get objectkey for partial object: %v", err)
+				continue
+			}
+			logrus.Debugf("Adding mapping between %s and %q (frame %d)", key, file, i)
+			m[key] = storage.FileLocation{Path: file, Frame: i}
 		}
-		logrus.Debugf("Adding mapping between %s and %q", key, file)
-		m[key] = file
 	}
 	return m, nil
 }
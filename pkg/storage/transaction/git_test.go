@@ -0,0 +1,342 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/gitdir"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// newLocalGitStorage creates a GitStorage for a new local repository
+func newLocalGitStorage(t *testing.T) (*GitStorage, gitdir.GitDirectory) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "libgitops-transaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	gitDir, err := gitdir.NewLocalGitDirectory(dir, gitdir.LocalGitDirectoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = gitDir.Cleanup() })
+
+	s, err := NewGitStorage(gitDir, nil, scheme.Serializer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s.(*GitStorage), gitDir
+}
+
+// branchRef returns the reference of the given local branch. The GitDirectory is suspended
+// while reading, as the GitStorage may be syncing in the background.
+func branchRef(s *GitStorage, branch string) (*plumbing.Reference, error) {
+	s.gitDir.Suspend()
+	defer s.gitDir.Resume()
+	return s.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+}
+
+func nextUpdate(t *testing.T, sub update.Subscription) update.Update {
+	t.Helper()
+	select {
+	case upd := <-sub.Updates():
+		return upd
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an update")
+		return update.Update{}
+	}
+}
+
+func TestGitStorage_LocalGitDirectory(t *testing.T) {
+	ctx := context.Background()
+	s, gitDir := newLocalGitStorage(t)
+	sub := s.Subscribe(update.SubscribeOptions{})
+
+	// Commit a new Car to the main branch, the GitStorage syncs and sends an event
+	if err := ioutil.WriteFile(filepath.Join(gitDir.Dir(), "cars.yaml"), []byte(fooCar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitDir.Suspend()
+	err := gitDir.Commit(ctx, "test", "test@example.com", "Add foo")
+	gitDir.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upd := nextUpdate(t, sub)
+	if upd.Event != update.ObjectEventCreate || upd.ObjectKey.GetIdentifier() != "default/foo" {
+		t.Fatalf("unexpected %s update for %v", upd.Event, upd.ObjectKey)
+	}
+	if len(upd.OldRevision) == 0 || len(upd.NewRevision) == 0 || upd.OldRevision == upd.NewRevision {
+		t.Errorf("unexpected revisions %q -> %q", upd.OldRevision, upd.NewRevision)
+	}
+	if _, err := s.Get(ctx, fooKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes outside of a transaction are refused
+	if err := s.Delete(ctx, fooKey); err != ErrNoTransaction {
+		t.Errorf("expected ErrNoTransaction, got %v", err)
+	}
+
+	// A transaction commits to its own branch, and goes back to the main branch afterwards
+	_, err = s.Transaction(ctx, "speed-up-foo", func(ctx context.Context, ts storage.Storage) (CommitResult, error) {
+		obj, err := ts.Get(ctx, fooKey)
+		if err != nil {
+			return nil, err
+		}
+		obj.(*v1alpha1.Car).Status.Speed = 100
+		if err := ts.Update(ctx, obj); err != nil {
+			return nil, err
+		}
+		return &GenericCommitResult{AuthorName: "test", AuthorEmail: "test@example.com", Title: "Speed up foo"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	branch, err := branchRef(s, "speed-up-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := s.AtRevision(ctx, branch.Hash().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := rs.Get(ctx, fooKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if speed := obj.(*v1alpha1.Car).Status.Speed; speed != 100 {
+		t.Errorf("expected the branch to have speed 100, got %v", speed)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(gitDir.Dir(), "cars.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != fooCar {
+		t.Errorf("expected the main branch to be checked out, got %q", content)
+	}
+}
+
+// commitCar commits fooCar to the main branch, and waits for the GitStorage to sync
+func commitCar(t *testing.T, s *GitStorage, gitDir gitdir.GitDirectory) {
+	t.Helper()
+	sub := s.Subscribe(update.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	if err := ioutil.WriteFile(filepath.Join(gitDir.Dir(), "cars.yaml"), []byte(fooCar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitDir.Suspend()
+	err := gitDir.Commit(context.Background(), "test", "test@example.com", "Add foo")
+	gitDir.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextUpdate(t, sub)
+}
+
+// speedUpFoo is a TransactionFunc that sets the speed of the Car foo, and then returns err
+func speedUpFoo(err error) TransactionFunc {
+	return func(ctx context.Context, ts storage.Storage) (CommitResult, error) {
+		obj, getErr := ts.Get(ctx, fooKey)
+		if getErr != nil {
+			return nil, getErr
+		}
+		obj.(*v1alpha1.Car).Status.Speed = 100
+		if updateErr := ts.Update(ctx, obj); updateErr != nil {
+			return nil, updateErr
+		}
+		return &GenericCommitResult{AuthorName: "test", AuthorEmail: "test@example.com", Title: "Speed up foo"}, err
+	}
+}
+
+func TestGitStorage_TransactionRollback(t *testing.T) {
+	ctx := context.Background()
+	s, gitDir := newLocalGitStorage(t)
+	commitCar(t, s, gitDir)
+
+	_, err := s.Transaction(ctx, "speed-up-foo", speedUpFoo(ErrAbortTransaction))
+	if !errors.Is(err, ErrAbortTransaction) {
+		t.Fatalf("expected ErrAbortTransaction, got %v", err)
+	}
+
+	// The branch is deleted, and the worktree reset
+	if _, err := branchRef(s, "speed-up-foo"); err != plumbing.ErrReferenceNotFound {
+		t.Errorf("expected the branch to be deleted, got %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(gitDir.Dir(), "cars.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != fooCar {
+		t.Errorf("expected the worktree to be reset, got %q", content)
+	}
+
+	// The same transaction can be run again
+	if _, err := s.Transaction(ctx, "speed-up-foo", speedUpFoo(nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitStorage_DryRun(t *testing.T) {
+	ctx := context.Background()
+	s, gitDir := newLocalGitStorage(t)
+	commitCar(t, s, gitDir)
+
+	diff, err := s.DryRun(ctx, speedUpFoo(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff[0].Event != update.ObjectEventModify || diff[0].Key.GetIdentifier() != "default/foo" {
+		t.Fatalf("unexpected diff %v", diff)
+	}
+	found := false
+	for _, field := range diff[0].ChangedFields {
+		found = found || field == "status.speed"
+	}
+	if !found {
+		t.Errorf("expected status.speed to be changed, got %v", diff[0].ChangedFields)
+	}
+
+	// Everything is discarded
+	content, err := ioutil.ReadFile(filepath.Join(gitDir.Dir(), "cars.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != fooCar {
+		t.Errorf("expected the worktree to be reset, got %q", content)
+	}
+
+	// Failures are returned, and rolled back as well
+	if _, err := s.DryRun(ctx, speedUpFoo(ErrAbortTransaction)); !errors.Is(err, ErrAbortTransaction) {
+		t.Errorf("expected ErrAbortTransaction, got %v", err)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []string
+	}{
+		{"equal", "a: 1\nb: [1, 2]\n", "b: [1, 2]\na: 1\n", []string{}},
+		{"nested", "spec:\n  brand: Volvo\n  seats: 4\n", "spec:\n  brand: Tesla\n  seats: 4\n", []string{"spec.brand"}},
+		{"added and removed", "a: 1\nb: 2\n", "a: 1\nc: 3\n", []string{"b", "c"}},
+		{"list element", "tags: [a, b]\n", "tags: [a, c]\n", []string{"tags[1]"}},
+		{"list length", "tags: [a, b]\n", "tags: [a]\n", []string{"tags"}},
+		{"removed map", "spec:\n  brand: Volvo\n", "spec: null\n", []string{"spec.brand"}},
+		{"type change", "spec:\n  brand: Volvo\n", "spec: Volvo\n", []string{"spec"}},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			got, err := changedFields([]byte(rt.old), []byte(rt.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+}
+
+func TestGitStorage_TransactionCreate(t *testing.T) {
+	ctx := context.Background()
+	s, gitDir := newLocalGitStorage(t)
+	commitCar(t, s, gitDir)
+
+	bazKey := storage.NewObjectKey(storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car")), runtime.NewIdentifier("default/baz"))
+	_, err := s.Transaction(ctx, "add-baz", func(ctx context.Context, ts storage.Storage) (CommitResult, error) {
+		car := &v1alpha1.Car{}
+		car.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("Car"))
+		car.Name = "baz"
+		car.Namespace = "default"
+		if err := ts.Create(ctx, car); err != nil {
+			return nil, err
+		}
+		return &GenericCommitResult{AuthorName: "test", AuthorEmail: "test@example.com", Title: "Add baz"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new Car is committed in its own file on the branch
+	rs, err := s.AtRevision(ctx, "add-baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Get(ctx, bazKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Get(ctx, fooKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir.Dir(), "Car", "default", "baz.yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected the main branch to be checked out without baz, got %v", err)
+	}
+}
+
+func TestGitStorage_TransactionUpdatesAfterMerge(t *testing.T) {
+	ctx := context.Background()
+	s, gitDir := newLocalGitStorage(t)
+	commitCar(t, s, gitDir)
+	sub := s.Subscribe(update.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	// The commit of the transaction is on its own branch, which isn't synced
+	if _, err := s.Transaction(ctx, "speed-up-foo", speedUpFoo(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitDir.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case upd := <-sub.Updates():
+		t.Fatalf("unexpected %s update for %v before the merge", upd.Event, upd.ObjectKey)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Merging the branch into the main branch sends the update
+	mainHash := branchHash(t, s, gitDir.MainBranch())
+	branch := branchHash(t, s, "speed-up-foo")
+	gitDir.Suspend()
+	err := s.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(gitDir.MainBranch()), branch))
+	if err == nil {
+		var wt *git.Worktree
+		if wt, err = s.repo.Worktree(); err == nil {
+			err = wt.Reset(&git.ResetOptions{Commit: branch, Mode: git.HardReset})
+		}
+	}
+	gitDir.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gitDir.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	upd := nextUpdate(t, sub)
+	if upd.Event != update.ObjectEventModify || upd.ObjectKey.GetIdentifier() != "default/foo" {
+		t.Fatalf("unexpected %s update for %v", upd.Event, upd.ObjectKey)
+	}
+	if upd.OldRevision != mainHash.String() || upd.NewRevision != branch.String() {
+		t.Errorf("expected the revisions %s -> %s, got %s -> %s", mainHash, branch, upd.OldRevision, upd.NewRevision)
+	}
+}
@@ -0,0 +1,89 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultMergePollInterval is used by WaitForMerge if no interval is given
+const defaultMergePollInterval = 30 * time.Second
+
+// WaitForMerge polls the status of pr every interval until it has been merged or closed. After
+// it has been merged, the GitDirectory is pulled, so the merged changes are synced right away.
+// In both cases the local merge branch is deleted, and later transactions on the same stream
+// start over from the main branch. ErrPullRequestClosed is returned if pr was closed without
+// being merged. The wait can be cancelled using ctx.
+func (s *GitStorage) WaitForMerge(ctx context.Context, pr *PullRequest, interval time.Duration) (*PullRequestStatus, error) {
+	if s.prProvider == nil {
+		return nil, ErrNoPullRequestProvider
+	}
+	if interval <= 0 {
+		interval = defaultMergePollInterval
+	}
+
+	status, err := s.pollUntilDone(ctx, pr, interval)
+	if err != nil {
+		return nil, err
+	}
+	if status.State == PullRequestStateMerged {
+		if err := s.gitDir.Pull(ctx); err != nil {
+			return nil, fmt.Errorf("failed to pull the merged changes: %w", err)
+		}
+	}
+
+	// Forget about the branch of the PR, no more commits can be pushed to it
+	s.deletePullRequestFor(pr.MergeBranch)
+	s.gitDir.Suspend()
+	err = s.gitDir.DeleteBranch(pr.MergeBranch)
+	s.gitDir.Resume()
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete branch %q: %w", pr.MergeBranch, err)
+	}
+
+	if status.State != PullRequestStateMerged {
+		return status, ErrPullRequestClosed
+	}
+	return status, nil
+}
+
+// pollUntilDone returns the status of pr once it isn't open anymore
+func (s *GitStorage) pollUntilDone(ctx context.Context, pr *PullRequest, interval time.Duration) (*PullRequestStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status, err := s.prProvider.GetPullRequestStatus(ctx, pr)
+		if err != nil {
+			return nil, err
+		}
+		if status.State != PullRequestStateOpen {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// pullRequestFor returns the open PR created for the given stream, if any
+func (s *GitStorage) pullRequestFor(streamName string) *PullRequest {
+	s.prMux.Lock()
+	defer s.prMux.Unlock()
+	return s.pullRequests[streamName]
+}
+
+func (s *GitStorage) setPullRequestFor(streamName string, pr *PullRequest) {
+	s.prMux.Lock()
+	defer s.prMux.Unlock()
+	s.pullRequests[streamName] = pr
+}
+
+func (s *GitStorage) deletePullRequestFor(streamName string) {
+	s.prMux.Lock()
+	defer s.prMux.Unlock()
+	delete(s.pullRequests, streamName)
+}
@@ -0,0 +1,169 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+// fakePRProvider records the created and updated PRs, and reports them as open until
+// polled openPolls times, and then with finalState
+type fakePRProvider struct {
+	mux        sync.Mutex
+	created    []PullRequestSpec
+	updated    []PullRequestSpec
+	openPolls  int
+	finalState PullRequestState
+}
+
+func (p *fakePRProvider) CreatePullRequest(ctx context.Context, spec PullRequestSpec) (*PullRequest, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.created = append(p.created, spec)
+	return &PullRequest{Number: len(p.created), URL: "https://example.com/pulls/1", MergeBranch: spec.GetMergeBranch()}, nil
+}
+
+func (p *fakePRProvider) UpdatePullRequest(ctx context.Context, pr *PullRequest, spec PullRequestSpec) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.updated = append(p.updated, spec)
+	return nil
+}
+
+func (p *fakePRProvider) GetPullRequestStatus(ctx context.Context, pr *PullRequest) (*PullRequestStatus, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.openPolls > 0 {
+		p.openPolls--
+		return &PullRequestStatus{State: PullRequestStateOpen, ReviewState: ReviewStateNone}, nil
+	}
+	return &PullRequestStatus{State: p.finalState, ReviewState: ReviewStateApproved}, nil
+}
+
+// setSpeed is a TransactionFunc that sets the speed of the Car foo, and asks for a PR with the given title
+func setSpeed(speed float64, title string) TransactionFunc {
+	return func(ctx context.Context, ts storage.Storage) (CommitResult, error) {
+		obj, err := ts.Get(ctx, fooKey)
+		if err != nil {
+			return nil, err
+		}
+		obj.(*v1alpha1.Car).Status.Speed = speed
+		if err := ts.Update(ctx, obj); err != nil {
+			return nil, err
+		}
+		return &GenericPullRequestResult{
+			CommitResult: &GenericCommitResult{AuthorName: "test", AuthorEmail: "test@example.com", Title: title},
+			Labels:       []string{"kind/bug"},
+		}, nil
+	}
+}
+
+func TestGitStorage_PullRequestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	s, gitDir := newLocalGitStorage(t)
+	provider := &fakePRProvider{openPolls: 2, finalState: PullRequestStateMerged}
+	s.prProvider = provider
+	commitCar(t, s, gitDir)
+
+	// The first transaction creates the PR
+	pr, err := s.Transaction(ctx, "speed-up-foo", setSpeed(100, "Speed up foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr == nil || pr.Number != 1 || pr.MergeBranch != "speed-up-foo" || len(provider.created) != 1 {
+		t.Fatalf("unexpected PR %+v, created %d", pr, len(provider.created))
+	}
+
+	// The second one on the same stream adds a commit to the branch, and updates the PR
+	updated, err := s.Transaction(ctx, "speed-up-foo", setSpeed(200, "Speed up foo even more"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != pr || len(provider.created) != 1 || len(provider.updated) != 1 || provider.updated[0].GetTitle() != "Speed up foo even more" {
+		t.Fatalf("expected the PR to be updated, got %+v, created %d, updated %d", updated, len(provider.created), len(provider.updated))
+	}
+	rs, err := s.AtRevision(ctx, "speed-up-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := rs.Get(ctx, fooKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if speed := obj.(*v1alpha1.Car).Status.Speed; speed != 200 {
+		t.Errorf("expected the branch to have speed 200, got %v", speed)
+	}
+	from := branchHash(t, s, "speed-up-foo")
+	gitDir.Suspend()
+	count := 0
+	commits, err := s.repo.Log(&git.LogOptions{From: from})
+	if err == nil {
+		_ = commits.ForEach(func(*object.Commit) error { count++; return nil })
+	}
+	gitDir.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("expected the initial, foo and two transaction commits, got %d", count)
+	}
+
+	// Once merged, the branch is deleted, and the next transaction creates a new PR
+	status, err := s.WaitForMerge(ctx, pr, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != PullRequestStateMerged || status.ReviewState != ReviewStateApproved {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if _, err := branchRef(s, "speed-up-foo"); err != plumbing.ErrReferenceNotFound {
+		t.Errorf("expected the branch to be deleted, got %v", err)
+	}
+	if _, err := s.Transaction(ctx, "speed-up-foo", setSpeed(300, "Speed up foo again")); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.created) != 2 {
+		t.Errorf("expected a new PR to be created, got %d", len(provider.created))
+	}
+}
+
+func TestGitStorage_WaitForMerge(t *testing.T) {
+	ctx := context.Background()
+	s, gitDir := newLocalGitStorage(t)
+	provider := &fakePRProvider{finalState: PullRequestStateClosed}
+	s.prProvider = provider
+	commitCar(t, s, gitDir)
+
+	pr, err := s.Transaction(ctx, "speed-up-foo", setSpeed(100, "Speed up foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitForMerge(ctx, pr, time.Millisecond); !errors.Is(err, ErrPullRequestClosed) {
+		t.Errorf("expected ErrPullRequestClosed, got %v", err)
+	}
+
+	// Waiting can be cancelled
+	provider.finalState = PullRequestStateOpen
+	cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.WaitForMerge(cancelCtx, pr, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func branchHash(t *testing.T, s *GitStorage, branch string) plumbing.Hash {
+	t.Helper()
+	ref, err := branchRef(s, branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.Hash()
+}
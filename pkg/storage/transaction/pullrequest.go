@@ -121,10 +121,63 @@ func (r *GenericPullRequestSpec) Validate() error {
 	return v.Error()
 }
 
+// PullRequest is a handle to a Pull Request created by a PullRequestProvider.
+type PullRequest struct {
+	// Number is the provider-specific number of the Pull Request, e.g. 42 for GitHub PR #42, or
+	// for GitLab the project-scoped IID of the Merge Request.
+	Number int
+	// URL is the web URL of the Pull Request, which can be shown to users.
+	URL string
+	// MergeBranch is the branch that is pending to be merged into main with this PR.
+	MergeBranch string
+	// RepositoryRef is the repository the Pull Request was created in.
+	RepositoryRef gitprovider.RepositoryRef
+}
+
+// PullRequestState describes whether a Pull Request is open, merged or closed.
+type PullRequestState string
+
+const (
+	// PullRequestStateOpen means the Pull Request is waiting to be merged.
+	PullRequestStateOpen = PullRequestState("open")
+	// PullRequestStateMerged means the Pull Request has been merged into the main branch.
+	PullRequestStateMerged = PullRequestState("merged")
+	// PullRequestStateClosed means the Pull Request was closed without being merged.
+	PullRequestStateClosed = PullRequestState("closed")
+)
+
+// ReviewState summarizes the reviews of a Pull Request.
+type ReviewState string
+
+const (
+	// ReviewStateNone means nobody has approved or requested changes yet.
+	ReviewStateNone = ReviewState("none")
+	// ReviewStateApproved means at least one reviewer approved, and nobody requested changes.
+	ReviewStateApproved = ReviewState("approved")
+	// ReviewStateChangesRequested means at least one reviewer requested changes.
+	ReviewStateChangesRequested = ReviewState("changes_requested")
+)
+
+// PullRequestStatus describes the current status of a Pull Request.
+type PullRequestStatus struct {
+	// State is either PullRequestStateOpen, PullRequestStateMerged or PullRequestStateClosed.
+	State PullRequestState
+	// ReviewState summarizes the latest review of every reviewer.
+	ReviewState ReviewState
+	// MergeCommit is the SHA of the merge commit, if the Pull Request has been merged.
+	// +optional
+	MergeCommit string
+}
+
 // PullRequestProvider is an interface for providers that can create so-called "Pull Requests",
 // as popularized by Git. A Pull Request is a formal ask for a branch to be merged into the main one.
 // It can be UI-based, as in GitHub and GitLab, or it can be using some other method.
 type PullRequestProvider interface {
-	// CreatePullRequest creates a Pull Request using the given specification.
-	CreatePullRequest(ctx context.Context, spec PullRequestSpec) error
+	// CreatePullRequest creates a Pull Request using the given specification, and returns a handle to it.
+	CreatePullRequest(ctx context.Context, spec PullRequestSpec) (*PullRequest, error)
+	// UpdatePullRequest sets the title, description, labels, assignees and milestone of the given
+	// Pull Request to the ones in spec. New commits are added by pushing to its merge branch.
+	UpdatePullRequest(ctx context.Context, pr *PullRequest, spec PullRequestSpec) error
+	// GetPullRequestStatus returns the current status of the given Pull Request.
+	GetPullRequestStatus(ctx context.Context, pr *PullRequest) (*PullRequestStatus, error)
 }
@@ -0,0 +1,89 @@
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fluxcd/go-git-providers/github"
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+	giteapr "github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/gitea"
+	githubpr "github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/github"
+	gitlabpr "github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/gitlab"
+)
+
+const (
+	// gitLabProviderID is the provider ID of go-git-providers' GitLab client. It's declared here, as
+	// importing the gitlab package would pull in the GitLab API client.
+	gitLabProviderID = gitprovider.ProviderID("gitlab")
+	// gitLabDomain is the domain of GitLab.com, whose API is at gitlabpr.DefaultBaseURL
+	gitLabDomain = "gitlab.com"
+	// GiteaProviderID is the provider ID gitprovider.Clients for Gitea are expected to return. The
+	// go-git-providers version in use doesn't contain a Gitea client, hence it's declared here.
+	GiteaProviderID = gitprovider.ProviderID("gitea")
+)
+
+var ErrProviderNotSupported = errors.New("only the GitHub, GitLab and Gitea go-git-providers providers are supported at the moment")
+
+// DispatchPRProviderOptions configures the PullRequestProviders NewDispatchPRProvider dispatches to.
+type DispatchPRProviderOptions struct {
+	// GitLabBaseURL is the URL of the GitLab API, e.g. "https://gitlab.example.com/api/v4". It can't be
+	// derived from the gitprovider.Client, hence it's required for GitLab instances other than GitLab.com.
+	// Default: gitlabpr.DefaultBaseURL, if the gitprovider.Client talks to GitLab.com
+	// +optional
+	GitLabBaseURL string
+	// GitLabToken is the access token used for creating GitLab Merge Requests, as the
+	// gitprovider.Client can't be used for that. It's required for GitLab.
+	// +optional
+	GitLabToken string
+	// GiteaBaseURL is the URL of the Gitea server, e.g. "https://gitea.example.com".
+	// Default: "https://" + the domain of the gitprovider.Client
+	// +optional
+	GiteaBaseURL string
+	// GiteaToken is the access token used for creating Gitea Pull Requests, as the
+	// gitprovider.Client can't be used for that. It's required for Gitea.
+	// +optional
+	GiteaToken string
+	// HTTPClient is used for talking to the GitLab and Gitea APIs.
+	// Default: http.DefaultClient
+	// +optional
+	HTTPClient *http.Client
+}
+
+// NewDispatchPRProvider returns the transaction.PullRequestProvider for the Git provider the given
+// gitprovider.Client talks to, based on c.ProviderID(). GitHub, GitLab and Gitea (GiteaProviderID)
+// are supported, for other providers ErrProviderNotSupported is returned.
+//
+// TODO: The go-git-providers version in use doesn't have a PullRequests API yet. Once it does,
+// a provider-neutral PullRequestProvider only depending on the gitprovider.Client can replace this.
+func NewDispatchPRProvider(c gitprovider.Client, opts DispatchPRProviderOptions) (transaction.PullRequestProvider, error) {
+	switch c.ProviderID() {
+	case github.ProviderID:
+		return githubpr.NewGitHubPRProvider(c)
+	case gitLabProviderID:
+		baseURL := opts.GitLabBaseURL
+		if len(baseURL) == 0 {
+			if c.SupportedDomain() != gitLabDomain {
+				return nil, fmt.Errorf("the GitLab API base URL is required for %q", c.SupportedDomain())
+			}
+			baseURL = gitlabpr.DefaultBaseURL
+		}
+		return gitlabpr.NewGitLabPRProvider(gitlabpr.GitLabPRProviderOptions{
+			BaseURL:    baseURL,
+			Token:      opts.GitLabToken,
+			HTTPClient: opts.HTTPClient,
+		})
+	case GiteaProviderID:
+		baseURL := opts.GiteaBaseURL
+		if len(baseURL) == 0 {
+			baseURL = "https://" + c.SupportedDomain()
+		}
+		return giteapr.NewGiteaPRProvider(giteapr.GiteaPRProviderOptions{
+			BaseURL:    baseURL,
+			Token:      opts.GiteaToken,
+			HTTPClient: opts.HTTPClient,
+		})
+	}
+	return nil, ErrProviderNotSupported
+}
@@ -0,0 +1,126 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/fluxcd/go-git-providers/github"
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	gogithub "github.com/google/go-github/v32/github"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+// fakeClient is a gitprovider.Client for a given provider, only the methods used for creating PRs are implemented
+type fakeClient struct {
+	gitprovider.Client
+	providerID gitprovider.ProviderID
+	domain     string
+	raw        interface{}
+}
+
+func (c *fakeClient) ProviderID() gitprovider.ProviderID { return c.providerID }
+func (c *fakeClient) SupportedDomain() string            { return c.domain }
+func (c *fakeClient) Raw() interface{}                   { return c.raw }
+
+func TestNewDispatchPRProvider(t *testing.T) {
+	// Record what paths were requested
+	var requests []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number":1,"iid":1}`))
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghClient := gogithub.NewClient(srv.Client())
+	ghClient.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	tests := []struct {
+		name    string
+		client  *fakeClient
+		baseURL string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "github",
+			client: &fakeClient{providerID: github.ProviderID, domain: srvURL.Host, raw: ghClient},
+			want:   "POST /repos/org/repo/pulls",
+		},
+		{
+			name:    "gitlab",
+			client:  &fakeClient{providerID: gitLabProviderID, domain: srvURL.Host},
+			baseURL: srv.URL + "/gitlab/api/v4",
+			want:    "POST /gitlab/api/v4/projects/org%2Frepo/merge_requests",
+		},
+		{
+			name:    "gitlab without base URL",
+			client:  &fakeClient{providerID: gitLabProviderID, domain: srvURL.Host},
+			wantErr: true,
+		},
+		{
+			name:   "gitea",
+			client: &fakeClient{providerID: GiteaProviderID, domain: srvURL.Host},
+			want:   "POST /api/v1/repos/org/repo/pulls",
+		},
+		{
+			name:    "unsupported",
+			client:  &fakeClient{providerID: "bitbucket", domain: srvURL.Host},
+			wantErr: true,
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			requests = nil
+			p, err := NewDispatchPRProvider(rt.client, DispatchPRProviderOptions{
+				GitLabBaseURL: rt.baseURL,
+				GitLabToken:   "secret",
+				GiteaToken:    "secret",
+				HTTPClient:    srv.Client(),
+			})
+			if rt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.CreatePullRequest(context.Background(), &transaction.GenericPullRequestSpec{
+				PullRequestResult: &transaction.GenericPullRequestResult{
+					CommitResult: &transaction.GenericCommitResult{AuthorName: "test", AuthorEmail: "test@example.com", Title: "Speed up foo"},
+				},
+				MainBranch:  "master",
+				MergeBranch: "speed-up-foo",
+				RepositoryRef: gitprovider.OrgRepositoryRef{
+					OrganizationRef: gitprovider.OrganizationRef{Domain: srvURL.Host, Organization: "org"},
+					RepositoryName:  "repo",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(requests) != 1 || requests[0] != rt.want {
+				t.Errorf("expected %q to be requested, got %v", rt.want, requests)
+			}
+		})
+	}
+}
+
+func TestNewDispatchPRProvider_GitLabDefaultBaseURL(t *testing.T) {
+	// GitLab.com doesn't need the base URL to be set
+	if _, err := NewDispatchPRProvider(&fakeClient{providerID: gitLabProviderID, domain: gitLabDomain}, DispatchPRProviderOptions{GitLabToken: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDispatchPRProvider(&fakeClient{providerID: "bitbucket"}, DispatchPRProviderOptions{}); err != ErrProviderNotSupported {
+		t.Errorf("expected ErrProviderNotSupported, got %v", err)
+	}
+}
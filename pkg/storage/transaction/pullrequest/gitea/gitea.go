@@ -0,0 +1,261 @@
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/internal/rest"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/internal/review"
+)
+
+// GiteaPRProviderOptions configures the Gitea PullRequestProvider.
+type GiteaPRProviderOptions struct {
+	// BaseURL is the URL of the Gitea server, e.g. "https://gitea.example.com".
+	// +required
+	BaseURL string
+	// Token is an access token of the user the Pull Requests are created as.
+	// +required
+	Token string
+	// HTTPClient is used for talking to the API.
+	// Default: http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func (o *GiteaPRProviderOptions) Default() {
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+}
+
+// NewGiteaPRProvider returns a new transaction.PullRequestProvider that creates
+// Pull Requests in a (self-hosted) Gitea instance using its REST API.
+func NewGiteaPRProvider(opts GiteaPRProviderOptions) (transaction.PullRequestProvider, error) {
+	opts.Default()
+	if opts.BaseURL == "" {
+		return nil, fmt.Errorf("the Gitea server URL is required")
+	}
+	if opts.Token == "" {
+		return nil, fmt.Errorf("a Gitea access token is required")
+	}
+	return &prCreator{&rest.Client{
+		BaseURL:    strings.TrimSuffix(opts.BaseURL, "/") + "/api/v1",
+		Header:     http.Header{"Authorization": []string{"token " + opts.Token}},
+		HTTPClient: opts.HTTPClient,
+	}}, nil
+}
+
+type prCreator struct {
+	c *rest.Client
+}
+
+type pullRequest struct {
+	Head      string   `json:"head"`
+	Base      string   `json:"base"`
+	Title     string   `json:"title"`
+	Body      string   `json:"body,omitempty"`
+	Assignees []string `json:"assignees,omitempty"`
+	Labels    []int64  `json:"labels,omitempty"`
+	Milestone int64    `json:"milestone,omitempty"`
+}
+
+// pullRequestUpdate sets all fields, empty values unset them
+type pullRequestUpdate struct {
+	Title     string   `json:"title"`
+	Body      string   `json:"body"`
+	Assignees []string `json:"assignees"`
+	Labels    []int64  `json:"labels"`
+	Milestone int64    `json:"milestone"`
+}
+
+type pullRequestResponse struct {
+	Number         int    `json:"number"`
+	HTMLURL        string `json:"html_url"`
+	State          string `json:"state"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+}
+
+// namedItem is the part of a Gitea label or milestone needed for looking up its ID
+type namedItem struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.PullRequestSpec) (*transaction.PullRequest, error) {
+	// First, validate the input
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("given PullRequestSpec wasn't valid: %w", err)
+	}
+
+	repo := repoPath(spec.GetRepositoryRef())
+	fields, err := c.fieldsFor(ctx, repo, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like GitLab, everything can be set when creating the Pull Request
+	resp := &pullRequestResponse{}
+	err = c.c.Do(ctx, http.MethodPost, repo+"/pulls", nil, &pullRequest{
+		Head:      spec.GetMergeBranch(),
+		Base:      spec.GetMainBranch(),
+		Title:     fields.Title,
+		Body:      fields.Body,
+		Assignees: fields.Assignees,
+		Labels:    fields.Labels,
+		Milestone: fields.Milestone,
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction.PullRequest{
+		Number:        resp.Number,
+		URL:           resp.HTMLURL,
+		MergeBranch:   spec.GetMergeBranch(),
+		RepositoryRef: spec.GetRepositoryRef(),
+	}, nil
+}
+
+func (c *prCreator) UpdatePullRequest(ctx context.Context, pr *transaction.PullRequest, spec transaction.PullRequestSpec) error {
+	// First, validate the input
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("given PullRequestSpec wasn't valid: %w", err)
+	}
+
+	repo := repoPath(pr.RepositoryRef)
+	fields, err := c.fieldsFor(ctx, repo, spec)
+	if err != nil {
+		return err
+	}
+	return c.c.Do(ctx, http.MethodPatch, pullRequestPath(repo, pr), nil, fields, nil)
+}
+
+func (c *prCreator) GetPullRequestStatus(ctx context.Context, pr *transaction.PullRequest) (*transaction.PullRequestStatus, error) {
+	repo := repoPath(pr.RepositoryRef)
+	resp := &pullRequestResponse{}
+	if err := c.c.Do(ctx, http.MethodGet, pullRequestPath(repo, pr), nil, nil, resp); err != nil {
+		return nil, err
+	}
+
+	status := &transaction.PullRequestStatus{State: transaction.PullRequestStateOpen}
+	switch {
+	case resp.Merged:
+		status.State = transaction.PullRequestStateMerged
+		status.MergeCommit = resp.MergeCommitSHA
+	case resp.State == "closed":
+		status.State = transaction.PullRequestStateClosed
+	}
+
+	// List all reviews, they are returned in chronological order
+	// TODO: This could/should use pagination
+	giteaReviews := []struct {
+		State     string `json:"state"`
+		Dismissed bool   `json:"dismissed"`
+		User      struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}{}
+	if err := c.c.Do(ctx, http.MethodGet, pullRequestPath(repo, pr)+"/reviews", nil, nil, &giteaReviews); err != nil {
+		return nil, err
+	}
+	reviews := make([]review.Review, 0, len(giteaReviews))
+	for _, r := range giteaReviews {
+		state := reviewStates[r.State]
+		if r.Dismissed {
+			state = transaction.ReviewStateNone
+		}
+		reviews = append(reviews, review.Review{Reviewer: r.User.Login, State: state})
+	}
+	status.ReviewState = review.Summarize(reviews)
+	return status, nil
+}
+
+// reviewStates maps the Gitea review states to ReviewStates, comments and pending reviews are ignored
+var reviewStates = map[string]transaction.ReviewState{
+	"APPROVED":        transaction.ReviewStateApproved,
+	"REQUEST_CHANGES": transaction.ReviewStateChangesRequested,
+}
+
+// fieldsFor resolves the fields of a Pull Request from spec
+func (c *prCreator) fieldsFor(ctx context.Context, repo string, spec transaction.PullRequestSpec) (*pullRequestUpdate, error) {
+	fields := &pullRequestUpdate{
+		Title:     spec.GetTitle(),
+		Body:      spec.GetDescription(),
+		Assignees: spec.GetAssignees(),
+		Labels:    []int64{},
+	}
+	if fields.Assignees == nil {
+		fields.Assignees = []string{}
+	}
+
+	// Labels are given by their names, but Gitea wants their IDs
+	if len(spec.GetLabels()) != 0 {
+		labelIDs, err := c.getLabelIDs(ctx, repo, spec.GetLabels())
+		if err != nil {
+			return nil, err
+		}
+		fields.Labels = labelIDs
+	}
+
+	if len(spec.GetMilestone()) != 0 {
+		milestoneID, err := c.getMilestoneID(ctx, repo, spec.GetMilestone())
+		if err != nil {
+			return nil, err
+		}
+		fields.Milestone = milestoneID
+	}
+	return fields, nil
+}
+
+func (c *prCreator) getLabelIDs(ctx context.Context, repo string, labelNames []string) ([]int64, error) {
+	// List all labels in the repo
+	// TODO: This could/should use pagination
+	labels := []namedItem{}
+	if err := c.c.Do(ctx, http.MethodGet, repo+"/labels", nil, nil, &labels); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]int64, len(labels))
+	for _, label := range labels {
+		ids[label.Name] = label.ID
+	}
+
+	labelIDs := make([]int64, 0, len(labelNames))
+	for _, name := range labelNames {
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("couldn't find label with name: %s", name)
+		}
+		labelIDs = append(labelIDs, id)
+	}
+	return labelIDs, nil
+}
+
+func (c *prCreator) getMilestoneID(ctx context.Context, repo, milestoneName string) (int64, error) {
+	milestones := []namedItem{}
+	query := url.Values{"state": {"all"}, "name": {milestoneName}}
+	if err := c.c.Do(ctx, http.MethodGet, repo+"/milestones", query, nil, &milestones); err != nil {
+		return 0, err
+	}
+	// The name filter is a fuzzy match, search for one with the right name
+	for _, milestone := range milestones {
+		if milestone.Title == milestoneName {
+			return milestone.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("couldn't find milestone with name: %s", milestoneName)
+}
+
+// repoPath returns the API path of the given repository
+func repoPath(ref gitprovider.RepositoryRef) string {
+	return "/repos/" + url.PathEscape(ref.GetIdentity()) + "/" + url.PathEscape(ref.GetRepository())
+}
+
+func pullRequestPath(repo string, pr *transaction.PullRequest) string {
+	return repo + "/pulls/" + strconv.Itoa(pr.Number)
+}
@@ -0,0 +1,270 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+// fakeGitea serves the parts of the Gitea API used by the provider, and records the created Pull Requests
+type fakeGitea struct {
+	created []map[string]interface{}
+	updated []map[string]interface{}
+	state   string
+	merged  bool
+	reviews []map[string]interface{}
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "token secret" {
+		http.Error(w, `{"message":"token is required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var resp interface{}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /api/v1/repos/org/repo/labels":
+		resp = []map[string]interface{}{
+			{"id": 1, "name": "kind/bug"},
+			{"id": 2, "name": "area/cars"},
+			{"id": 3, "name": "area/bikes"},
+		}
+	case "GET /api/v1/repos/org/repo/milestones":
+		// Gitea matches milestone names fuzzily
+		resp = []map[string]interface{}{}
+		if name := r.URL.Query().Get("name"); strings.HasPrefix("v1.0", name) {
+			resp = []map[string]interface{}{{"id": 7, "title": "v1.0-rc"}, {"id": 8, "title": "v1.0"}}
+		}
+	case "POST /api/v1/repos/org/repo/pulls":
+		pr := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		f.created = append(f.created, pr)
+		w.WriteHeader(http.StatusCreated)
+		resp = map[string]interface{}{"number": len(f.created), "html_url": "https://gitea.example.com/org/repo/pulls/1"}
+	case "PATCH /api/v1/repos/org/repo/pulls/1":
+		pr := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		f.updated = append(f.updated, pr)
+		resp = map[string]interface{}{"number": 1}
+	case "GET /api/v1/repos/org/repo/pulls/1":
+		resp = map[string]interface{}{"number": 1, "state": f.state, "merged": f.merged, "merge_commit_sha": "abc123"}
+	case "GET /api/v1/repos/org/repo/pulls/1/reviews":
+		resp = f.reviews
+		if f.reviews == nil {
+			resp = []interface{}{}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newSpec(result *transaction.GenericPullRequestResult) transaction.PullRequestSpec {
+	result.CommitResult = &transaction.GenericCommitResult{
+		AuthorName:  "test",
+		AuthorEmail: "test@example.com",
+		Title:       "Speed up foo",
+	}
+	return &transaction.GenericPullRequestSpec{
+		PullRequestResult: result,
+		MainBranch:        "master",
+		MergeBranch:       "speed-up-foo",
+		RepositoryRef: gitprovider.OrgRepositoryRef{
+			OrganizationRef: gitprovider.OrganizationRef{Domain: "gitea.example.com", Organization: "org"},
+			RepositoryName:  "repo",
+		},
+	}
+}
+
+func TestCreatePullRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  *transaction.GenericPullRequestResult
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "minimal",
+			result: &transaction.GenericPullRequestResult{},
+			want: map[string]interface{}{
+				"head":  "speed-up-foo",
+				"base":  "master",
+				"title": "Speed up foo",
+			},
+		},
+		{
+			name: "labels, assignees and milestone",
+			result: &transaction.GenericPullRequestResult{
+				Labels:    []string{"area/cars", "kind/bug"},
+				Assignees: []string{"alice"},
+				Milestone: "v1.0",
+			},
+			want: map[string]interface{}{
+				"head":      "speed-up-foo",
+				"base":      "master",
+				"title":     "Speed up foo",
+				"labels":    []interface{}{float64(2), float64(1)},
+				"assignees": []interface{}{"alice"},
+				"milestone": float64(8),
+			},
+		},
+		{
+			name:    "unknown label",
+			result:  &transaction.GenericPullRequestResult{Labels: []string{"kind/feature"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown milestone",
+			result:  &transaction.GenericPullRequestResult{Milestone: "v2.0"},
+			wantErr: true,
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			fake := &fakeGitea{}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			p, err := NewGiteaPRProvider(GiteaPRProviderOptions{BaseURL: srv.URL, Token: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr, err := p.CreatePullRequest(context.Background(), newSpec(rt.result))
+			if rt.wantErr {
+				if err == nil || len(fake.created) != 0 {
+					t.Fatalf("expected an error and no Pull Request, got %v and %v", err, fake.created)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fake.created) != 1 || !reflect.DeepEqual(fake.created[0], rt.want) {
+				t.Errorf("expected %v to be created, got %v", rt.want, fake.created)
+			}
+			if pr.Number != 1 || pr.URL != "https://gitea.example.com/org/repo/pulls/1" || pr.MergeBranch != "speed-up-foo" {
+				t.Errorf("unexpected Pull Request %+v", pr)
+			}
+		})
+	}
+}
+
+func TestNewGiteaPRProvider(t *testing.T) {
+	if _, err := NewGiteaPRProvider(GiteaPRProviderOptions{Token: "secret"}); err == nil {
+		t.Error("expected an error without a BaseURL")
+	}
+	if _, err := NewGiteaPRProvider(GiteaPRProviderOptions{BaseURL: "https://gitea.example.com"}); err == nil {
+		t.Error("expected an error without a Token")
+	}
+}
+
+func TestUpdatePullRequest(t *testing.T) {
+	fake := &fakeGitea{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p, err := NewGiteaPRProvider(GiteaPRProviderOptions{BaseURL: srv.URL, Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := newSpec(&transaction.GenericPullRequestResult{Labels: []string{"area/bikes"}})
+	pr := &transaction.PullRequest{Number: 1, RepositoryRef: spec.GetRepositoryRef()}
+	if err := p.UpdatePullRequest(context.Background(), pr, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	// All fields are set, so that e.g. the assignees are removed
+	want := map[string]interface{}{
+		"title":     "Speed up foo",
+		"body":      "",
+		"labels":    []interface{}{float64(3)},
+		"assignees": []interface{}{},
+		"milestone": float64(0),
+	}
+	if len(fake.updated) != 1 || !reflect.DeepEqual(fake.updated[0], want) {
+		t.Errorf("expected %v to be updated, got %v", want, fake.updated)
+	}
+}
+
+func reviewBy(login, state string, dismissed bool) map[string]interface{} {
+	return map[string]interface{}{"state": state, "dismissed": dismissed, "user": map[string]interface{}{"login": login}}
+}
+
+func TestGetPullRequestStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		fake *fakeGitea
+		want transaction.PullRequestStatus
+	}{
+		{
+			name: "open",
+			fake: &fakeGitea{state: "open", reviews: []map[string]interface{}{reviewBy("alice", "COMMENT", false)}},
+			want: transaction.PullRequestStatus{State: transaction.PullRequestStateOpen, ReviewState: transaction.ReviewStateNone},
+		},
+		{
+			name: "changes requested",
+			fake: &fakeGitea{state: "open", reviews: []map[string]interface{}{
+				reviewBy("alice", "APPROVED", false),
+				reviewBy("bob", "REQUEST_CHANGES", false),
+			}},
+			want: transaction.PullRequestStatus{State: transaction.PullRequestStateOpen, ReviewState: transaction.ReviewStateChangesRequested},
+		},
+		{
+			name: "latest review counts",
+			fake: &fakeGitea{state: "open", reviews: []map[string]interface{}{
+				reviewBy("bob", "REQUEST_CHANGES", false),
+				reviewBy("alice", "APPROVED", false),
+				reviewBy("bob", "APPROVED", false),
+			}},
+			want: transaction.PullRequestStatus{State: transaction.PullRequestStateOpen, ReviewState: transaction.ReviewStateApproved},
+		},
+		{
+			name: "dismissed",
+			fake: &fakeGitea{state: "open", reviews: []map[string]interface{}{reviewBy("alice", "APPROVED", true)}},
+			want: transaction.PullRequestStatus{State: transaction.PullRequestStateOpen, ReviewState: transaction.ReviewStateNone},
+		},
+		{
+			name: "merged",
+			fake: &fakeGitea{state: "closed", merged: true},
+			want: transaction.PullRequestStatus{State: transaction.PullRequestStateMerged, ReviewState: transaction.ReviewStateNone, MergeCommit: "abc123"},
+		},
+		{
+			name: "closed",
+			fake: &fakeGitea{state: "closed"},
+			want: transaction.PullRequestStatus{State: transaction.PullRequestStateClosed, ReviewState: transaction.ReviewStateNone},
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			srv := httptest.NewServer(rt.fake)
+			defer srv.Close()
+
+			p, err := NewGiteaPRProvider(GiteaPRProviderOptions{BaseURL: srv.URL, Token: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr := &transaction.PullRequest{Number: 1, RepositoryRef: newSpec(&transaction.GenericPullRequestResult{}).GetRepositoryRef()}
+			status, err := p.GetPullRequestStatus(context.Background(), pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *status != rt.want {
+				t.Errorf("expected %+v, got %+v", rt.want, *status)
+			}
+		})
+	}
+}
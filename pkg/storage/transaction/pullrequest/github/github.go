@@ -9,6 +9,7 @@ import (
 	"github.com/fluxcd/go-git-providers/gitprovider"
 	gogithub "github.com/google/go-github/v32/github"
 	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/internal/review"
 )
 
 // TODO: This package should really only depend on go-git-providers' abstraction interface
@@ -28,10 +29,10 @@ type prCreator struct {
 	c gitprovider.Client
 }
 
-func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.PullRequestSpec) error {
+func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.PullRequestSpec) (*transaction.PullRequest, error) {
 	// First, validate the input
 	if err := spec.Validate(); err != nil {
-		return fmt.Errorf("given PullRequestSpec wasn't valid")
+		return nil, fmt.Errorf("given PullRequestSpec wasn't valid")
 	}
 
 	// Use the "raw" go-github client to do this
@@ -52,45 +53,123 @@ func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.Pull
 		Title: gogithub.String(spec.GetTitle()),
 		Body:  body,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the milestone, assignees and labels
+	req, err := issueRequest(ctx, ghClient, owner, repo, spec)
+	if err != nil {
+		return nil, err
+	}
+	// Only PATCH the PR if any of the fields were set
+	if req.Milestone != nil || req.Assignees != nil || req.Labels != nil {
+		if _, _, err := ghClient.Issues.Edit(ctx, owner, repo, pr.GetNumber(), req); err != nil {
+			return nil, err
+		}
+	}
+
+	return &transaction.PullRequest{
+		Number:        pr.GetNumber(),
+		URL:           pr.GetHTMLURL(),
+		MergeBranch:   spec.GetMergeBranch(),
+		RepositoryRef: spec.GetRepositoryRef(),
+	}, nil
+}
+
+func (c *prCreator) UpdatePullRequest(ctx context.Context, pr *transaction.PullRequest, spec transaction.PullRequestSpec) error {
+	// First, validate the input
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("given PullRequestSpec wasn't valid")
+	}
+
+	// Use the "raw" go-github client to do this
+	ghClient := c.c.Raw().(*gogithub.Client)
+	owner := pr.RepositoryRef.GetIdentity()
+	repo := pr.RepositoryRef.GetRepository()
+
+	req, err := issueRequest(ctx, ghClient, owner, repo, spec)
 	if err != nil {
 		return err
 	}
+	// Set all fields, so that e.g. removed labels are removed from the PR too
+	req.Title = gogithub.String(spec.GetTitle())
+	req.Body = gogithub.String(spec.GetDescription())
+	if req.Assignees == nil {
+		req.Assignees = &[]string{}
+	}
+	if req.Labels == nil {
+		req.Labels = &[]string{}
+	}
+	_, _, err = ghClient.Issues.Edit(ctx, owner, repo, pr.Number, req)
+	return err
+}
+
+func (c *prCreator) GetPullRequestStatus(ctx context.Context, pr *transaction.PullRequest) (*transaction.PullRequestStatus, error) {
+	// Use the "raw" go-github client to do this
+	ghClient := c.c.Raw().(*gogithub.Client)
+	owner := pr.RepositoryRef.GetIdentity()
+	repo := pr.RepositoryRef.GetRepository()
+
+	ghPR, _, err := ghClient.PullRequests.Get(ctx, owner, repo, pr.Number)
+	if err != nil {
+		return nil, err
+	}
+	status := &transaction.PullRequestStatus{State: transaction.PullRequestStateOpen}
+	switch {
+	case ghPR.GetMerged():
+		status.State = transaction.PullRequestStateMerged
+		status.MergeCommit = ghPR.GetMergeCommitSHA()
+	case ghPR.GetState() == "closed":
+		status.State = transaction.PullRequestStateClosed
+	}
+
+	// List all reviews, they are returned in chronological order
+	// TODO: This could/should use pagination
+	ghReviews, _, err := ghClient.PullRequests.ListReviews(ctx, owner, repo, pr.Number, nil)
+	if err != nil {
+		return nil, err
+	}
+	reviews := make([]review.Review, 0, len(ghReviews))
+	for _, r := range ghReviews {
+		reviews = append(reviews, review.Review{Reviewer: r.GetUser().GetLogin(), State: reviewStates[r.GetState()]})
+	}
+	status.ReviewState = review.Summarize(reviews)
+	return status, nil
+}
+
+// reviewStates maps the GitHub review states to ReviewStates, comments and pending reviews are ignored
+var reviewStates = map[string]transaction.ReviewState{
+	"APPROVED":          transaction.ReviewStateApproved,
+	"CHANGES_REQUESTED": transaction.ReviewStateChangesRequested,
+	"DISMISSED":         transaction.ReviewStateNone,
+}
+
+// issueRequest returns a request setting the milestone, assignees and labels of spec. The fields
+// that aren't specified are left nil.
+func issueRequest(ctx context.Context, c *gogithub.Client, owner, repo string, spec transaction.PullRequestSpec) (*gogithub.IssueRequest, error) {
+	req := &gogithub.IssueRequest{}
 
 	// If spec.GetMilestone() is set, fetch the ID of the milestone
 	// Only set milestoneID to non-nil if specified
-	var milestoneID *int
 	if len(spec.GetMilestone()) != 0 {
-		milestoneID, err = getMilestoneID(ctx, ghClient, owner, repo, spec.GetMilestone())
+		milestoneID, err := getMilestoneID(ctx, c, owner, repo, spec.GetMilestone())
 		if err != nil {
-			return err
+			return nil, err
 		}
+		req.Milestone = milestoneID
 	}
 
 	// Only set assignees to non-nil if specified
-	var assignees *[]string
 	if a := spec.GetAssignees(); len(a) != 0 {
-		assignees = &a
+		req.Assignees = &a
 	}
 
 	// Only set labels to non-nil if specified
-	var labels *[]string
 	if l := spec.GetLabels(); len(l) != 0 {
-		labels = &l
+		req.Labels = &l
 	}
-
-	// Only PATCH the PR if any of the fields were set
-	if milestoneID != nil || assignees != nil || labels != nil {
-		_, _, err := ghClient.Issues.Edit(ctx, owner, repo, pr.GetNumber(), &gogithub.IssueRequest{
-			Milestone: milestoneID,
-			Assignees: assignees,
-			Labels:    labels,
-		})
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return req, nil
 }
 
 func getMilestoneID(ctx context.Context, c *gogithub.Client, owner, repo, milestoneName string) (*int, error) {
@@ -0,0 +1,238 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/internal/rest"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction/pullrequest/internal/review"
+)
+
+// DefaultBaseURL is the URL of the GitLab.com API.
+const DefaultBaseURL = "https://gitlab.com/api/v4"
+
+// GitLabPRProviderOptions configures the GitLab PullRequestProvider.
+type GitLabPRProviderOptions struct {
+	// BaseURL is the URL of the GitLab API, e.g. "https://gitlab.example.com/api/v4".
+	// Default: DefaultBaseURL
+	BaseURL string
+	// Token is a personal or project access token with the "api" scope.
+	// +required
+	Token string
+	// HTTPClient is used for talking to the API.
+	// Default: http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func (o *GitLabPRProviderOptions) Default() {
+	if o.BaseURL == "" {
+		o.BaseURL = DefaultBaseURL
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+}
+
+// NewGitLabPRProvider returns a new transaction.PullRequestProvider that creates
+// GitLab Merge Requests using the GitLab REST API.
+func NewGitLabPRProvider(opts GitLabPRProviderOptions) (transaction.PullRequestProvider, error) {
+	opts.Default()
+	if opts.Token == "" {
+		return nil, fmt.Errorf("a GitLab access token is required")
+	}
+	return &prCreator{&rest.Client{
+		BaseURL:    opts.BaseURL,
+		Header:     http.Header{"Private-Token": []string{opts.Token}},
+		HTTPClient: opts.HTTPClient,
+	}}, nil
+}
+
+type prCreator struct {
+	c *rest.Client
+}
+
+type mergeRequest struct {
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	Title        string `json:"title"`
+	Description  string `json:"description,omitempty"`
+	Labels       string `json:"labels,omitempty"`
+	AssigneeIDs  []int  `json:"assignee_ids,omitempty"`
+	MilestoneID  int    `json:"milestone_id,omitempty"`
+}
+
+// mergeRequestUpdate sets all fields, empty values unset them
+type mergeRequestUpdate struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Labels      string `json:"labels"`
+	AssigneeIDs []int  `json:"assignee_ids"`
+	MilestoneID int    `json:"milestone_id"`
+}
+
+type mergeRequestResponse struct {
+	IID            int    `json:"iid"`
+	WebURL         string `json:"web_url"`
+	State          string `json:"state"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+}
+
+func (c *prCreator) CreatePullRequest(ctx context.Context, spec transaction.PullRequestSpec) (*transaction.PullRequest, error) {
+	// First, validate the input
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("given PullRequestSpec wasn't valid: %w", err)
+	}
+
+	// GitLab addresses projects by their URL-encoded full path, including any sub-groups
+	project := projectPath(spec.GetRepositoryRef())
+	fields, err := c.fieldsFor(ctx, project, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlike GitHub, everything can be set when creating the Merge Request
+	resp := &mergeRequestResponse{}
+	err = c.c.Do(ctx, http.MethodPost, project+"/merge_requests", nil, &mergeRequest{
+		SourceBranch: spec.GetMergeBranch(),
+		TargetBranch: spec.GetMainBranch(),
+		Title:        fields.Title,
+		Description:  fields.Description,
+		Labels:       fields.Labels,
+		AssigneeIDs:  fields.AssigneeIDs,
+		MilestoneID:  fields.MilestoneID,
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction.PullRequest{
+		Number:        resp.IID,
+		URL:           resp.WebURL,
+		MergeBranch:   spec.GetMergeBranch(),
+		RepositoryRef: spec.GetRepositoryRef(),
+	}, nil
+}
+
+func (c *prCreator) UpdatePullRequest(ctx context.Context, pr *transaction.PullRequest, spec transaction.PullRequestSpec) error {
+	// First, validate the input
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("given PullRequestSpec wasn't valid: %w", err)
+	}
+
+	project := projectPath(pr.RepositoryRef)
+	fields, err := c.fieldsFor(ctx, project, spec)
+	if err != nil {
+		return err
+	}
+	return c.c.Do(ctx, http.MethodPut, mergeRequestPath(project, pr), nil, fields, nil)
+}
+
+func (c *prCreator) GetPullRequestStatus(ctx context.Context, pr *transaction.PullRequest) (*transaction.PullRequestStatus, error) {
+	project := projectPath(pr.RepositoryRef)
+	mr := &mergeRequestResponse{}
+	if err := c.c.Do(ctx, http.MethodGet, mergeRequestPath(project, pr), nil, nil, mr); err != nil {
+		return nil, err
+	}
+
+	status := &transaction.PullRequestStatus{State: transaction.PullRequestStateOpen}
+	switch mr.State {
+	case "merged":
+		status.State = transaction.PullRequestStateMerged
+		status.MergeCommit = mr.MergeCommitSHA
+	case "closed":
+		status.State = transaction.PullRequestStateClosed
+	}
+
+	// GitLab only has approvals, changes can't be requested
+	approvals := &struct {
+		ApprovedBy []struct {
+			User struct {
+				Username string `json:"username"`
+			} `json:"user"`
+		} `json:"approved_by"`
+	}{}
+	if err := c.c.Do(ctx, http.MethodGet, mergeRequestPath(project, pr)+"/approvals", nil, nil, approvals); err != nil {
+		return nil, err
+	}
+	reviews := make([]review.Review, 0, len(approvals.ApprovedBy))
+	for _, approval := range approvals.ApprovedBy {
+		reviews = append(reviews, review.Review{Reviewer: approval.User.Username, State: transaction.ReviewStateApproved})
+	}
+	status.ReviewState = review.Summarize(reviews)
+	return status, nil
+}
+
+// fieldsFor resolves the fields of a Merge Request from spec
+func (c *prCreator) fieldsFor(ctx context.Context, project string, spec transaction.PullRequestSpec) (*mergeRequestUpdate, error) {
+	fields := &mergeRequestUpdate{
+		Title:       spec.GetTitle(),
+		Description: spec.GetDescription(),
+		Labels:      strings.Join(spec.GetLabels(), ","),
+		AssigneeIDs: []int{},
+	}
+
+	// Assignees are given by their user login names, but GitLab wants their IDs
+	for _, username := range spec.GetAssignees() {
+		id, err := c.getUserID(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		fields.AssigneeIDs = append(fields.AssigneeIDs, id)
+	}
+
+	if len(spec.GetMilestone()) != 0 {
+		id, err := c.getMilestoneID(ctx, project, spec.GetMilestone())
+		if err != nil {
+			return nil, err
+		}
+		fields.MilestoneID = id
+	}
+	return fields, nil
+}
+
+func (c *prCreator) getUserID(ctx context.Context, username string) (int, error) {
+	users := []struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	}{}
+	if err := c.c.Do(ctx, http.MethodGet, "/users", url.Values{"username": {username}}, nil, &users); err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		if user.Username == username {
+			return user.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("couldn't find user with username: %s", username)
+}
+
+func (c *prCreator) getMilestoneID(ctx context.Context, project, milestoneName string) (int, error) {
+	// The title filter is an exact match, but check anyways
+	milestones := []struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	}{}
+	if err := c.c.Do(ctx, http.MethodGet, project+"/milestones", url.Values{"title": {milestoneName}}, nil, &milestones); err != nil {
+		return 0, err
+	}
+	for _, milestone := range milestones {
+		if milestone.Title == milestoneName {
+			return milestone.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("couldn't find milestone with name: %s", milestoneName)
+}
+
+// projectPath returns the API path of the given project
+func projectPath(ref gitprovider.RepositoryRef) string {
+	return "/projects/" + url.PathEscape(ref.GetIdentity()+"/"+ref.GetRepository())
+}
+
+func mergeRequestPath(project string, pr *transaction.PullRequest) string {
+	return project + "/merge_requests/" + strconv.Itoa(pr.Number)
+}
@@ -0,0 +1,250 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fluxcd/go-git-providers/gitprovider"
+	"github.com/weaveworks/libgitops/pkg/storage/transaction"
+)
+
+// fakeGitLab serves the parts of the GitLab API used by the provider, and records the created Merge Requests
+type fakeGitLab struct {
+	created    []map[string]interface{}
+	updated    []map[string]interface{}
+	state      string
+	approvedBy []string
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Private-Token") != "secret" {
+		http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var resp interface{}
+	switch r.Method + " " + r.URL.EscapedPath() {
+	case "GET /api/v4/users":
+		resp = []map[string]interface{}{}
+		if username := r.URL.Query().Get("username"); username == "alice" || username == "bob" {
+			resp = []map[string]interface{}{{"id": len(username), "username": username}}
+		}
+	case "GET /api/v4/projects/org%2Fteam%2Frepo/milestones":
+		resp = []map[string]interface{}{}
+		if r.URL.Query().Get("title") == "v1.0" {
+			resp = []map[string]interface{}{{"id": 42, "title": "v1.0"}}
+		}
+	case "POST /api/v4/projects/org%2Fteam%2Frepo/merge_requests":
+		mr := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&mr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.created = append(f.created, mr)
+		resp = map[string]interface{}{"iid": len(f.created), "web_url": "https://gitlab.example.com/org/team/repo/-/merge_requests/1"}
+	case "PUT /api/v4/projects/org%2Fteam%2Frepo/merge_requests/1":
+		mr := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&mr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.updated = append(f.updated, mr)
+		resp = map[string]interface{}{"iid": 1}
+	case "GET /api/v4/projects/org%2Fteam%2Frepo/merge_requests/1":
+		resp = map[string]interface{}{"iid": 1, "state": f.state, "merge_commit_sha": "abc123"}
+	case "GET /api/v4/projects/org%2Fteam%2Frepo/merge_requests/1/approvals":
+		approvedBy := []map[string]interface{}{}
+		for _, username := range f.approvedBy {
+			approvedBy = append(approvedBy, map[string]interface{}{"user": map[string]interface{}{"username": username}})
+		}
+		resp = map[string]interface{}{"approved_by": approvedBy}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newSpec(result *transaction.GenericPullRequestResult) transaction.PullRequestSpec {
+	result.CommitResult = &transaction.GenericCommitResult{
+		AuthorName:  "test",
+		AuthorEmail: "test@example.com",
+		Title:       "Speed up foo",
+		Description: "Foo is too slow",
+	}
+	return &transaction.GenericPullRequestSpec{
+		PullRequestResult: result,
+		MainBranch:        "master",
+		MergeBranch:       "speed-up-foo",
+		RepositoryRef: gitprovider.OrgRepositoryRef{
+			OrganizationRef: gitprovider.OrganizationRef{Domain: "gitlab.example.com", Organization: "org", SubOrganizations: []string{"team"}},
+			RepositoryName:  "repo",
+		},
+	}
+}
+
+func TestCreatePullRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  *transaction.GenericPullRequestResult
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "minimal",
+			result: &transaction.GenericPullRequestResult{},
+			want: map[string]interface{}{
+				"source_branch": "speed-up-foo",
+				"target_branch": "master",
+				"title":         "Speed up foo",
+				"description":   "Foo is too slow",
+			},
+		},
+		{
+			name: "labels, assignees and milestone",
+			result: &transaction.GenericPullRequestResult{
+				Labels:    []string{"kind/bug", "area/cars"},
+				Assignees: []string{"alice", "bob"},
+				Milestone: "v1.0",
+			},
+			want: map[string]interface{}{
+				"source_branch": "speed-up-foo",
+				"target_branch": "master",
+				"title":         "Speed up foo",
+				"description":   "Foo is too slow",
+				"labels":        "kind/bug,area/cars",
+				"assignee_ids":  []interface{}{float64(5), float64(3)},
+				"milestone_id":  float64(42),
+			},
+		},
+		{
+			name:    "unknown assignee",
+			result:  &transaction.GenericPullRequestResult{Assignees: []string{"mallory"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown milestone",
+			result:  &transaction.GenericPullRequestResult{Milestone: "v2.0"},
+			wantErr: true,
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			fake := &fakeGitLab{}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			p, err := NewGitLabPRProvider(GitLabPRProviderOptions{BaseURL: srv.URL + "/api/v4", Token: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr, err := p.CreatePullRequest(context.Background(), newSpec(rt.result))
+			if rt.wantErr {
+				if err == nil || len(fake.created) != 0 {
+					t.Fatalf("expected an error and no Merge Request, got %v and %v", err, fake.created)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fake.created) != 1 || !reflect.DeepEqual(fake.created[0], rt.want) {
+				t.Errorf("expected %v to be created, got %v", rt.want, fake.created)
+			}
+			if pr.Number != 1 || pr.URL != "https://gitlab.example.com/org/team/repo/-/merge_requests/1" || pr.MergeBranch != "speed-up-foo" {
+				t.Errorf("unexpected Merge Request %+v", pr)
+			}
+		})
+	}
+}
+
+func TestCreatePullRequest_Unauthorized(t *testing.T) {
+	srv := httptest.NewServer(&fakeGitLab{})
+	defer srv.Close()
+
+	p, err := NewGitLabPRProvider(GitLabPRProviderOptions{BaseURL: srv.URL + "/api/v4", Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CreatePullRequest(context.Background(), newSpec(&transaction.GenericPullRequestResult{})); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestUpdatePullRequest(t *testing.T) {
+	fake := &fakeGitLab{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p, err := NewGitLabPRProvider(GitLabPRProviderOptions{BaseURL: srv.URL + "/api/v4", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := newSpec(&transaction.GenericPullRequestResult{Labels: []string{"kind/bug"}})
+	pr := &transaction.PullRequest{Number: 1, RepositoryRef: spec.GetRepositoryRef()}
+	if err := p.UpdatePullRequest(context.Background(), pr, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	// All fields are set, so that e.g. the assignees are removed
+	want := map[string]interface{}{
+		"title":        "Speed up foo",
+		"description":  "Foo is too slow",
+		"labels":       "kind/bug",
+		"assignee_ids": []interface{}{},
+		"milestone_id": float64(0),
+	}
+	if len(fake.updated) != 1 || !reflect.DeepEqual(fake.updated[0], want) {
+		t.Errorf("expected %v to be updated, got %v", want, fake.updated)
+	}
+}
+
+func TestGetPullRequestStatus(t *testing.T) {
+	tests := []struct {
+		state      string
+		approvedBy []string
+		want       transaction.PullRequestStatus
+	}{
+		{
+			state: "opened",
+			want:  transaction.PullRequestStatus{State: transaction.PullRequestStateOpen, ReviewState: transaction.ReviewStateNone},
+		},
+		{
+			state:      "opened",
+			approvedBy: []string{"alice"},
+			want:       transaction.PullRequestStatus{State: transaction.PullRequestStateOpen, ReviewState: transaction.ReviewStateApproved},
+		},
+		{
+			state:      "merged",
+			approvedBy: []string{"alice"},
+			want:       transaction.PullRequestStatus{State: transaction.PullRequestStateMerged, ReviewState: transaction.ReviewStateApproved, MergeCommit: "abc123"},
+		},
+		{
+			state: "closed",
+			want:  transaction.PullRequestStatus{State: transaction.PullRequestStateClosed, ReviewState: transaction.ReviewStateNone},
+		},
+	}
+	for _, rt := range tests {
+		t.Run(rt.state, func(t *testing.T) {
+			srv := httptest.NewServer(&fakeGitLab{state: rt.state, approvedBy: rt.approvedBy})
+			defer srv.Close()
+
+			p, err := NewGitLabPRProvider(GitLabPRProviderOptions{BaseURL: srv.URL + "/api/v4", Token: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr := &transaction.PullRequest{Number: 1, RepositoryRef: newSpec(&transaction.GenericPullRequestResult{}).GetRepositoryRef()}
+			status, err := p.GetPullRequestStatus(context.Background(), pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *status != rt.want {
+				t.Errorf("expected %+v, got %+v", rt.want, *status)
+			}
+		})
+	}
+}
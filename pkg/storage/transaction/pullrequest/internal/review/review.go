@@ -0,0 +1,35 @@
+package review
+
+import "github.com/weaveworks/libgitops/pkg/storage/transaction"
+
+// Review is a single review of a Pull Request.
+type Review struct {
+	// Reviewer is the login name of the reviewer.
+	Reviewer string
+	// State is the outcome of the review. ReviewStateNone resets an earlier review of the
+	// same reviewer, e.g. when it was dismissed. Reviews with an empty State, e.g. comments,
+	// are ignored.
+	State transaction.ReviewState
+}
+
+// Summarize returns the ReviewState of a Pull Request from its reviews, given in chronological
+// order. Only the latest review of every reviewer counts.
+func Summarize(reviews []Review) transaction.ReviewState {
+	latest := make(map[string]transaction.ReviewState, len(reviews))
+	for _, r := range reviews {
+		if len(r.State) != 0 {
+			latest[r.Reviewer] = r.State
+		}
+	}
+
+	state := transaction.ReviewStateNone
+	for _, s := range latest {
+		switch s {
+		case transaction.ReviewStateChangesRequested:
+			return s
+		case transaction.ReviewStateApproved:
+			state = s
+		}
+	}
+	return state
+}
@@ -0,0 +1,238 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+// ErrReadOnlyRevision is returned when trying to write to a past revision.
+var ErrReadOnlyRevision = errors.New("cannot write to a past revision")
+
+// RevisionStorage is a storage that can read the state of its Objects at earlier revisions.
+type RevisionStorage interface {
+	// AtRevision returns a read-only view of the Objects at the given revision. The revision
+	// can be anything go-git resolves, e.g. a commit SHA, a tag, a branch or "master~2".
+	AtRevision(ctx context.Context, rev string) (storage.ReadStorage, error)
+	// History returns the commits that changed the Object with the given key, newest first.
+	History(ctx context.Context, key storage.ObjectKey) ([]CommitInfo, error)
+}
+
+// CommitInfo describes a commit that changed an Object.
+type CommitInfo struct {
+	// SHA is the hash of the commit.
+	SHA string
+	// AuthorName and AuthorEmail describe who authored the change.
+	AuthorName  string
+	AuthorEmail string
+	// Time is when the change was authored.
+	Time time.Time
+	// Message is the full commit message.
+	Message string
+	// Event describes how the commit changed the Object.
+	Event update.ObjectEvent
+}
+
+// GitStorage implements RevisionStorage.
+var _ RevisionStorage = &GitStorage{}
+
+// AtRevision returns a read-only view of the Objects at the given revision. The Objects are
+// read straight from the Git objects, no checkout is done. As the GitDirectory is cloned without
+// tags, tags are only resolvable if they have been fetched or created locally.
+func (s *GitStorage) AtRevision(ctx context.Context, rev string) (storage.ReadStorage, error) {
+	s.gitDir.Suspend()
+	defer s.gitDir.Resume()
+
+	return readAtRevision(ctx, s.repo, s.s, rev)
+}
+
+// History returns the commits on the main branch that changed the Object with the given key,
+// newest first. Merge commits are skipped, the changes are attributed to the merged commits.
+func (s *GitStorage) History(ctx context.Context, key storage.ObjectKey) ([]CommitInfo, error) {
+	s.gitDir.Suspend()
+	defer s.gitDir.Resume()
+
+	ref, err := s.repo.Reference(plumbing.NewBranchReferenceName(s.gitDir.MainBranch()), true)
+	if err != nil {
+		return nil, err
+	}
+	return history(ctx, s.repo, s.s, ref.Hash(), key)
+}
+
+// readAtRevision decodes all Objects in the tree of the given revision into a new
+// read-only Storage, using the serializer of s
+func readAtRevision(ctx context.Context, repo *git.Repository, s storage.Storage, rev string) (storage.ReadStorage, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve revision %q: %w", rev, err)
+	}
+	tree, err := treeAt(repo, hash.String())
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make(map[storage.ObjectKey]revisionObject)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return decodeRevisionFile(s, file, objs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw := &revisionRawStorage{commit: hash.String(), objs: objs}
+	return storage.NewGenericStorage(raw, s.Serializer(), identifiers), nil
+}
+
+// history walks the commits reachable from the given one, and returns those that changed the Object
+func history(ctx context.Context, repo *git.Repository, s storage.Storage, from plumbing.Hash, key storage.ObjectKey) ([]CommitInfo, error) {
+	iter, err := repo.Log(&git.LogOptions{From: from})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	result := []CommitInfo{}
+	err = iter.ForEach(func(commit *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if commit.NumParents() > 1 {
+			return nil
+		}
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+		// The first commit is compared to an empty tree
+		var parentTree *object.Tree
+		if commit.NumParents() == 1 {
+			parent, err := commit.Parent(0)
+			if err != nil {
+				return err
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				return err
+			}
+		}
+
+		changes, err := diffTrees(s, parentTree, tree)
+		if err != nil {
+			return fmt.Errorf("failed to diff commit %s: %w", commit.Hash, err)
+		}
+		for _, change := range changes {
+			if change.key.EqualsGVK(key, false) && change.key.GetIdentifier() == key.GetIdentifier() {
+				result = append(result, CommitInfo{
+					SHA:         commit.Hash.String(),
+					AuthorName:  commit.Author.Name,
+					AuthorEmail: commit.Author.Email,
+					Time:        commit.Author.When,
+					Message:     commit.Message,
+					Event:       change.event,
+				})
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// revisionRawStorage is a read-only RawStorage serving the Objects of one revision from memory.
+type revisionRawStorage struct {
+	commit string
+	objs   map[storage.ObjectKey]revisionObject
+}
+
+var _ storage.RawStorage = &revisionRawStorage{}
+
+func (r *revisionRawStorage) get(key storage.ObjectKey) (revisionObject, error) {
+	obj, ok := r.objs[key]
+	if !ok {
+		return revisionObject{}, fmt.Errorf("%s at revision %s: %w", key, r.commit, storage.ErrNotFound)
+	}
+	return obj, nil
+}
+
+func (r *revisionRawStorage) Read(ctx context.Context, key storage.ObjectKey) ([]byte, error) {
+	obj, err := r.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return obj.content, nil
+}
+
+func (r *revisionRawStorage) Exists(ctx context.Context, key storage.ObjectKey) bool {
+	_, ok := r.objs[key]
+	return ok
+}
+
+func (r *revisionRawStorage) Write(ctx context.Context, key storage.ObjectKey, content []byte) error {
+	return ErrReadOnlyRevision
+}
+
+func (r *revisionRawStorage) Delete(ctx context.Context, key storage.ObjectKey) error {
+	return ErrReadOnlyRevision
+}
+
+func (r *revisionRawStorage) List(ctx context.Context, kind storage.KindKey) ([]storage.ObjectKey, error) {
+	result := make([]storage.ObjectKey, 0)
+	for key := range r.objs {
+		// Include objects with the same kind and group, ignore version mismatches
+		if key.EqualsGVK(kind, false) {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+// Checksum returns the Git blob hash of the Object's content, which never changes within a revision.
+func (r *revisionRawStorage) Checksum(ctx context.Context, key storage.ObjectKey) (string, error) {
+	obj, err := r.get(key)
+	if err != nil {
+		return "", err
+	}
+	return plumbing.ComputeHash(plumbing.BlobObject, obj.content).String(), nil
+}
+
+func (r *revisionRawStorage) ContentType(key storage.ObjectKey) (ct serializer.ContentType) {
+	if obj, ok := r.objs[key]; ok {
+		ct = storage.ContentTypes[filepath.Ext(obj.loc.Path)]
+	}
+	return
+}
+
+// WatchDir returns an empty string, as a past revision never changes.
+func (r *revisionRawStorage) WatchDir() string {
+	return ""
+}
+
+// GetKey returns the Key of the first frame in the file with the given path, relative to the repository root.
+func (r *revisionRawStorage) GetKey(path string) (storage.ObjectKey, error) {
+	var result storage.ObjectKey
+	frame := -1
+	for key, obj := range r.objs {
+		if obj.loc.Path == path && (frame == -1 || obj.loc.Frame < frame) {
+			result, frame = key, obj.loc.Frame
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no object found for path %q at revision %s", path, r.commit)
+	}
+	return result, nil
+}
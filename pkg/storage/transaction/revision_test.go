@@ -0,0 +1,95 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+var fooKey = storage.NewObjectKey(storage.NewKindKey(v1alpha1.SchemeGroupVersion.WithKind("Car")), runtime.NewIdentifier("default/foo"))
+
+func TestReadAtRevision(t *testing.T) {
+	ctx := context.Background()
+	dir, repo, s := newTestRepo(t)
+
+	first := commitFiles(t, repo, dir, "Add foo", map[string]string{"cars.yaml": fooCar})
+	if _, err := repo.CreateTag("v1", plumbing.NewHash(first), nil); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, repo, dir, "Speed up foo", map[string]string{"cars.yaml": barCar + "---\n" + fooCarFast})
+	commitFiles(t, repo, dir, "Remove foo", map[string]string{"cars.yaml": barCar})
+
+	tests := []struct {
+		rev   string
+		speed float64
+		found bool
+	}{
+		{rev: first, speed: 0, found: true},
+		{rev: "v1", speed: 0, found: true},
+		{rev: "master~1", speed: 100, found: true},
+		{rev: "master", found: false},
+	}
+	for _, rt := range tests {
+		t.Run(rt.rev, func(t *testing.T) {
+			rs, err := readAtRevision(ctx, repo, s, rt.rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			obj, err := rs.Get(ctx, fooKey)
+			if !rt.found {
+				if !errors.Is(err, storage.ErrNotFound) {
+					t.Fatalf("expected ErrNotFound, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if speed := obj.(*v1alpha1.Car).Status.Speed; speed != rt.speed {
+				t.Errorf("expected speed %v, got %v", rt.speed, speed)
+			}
+		})
+	}
+
+	if _, err := readAtRevision(ctx, repo, s, "does-not-exist"); err == nil {
+		t.Error("expected an error for an unknown revision")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, repo, s := newTestRepo(t)
+
+	commitFiles(t, repo, dir, "Add foo", map[string]string{"cars.yaml": fooCar})
+	commitFiles(t, repo, dir, "Add bar", map[string]string{"bar.yaml": barCar})
+	commitFiles(t, repo, dir, "Move foo", map[string]string{"cars.yaml": "", "foo.yaml": fooCar})
+	commitFiles(t, repo, dir, "Speed up foo", map[string]string{"foo.yaml": fooCarFast})
+	commitFiles(t, repo, dir, "Remove foo", map[string]string{"foo.yaml": ""})
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := history(context.Background(), repo, s, head.Hash(), fooKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"DELETE Remove foo", "MODIFY Speed up foo", "CREATE Add foo"}
+	if len(commits) != len(want) {
+		t.Fatalf("expected %d commits, got %v", len(want), commits)
+	}
+	for i, commit := range commits {
+		if got := commit.Event.String() + " " + commit.Message; got != want[i] {
+			t.Errorf("commit %d: expected %q, got %q", i, want[i], got)
+		}
+		if commit.AuthorName != "test" || commit.Time.IsZero() {
+			t.Errorf("commit %d: unexpected author %q at %v", i, commit.AuthorName, commit.Time)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package transaction
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/weaveworks/libgitops/pkg/storage"
 )
@@ -11,6 +12,7 @@ var (
 	ErrAbortTransaction      = errors.New("transaction aborted")
 	ErrTransactionActive     = errors.New("transaction is active")
 	ErrNoPullRequestProvider = errors.New("no pull request provider given")
+	ErrPullRequestClosed     = errors.New("pull request was closed without being merged")
 )
 
 type TransactionFunc func(ctx context.Context, s storage.Storage) (CommitResult, error)
@@ -23,6 +25,15 @@ type TransactionStorage interface {
 	// The environment is made sure to be as up-to-date as possible before fn executes. When
 	// fn executes, the given storage can be used to modify the desired state. If you want to
 	// "commit" the changes made in fn, just return nil. If you want to abort, return ErrAbortTransaction.
-	// If you want to
-	Transaction(ctx context.Context, streamName string, fn TransactionFunc) error
+	// If fn returns an error, all changes are rolled back and the new stream is removed again. Errors
+	// during the rollback are returned combined with (and wrapping) the error of fn.
+	// If fn returns a PullRequestResult, a Pull Request is created and returned. A later transaction
+	// on the same stream adds its commit to that Pull Request, and updates it from its result.
+	Transaction(ctx context.Context, streamName string, fn TransactionFunc) (*PullRequest, error)
+	// DryRun executes fn like Transaction, but returns the changes fn made to the Objects instead of
+	// committing them. All changes are discarded afterwards, fn can be run again using Transaction.
+	DryRun(ctx context.Context, fn TransactionFunc) ([]ObjectDiff, error)
+	// WaitForMerge waits until the given Pull Request has been merged or closed, and then brings
+	// the storage up-to-date with the main branch. ErrPullRequestClosed is returned if it was closed.
+	WaitForMerge(ctx context.Context, pr *PullRequest, interval time.Duration) (*PullRequestStatus, error)
 }
@@ -1,7 +1,10 @@
 package watch
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
+	gosync "sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/weaveworks/libgitops/pkg/runtime"
@@ -10,17 +13,19 @@ import (
 	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
 	"github.com/weaveworks/libgitops/pkg/util/sync"
 	"github.com/weaveworks/libgitops/pkg/util/watcher"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 )
 
 // NewManifestStorage returns a pre-configured GenericWatchStorage backed by a storage.GenericStorage,
 // and a GenericMappedRawStorage for the given manifestDir and Serializer. This should be sufficient
-// for most users that want to watch changes in a directory with manifests.
+// for most users that want to watch changes in a directory with manifests. The Checksums are SHA-256
+// hashes of the content, so files that are rewritten without changes, e.g. by "git pull", don't
+// cause MODIFY events.
 func NewManifestStorage(manifestDir string, ser serializer.Serializer) (update.EventStorage, error) {
 	return NewGenericWatchStorage(
 		storage.NewGenericStorage(
-			storage.NewGenericMappedRawStorage(manifestDir),
+			storage.NewGenericMappedRawStorageWithOptions(manifestDir, storage.MappedRawStorageOptions{
+				Checksum: storage.NewSHA256Checksum(),
+			}),
 			ser,
 			[]runtime.IdentifierFactory{runtime.Metav1NameIdentifier},
 		),
@@ -30,12 +35,16 @@ func NewManifestStorage(manifestDir string, ser serializer.Serializer) (update.E
 // NewGenericWatchStorage is an extended Storage implementation, which provides a watcher
 // for watching changes in the directory managed by the embedded Storage's RawStorage.
 // If the RawStorage is a MappedRawStorage instance, it's mappings will automatically
-// be updated by the WatchStorage. Update events are sent to the given event stream.
-// Note: This WatchStorage only works for one-frame files (i.e. only one YAML document
-// per file is supported).
+// be updated by the WatchStorage. Update events are broadcast to all subscribers.
+// Files may contain multiple YAML documents, events are sent for each object in a file.
+// MODIFY events are only sent if the Checksum of the object changed, so with content-based
+// Checksums, rewriting a file without changes doesn't cause any events.
 func NewGenericWatchStorage(s storage.Storage) (update.EventStorage, error) {
 	ws := &GenericWatchStorage{
-		Storage: s,
+		Storage:     s,
+		broadcaster: update.NewBroadcaster(),
+		checksums:   make(map[storage.ObjectKey]string),
+		checksumMux: &gosync.Mutex{},
 	}
 
 	var err error
@@ -52,173 +61,265 @@ func NewGenericWatchStorage(s storage.Storage) (update.EventStorage, error) {
 }
 
 // EventDeleteObjectName is used as the name of an object sent to the
-// GenericWatchStorage's event stream when the the object has been deleted
-const EventDeleteObjectName = "<deleted>"
+// GenericWatchStorage's event stream when the object has been deleted.
+//
+// Deprecated: Use update.EventDeleteObjectName instead.
+const EventDeleteObjectName = update.EventDeleteObjectName
 
 // GenericWatchStorage implements the WatchStorage interface
 type GenericWatchStorage struct {
 	storage.Storage
-	watcher *watcher.FileWatcher
-	events  update.UpdateStream
-	monitor *sync.Monitor
+	watcher     *watcher.FileWatcher
+	broadcaster *update.Broadcaster
+	monitor     *sync.Monitor
+	// checksums holds the last known Checksum of every object, for detecting no-op changes
+	checksums   map[storage.ObjectKey]string
+	checksumMux *gosync.Mutex
 }
 
 var _ update.EventStorage = &GenericWatchStorage{}
 
 // Suspend modify events during Create
-func (s *GenericWatchStorage) Create(obj runtime.Object) error {
-	s.watcher.Suspend(watcher.FileEventModify)
-	return s.Storage.Create(obj)
+func (s *GenericWatchStorage) Create(ctx context.Context, obj runtime.Object) error {
+	if err := s.suspendDuring(watcher.FileEventModify, func() error {
+		return s.Storage.Create(ctx, obj)
+	}); err != nil {
+		return err
+	}
+	s.observeWrite(ctx, obj)
+	return nil
 }
 
 // Suspend modify events during Update
-func (s *GenericWatchStorage) Update(obj runtime.Object) error {
-	s.watcher.Suspend(watcher.FileEventModify)
-	return s.Storage.Update(obj)
+func (s *GenericWatchStorage) Update(ctx context.Context, obj runtime.Object) error {
+	if err := s.suspendDuring(watcher.FileEventModify, func() error {
+		return s.Storage.Update(ctx, obj)
+	}); err != nil {
+		return err
+	}
+	s.observeWrite(ctx, obj)
+	return nil
 }
 
 // Suspend modify events during Patch
-func (s *GenericWatchStorage) Patch(key storage.ObjectKey, patch []byte) error {
-	s.watcher.Suspend(watcher.FileEventModify)
-	return s.Storage.Patch(key, patch)
+func (s *GenericWatchStorage) Patch(ctx context.Context, key storage.ObjectKey, patch []byte) error {
+	if err := s.suspendDuring(watcher.FileEventModify, func() error {
+		return s.Storage.Patch(ctx, key, patch)
+	}); err != nil {
+		return err
+	}
+	s.checksumChanged(ctx, key)
+	return nil
 }
 
-// Suspend delete events during Delete
-func (s *GenericWatchStorage) Delete(key storage.ObjectKey) error {
-	s.watcher.Suspend(watcher.FileEventDelete)
-	return s.Storage.Delete(key)
+// Suspend the file event caused by Delete
+func (s *GenericWatchStorage) Delete(ctx context.Context, key storage.ObjectKey) error {
+	if err := s.suspendDuring(s.deleteEvent(key), func() error {
+		return s.Storage.Delete(ctx, key)
+	}); err != nil {
+		return err
+	}
+
+	// Objects with finalizers are only marked as being deleted, which modifies their file
+	// instead. The MODIFY event is a no-op, as the new Checksum has been recorded already.
+	if s.RawStorage().Exists(ctx, key) {
+		s.watcher.ClearSuspend()
+		s.checksumChanged(ctx, key)
+		return nil
+	}
+	s.forgetChecksum(key)
+	return nil
 }
 
-func (s *GenericWatchStorage) SetUpdateStream(eventStream update.UpdateStream) {
-	s.events = eventStream
+// deleteEvent returns the file event that deleting the Object with the given key causes. Objects
+// sharing their file with other Objects are removed by rewriting the file, which is a MODIFY.
+func (s *GenericWatchStorage) deleteEvent(key storage.ObjectKey) watcher.FileEvent {
+	if mapped, ok := s.RawStorage().(storage.MappedRawStorage); ok {
+		if loc, ok := mapped.GetMapping(key); ok && len(mapped.GetKeys(loc.Path)) > 1 {
+			return watcher.FileEventModify
+		}
+	}
+	return watcher.FileEventDelete
 }
 
+// suspendDuring suspends the given file event while running fn. If fn fails, e.g. with
+// storage.ErrConflict, nothing was written and the suspend is cleared again, so that
+// it doesn't swallow the next event of that type caused by someone else.
+func (s *GenericWatchStorage) suspendDuring(event watcher.FileEvent, fn func() error) error {
+	s.watcher.Suspend(event)
+	if err := fn(); err != nil {
+		s.watcher.ClearSuspend()
+		return err
+	}
+	return nil
+}
+
+func (s *GenericWatchStorage) Subscribe(opts update.SubscribeOptions) update.Subscription {
+	return s.broadcaster.Subscribe(opts)
+}
+
+// Close stops the watcher, and closes the UpdateStreams of all subscribers
+// after the last event has been sent.
 func (s *GenericWatchStorage) Close() error {
 	s.watcher.Close()
 	s.monitor.Wait()
+	s.broadcaster.Close()
 	return nil
 }
 
 func (s *GenericWatchStorage) monitorFunc(raw storage.RawStorage, files []string) {
 	log.Debug("GenericWatchStorage: Monitoring thread started")
 	defer log.Debug("GenericWatchStorage: Monitoring thread stopped")
-	var content []byte
 
-	// Send a MODIFY event for all files (and fill the mappings
-	// of the MappedRawStorage) before starting to monitor changes
+	// Send a MODIFY event for all objects in all files (and fill the
+	// mappings of the MappedRawStorage) before starting to monitor changes
 	for _, file := range files {
-		content, err := ioutil.ReadFile(file)
+		partObjs, err := readPartialObjects(file)
 		if err != nil {
 			log.Warnf("Ignoring %q: %v", file, err)
 			continue
 		}
 
-		obj, err := runtime.NewPartialObject(content)
-		if err != nil {
-			log.Warnf("Ignoring %q: %v", file, err)
-			continue
-		}
+		for i, partObj := range partObjs {
+			key, err := s.ObjectKeyFor(partObj)
+			if err != nil {
+				log.Warnf("Ignoring frame %d of %q: %v", i, file, err)
+				continue
+			}
 
-		// Add a mapping between this object and path
-		s.addMapping(raw, obj, file)
-		// Send the event to the events channel
-		s.sendEvent(update.ObjectEventModify, obj)
+			// Add a mapping between this object and its location
+			s.addMapping(raw, partObj, storage.FileLocation{Path: file, Frame: i})
+			s.checksumChanged(context.Background(), key)
+			// Broadcast the event to the subscribers
+			s.sendEvent(update.ObjectEventModify, key, partObj)
+		}
 	}
 
 	for {
-		if event, ok := <-s.watcher.GetFileUpdateStream(); ok {
-			var partObj runtime.PartialObject
-			var err error
-
-			var objectEvent update.ObjectEvent
-			switch event.Event {
-			case watcher.FileEventModify:
-				objectEvent = update.ObjectEventModify
-			case watcher.FileEventDelete:
-				objectEvent = update.ObjectEventDelete
-			}
-
-			log.Tracef("GenericWatchStorage: Processing event: %s", event.Event)
-			if event.Event == watcher.FileEventDelete {
-				key, err := raw.GetKey(event.Path)
-				if err != nil {
-					log.Warnf("Failed to retrieve data for %q: %v", event.Path, err)
-					continue
-				}
-
-				// This creates a "fake" Object from the key to be used for
-				// deletion, as the original has already been removed from disk
-				apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
-				partObj = &runtime.PartialObjectImpl{
-					TypeMeta: metav1.TypeMeta{
-						APIVersion: apiVersion,
-						Kind:       kind,
-					},
-					ObjectMeta: metav1.ObjectMeta{
-						Name: EventDeleteObjectName,
-						// TODO: This doesn't take into account where e.g. the identifier is "{namespace}/{name}"
-						UID: types.UID(key.GetIdentifier()),
-					},
-				}
-				// remove the mapping for this key as it's now deleted
-				s.removeMapping(raw, key)
-			} else {
-				content, err = ioutil.ReadFile(event.Path)
-				if err != nil {
-					log.Warnf("Ignoring %q: %v", event.Path, err)
-					continue
-				}
-
-				if partObj, err = runtime.NewPartialObject(content); err != nil {
-					log.Warnf("Ignoring %q: %v", event.Path, err)
-					continue
-				}
-
-				if event.Event == watcher.FileEventMove {
-					// Update the mappings for the moved file (AddMapping overwrites)
-					s.addMapping(raw, partObj, event.Path)
-
-					// Internal move events are a no-op
-					continue
-				}
-
-				// This is based on the key's existence instead of watcher.EventCreate,
-				// as Objects can get updated (via watcher.FileEventModify) to be conformant
-				if _, err = raw.GetKey(event.Path); err != nil {
-					// Add a mapping between this object and path
-					s.addMapping(raw, partObj, event.Path)
-
-					// This is what actually determines if an Object is created,
-					// so update the event to update.ObjectEventCreate here
-					objectEvent = update.ObjectEventCreate
-				}
-			}
+		event, ok := <-s.watcher.GetFileUpdateStream()
+		if !ok {
+			return
+		}
 
-			// Send the objectEvent to the events channel
-			if objectEvent != update.ObjectEventNone {
-				s.sendEvent(objectEvent, partObj)
-			}
+		log.Tracef("GenericWatchStorage: Processing event: %s", event.Event)
+		if event.Event == watcher.FileEventDelete {
+			s.handleDelete(raw, event.Path)
 		} else {
-			return
+			s.handleModify(raw, event)
+		}
+	}
+}
+
+// handleDelete sends a DELETE event for every object that was stored in the removed file
+func (s *GenericWatchStorage) handleDelete(raw storage.RawStorage, path string) {
+	keys := s.keysForPath(raw, path)
+	if len(keys) == 0 {
+		// This is expected for the files removed through this storage, e.g. the dependents of a
+		// deleted Object, as the suspend only covers a single event, and the mappings are gone
+		log.Debugf("GenericWatchStorage: No objects mapped to the removed file %q", path)
+		return
+	}
+
+	for _, key := range keys {
+		// remove the mapping for this key as it's now deleted
+		s.removeMapping(raw, key)
+		s.forgetChecksum(key)
+		s.sendEvent(update.ObjectEventDelete, key, update.DeletedObjectFor(key))
+	}
+}
+
+// handleModify diffs the objects in the modified file against the ones previously mapped to it,
+// and sends out CREATE, MODIFY and DELETE events on a per-object basis
+func (s *GenericWatchStorage) handleModify(raw storage.RawStorage, event *watcher.FileUpdate) {
+	partObjs, err := readPartialObjects(event.Path)
+	if err != nil {
+		log.Warnf("Ignoring %q: %v", event.Path, err)
+		return
+	}
+
+	// The objects this file contained before this event. Only a MappedRawStorage
+	// tracks the objects per file, other RawStorages only know if the path is valid.
+	oldKeys := make(map[storage.ObjectKey]bool)
+	oldKeyList := s.keysForPath(raw, event.Path)
+	for _, key := range oldKeyList {
+		oldKeys[key] = true
+	}
+	_, isMapped := raw.(storage.MappedRawStorage)
+
+	for i, partObj := range partObjs {
+		key, err := s.ObjectKeyFor(partObj)
+		if err != nil {
+			log.Warnf("Ignoring frame %d of %q: %v", i, event.Path, err)
+			continue
+		}
+
+		// Update the mappings for the object (AddMapping overwrites)
+		s.addMapping(raw, partObj, storage.FileLocation{Path: event.Path, Frame: i})
+
+		// This is based on the key's existence instead of watcher.EventCreate,
+		// as Objects can get updated (via watcher.FileEventModify) to be conformant
+		objectEvent := update.ObjectEventCreate
+		if oldKeys[key] || (!isMapped && len(oldKeyList) != 0) {
+			objectEvent = update.ObjectEventModify
+			delete(oldKeys, key)
+		}
+
+		// Internal move events are a no-op, as are modifications that don't change the Checksum
+		changed := s.checksumChanged(context.Background(), key)
+		if objectEvent == update.ObjectEventModify && !changed {
+			log.Tracef("GenericWatchStorage: Skipping no-op MODIFY event for %s", key)
+			continue
+		}
+		if event.Event != watcher.FileEventMove {
+			s.sendEvent(objectEvent, key, partObj)
 		}
 	}
+
+	// The objects that aren't in the file anymore have been deleted
+	if !isMapped {
+		return
+	}
+	for key := range oldKeys {
+		s.removeMapping(raw, key)
+		s.forgetChecksum(key)
+		s.sendEvent(update.ObjectEventDelete, key, update.DeletedObjectFor(key))
+	}
 }
 
-func (s *GenericWatchStorage) sendEvent(event update.ObjectEvent, partObj runtime.PartialObject) {
-	if s.events != nil {
-		log.Tracef("GenericWatchStorage: Sending event: %v", event)
-		s.events <- update.Update{
-			Event:         event,
-			PartialObject: partObj,
-			Storage:       s,
+// readPartialObjects decodes every frame of the given file into a PartialObject
+func readPartialObjects(path string) ([]runtime.PartialObject, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := storage.SplitFrames(content)
+	partObjs := make([]runtime.PartialObject, 0, len(frames))
+	for i, frame := range frames {
+		partObj, err := runtime.NewPartialObject(frame)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
 		}
+		partObjs = append(partObjs, partObj)
 	}
+
+	return partObjs, nil
+}
+
+func (s *GenericWatchStorage) sendEvent(event update.ObjectEvent, key storage.ObjectKey, partObj runtime.PartialObject) {
+	log.Tracef("GenericWatchStorage: Sending event: %v", event)
+	s.broadcaster.Broadcast(update.Update{
+		Event:         event,
+		PartialObject: partObj,
+		ObjectKey:     key,
+		Storage:       s,
+	})
 }
 
 // addMapping registers a mapping between the given object and the specified path, if raw is a
 // MappedRawStorage. If a given mapping already exists between this object and some path, it
 // will be overridden with the specified new path
-func (s *GenericWatchStorage) addMapping(raw storage.RawStorage, obj runtime.Object, file string) {
+func (s *GenericWatchStorage) addMapping(raw storage.RawStorage, obj runtime.Object, loc storage.FileLocation) {
 	mapped, ok := raw.(storage.MappedRawStorage)
 	if !ok {
 		return
@@ -228,9 +329,24 @@ func (s *GenericWatchStorage) addMapping(raw storage.RawStorage, obj runtime.Obj
 	key, err := s.Storage.ObjectKeyFor(obj)
 	if err != nil {
 		log.Errorf("couldn't get object key for: gvk=%s, uid=%s, name=%s", obj.GetObjectKind().GroupVersionKind(), obj.GetUID(), obj.GetName())
+		return
 	}
 
-	mapped.AddMapping(key, file)
+	mapped.AddMapping(key, loc)
+}
+
+// keysForPath returns the keys of all objects stored in the given file. If raw is not a
+// MappedRawStorage, there is only one object per file, and its key is given by raw.GetKey
+func (s *GenericWatchStorage) keysForPath(raw storage.RawStorage, path string) []storage.ObjectKey {
+	if mapped, ok := raw.(storage.MappedRawStorage); ok {
+		return mapped.GetKeys(path)
+	}
+
+	key, err := raw.GetKey(path)
+	if err != nil {
+		return nil
+	}
+	return []storage.ObjectKey{key}
 }
 
 // removeMapping removes a mapping a file that doesn't exist
@@ -242,3 +358,39 @@ func (s *GenericWatchStorage) removeMapping(raw storage.RawStorage, key storage.
 
 	mapped.RemoveMapping(key)
 }
+
+// observeWrite records the Checksum of an object written through this storage, so that
+// the suspended file event doesn't get compared against an outdated Checksum later
+func (s *GenericWatchStorage) observeWrite(ctx context.Context, obj runtime.Object) {
+	key, err := s.Storage.ObjectKeyFor(obj)
+	if err != nil {
+		return
+	}
+	s.checksumChanged(ctx, key)
+}
+
+// checksumChanged records the current Checksum of the object with the given key, and reports
+// if it differs from the previously recorded one. If the Checksum can't be computed, the
+// object is always considered changed.
+func (s *GenericWatchStorage) checksumChanged(ctx context.Context, key storage.ObjectKey) bool {
+	checksum, err := s.RawStorage().Checksum(ctx, key)
+	s.checksumMux.Lock()
+	defer s.checksumMux.Unlock()
+
+	if err != nil {
+		log.Debugf("GenericWatchStorage: Failed to compute the checksum of %s: %v", key, err)
+		delete(s.checksums, key)
+		return true
+	}
+	old, ok := s.checksums[key]
+	s.checksums[key] = checksum
+	return !ok || old != checksum
+}
+
+// forgetChecksum removes the recorded Checksum of a deleted object
+func (s *GenericWatchStorage) forgetChecksum(key storage.ObjectKey) {
+	s.checksumMux.Lock()
+	defer s.checksumMux.Unlock()
+
+	delete(s.checksums, key)
+}
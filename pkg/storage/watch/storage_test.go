@@ -0,0 +1,159 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/scheme"
+	"github.com/weaveworks/libgitops/cmd/sample-app/apis/sample/v1alpha1"
+	"github.com/weaveworks/libgitops/pkg/serializer"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	"github.com/weaveworks/libgitops/pkg/storage/conformance"
+	"github.com/weaveworks/libgitops/pkg/storage/storagetest"
+	"github.com/weaveworks/libgitops/pkg/storage/watch/update"
+)
+
+func TestGenericWatchStorage_Conformance(t *testing.T) {
+	conformance.TestEventStorage(t, func(t *testing.T, ser serializer.Serializer) (update.EventStorage, storage.Storage) {
+		es, writer, _ := newWatchTestStorage(t, ser)
+		return es, writer
+	}, conformance.EventStorageOptions{})
+}
+
+func TestGenericWatchStorage_ConflictDoesNotSuspend(t *testing.T) {
+	ctx := context.Background()
+	es, writer, _ := newWatchTestStorage(t, scheme.Serializer)
+	sub := es.Subscribe(update.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	if err := writer.Create(ctx, storagetest.NewCar("foo", "Volvo")); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	expectWatchUpdate(t, sub, update.ObjectEventCreate, storagetest.CarKey("foo"))
+
+	// The failed Update doesn't write anything, so it must not swallow the next MODIFY
+	car := storagetest.NewCar("foo", "Saab")
+	car.ResourceVersion = "stale"
+	if err := es.Update(ctx, car); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("Update: expected ErrConflict, got %v", err)
+	}
+	if err := writer.Patch(ctx, storagetest.CarKey("foo"), []byte(`{"spec":{"brand":"Tesla"}}`)); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	expectWatchUpdate(t, sub, update.ObjectEventModify, storagetest.CarKey("foo"))
+}
+
+func TestGenericWatchStorage_DeleteFrame(t *testing.T) {
+	ctx := context.Background()
+	es, _, dir := newWatchTestStorage(t, scheme.Serializer)
+	sub := es.Subscribe(update.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	path := filepath.Join(dir, "cars.yaml")
+	if err := ioutil.WriteFile(path, []byte(carsYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchUpdate(t, sub, update.ObjectEventCreate, storagetest.CarKey("foo"))
+	expectWatchUpdate(t, sub, update.ObjectEventCreate, storagetest.CarKey("bar"))
+
+	// Deleting one of the frames rewrites the file, it must not swallow the DELETE of the whole file
+	if err := es.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchUpdate(t, sub, update.ObjectEventDelete, storagetest.CarKey("bar"))
+}
+
+func TestGenericWatchStorage_DeleteWithFinalizer(t *testing.T) {
+	ctx := context.Background()
+	es, writer, _ := newWatchTestStorage(t, scheme.Serializer)
+	sub := es.Subscribe(update.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	foo := storagetest.NewCar("foo", "Volvo")
+	foo.Finalizers = []string{"example.com/keep"}
+	for _, car := range []*v1alpha1.Car{foo, storagetest.NewCar("bar", "Saab")} {
+		if err := writer.Create(ctx, car); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		expectWatchUpdate(t, sub, update.ObjectEventCreate, storagetest.CarKey(car.Name))
+	}
+
+	// The finalizer keeps foo, only its deletionTimestamp is set, so there is no DELETE to suspend
+	if err := es.Delete(ctx, storagetest.CarKey("foo")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if !es.RawStorage().Exists(ctx, storagetest.CarKey("foo")) {
+		t.Fatal("Delete: expected the finalizer to keep the Object")
+	}
+	if err := writer.Delete(ctx, storagetest.CarKey("bar")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	expectWatchUpdate(t, sub, update.ObjectEventDelete, storagetest.CarKey("bar"))
+}
+
+const carsYAML = `apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: foo
+  namespace: default
+spec:
+  brand: Volvo
+---
+apiVersion: sample-app.weave.works/v1alpha1
+kind: Car
+metadata:
+  name: bar
+  namespace: default
+spec:
+  brand: Saab
+`
+
+// newWatchTestStorage returns a GenericWatchStorage for a new temporary directory, together with
+// a Storage for writing to the same directory, and the directory itself. The GenericWatchStorage doesn't send updates for
+// its own writes, so external changes are made through the writer. Its files are placed in the
+// watched directory itself, as files written right after creating a subdirectory may be missed
+// before the subdirectory is watched.
+func newWatchTestStorage(t *testing.T, ser serializer.Serializer) (update.EventStorage, storage.Storage, string) {
+	dir := storagetest.TempDir(t)
+	es, err := NewManifestStorage(dir, ser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = es.Close() })
+
+	writer := storage.NewGenericStorage(
+		storage.NewGenericMappedRawStorageWithOptions(dir, storage.MappedRawStorageOptions{
+			Placement: storage.PlacementFunc(func(key storage.ObjectKey) (string, error) {
+				return key.GetKind() + "_" + strings.ReplaceAll(key.GetIdentifier(), "/", "_") + ".json", nil
+			}),
+		}),
+		ser,
+		conformance.Identifiers,
+	)
+	return es, writer, dir
+}
+
+// expectWatchUpdate waits for the next update of sub, and checks its event and key
+func expectWatchUpdate(t *testing.T, sub update.Subscription, event update.ObjectEvent, key storage.ObjectKey) {
+	t.Helper()
+	select {
+	case upd, ok := <-sub.Updates():
+		if !ok {
+			t.Fatalf("the UpdateStream was closed while waiting for the %s of %s", event, key)
+		}
+		if upd.Event != event || upd.ObjectKey.GetIdentifier() != key.GetIdentifier() {
+			t.Fatalf("expected the %s of %s, got the %s of %s", event, key, upd.Event, upd.ObjectKey)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for the %s of %s", event, key)
+	}
+}
@@ -0,0 +1,305 @@
+package update
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/storage"
+)
+
+const defaultBufferSize = 1024
+
+// OverflowPolicy describes what happens when an update is sent
+// to a subscriber whose buffer is full.
+type OverflowPolicy byte
+
+var _ fmt.Stringer = OverflowPolicy(0)
+
+const (
+	// OverflowBlock blocks the sender until the subscriber has received a pending update.
+	// Note that this also delays the updates for all other subscribers of the same sender.
+	OverflowBlock OverflowPolicy = iota // 0
+	// OverflowDrop drops the new update.
+	OverflowDrop // 1
+	// OverflowCoalesce merges the new update into a pending update for the same object, so
+	// that only the latest state of the object is delivered. If there is no pending update
+	// for the object, the sender blocks like with OverflowBlock.
+	OverflowCoalesce // 2
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case 0:
+		return "BLOCK"
+	case 1:
+		return "DROP"
+	case 2:
+		return "COALESCE"
+	}
+
+	return "UNKNOWN"
+}
+
+// SubscribeOptions configures a subscription to an EventStorage.
+type SubscribeOptions struct {
+	// BufferSize specifies how many updates can be pending for the subscriber. Default 1024.
+	// +optional
+	BufferSize int
+	// OverflowPolicy specifies what to do when the buffer is full. Default OverflowBlock.
+	// +optional
+	OverflowPolicy OverflowPolicy
+	// Kind, if set, only lets updates for objects of this kind through. The version is ignored.
+	// +optional
+	Kind storage.KindKey
+	// Filter, if set, only lets updates for objects matching it through, e.g. a filter.NameFilter.
+	// DELETE updates are always let through, as the metadata of a deleted object isn't available.
+	// +optional
+	Filter filter.ObjectFilter
+}
+
+func (o *SubscribeOptions) Default() {
+	if o.BufferSize <= 0 {
+		o.BufferSize = defaultBufferSize
+	}
+}
+
+// Subscription is a registered listener for updates.
+type Subscription interface {
+	// Updates returns the stream of updates for this subscriber. It is closed
+	// after Unsubscribe is called, or the EventStorage is closed.
+	Updates() UpdateStream
+	// Unsubscribe stops sending updates to this subscriber. It is safe to call multiple times.
+	Unsubscribe()
+}
+
+// NewBroadcaster creates a new Broadcaster without subscribers.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subs: make(map[*subscription]struct{}),
+		mux:  &sync.Mutex{},
+	}
+}
+
+// Broadcaster sends updates to any number of subscribers, each with its own
+// buffer, filter and overflow policy. It can be used by EventStorage
+// implementations to implement Subscribe.
+type Broadcaster struct {
+	subs   map[*subscription]struct{}
+	closed bool
+	mux    *sync.Mutex
+}
+
+// Subscribe registers a new subscriber with the given options.
+func (b *Broadcaster) Subscribe(opts SubscribeOptions) Subscription {
+	opts.Default()
+	sub := &subscription{
+		opts:        opts,
+		broadcaster: b,
+		updates:     make(chan Update),
+		done:        make(chan struct{}),
+		cond:        sync.NewCond(&sync.Mutex{}),
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	// Subscribing to a closed Broadcaster yields a closed stream
+	if b.closed {
+		sub.stop()
+		close(sub.updates)
+		return sub
+	}
+
+	b.subs[sub] = struct{}{}
+	go sub.pump()
+	return sub
+}
+
+// Broadcast sends the given update to all subscribers whose filters match it.
+func (b *Broadcaster) Broadcast(upd Update) {
+	b.mux.Lock()
+	subs := make([]*subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mux.Unlock()
+
+	for _, sub := range subs {
+		if sub.matches(upd) {
+			sub.send(upd)
+		}
+	}
+}
+
+// Close unsubscribes all subscribers, which closes their UpdateStreams.
+func (b *Broadcaster) Close() {
+	b.mux.Lock()
+	subs := b.subs
+	b.subs = make(map[*subscription]struct{})
+	b.closed = true
+	b.mux.Unlock()
+
+	for sub := range subs {
+		sub.stop()
+	}
+}
+
+func (b *Broadcaster) remove(sub *subscription) {
+	b.mux.Lock()
+	delete(b.subs, sub)
+	b.mux.Unlock()
+}
+
+// subscription implements Subscription. Updates are queued in a slice (instead of a buffered
+// channel) so that pending updates can be coalesced. A goroutine pumps the queue to the channel.
+type subscription struct {
+	opts        SubscribeOptions
+	broadcaster *Broadcaster
+	updates     chan Update
+	// done is closed when the subscription is stopped
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// cond.L guards queue and stopped
+	cond    *sync.Cond
+	queue   []Update
+	stopped bool
+}
+
+func (s *subscription) Updates() UpdateStream {
+	return s.updates
+}
+
+func (s *subscription) Unsubscribe() {
+	s.broadcaster.remove(s)
+	s.stop()
+}
+
+func (s *subscription) stop() {
+	s.stopOnce.Do(func() {
+		s.cond.L.Lock()
+		s.stopped = true
+		s.cond.L.Unlock()
+		s.cond.Broadcast()
+		close(s.done)
+	})
+}
+
+func (s *subscription) matches(upd Update) bool {
+	if s.opts.Kind != nil && upd.ObjectKey != nil && !upd.ObjectKey.EqualsGVK(s.opts.Kind, false) {
+		return false
+	}
+
+	if s.opts.Filter == nil || upd.Event == ObjectEventDelete || upd.PartialObject == nil {
+		return true
+	}
+
+	match, err := s.opts.Filter.Filter(upd.PartialObject)
+	if err != nil {
+		log.Warnf("Broadcaster: Subscription filter failed, not sending update: %v", err)
+		return false
+	}
+	return match
+}
+
+// send queues the update according to the overflow policy
+func (s *subscription) send(upd Update) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	for !s.stopped && len(s.queue) >= s.opts.BufferSize {
+		switch s.opts.OverflowPolicy {
+		case OverflowDrop:
+			log.Debugf("Broadcaster: Buffer full, dropping %s update for %v", upd.Event, upd.ObjectKey)
+			return
+		case OverflowCoalesce:
+			if s.coalesce(upd) {
+				return
+			}
+		}
+
+		// Block until the pump has taken an update from the queue
+		s.cond.Wait()
+	}
+
+	if s.stopped {
+		return
+	}
+
+	s.queue = append(s.queue, upd)
+	s.cond.Broadcast()
+}
+
+// coalesce merges upd into a pending update for the same object, if any.
+// s.cond.L must be held by the caller.
+func (s *subscription) coalesce(upd Update) bool {
+	if upd.ObjectKey == nil {
+		return false
+	}
+
+	for i, pending := range s.queue {
+		if pending.ObjectKey != upd.ObjectKey {
+			continue
+		}
+
+		switch {
+		case pending.Event == ObjectEventCreate && upd.Event == ObjectEventDelete:
+			// The subscriber never got to know about the object, forget it
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		case pending.Event == ObjectEventCreate:
+			// The object is still new to the subscriber, but send its latest state
+			upd.Event = ObjectEventCreate
+			s.queue[i] = upd
+		case pending.Event == ObjectEventDelete && upd.Event == ObjectEventCreate:
+			// The subscriber knows about the object, it has only changed
+			upd.Event = ObjectEventModify
+			s.queue[i] = upd
+		default:
+			s.queue[i] = upd
+		}
+
+		log.Tracef("Broadcaster: Coalesced %s update into pending %s update for %v", upd.Event, pending.Event, upd.ObjectKey)
+		return true
+	}
+
+	return false
+}
+
+// pump sends the queued updates to the subscriber, in order
+func (s *subscription) pump() {
+	defer close(s.updates)
+
+	for {
+		s.cond.L.Lock()
+		for !s.stopped && len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		if s.stopped {
+			s.cond.L.Unlock()
+			return
+		}
+
+		// Take the update from the queue before sending, so it can't be coalesced anymore
+		upd := s.queue[0]
+		s.queue = s.queue[1:]
+		s.cond.L.Unlock()
+		// Wake up senders blocked on a full queue
+		s.cond.Broadcast()
+
+		if !s.deliver(upd) {
+			return
+		}
+	}
+}
+
+// deliver blocks until the subscriber receives upd, or the subscription is stopped
+func (s *subscription) deliver(upd Update) bool {
+	select {
+	case s.updates <- upd:
+		return true
+	case <-s.done:
+		return false
+	}
+}
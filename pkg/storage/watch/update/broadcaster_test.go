@@ -0,0 +1,244 @@
+package update
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/libgitops/pkg/filter"
+	"github.com/weaveworks/libgitops/pkg/runtime"
+	"github.com/weaveworks/libgitops/pkg/storage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	carGVK        = schema.GroupVersionKind{Group: "sample-app.weave.works", Version: "v1alpha1", Kind: "Car"}
+	motorcycleGVK = schema.GroupVersionKind{Group: "sample-app.weave.works", Version: "v1alpha1", Kind: "Motorcycle"}
+)
+
+func newUpdate(event ObjectEvent, gvk schema.GroupVersionKind, name string) Update {
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	return Update{
+		Event: event,
+		PartialObject: &runtime.PartialObjectImpl{
+			TypeMeta:   metav1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		},
+		ObjectKey: storage.NewObjectKey(storage.NewKindKey(gvk), runtime.NewIdentifier(name)),
+	}
+}
+
+// receive reads n updates from the subscription, failing after a timeout
+func receive(t *testing.T, sub Subscription, n int) []Update {
+	t.Helper()
+	result := make([]Update, 0, n)
+	for len(result) < n {
+		select {
+		case upd, ok := <-sub.Updates():
+			if !ok {
+				t.Fatalf("stream closed after %d of %d updates", len(result), n)
+			}
+			result = append(result, upd)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after %d of %d updates", len(result), n)
+		}
+	}
+	return result
+}
+
+func expectClosed(t *testing.T, sub Subscription) {
+	t.Helper()
+	select {
+	case upd, ok := <-sub.Updates():
+		if ok {
+			t.Fatalf("expected closed stream, got %s update for %v", upd.Event, upd.ObjectKey)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the stream to close")
+	}
+}
+
+// describe returns the event and name of every update, for comparisons
+func describe(updates []Update) []string {
+	result := make([]string, 0, len(updates))
+	for _, upd := range updates {
+		result = append(result, upd.Event.String()+" "+upd.ObjectKey.GetKind()+" "+upd.ObjectKey.GetIdentifier())
+	}
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBroadcaster_Subscribe(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SubscribeOptions
+		want []string
+	}{
+		{
+			name: "all",
+			opts: SubscribeOptions{},
+			want: []string{"CREATE Car foo", "MODIFY Motorcycle bar", "DELETE Car baz"},
+		},
+		{
+			name: "kind",
+			opts: SubscribeOptions{Kind: storage.NewKindKey(carGVK)},
+			want: []string{"CREATE Car foo", "DELETE Car baz"},
+		},
+		{
+			name: "filter",
+			opts: SubscribeOptions{Filter: filter.NameFilter{Name: "bar"}},
+			want: []string{"MODIFY Motorcycle bar", "DELETE Car baz"},
+		},
+	}
+
+	b := NewBroadcaster()
+	subs := make([]Subscription, 0, len(tests))
+	for _, rt := range tests {
+		subs = append(subs, b.Subscribe(rt.opts))
+	}
+
+	b.Broadcast(newUpdate(ObjectEventCreate, carGVK, "foo"))
+	b.Broadcast(newUpdate(ObjectEventModify, motorcycleGVK, "bar"))
+	b.Broadcast(newUpdate(ObjectEventDelete, carGVK, "baz"))
+
+	for i, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			if got := describe(receive(t, subs[i], len(rt.want))); !equal(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+
+	b.Close()
+	for _, sub := range subs {
+		expectClosed(t, sub)
+	}
+
+	// Subscribing after Close yields a closed stream
+	expectClosed(t, b.Subscribe(SubscribeOptions{}))
+}
+
+func TestBroadcaster_Unsubscribe(t *testing.T) {
+	b := NewBroadcaster()
+	defer b.Close()
+
+	sub := b.Subscribe(SubscribeOptions{BufferSize: 1})
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	expectClosed(t, sub)
+
+	// Broadcasting must not block on the removed subscriber
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			b.Broadcast(newUpdate(ObjectEventCreate, carGVK, "foo"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Broadcast blocked on an unsubscribed subscriber")
+	}
+}
+
+func TestBroadcaster_OverflowDrop(t *testing.T) {
+	b := NewBroadcaster()
+	defer b.Close()
+	sub := b.Subscribe(SubscribeOptions{BufferSize: 1, OverflowPolicy: OverflowDrop})
+	s := sub.(*subscription)
+
+	// Wait for the pump to take the first update, it blocks until it is received
+	b.Broadcast(newUpdate(ObjectEventCreate, carGVK, "foo"))
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.cond.L.Lock()
+		pending := len(s.queue)
+		s.cond.L.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the pump")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The second update fills the buffer, the third one is dropped
+	b.Broadcast(newUpdate(ObjectEventCreate, carGVK, "bar"))
+	b.Broadcast(newUpdate(ObjectEventCreate, carGVK, "baz"))
+
+	want := []string{"CREATE Car foo", "CREATE Car bar"}
+	if got := describe(receive(t, sub, 2)); !equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSubscription_Coalesce(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending []Update
+		upd     Update
+		want    []string
+		ok      bool
+	}{
+		{
+			name:    "create and modify",
+			pending: []Update{newUpdate(ObjectEventCreate, carGVK, "foo"), newUpdate(ObjectEventModify, carGVK, "bar")},
+			upd:     newUpdate(ObjectEventModify, carGVK, "foo"),
+			want:    []string{"CREATE Car foo", "MODIFY Car bar"},
+			ok:      true,
+		},
+		{
+			name:    "create and delete",
+			pending: []Update{newUpdate(ObjectEventCreate, carGVK, "foo"), newUpdate(ObjectEventModify, carGVK, "bar")},
+			upd:     newUpdate(ObjectEventDelete, carGVK, "foo"),
+			want:    []string{"MODIFY Car bar"},
+			ok:      true,
+		},
+		{
+			name:    "modify and delete",
+			pending: []Update{newUpdate(ObjectEventModify, carGVK, "foo")},
+			upd:     newUpdate(ObjectEventDelete, carGVK, "foo"),
+			want:    []string{"DELETE Car foo"},
+			ok:      true,
+		},
+		{
+			name:    "delete and create",
+			pending: []Update{newUpdate(ObjectEventDelete, carGVK, "foo")},
+			upd:     newUpdate(ObjectEventCreate, carGVK, "foo"),
+			want:    []string{"MODIFY Car foo"},
+			ok:      true,
+		},
+		{
+			name:    "different kind",
+			pending: []Update{newUpdate(ObjectEventModify, carGVK, "foo")},
+			upd:     newUpdate(ObjectEventModify, motorcycleGVK, "foo"),
+			want:    []string{"MODIFY Car foo"},
+			ok:      false,
+		},
+	}
+
+	for _, rt := range tests {
+		t.Run(rt.name, func(t *testing.T) {
+			s := &subscription{queue: rt.pending}
+			if ok := s.coalesce(rt.upd); ok != rt.ok {
+				t.Errorf("expected coalesce to return %t, got %t", rt.ok, ok)
+			}
+			if got := describe(s.queue); !equal(got, rt.want) {
+				t.Errorf("expected %v, got %v", rt.want, got)
+			}
+		})
+	}
+}
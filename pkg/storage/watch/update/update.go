@@ -1,28 +1,86 @@
 package update
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/weaveworks/libgitops/pkg/runtime"
 	"github.com/weaveworks/libgitops/pkg/storage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// EventDeleteObjectName is used as the name of the object sent to the
+// subscribers of an EventStorage when the object has been deleted
+const EventDeleteObjectName = "<deleted>"
+
 // Update bundles an FileEvent with an
 // APIType for Storage retrieval.
 type Update struct {
 	Event         ObjectEvent
 	PartialObject runtime.PartialObject
-	Storage       storage.Storage
+	// ObjectKey is the key of the changed Object in Storage.
+	ObjectKey storage.ObjectKey
+	Storage   storage.Storage
+	// OldRevision and NewRevision are the revisions (e.g. Git commit SHAs) between which
+	// the change happened, if the EventStorage is backed by a versioned source.
+	// +optional
+	OldRevision string
+	// +optional
+	NewRevision string
 }
 
-// UpdateStream is a channel of updates.
-type UpdateStream chan Update
+// UpdateStream is a channel of updates, as received by a subscriber.
+type UpdateStream <-chan Update
 
-// EventStorage is a storage that exposes an UpdateStream.
+// EventStorage is a storage that exposes UpdateStreams to any number of subscribers.
 type EventStorage interface {
 	storage.Storage
 
-	// SetUpdateStream gives the EventStorage a channel to send events to.
-	// The caller is responsible for choosing a large enough buffer to avoid
-	// blocking the underlying EventStorage implementation unnecessarily.
-	// TODO: In the future maybe enable sending events to multiple listeners?
-	SetUpdateStream(UpdateStream)
+	// Subscribe registers a new listener for the events of this EventStorage. Every
+	// subscriber gets its own UpdateStream, buffer and filter; see SubscribeOptions.
+	Subscribe(opts SubscribeOptions) Subscription
+}
+
+// DeletedObjectFor creates a "fake" Object from the key to be sent in DELETE
+// updates, as the original has already been removed from the storage
+func DeletedObjectFor(key storage.ObjectKey) runtime.PartialObject {
+	apiVersion, kind := key.GetGVK().ToAPIVersionAndKind()
+	return &runtime.PartialObjectImpl{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiVersion,
+			Kind:       kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: EventDeleteObjectName,
+			// TODO: This doesn't take into account where e.g. the identifier is "{namespace}/{name}"
+			UID: types.UID(key.GetIdentifier()),
+		},
+	}
+}
+
+// UpdateFor creates the Update an EventStorage s sends when the change with the given revision
+// of its RawStorage modified the Object with the given key. The revisions are counters, the one
+// before the change is revision-1. The PartialObject of DELETE updates is created by
+// DeletedObjectFor, otherwise it's decoded from content, the new content of the Object.
+func UpdateFor(s storage.Storage, event ObjectEvent, key storage.ObjectKey, content []byte, revision uint64) (Update, error) {
+	upd := Update{
+		Event:       event,
+		ObjectKey:   key,
+		Storage:     s,
+		OldRevision: strconv.FormatUint(revision-1, 10),
+		NewRevision: strconv.FormatUint(revision, 10),
+	}
+
+	if event == ObjectEventDelete {
+		upd.PartialObject = DeletedObjectFor(key)
+		return upd, nil
+	}
+
+	partObj, err := runtime.NewPartialObject(content)
+	if err != nil {
+		return Update{}, fmt.Errorf("failed to decode %s %q: %w", key.GetGVK(), key.GetIdentifier(), err)
+	}
+	upd.PartialObject = partObj
+	return upd, nil
 }
@@ -1,9 +1,17 @@
 package util
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// TempFileSuffix is the suffix of the temporary files written by AtomicWriteFile.
+// Temporary files are also hidden, i.e. their name starts with a dot.
+const TempFileSuffix = ".libgitops-tmp"
+
 func PathExists(path string) (bool, os.FileInfo) {
 	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -21,3 +29,93 @@ func FileExists(filename string) bool {
 
 	return !info.IsDir()
 }
+
+// AtomicWriteFile writes data to the named file like ioutil.WriteFile, but crash-safely:
+// the data is written and synced to a temporary file in the same directory, which is then
+// renamed to filename. Finally, the directory is synced to persist the rename. Readers, and
+// the file after a crash or power loss, see either the old or the new content, never a
+// truncated file. If the file exists already, its permissions are kept.
+func AtomicWriteFile(filename string, data []byte, perm os.FileMode) (err error) {
+	dir, base := filepath.Split(filename)
+	if exists, info := PathExists(filename); exists {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+base+".*"+TempFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Don't leave the temporary file behind on failure
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+// syncDir fsyncs the given directory, so that changes to its entries are persisted
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %q: %w", dir, err)
+	}
+	return nil
+}
+
+// IsTempFile returns true if the given path is a temporary file written by AtomicWriteFile.
+func IsTempFile(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") && strings.HasSuffix(base, TempFileSuffix)
+}
+
+// RemoveTempFiles recursively removes the temporary files left behind in dir by AtomicWriteFile
+// calls that were interrupted by a crash. Directories in excludeDirs, e.g. ".git", are skipped.
+func RemoveTempFiles(dir string, excludeDirs ...string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil // Nothing to clean up if dir doesn't exist (yet)
+		} else if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			for _, exclude := range excludeDirs {
+				if info.Name() == exclude {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		if IsTempFile(path) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libgitops-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "car.yaml")
+	if err := AtomicWriteFile(file, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Overwriting keeps the permissions of the existing file
+	if err := AtomicWriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "new" {
+		t.Errorf("expected content %q, got %q", "new", content)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, got %d files", len(entries))
+	}
+
+	if err := AtomicWriteFile(filepath.Join(dir, "missing", "car.yaml"), []byte("new"), 0644); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libgitops-util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]bool{
+		"car.yaml":                            true,
+		".car.yaml.123" + TempFileSuffix:      false,
+		"sub/.bike.yaml.456" + TempFileSuffix: false,
+		".git/.index.789" + TempFileSuffix:    true,
+		"notes" + TempFileSuffix:              true,
+	}
+	for name := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := RemoveTempFiles(dir, ".git"); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range files {
+		if FileExists(filepath.Join(dir, filepath.FromSlash(name))) != kept {
+			t.Errorf("expected %q to be kept: %t", name, kept)
+		}
+	}
+
+	if err := RemoveTempFiles(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("expected no error for a missing directory, got %v", err)
+	}
+}
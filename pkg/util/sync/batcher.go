@@ -12,7 +12,9 @@ func NewBatchWriter(duration time.Duration) *BatchWriter {
 	return &BatchWriter{
 		duration: duration,
 		flushCh:  make(chan struct{}),
+		closeCh:  make(chan struct{}),
 		syncMap:  &sync.Map{},
+		mapMux:   &sync.Mutex{},
 	}
 }
 
@@ -26,12 +28,16 @@ type BatchWriter struct {
 	duration time.Duration
 	timer    *time.Timer
 	flushCh  chan struct{}
-	syncMap  *sync.Map
+	// closeCh is closed on Close. flushCh isn't closed, as a timer may be sending to it.
+	closeCh chan struct{}
+	syncMap *sync.Map
+	// mapMux guards replacing syncMap after a batch has been released
+	mapMux *sync.Mutex
 }
 
 // Load reads the key from the map
 func (b *BatchWriter) Load(key interface{}) (value interface{}, ok bool) {
-	return b.syncMap.Load(key)
+	return b.currentMap().Load(key)
 }
 
 // Store writes the value for the specified key to the map
@@ -42,15 +48,17 @@ func (b *BatchWriter) Store(key, value interface{}) {
 	b.cancelUnfiredTimer()
 	// store the key and the value as requested
 	log.Tracef("BatchWriter: Storing key %v and value %q, reset the timer.", key, value)
+	b.mapMux.Lock()
 	b.syncMap.Store(key, value)
+	b.mapMux.Unlock()
 	// set the timer to fire after the duration, unless there's a new .Store call
 	b.dispatchAfterTimeout()
 }
 
-// Close closes the underlying channel
+// Close stops the dispatching of batches
 func (b *BatchWriter) Close() {
 	log.Trace("BatchWriter: Closing the batch channel")
-	close(b.flushCh)
+	close(b.closeCh)
 }
 
 // ProcessBatch is effectively a Range over the sync.Map, once a batch write is
@@ -58,16 +66,29 @@ func (b *BatchWriter) Close() {
 // reset after this call, so be sure to capture all the contents if needed. This
 // function returns false if Close() has been called.
 func (b *BatchWriter) ProcessBatch(fn func(key, val interface{}) bool) bool {
-	if _, ok := <-b.flushCh; !ok {
-		// channel is closed
+	select {
+	case <-b.flushCh:
+	case <-b.closeCh:
+		// the BatchWriter is closed
 		return false
 	}
 	log.Trace("BatchWriter: Received a flush for the batch. Dispatching it now.")
-	b.syncMap.Range(fn)
-	*b.syncMap = sync.Map{}
+	// Swap in a new map first, so that concurrent writes end up in the next batch
+	b.mapMux.Lock()
+	batch := b.syncMap
+	b.syncMap = &sync.Map{}
+	b.mapMux.Unlock()
+
+	batch.Range(fn)
 	return true
 }
 
+func (b *BatchWriter) currentMap() *sync.Map {
+	b.mapMux.Lock()
+	defer b.mapMux.Unlock()
+	return b.syncMap
+}
+
 func (b *BatchWriter) cancelUnfiredTimer() {
 	// If the timer already exists; stop it
 	if b.timer != nil {
@@ -80,6 +101,9 @@ func (b *BatchWriter) cancelUnfiredTimer() {
 func (b *BatchWriter) dispatchAfterTimeout() {
 	b.timer = time.AfterFunc(b.duration, func() {
 		log.Tracef("BatchWriter: Dispatching a batch job")
-		b.flushCh <- struct{}{}
+		select {
+		case b.flushCh <- struct{}{}:
+		case <-b.closeCh:
+		}
 	})
 }
@@ -70,3 +70,41 @@ func TestBatchWriter(t *testing.T) {
 
 	//t.Error("err")
 }
+
+func TestBatchWriter_StoreDuringProcessBatch(t *testing.T) {
+	const count = 100
+	b := NewBatchWriter(time.Millisecond)
+	received := make(chan string, count)
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		for b.ProcessBatch(func(key, _ interface{}) bool {
+			received <- key.(string)
+			// Give the writer a chance to store keys while the batch is processed
+			time.Sleep(100 * time.Microsecond)
+			return true
+		}) {
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		b.Store(fmt.Sprintf("foo%d", i), i)
+		time.Sleep(50 * time.Microsecond)
+	}
+
+	// Every stored key ends up in exactly one batch
+	seen := make(map[string]bool, count)
+	for len(seen) < count {
+		select {
+		case key := <-received:
+			if seen[key] {
+				t.Fatalf("key %q was processed twice", key)
+			}
+			seen[key] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out, got %d of %d keys", len(seen), count)
+		}
+	}
+	b.Close()
+	<-processed
+}
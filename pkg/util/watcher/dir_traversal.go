@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/weaveworks/libgitops/pkg/util"
 )
 
 func (w *FileWatcher) getFiles() ([]string, error) {
@@ -37,9 +39,14 @@ func WalkDirectoryForFiles(dir string, validExts, excludeDirs []string) (files [
 }
 
 // isValidFile is used to filter out all unsupported
-// files based on if their extension is unknown or
+// files based on if their extension is unknown, if
+// they are temporary files of an atomic write or
 // if their path contains an excluded directory
 func isValidFile(path string, validExts, excludeDirs []string) bool {
+	if util.IsTempFile(path) {
+		return false
+	}
+
 	parts := strings.Split(filepath.Clean(path), string(os.PathSeparator))
 	ext := filepath.Ext(parts[len(parts)-1])
 	for _, suffix := range validExts {
@@ -3,6 +3,7 @@ package watcher
 import (
 	"fmt"
 	"path"
+	gosync "sync"
 	"time"
 
 	"github.com/rjeczalik/notify"
@@ -87,11 +88,12 @@ func NewFileWatcher(dir string) (w *FileWatcher, files []string, err error) {
 // MappedRawStorage fileMappings
 func NewFileWatcherWithOptions(dir string, opts Options) (w *FileWatcher, files []string, err error) {
 	w = &FileWatcher{
-		dir:     dir,
-		events:  make(eventStream, eventBuffer),
-		updates: make(FileUpdateStream, eventBuffer),
-		batcher: sync.NewBatchWriter(opts.BatchTimeout),
-		opts:    opts,
+		dir:        dir,
+		events:     make(eventStream, eventBuffer),
+		updates:    make(FileUpdateStream, eventBuffer),
+		batcher:    sync.NewBatchWriter(opts.BatchTimeout),
+		opts:       opts,
+		suspendMux: &gosync.Mutex{},
 	}
 
 	log.Tracef("FileWatcher: Starting recursive watch for %q", dir)
@@ -114,9 +116,13 @@ type FileWatcher struct {
 	events       eventStream
 	updates      FileUpdateStream
 	suspendEvent FileEvent
-	monitor      *sync.Monitor
-	dispatcher   *sync.Monitor
-	opts         Options
+	// suspendMux guards suspendEvent, which is set by the users of the FileWatcher
+	suspendMux *gosync.Mutex
+	monitor    *sync.Monitor
+	dispatcher *sync.Monitor
+	opts       Options
+	// moves tracks the updates of incomplete moves being sent
+	moves gosync.WaitGroup
 	// the batcher is used for properly sending many concurrent inotify events
 	// as a group, after a specified timeout. This fixes the issue of one single
 	// file operation being registered as many different inotify events
@@ -126,7 +132,6 @@ type FileWatcher struct {
 func (w *FileWatcher) monitorFunc() {
 	log.Debug("FileWatcher: Monitoring thread started")
 	defer log.Debug("FileWatcher: Monitoring thread stopped")
-	defer close(w.updates) // Close the update stream after the FileWatcher has stopped
 
 	for {
 		event, ok := <-w.events
@@ -143,8 +148,11 @@ func (w *FileWatcher) monitorFunc() {
 		}
 
 		updateEvent := convertEvent(event.Event())
-		if w.suspendEvent > 0 && updateEvent == w.suspendEvent {
-			w.suspendEvent = 0
+		if event.Event() == notify.InMovedTo {
+			// Atomic writes rename a temporary file over the target, which is a modification
+			updateEvent = FileEventModify
+		}
+		if w.consumeSuspend(updateEvent) {
 			log.Debugf("FileWatcher: Skipping suspended event %s for path: %q", updateEvent, event.Path())
 			continue // Skip the suspended event
 		}
@@ -203,14 +211,46 @@ func (w *FileWatcher) Close() {
 	close(w.events) // Close the event stream
 	w.monitor.Wait()
 	w.dispatcher.Wait()
+
+	// Drop the pending moves, and close the update stream after the last update has been sent
+	moveCachesMux.Lock()
+	for _, cache := range moveCaches {
+		if cache.watcher == w {
+			cache.cancel()
+		}
+	}
+	moveCachesMux.Unlock()
+	w.moves.Wait()
+	close(w.updates)
 }
 
 // Suspend enables a one-time suspend of the given event,
 // the FileWatcher will skip the given event once
 func (w *FileWatcher) Suspend(updateEvent FileEvent) {
+	w.suspendMux.Lock()
+	defer w.suspendMux.Unlock()
 	w.suspendEvent = updateEvent
 }
 
+// ClearSuspend removes the suspend set by Suspend, if the suspended
+// event hasn't happened yet, e.g. as the operation causing it failed
+func (w *FileWatcher) ClearSuspend() {
+	w.suspendMux.Lock()
+	defer w.suspendMux.Unlock()
+	w.suspendEvent = FileEventNone
+}
+
+// consumeSuspend reports if the given event is suspended, and removes the suspend if so
+func (w *FileWatcher) consumeSuspend(updateEvent FileEvent) bool {
+	w.suspendMux.Lock()
+	defer w.suspendMux.Unlock()
+	if w.suspendEvent == FileEventNone || updateEvent != w.suspendEvent {
+		return false
+	}
+	w.suspendEvent = FileEventNone
+	return true
+}
+
 func convertEvent(event notify.Event) FileEvent {
 	if updateEvent, ok := eventMap[event]; ok {
 		return updateEvent
@@ -272,26 +312,42 @@ func (m *moveCache) incomplete() {
 		panic(fmt.Sprintf("moveCache: unrecognized event: %v", m.event.Event()))
 	}
 
-	log.Tracef("moveCache: Timer expired for %d, dispatching...", m.cookie())
-	m.watcher.sendUpdate(&FileUpdate{event, m.event.Path()})
-
+	moveCachesMux.Lock()
+	if moveCaches[m.cookie()] != m {
+		moveCachesMux.Unlock()
+		return // The move was completed or the FileWatcher closed while the timer fired
+	}
 	// Delete the cache after the timer has fired
 	delete(moveCaches, m.cookie())
+	m.watcher.moves.Add(1)
+	moveCachesMux.Unlock()
+
+	log.Tracef("moveCache: Timer expired for %d, dispatching...", m.cookie())
+	m.watcher.sendUpdate(&FileUpdate{event, m.event.Path()})
+	m.watcher.moves.Done()
 }
 
+// cancel stops the timer of the moveCache. moveCachesMux must be locked.
 func (m *moveCache) cancel() {
 	m.timer.Stop()
 	delete(moveCaches, m.cookie())
 	log.Tracef("moveCache: Dispatching cancelled for %d", m.cookie())
 }
 
-// moveCaches keeps track of active moves by cookie
-var moveCaches = make(map[uint32]*moveCache)
+var (
+	// moveCaches keeps track of active moves by cookie
+	moveCaches = make(map[uint32]*moveCache)
+	// moveCachesMux guards moveCaches, the timers of the moveCaches fire in their own goroutines
+	moveCachesMux = &gosync.Mutex{}
+)
 
 // move processes InMovedFrom and InMovedTo events in any order
 // and dispatches FileUpdates when a move is detected
 func (w *FileWatcher) move(event notify.EventInfo) (moveUpdate *FileUpdate) {
 	cookie := ievent(event).Cookie
+	moveCachesMux.Lock()
+	defer moveCachesMux.Unlock()
+
 	cache, ok := moveCaches[cookie]
 	if !ok {
 		// The cookie is not cached, create a new cache object for it
@@ -1,7 +1,11 @@
 package watcher
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rjeczalik/notify"
 	"golang.org/x/sys/unix"
@@ -99,3 +103,66 @@ func TestEventConcatenation(t *testing.T) {
 		}
 	}
 }
+
+func TestIsValidFile(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/dir/car.yaml", true},
+		{"/dir/car.txt", false},
+		{"/dir/.car.yaml.123.libgitops-tmp", false},
+		{"/dir/.car.yaml", true},
+	}
+	for _, rt := range tests {
+		if got := isValidFile(rt.path, DefaultOptions().ValidExtensions, DefaultOptions().ExcludeDirs); got != rt.want {
+			t.Errorf("isValidFile(%q): expected %t, got %t", rt.path, rt.want, got)
+		}
+	}
+}
+
+func TestFileWatcher_CloseWithPendingMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libgitops-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "libgitops-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	file := filepath.Join(dir, "car.yaml")
+	if err := ioutil.WriteFile(file, []byte("kind: Car\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.BatchTimeout = 10 * time.Millisecond
+	w, _, err := NewFileWatcherWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving the file out of the directory starts a move, which only completes after a timeout
+	if err := os.Rename(file, filepath.Join(outside, "car.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Closing drops the pending move, and closes the update stream
+	w.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range w.GetFileUpdateStream() {
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the update stream to be closed")
+	}
+	// Wait for the timer of the move, which must not send to the closed stream
+	time.Sleep(1500 * time.Millisecond)
+}